@@ -0,0 +1,238 @@
+package plex
+
+import (
+	"slices"
+	"sort"
+	"strings"
+	plex_css "visualsource/plex/internal/css"
+)
+
+// A declaration that applies to an element along with everything the cascade sorts it by.
+// https://www.w3.org/TR/css-cascade-5/#cascade-sort
+type cascadedDeclaration struct {
+	declaration plex_css.Declaration
+	origin      uint
+	// declared in the element's style attribute
+	inline      bool
+	layer       int
+	specificity plex_css.Specificity
+	// order of appearance: stylesheet, rule and declaration index
+	order [3]int
+}
+
+// Ranks origin and importance, lowest precedence first:
+// user-agent, user and author normal declarations followed by
+// author, user and user-agent important declarations.
+func (d *cascadedDeclaration) originRank() int {
+	if d.declaration.Important {
+		return 3 + int(plex_css.Origin_Author-d.origin)
+	}
+	return int(d.origin)
+}
+
+// Reports whether a loses the cascade against b.
+func cascadeLess(a *cascadedDeclaration, b *cascadedDeclaration) bool {
+	if a.originRank() != b.originRank() {
+		return a.originRank() < b.originRank()
+	}
+
+	// element-attached styles win over rules of the same origin and importance
+	if a.inline != b.inline {
+		return b.inline
+	}
+
+	// later layers win for normal declarations and lose for important ones
+	if a.layer != b.layer {
+		if a.declaration.Important {
+			return a.layer > b.layer
+		}
+		return a.layer < b.layer
+	}
+
+	if c := a.specificity.Compare(&b.specificity); c != 0 {
+		return c < 0
+	}
+
+	for i := range a.order {
+		if a.order[i] != b.order[i] {
+			return a.order[i] < b.order[i]
+		}
+	}
+
+	return false
+}
+
+// Sorts declarations from lowest to highest precedence and keeps the winner for each property.
+func cascade(declarations []cascadedDeclaration) plex_css.CssPropertyMap {
+	sort.SliceStable(declarations, func(i, j int) bool {
+		return cascadeLess(&declarations[i], &declarations[j])
+	})
+
+	stacks := map[string][]*cascadedDeclaration{}
+	for i := range declarations {
+		name := declarations[i].declaration.Name
+		stacks[name] = append(stacks[name], &declarations[i])
+	}
+
+	values := plex_css.CssPropertyMap{}
+	for name, stack := range stacks {
+		if dec, ok := resolveRevert(stack); ok {
+			values[name] = dec
+		}
+	}
+
+	return values
+}
+
+/*
+Returns the winning declaration of a sorted stack, rolling the cascade back while the winner
+is 'revert' or 'revert-layer'. Rolling back past every declaration leaves the property unset.
+
+Source: https://www.w3.org/TR/css-cascade-5/#default
+*/
+func resolveRevert(stack []*cascadedDeclaration) (plex_css.Declaration, bool) {
+	i := len(stack) - 1
+	for i >= 0 {
+		winner := stack[i]
+		switch winner.declaration.GetCssWideKeyword() {
+		case plex_css.Keyword_Revert:
+			// drop every declaration of the winner's origin
+			for i >= 0 && stack[i].origin >= winner.origin {
+				i--
+			}
+		case plex_css.Keyword_RevertLayer:
+			// drop every declaration of the winner's layer
+			for i >= 0 && stack[i].originRank() == winner.originRank() && stack[i].inline == winner.inline && stack[i].layer == winner.layer {
+				i--
+			}
+		default:
+			return winner.declaration, true
+		}
+	}
+
+	return plex_css.Declaration{}, false
+}
+
+/*
+Gives every supported property a value. Properties without a cascaded value and the
+'inherit', 'initial' and 'unset' keywords take the parent's value or the initial value.
+
+Source: https://www.w3.org/TR/css-cascade-5/#defaulting
+*/
+func defaultValues(specified plex_css.CssPropertyMap, parent plex_css.CssPropertyMap) plex_css.CssPropertyMap {
+	values := plex_css.CssPropertyMap{}
+	for name, dec := range specified {
+		values[name] = dec
+	}
+
+	for name, definition := range plex_css.PROPERTIES {
+		keyword := plex_css.Keyword_Unset
+		if dec, ok := specified[name]; ok {
+			keyword = dec.GetCssWideKeyword()
+		}
+
+		switch keyword {
+		case plex_css.Keyword_Inherit:
+			values[name] = inheritedValue(name, parent)
+		case plex_css.Keyword_Initial:
+			values[name] = initialValue(name)
+		case plex_css.Keyword_Unset, plex_css.Keyword_Revert, plex_css.Keyword_RevertLayer:
+			if definition.Inherited {
+				values[name] = inheritedValue(name, parent)
+			} else {
+				values[name] = initialValue(name)
+			}
+		}
+	}
+
+	return values
+}
+
+func inheritedValue(name string, parent plex_css.CssPropertyMap) plex_css.Declaration {
+	if dec, ok := parent[name]; ok {
+		return plex_css.Declaration{Name: name, Value: dec.Value}
+	}
+	return initialValue(name)
+}
+
+func initialValue(name string) plex_css.Declaration {
+	return plex_css.Declaration{Name: name, Value: plex_css.GetInitialValue(name)}
+}
+
+/*
+Assigns every cascade layer a rank per origin. Layers are ordered by their first declaration,
+sublayers rank before the rules of their parent layer and unlayered rules rank after every
+layer of their origin.
+
+Source: https://www.w3.org/TR/css-cascade-5/#layer-ordering
+*/
+type layerOrder map[uint]map[string]int
+
+func createLayerOrder(stylesheets []plex_css.Stylesheet) layerOrder {
+	declared := map[uint][]string{}
+
+	// declaring a.b also declares a
+	declare := func(origin uint, name string) {
+		if name == "" {
+			return
+		}
+		parts := strings.Split(name, ".")
+		for i := range parts {
+			layer := strings.Join(parts[:i+1], ".")
+			if !slices.Contains(declared[origin], layer) {
+				declared[origin] = append(declared[origin], layer)
+			}
+		}
+	}
+
+	for _, stylesheet := range stylesheets {
+		for _, name := range stylesheet.Layers {
+			declare(stylesheet.Origin, name)
+		}
+		for _, rule := range stylesheet.Rules {
+			declare(stylesheet.Origin, rule.Layer)
+		}
+		for _, page := range stylesheet.Pages {
+			declare(stylesheet.Origin, page.Layer)
+		}
+	}
+
+	order := layerOrder{}
+	for origin, names := range declared {
+		order[origin] = rankLayers(names)
+	}
+	return order
+}
+
+// Ranks the layers depth first, "a.x", "b", "a.y" rank as a.x, a.y, a, b.
+func rankLayers(names []string) map[string]int {
+	ranks := map[string]int{}
+
+	var visit func(parent string)
+	visit = func(parent string) {
+		for _, name := range names {
+			if layerParent(name) == parent {
+				visit(name)
+				ranks[name] = len(ranks)
+			}
+		}
+	}
+	visit("")
+
+	return ranks
+}
+
+func layerParent(name string) string {
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		return name[:i]
+	}
+	return ""
+}
+
+func (o layerOrder) rank(origin uint, name string) int {
+	layers := o[origin]
+	if rank, ok := layers[name]; ok && name != "" {
+		return rank
+	}
+	return len(layers)
+}
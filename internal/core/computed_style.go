@@ -0,0 +1,526 @@
+package plex
+
+import (
+	"strings"
+	plex_css "visualsource/plex/internal/css"
+)
+
+type PositionType uint8
+type FloatType uint8
+
+const (
+	PositionType_Static PositionType = iota
+	PositionType_Relative
+	PositionType_Absolute
+	PositionType_Fixed
+	PositionType_Sticky
+)
+
+const (
+	FloatType_None FloatType = iota
+	FloatType_Left
+	FloatType_Right
+)
+
+// The page breaks of 'break-before', 'break-after' and 'break-inside', column and region breaks are auto.
+type BreakType uint8
+
+const (
+	BreakType_Auto BreakType = iota
+	BreakType_Avoid
+	BreakType_Page
+	// a page break followed by one or two page breaks so that the next page is a left or right page
+	BreakType_Left
+	BreakType_Right
+)
+
+// A computed length or percentage, or 'auto'. Percentages are resolved at layout.
+type ComputedLength struct {
+	Value   float32
+	Percent bool
+	Auto    bool
+	// a math function mixing lengths and percentages
+	Calc *plex_css.CssFunction
+}
+
+var ZERO_LENGTH = ComputedLength{}
+var AUTO_LENGTH = ComputedLength{Auto: true}
+
+// The used value of the length inside a containing block of the given size. 'auto' resolves to 0.
+func (c ComputedLength) Resolve(base float32) float32 {
+	switch {
+	case c.Auto:
+		return 0
+	case c.Calc != nil:
+		// relative lengths in the calculation were converted to px when it was computed
+		return lengthResolver{}.math(c.Calc, base)
+	case c.Percent:
+		return c.Value * base / 100
+	default:
+		return c.Value
+	}
+}
+
+type LengthEdges struct {
+	Left   ComputedLength
+	Right  ComputedLength
+	Top    ComputedLength
+	Bottom ComputedLength
+}
+
+type ColorEdges struct {
+	Left   plex_css.CssColor
+	Right  plex_css.CssColor
+	Top    plex_css.CssColor
+	Bottom plex_css.CssColor
+}
+
+// The font a text run is rendered with.
+type FontDescriptor struct {
+	Family string
+	Size   float32
+	Weight int
+	Italic bool
+	// families tried after Family, separated by NUL which the tokenizer never lets into a name.
+	// A string rather than a slice keeps the descriptor, and the styles holding it, comparable
+	// for the style cache without limiting the length of the list.
+	fallbacks string
+}
+
+// The families of the 'font-family' list in the order they are tried.
+func (f FontDescriptor) Families() []string {
+	families := []string{f.Family}
+	if f.fallbacks != "" {
+		families = append(families, strings.Split(f.fallbacks, "\x00")...)
+	}
+	return families
+}
+
+/*
+The computed values used by layout and paint. Styles are comparable so identical
+styles are shared between nodes.
+
+Source: https://www.w3.org/TR/css-cascade-5/#computed
+*/
+type ComputedStyle struct {
+	Display  DisplayType
+	Position PositionType
+	Float    FloatType
+
+	Width   ComputedLength
+	Height  ComputedLength
+	Margin  LengthEdges
+	Padding LengthEdges
+	// border widths are 0 when the border style is 'none'
+	Border      EdgeSizes
+	BorderColor ColorEdges
+
+	Color               plex_css.CssColor
+	BackgroundColor     plex_css.CssColor
+	OutlineColor        plex_css.CssColor
+	TextDecorationColor plex_css.CssColor
+	// the paint of SVG shapes, transparent for 'none'
+	Fill plex_css.CssColor
+	Font FontDescriptor
+	// the height of a line box in px
+	LineHeight float32
+
+	ListStyleInside bool
+	ContainerType   ContainerType
+
+	BreakBefore BreakType
+	BreakAfter  BreakType
+	BreakInside BreakType
+}
+
+var INITIAL_FONT = FontDescriptor{Family: "Ubuntu", Size: 16, Weight: 400}
+
+// The font sizes of the absolute-size keywords.
+// https://www.w3.org/TR/css-fonts-4/#absolute-size-mapping
+var FONT_SIZE_KEYWORDS = map[string]float32{
+	"xx-small":  9,
+	"x-small":   10,
+	"small":     13,
+	"medium":    16,
+	"large":     18,
+	"x-large":   24,
+	"xx-large":  32,
+	"xxx-large": 48,
+}
+
+// Shared by the nodes of a style tree. Identical computed styles are stored once.
+type computedStyleCache struct {
+	styles  map[ComputedStyle]*ComputedStyle
+	context lengthContext
+	queries containerQueries
+}
+
+func createComputedStyleCache(viewport Viewport) *computedStyleCache {
+	return &computedStyleCache{
+		styles:  map[ComputedStyle]*ComputedStyle{},
+		context: createLengthContext(viewport),
+	}
+}
+
+func (c *computedStyleCache) intern(style ComputedStyle) *ComputedStyle {
+	if shared, ok := c.styles[style]; ok {
+		return shared
+	}
+	c.styles[style] = &style
+	return &style
+}
+
+var sides = [4]string{"top", "right", "bottom", "left"}
+
+/*
+Computes the typed style of a node from its cascaded values. Properties missing from
+props take their initial value, or the parent's value when they are inherited.
+*/
+func computeStyle(props plex_css.CssPropertyMap, parent *ComputedStyle, context lengthContext) ComputedStyle {
+	inherited := ComputedStyle{
+		Font:       INITIAL_FONT,
+		LineHeight: normalLineHeight(INITIAL_FONT),
+		Color:      plex_css.CSS_COLOR_KEYWORDS["black"],
+		Fill:       plex_css.CSS_COLOR_KEYWORDS["black"],
+	}
+	if parent != nil {
+		inherited = *parent
+	}
+
+	// font-size and line-height resolve against the parent's font and line height
+	resolver := lengthResolver{context: context, font: inherited.Font, lineHeight: inherited.LineHeight}
+	font := computeFont(props, inherited.Font, resolver)
+	resolver.font = font
+	lineHeight := computeLineHeight(props, resolver)
+	resolver.lineHeight = lineHeight
+
+	style := ComputedStyle{
+		Display:         computeDisplay(props.ResolveLookupToCssValue("display").Unwrap()),
+		Width:           computeLength(props, "width", AUTO_LENGTH, resolver),
+		Height:          computeLength(props, "height", AUTO_LENGTH, resolver),
+		Font:            font,
+		LineHeight:      lineHeight,
+		ListStyleInside: inherited.ListStyleInside,
+	}
+
+	// 'currentcolor' in 'color' is the inherited color, everywhere else it is the computed one
+	style.Color = computeColor(props, "color", inherited.Color, inherited.Color)
+	style.BackgroundColor = computeColor(props, "background-color", style.Color, plex_css.CssColor{})
+	style.OutlineColor = computeColor(props, "outline-color", style.Color, style.Color)
+	style.TextDecorationColor = computeColor(props, "text-decoration-color", style.Color, style.Color)
+	style.Fill = computeColor(props, "fill", style.Color, inherited.Fill)
+	if keyword, ok := keywordValue(props, "fill"); ok && keyword == "none" {
+		style.Fill = plex_css.CssColor{}
+	}
+
+	if keyword, ok := keywordValue(props, "position"); ok {
+		switch keyword {
+		case "relative":
+			style.Position = PositionType_Relative
+		case "absolute":
+			style.Position = PositionType_Absolute
+		case "fixed":
+			style.Position = PositionType_Fixed
+		case "sticky":
+			style.Position = PositionType_Sticky
+		}
+	}
+
+	if keyword, ok := keywordValue(props, "float"); ok {
+		switch keyword {
+		case "left":
+			style.Float = FloatType_Left
+		case "right":
+			style.Float = FloatType_Right
+		}
+	}
+
+	if keyword, ok := keywordValue(props, "container-type"); ok {
+		switch keyword {
+		case "size":
+			style.ContainerType = ContainerType_Size
+		case "inline-size":
+			style.ContainerType = ContainerType_InlineSize
+		}
+	}
+
+	if keyword, ok := keywordValue(props, "break-before"); ok {
+		style.BreakBefore = computeBreak(keyword)
+	}
+	if keyword, ok := keywordValue(props, "break-after"); ok {
+		style.BreakAfter = computeBreak(keyword)
+	}
+	if keyword, ok := keywordValue(props, "break-inside"); ok {
+		style.BreakInside = computeBreak(keyword)
+	}
+
+	if keyword, ok := keywordValue(props, "list-style-position"); ok {
+		style.ListStyleInside = keyword == "inside"
+	}
+
+	margin := [4]*ComputedLength{&style.Margin.Top, &style.Margin.Right, &style.Margin.Bottom, &style.Margin.Left}
+	padding := [4]*ComputedLength{&style.Padding.Top, &style.Padding.Right, &style.Padding.Bottom, &style.Padding.Left}
+	border := [4]*float32{&style.Border.Top, &style.Border.Right, &style.Border.Bottom, &style.Border.Left}
+	borderColor := [4]*plex_css.CssColor{&style.BorderColor.Top, &style.BorderColor.Right, &style.BorderColor.Bottom, &style.BorderColor.Left}
+
+	for i, side := range sides {
+		*margin[i] = computeLength(props, "margin-"+side, ZERO_LENGTH, resolver)
+		*padding[i] = computeLength(props, "padding-"+side, ZERO_LENGTH, resolver)
+
+		*borderColor[i] = computeColor(props, "border-"+side+"-color", style.Color, style.Color)
+
+		// https://www.w3.org/TR/css-backgrounds-3/#border-width
+		borderStyle, ok := keywordValue(props, "border-"+side+"-style")
+		if !ok || borderStyle == "none" || borderStyle == "hidden" {
+			continue
+		}
+		*border[i] = computeBorderWidth(props.ResolveLookupToCssValue("border-"+side+"-width").Unwrap(), resolver)
+	}
+
+	return style
+}
+
+// Documents are left-to-right, so recto pages are right pages and verso pages left ones.
+// https://www.w3.org/TR/css-break-3/#break-between
+func computeBreak(keyword string) BreakType {
+	switch keyword {
+	case "avoid", "avoid-page":
+		return BreakType_Avoid
+	case "page", "always", "all":
+		return BreakType_Page
+	case "left", "verso":
+		return BreakType_Left
+	case "right", "recto":
+		return BreakType_Right
+	}
+	return BreakType_Auto
+}
+
+// Resolves a color property against currentColor, fallback when it is missing or can not be resolved.
+func computeColor(props plex_css.CssPropertyMap, name string, currentColor plex_css.CssColor, fallback plex_css.CssColor) plex_css.CssColor {
+	value := props.ResolveLookupToCssValue(name)
+	if value.IsNone() {
+		return fallback
+	}
+	return plex_css.ResolveCssValueToColor(value.Unwrap(), currentColor).TakeOr(fallback)
+}
+
+func computeDisplay(value plex_css.CssValue) DisplayType {
+	keyword, ok := value.(*plex_css.CssKeyword)
+	if !ok {
+		return DisplayType_Inline
+	}
+
+	switch strings.ToLower(keyword.Value) {
+	case "block":
+		return DisplayType_Block
+	case "list-item":
+		return DisplayType_ListItem
+	case "none":
+		return DisplayType_None
+	default:
+		return DisplayType_Inline
+	}
+}
+
+/*
+Returns the families of a 'font-family' value in order. Unquoted names made of several
+identifiers are joined with a single space.
+
+Source: https://www.w3.org/TR/css-fonts-4/#family-name-syntax
+*/
+func familyNames(v plex_css.CssValue) []string {
+	if list, ok := v.(*plex_css.CssList); ok && list.Separator == ',' {
+		names := []string{}
+		for _, family := range list.Values {
+			if name := familyName(family); name != "" {
+				names = append(names, name)
+			}
+		}
+		return names
+	}
+	if name := familyName(v); name != "" {
+		return []string{name}
+	}
+	return nil
+}
+
+func familyName(v plex_css.CssValue) string {
+	switch family := v.(type) {
+	case *plex_css.CssKeyword:
+		return family.Value
+	case *plex_css.CssString:
+		return family.Value
+	case *plex_css.CssList:
+		if family.Separator != ' ' {
+			return ""
+		}
+		words := []string{}
+		for _, word := range family.Values {
+			if keyword, ok := word.(*plex_css.CssKeyword); ok {
+				words = append(words, keyword.Value)
+			}
+		}
+		return strings.Join(words, " ")
+	}
+	return ""
+}
+
+// Relative font sizes resolve against the parent's font, passed in resolver.
+func computeFont(props plex_css.CssPropertyMap, parent FontDescriptor, resolver lengthResolver) FontDescriptor {
+	font := parent
+
+	props.ResolveLookupToCssValue("font-family").IfSome(func(v plex_css.CssValue) {
+		if families := familyNames(v); len(families) > 0 {
+			font.Family = families[0]
+			font.fallbacks = strings.Join(families[1:], "\x00")
+		}
+	})
+
+	props.ResolveLookupToCssValue("font-size").IfSome(func(v plex_css.CssValue) {
+		switch size := v.(type) {
+		case *plex_css.CssKeyword:
+			keyword := strings.ToLower(size.Value)
+			if absolute, ok := FONT_SIZE_KEYWORDS[keyword]; ok {
+				font.Size = absolute
+			} else if keyword == "larger" {
+				font.Size = parent.Size * 1.2
+			} else if keyword == "smaller" {
+				font.Size = parent.Size / 1.2
+			}
+		case *plex_css.CssDimention:
+			if size.Unit == plex_css.CssUnit_PRESENT {
+				font.Size = parent.Size * size.Value / 100
+			} else if px := resolver.px(size); px > 0 {
+				font.Size = px
+			}
+		case *plex_css.CssFunction:
+			// percentages are relative to the parent's font size
+			font.Size = max(resolver.math(size, parent.Size), 0)
+		}
+	})
+
+	// https://www.w3.org/TR/css-fonts-4/#relative-weights
+	props.ResolveLookupToCssValue("font-weight").IfSome(func(v plex_css.CssValue) {
+		switch weight := v.(type) {
+		case *plex_css.CssKeyword:
+			switch weight.Value {
+			case "normal":
+				font.Weight = 400
+			case "bold":
+				font.Weight = 700
+			case "bolder":
+				font.Weight = min(max(parent.Weight+300, 400), 900)
+			case "lighter":
+				font.Weight = max(min(parent.Weight-300, 700), 100)
+			}
+		case *plex_css.CssDimention:
+			if weight.Unit == plex_css.CssUnit_NO_UNIT && weight.Value >= 1 && weight.Value <= 1000 {
+				font.Weight = int(weight.Value)
+			}
+		case *plex_css.CssFunction:
+			font.Weight = int(min(max(resolver.math(weight, 0), 1), 1000))
+		}
+	})
+
+	if keyword, ok := keywordValue(props, "font-style"); ok {
+		font.Italic = keyword == "italic" || keyword == "oblique"
+	}
+
+	return font
+}
+
+// 'normal' is approximated from the font size, like the line height of the text measurer.
+func normalLineHeight(font FontDescriptor) float32 {
+	return font.Size * 1.2
+}
+
+/*
+Computes the line height in px. Numbers and percentages are relative to the element's font,
+passed in resolver.
+
+Source: https://www.w3.org/TR/css-inline-3/#line-height-property
+*/
+func computeLineHeight(props plex_css.CssPropertyMap, resolver lengthResolver) float32 {
+	switch height := props.ResolveLookupToCssValue("line-height").Unwrap().(type) {
+	case *plex_css.CssDimention:
+		switch height.Unit {
+		case plex_css.CssUnit_NO_UNIT:
+			return height.Value * resolver.font.Size
+		case plex_css.CssUnit_PRESENT:
+			return height.Value * resolver.font.Size / 100
+		default:
+			return max(resolver.px(height), 0)
+		}
+	case *plex_css.CssFunction:
+		if mathType, ok := plex_css.MathTypeOf(height); ok && mathType == plex_css.MathType_Number {
+			return max(resolver.math(height, 0), 0) * resolver.font.Size
+		}
+		if plex_css.IsMathFunction(height) {
+			return max(resolver.math(height, resolver.font.Size), 0)
+		}
+	}
+	return normalLineHeight(resolver.font)
+}
+
+// https://www.w3.org/TR/css-backgrounds-3/#typedef-line-width
+func computeBorderWidth(value plex_css.CssValue, resolver lengthResolver) float32 {
+	switch width := value.(type) {
+	case *plex_css.CssKeyword:
+		switch width.Value {
+		case "thin":
+			return 1
+		case "thick":
+			return 5
+		default:
+			return 3
+		}
+	case *plex_css.CssDimention:
+		return max(resolver.px(width), 0)
+	case *plex_css.CssFunction:
+		return max(resolver.math(width, 0), 0)
+	default:
+		return 3
+	}
+}
+
+func computeLength(props plex_css.CssPropertyMap, name string, initial ComputedLength, resolver lengthResolver) ComputedLength {
+	return toComputedLength(props.ResolveLookupToCssValue(name).Unwrap(), initial, resolver)
+}
+
+func toComputedLength(value plex_css.CssValue, initial ComputedLength, resolver lengthResolver) ComputedLength {
+	switch length := value.(type) {
+	case *plex_css.CssKeyword:
+		if strings.EqualFold(length.Value, "auto") {
+			return AUTO_LENGTH
+		}
+	case *plex_css.CssDimention:
+		if length.Unit == plex_css.CssUnit_PRESENT {
+			return ComputedLength{Value: length.Value, Percent: true}
+		}
+		return ComputedLength{Value: resolver.px(length)}
+	case *plex_css.CssFunction:
+		if !plex_css.IsMathFunction(length) {
+			break
+		}
+		// calculations with percentages are kept until the containing block is known
+		if plex_css.MathContainsPercentage(length) {
+			return ComputedLength{Calc: resolver.absolutize(length).(*plex_css.CssFunction)}
+		}
+		return ComputedLength{Value: resolver.math(length, 0)}
+	}
+	return initial
+}
+
+func keywordValue(props plex_css.CssPropertyMap, name string) (string, bool) {
+	value := props.ResolveLookupToCssValue(name)
+	if value.IsNone() {
+		return "", false
+	}
+
+	keyword, ok := value.Unwrap().(*plex_css.CssKeyword)
+	if !ok {
+		return "", false
+	}
+	return strings.ToLower(keyword.Value), true
+}
@@ -0,0 +1,148 @@
+package plex
+
+import (
+	"fmt"
+	"maps"
+	"strings"
+	plex_css "visualsource/plex/internal/css"
+)
+
+type ContainerType uint8
+
+const (
+	ContainerType_Normal ContainerType = iota
+	ContainerType_Size
+	ContainerType_InlineSize
+)
+
+// The keyword of the type as written in 'container-type'.
+func (t ContainerType) keyword() string {
+	switch t {
+	case ContainerType_Size:
+		return "size"
+	case ContainerType_InlineSize:
+		return "inline-size"
+	}
+	return "normal"
+}
+
+// The size of a content box in px.
+type containerSize struct {
+	Width  float32
+	Height float32
+}
+
+/*
+The state container queries are evaluated with while computing a style tree. The sizes of the
+query containers come from the previous layout of the document, a container that was not laid
+out yet can not be queried.
+
+Source: https://www.w3.org/TR/css-contain-3/#container-queries
+*/
+type containerQueries struct {
+	sizes map[*ElementNode]containerSize
+	env   plex_css.MediaEnvironment
+	// the rules of matching @media and @supports blocks, including the rules of every @container block
+	stylesheets []plex_css.Stylesheet
+	// the measured query containers the current node is inside, the nearest first
+	ancestors []plex_css.QueryContainer
+}
+
+// Keeps the rules that are not in an @container block and those whose queries match the ancestors.
+func (q *containerQueries) rules() []plex_css.Stylesheet {
+	result := []plex_css.Stylesheet{}
+	for _, stylesheet := range q.stylesheets {
+		rules := []plex_css.Rule{}
+		for _, rule := range stylesheet.Rules {
+			if rule.MatchesContainers(q.ancestors, q.env) {
+				rules = append(rules, rule)
+			}
+		}
+		stylesheet.Rules = rules
+		result = append(result, stylesheet)
+	}
+	return result
+}
+
+/*
+Makes a styled element the nearest query container of its descendants when it is one and its
+size is known. Returns false when the descendants are inside the same containers as the element.
+*/
+func (c *computedStyleCache) enterContainer(el *ElementNode, styled *StyledNode) bool {
+	containerType := styled.style.ContainerType
+	size, ok := c.queries.sizes[el]
+	if containerType == ContainerType_Normal || !ok {
+		return false
+	}
+
+	container := plex_css.QueryContainer{
+		Type:     containerType.keyword(),
+		Names:    containerNames(styled.props),
+		Width:    size.Width,
+		Height:   size.Height,
+		FontSize: styled.style.Font.Size,
+	}
+	c.queries.ancestors = append([]plex_css.QueryContainer{container}, c.queries.ancestors...)
+
+	// container units use the nearest container that can be queried in their axis
+	c.context.container.Width = size.Width
+	if containerType == ContainerType_Size {
+		c.context.container.Height = size.Height
+	}
+	return true
+}
+
+func containerNames(props plex_css.CssPropertyMap) []string {
+	names := []string{}
+	for _, value := range props["container-name"].Value {
+		if keyword, ok := value.(*plex_css.CssKeyword); ok && !strings.EqualFold(keyword.Value, "none") {
+			names = append(names, keyword.Value)
+		}
+	}
+	return names
+}
+
+// Records the content boxes of the laid out query containers.
+func measureContainers(box *LayoutBox, sizes map[*ElementNode]containerSize) {
+	if box.boxType == BoxType_Block && box.node.IsSome() {
+		styled := box.node.UnwrapAsPtr()
+		if el, ok := styled.node.(*ElementNode); ok && styled.style.ContainerType != ContainerType_Normal {
+			sizes[el] = containerSize{Width: box.dimensions.Content.W, Height: box.dimensions.Content.H}
+		}
+	}
+	for i := range box.children {
+		measureContainers(&box.children[i], sizes)
+	}
+}
+
+// Restyling changes the size of containers at most this many times before the layout is kept.
+const maxContainerPasses = 4
+
+/*
+Lays out the document in the containing block. Rules in @container blocks and container units
+depend on the size of the query containers, which is only known after layout, so the document
+is restyled and laid out again until the containers keep their size. Containers still changing
+size after the last pass are reported in Diagnostics.
+*/
+func (d *Document) Layout(containing Dimensions) LayoutBox {
+	d.Diagnostics = nil
+	layout := LayoutTree(d.styleTree, containing)
+	for pass := 0; ; pass++ {
+		sizes := map[*ElementNode]containerSize{}
+		measureContainers(&layout, sizes)
+		if maps.Equal(sizes, d.containerSizes) {
+			break
+		}
+		if pass == maxContainerPasses {
+			d.Diagnostics = append(d.Diagnostics, plex_css.Diagnostic{
+				Message: fmt.Sprintf("container sizes still change after %d passes, the last layout is kept", maxContainerPasses),
+			})
+			break
+		}
+
+		d.containerSizes = sizes
+		d.computeStyles()
+		layout = LayoutTree(d.styleTree, containing)
+	}
+	return layout
+}
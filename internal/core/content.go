@@ -0,0 +1,311 @@
+package plex
+
+import (
+	"strconv"
+	"strings"
+	plex_css "visualsource/plex/internal/css"
+)
+
+// Quote marks used when 'quotes' is auto.
+var DEFAULT_QUOTES = []string{"“", "”", "‘", "’"}
+
+// Tracks the state needed to resolve generated content in document order.
+// https://www.w3.org/TR/css-lists-3/#auto-numbering
+type generatedContentState struct {
+	quoteDepth int
+	counters   map[string][]int
+	// depth of each counter stack when the current sibling scope started
+	scope map[string]int
+}
+
+func createGeneratedContentState() generatedContentState {
+	return generatedContentState{
+		counters: map[string][]int{},
+		scope:    map[string]int{},
+	}
+}
+
+// Starts the scope of an element's children and returns the scope to restore with leaveScope.
+func (s *generatedContentState) enterScope() map[string]int {
+	previous := s.scope
+	s.scope = map[string]int{}
+	for name, stack := range s.counters {
+		s.scope[name] = len(stack)
+	}
+	return previous
+}
+
+// Drops the counters created by the children of the current element.
+func (s *generatedContentState) leaveScope(previous map[string]int) {
+	for name, stack := range s.counters {
+		depth := s.scope[name]
+		if depth < len(stack) {
+			s.counters[name] = stack[:depth]
+		}
+	}
+	s.scope = previous
+}
+
+// Applies 'counter-reset' and then 'counter-increment'.
+func (s *generatedContentState) applyCounters(props plex_css.CssPropertyMap) {
+	props.GetProp("counter-reset").IfSome(func(dec plex_css.Declaration) {
+		forEachCounter(dec.Value, 0, func(name string, value int) {
+			stack := s.counters[name]
+			// a counter created by a preceding sibling is replaced instead of nested
+			if len(stack) > s.scope[name] {
+				stack[len(stack)-1] = value
+				return
+			}
+			s.counters[name] = append(stack, value)
+		})
+	})
+
+	props.GetProp("counter-increment").IfSome(func(dec plex_css.Declaration) {
+		forEachCounter(dec.Value, 1, func(name string, value int) {
+			stack := s.counters[name]
+			if len(stack) == 0 {
+				// incrementing a counter that does not exist instantiates it
+				s.counters[name] = []int{value}
+				return
+			}
+			stack[len(stack)-1] += value
+		})
+	})
+}
+
+// List items increment the list-item counter unless 'counter-increment' already mentions it.
+// https://www.w3.org/TR/css-lists-3/#list-item-counter
+func (s *generatedContentState) incrementListItem(props plex_css.CssPropertyMap) {
+	explicit := false
+	props.GetProp("counter-increment").IfSome(func(dec plex_css.Declaration) {
+		forEachCounter(dec.Value, 1, func(name string, value int) {
+			explicit = explicit || name == "list-item"
+		})
+	})
+	if explicit {
+		return
+	}
+
+	stack := s.counters["list-item"]
+	if len(stack) == 0 {
+		s.counters["list-item"] = []int{1}
+		return
+	}
+	stack[len(stack)-1]++
+}
+
+// Walks a list of <counter-name> <integer>? pairs.
+func forEachCounter(values []plex_css.CssValue, fallback int, apply func(name string, value int)) {
+	for i := 0; i < len(values); i++ {
+		keyword, ok := values[i].(*plex_css.CssKeyword)
+		if !ok || strings.EqualFold(keyword.Value, "none") {
+			continue
+		}
+
+		value := fallback
+		if i+1 < len(values) {
+			if number, ok := values[i+1].(*plex_css.CssDimention); ok && number.Unit == plex_css.CssUnit_NO_UNIT {
+				value = int(number.Value)
+				i++
+			}
+		}
+
+		apply(keyword.Value, value)
+	}
+}
+
+func (s *generatedContentState) counterValue(name string) int {
+	stack := s.counters[name]
+	if len(stack) == 0 {
+		return 0
+	}
+	return stack[len(stack)-1]
+}
+
+// Resolves the value of the 'content' property into the text of the generated box.
+// The alternative text after a '/' is for assistive technology and is not shown.
+func (s *generatedContentState) resolveContent(el *ElementNode, props plex_css.CssPropertyMap, values []plex_css.CssValue) string {
+	quotes := resolveQuotes(props)
+	var builder strings.Builder
+
+	if len(values) == 1 {
+		if list, ok := values[0].(*plex_css.CssList); ok && list.Separator == '/' && len(list.Values) > 0 {
+			values = []plex_css.CssValue{list.Values[0]}
+			if shown, ok := list.Values[0].(*plex_css.CssList); ok {
+				values = shown.Values
+			}
+		}
+	}
+
+	for _, value := range values {
+		switch v := value.(type) {
+		case *plex_css.CssString:
+			builder.WriteString(v.Value)
+		case *plex_css.CssKeyword:
+			switch v.Value {
+			case "open-quote":
+				builder.WriteString(quoteAt(quotes, s.quoteDepth, 0))
+				s.quoteDepth++
+			case "close-quote":
+				if s.quoteDepth > 0 {
+					s.quoteDepth--
+				}
+				builder.WriteString(quoteAt(quotes, s.quoteDepth, 1))
+			case "no-open-quote":
+				s.quoteDepth++
+			case "no-close-quote":
+				if s.quoteDepth > 0 {
+					s.quoteDepth--
+				}
+			}
+		case *plex_css.CssFunction:
+			builder.WriteString(s.resolveContentFunction(el, v))
+		}
+	}
+
+	return builder.String()
+}
+
+func (s *generatedContentState) resolveContentFunction(el *ElementNode, fn *plex_css.CssFunction) string {
+	name := func(i int) string {
+		if i >= len(fn.Args) {
+			return ""
+		}
+		switch v := fn.Args[i].(type) {
+		case *plex_css.CssKeyword:
+			return v.Value
+		case *plex_css.CssString:
+			return v.Value
+		}
+		return ""
+	}
+
+	switch strings.ToLower(fn.Name) {
+	case "attr":
+		// page margin boxes have no element
+		if el == nil {
+			return ""
+		}
+		return el.GetAttribute(name(0))
+	case "counter":
+		return formatCounter(s.counterValue(name(0)), name(1))
+	case "counters":
+		stack := s.counters[name(0)]
+		items := make([]string, 0, len(stack))
+		for _, value := range stack {
+			items = append(items, formatCounter(value, name(2)))
+		}
+		if len(items) == 0 {
+			items = append(items, formatCounter(0, name(2)))
+		}
+		return strings.Join(items, name(1))
+	default:
+		return ""
+	}
+}
+
+// https://www.w3.org/TR/css-content-3/#quotes
+func resolveQuotes(props plex_css.CssPropertyMap) []string {
+	dec := props.GetProp("quotes")
+	if dec.IsNone() {
+		return DEFAULT_QUOTES
+	}
+
+	quotes := []string{}
+	for _, value := range dec.Unwrap().Value {
+		switch v := value.(type) {
+		case *plex_css.CssString:
+			quotes = append(quotes, v.Value)
+		case *plex_css.CssKeyword:
+			if strings.EqualFold(v.Value, "auto") {
+				return DEFAULT_QUOTES
+			}
+		}
+	}
+
+	// an odd number of strings is invalid
+	if len(quotes)%2 != 0 {
+		return DEFAULT_QUOTES
+	}
+
+	return quotes
+}
+
+// Picks the open (side 0) or close (side 1) quote for the given nesting depth,
+// reusing the innermost pair when the depth exceeds the list.
+func quoteAt(quotes []string, depth int, side int) string {
+	if len(quotes) == 0 {
+		return ""
+	}
+	pair := min(depth, len(quotes)/2-1)
+	return quotes[pair*2+side]
+}
+
+// https://www.w3.org/TR/css-counter-styles-3/#predefined-counters
+func formatCounter(value int, style string) string {
+	switch style {
+	case "none":
+		return ""
+	case "disc":
+		return "•"
+	case "circle":
+		return "◦"
+	case "square":
+		return "▪"
+	case "decimal-leading-zero":
+		if value >= 0 && value < 10 {
+			return "0" + strconv.Itoa(value)
+		}
+		return strconv.Itoa(value)
+	case "lower-alpha", "lower-latin":
+		return strings.ToLower(formatAlphabetic(value))
+	case "upper-alpha", "upper-latin":
+		return formatAlphabetic(value)
+	case "lower-roman":
+		return strings.ToLower(formatRoman(value))
+	case "upper-roman":
+		return formatRoman(value)
+	default:
+		return strconv.Itoa(value)
+	}
+}
+
+// Alphabetic systems have no zero or negative representation and fall back to decimal.
+func formatAlphabetic(value int) string {
+	if value < 1 {
+		return strconv.Itoa(value)
+	}
+
+	result := []rune{}
+	for value > 0 {
+		value--
+		result = append([]rune{rune('A' + value%26)}, result...)
+		value /= 26
+	}
+	return string(result)
+}
+
+// Roman numerals are only defined for 1 to 3999.
+func formatRoman(value int) string {
+	if value < 1 || value > 3999 {
+		return strconv.Itoa(value)
+	}
+
+	numerals := []struct {
+		value  int
+		symbol string
+	}{
+		{1000, "M"}, {900, "CM"}, {500, "D"}, {400, "CD"},
+		{100, "C"}, {90, "XC"}, {50, "L"}, {40, "XL"},
+		{10, "X"}, {9, "IX"}, {5, "V"}, {4, "IV"}, {1, "I"},
+	}
+
+	var builder strings.Builder
+	for _, numeral := range numerals {
+		for value >= numeral.value {
+			builder.WriteString(numeral.symbol)
+			value -= numeral.value
+		}
+	}
+	return builder.String()
+}
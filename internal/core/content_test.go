@@ -0,0 +1,125 @@
+package plex_test
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	plex "visualsource/plex/internal/core"
+	plex_css "visualsource/plex/internal/css"
+
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// Records the text of the generated boxes, which are written between [ and ] by the tests.
+type generatedTextMeasurer struct {
+	texts *[]string
+}
+
+func (m generatedTextMeasurer) MeasureText(text string, style plex.TextStyle) (float32, float32) {
+	text = strings.TrimSpace(text)
+	if strings.HasPrefix(text, "[") && strings.HasSuffix(text, "]") {
+		*m.texts = append(*m.texts, strings.Trim(text, "[]"))
+	}
+	return float32(len(text)) * style.Font.Size * 0.5, style.Font.Size * 1.2
+}
+
+func generatedTexts(t *testing.T, html string, css string) []string {
+	t.Helper()
+	parser := plex.HtmlParser{}
+	dom, err := parser.Parse(html)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := plex_css.CssParser{}
+	stylesheet, err := p.ParseStylesheet(css, plex_css.Origin_Author)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	texts := []string{}
+	defer func(previous plex.TextMeasurer) { plex.DefaultTextMeasurer = previous }(plex.DefaultTextMeasurer)
+	plex.DefaultTextMeasurer = generatedTextMeasurer{texts: &texts}
+
+	styled := plex.StyleTree(dom, []plex_css.Stylesheet{stylesheet}, plex.Viewport{Width: 1000, Height: 500})
+	plex.LayoutTree(styled, plex.Dimensions{Content: sdl.FRect{W: 1000, H: 500}})
+	return texts
+}
+
+func TestContent_PSEUDO_ELEMENT_COUNTER_SCOPE(t *testing.T) {
+	// the counter instantiated by the first ::after ends with its paragraph
+	texts := generatedTexts(t, `<html><body><p>a</p><p>b</p></body></html>`, `
+		html, body, p { display: block }
+		p::after { counter-increment: y; content: "[" counter(y) "]" }
+		p::before { content: "[" counter(y) "]" }
+	`)
+	if !slices.Equal(texts, []string{"0", "1", "0", "1"}) {
+		t.Fatalf("expected every paragraph to count from 0, got %v", texts)
+	}
+}
+
+func TestContent_NESTED_COUNTERS(t *testing.T) {
+	texts := generatedTexts(t, `<html><body><ol>
+		<li>a</li>
+		<li>b<ol><li>c</li><li>d</li></ol></li>
+		<li>e</li>
+	</ol></body></html>`, `
+		html, body, ol, li { display: block }
+		ol { counter-reset: item }
+		li { counter-increment: item }
+		li::before { content: "[" counters(item, ".") "|" counter(item) "]" }
+	`)
+	if !slices.Equal(texts, []string{"1|1", "2|2", "2.1|1", "2.2|2", "3|3"}) {
+		t.Fatalf("expected a nested counter per list, got %v", texts)
+	}
+}
+
+func TestContent_QUOTES(t *testing.T) {
+	texts := generatedTexts(t, `<html><body><p><q>a <q>b <q>c</q></q></q><q>d</q></p></body></html>`, `
+		html, body, p { display: block }
+		p { quotes: "<" ">" "{" "}" }
+		q::before { content: "[" open-quote "]" }
+		q::after { content: "[" close-quote "]" }
+	`)
+	// the depth past the last pair reuses it
+	if !slices.Equal(texts, []string{"<", "{", "{", "}", "}", ">", "<", ">"}) {
+		t.Fatalf("expected the quotes of each nesting depth, got %v", texts)
+	}
+}
+
+func TestContent_ATTR(t *testing.T) {
+	texts := generatedTexts(t, `<html><body><p><a href="/home">a</a><a>b</a></p></body></html>`, `
+		html, body, p { display: block }
+		a::after { content: "[" attr(href) "]" }
+	`)
+	if !slices.Equal(texts, []string{"/home", ""}) {
+		t.Fatalf("expected the attribute value or an empty string, got %v", texts)
+	}
+}
+
+func TestContent_COUNTER_STYLES(t *testing.T) {
+	styles := []string{"decimal", "decimal-leading-zero", "lower-alpha", "upper-latin", "lower-roman", "upper-roman", "disc", "none"}
+	content := `"["`
+	for _, style := range styles {
+		content += ` counter(n, ` + style + `) "|"`
+	}
+	content += ` "]"`
+
+	for _, test := range []struct {
+		value    int
+		expected []string
+	}{
+		{1, []string{"1", "01", "a", "A", "i", "I", "•", ""}},
+		{27, []string{"27", "27", "aa", "AA", "xxvii", "XXVII", "•", ""}},
+		{-1, []string{"-1", "-1", "-1", "-1", "-1", "-1", "•", ""}},
+	} {
+		texts := generatedTexts(t, `<html><body><p>a</p></body></html>`, fmt.Sprintf(`
+			html, body, p { display: block }
+			p { counter-reset: n %d }
+			p::before { content: %s }
+		`, test.value, content))
+		if len(texts) != 1 || !slices.Equal(strings.Split(texts[0], "|")[:len(styles)], test.expected) {
+			t.Fatalf("expected %v for %v, got %v", test.expected, test.value, texts)
+		}
+	}
+}
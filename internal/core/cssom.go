@@ -0,0 +1,165 @@
+package plex
+
+import (
+	"fmt"
+	"slices"
+	plex_css "visualsource/plex/internal/css"
+)
+
+/*
+A stylesheet of a document. Inserting and deleting rules restyles the document.
+
+Source: https://www.w3.org/TR/cssom-1/#the-cssstylesheet-interface
+*/
+type CSSStyleSheet struct {
+	sheet    plex_css.Stylesheet
+	document *Document
+	// one per style rule of the stylesheet, in the same order
+	rules []*CSSStyleRule
+}
+
+func createCSSStyleSheet(stylesheet plex_css.Stylesheet, document *Document) *CSSStyleSheet {
+	sheet := &CSSStyleSheet{sheet: stylesheet, document: document}
+	for range stylesheet.Rules {
+		sheet.rules = append(sheet.rules, &CSSStyleRule{parent: sheet})
+	}
+	return sheet
+}
+
+func (s *CSSStyleSheet) GetStylesheet() *plex_css.Stylesheet {
+	return &s.sheet
+}
+
+// The style rules of the stylesheet, at-rules are not part of the list.
+func (s *CSSStyleSheet) CssRules() []*CSSStyleRule {
+	return s.rules
+}
+
+// https://www.w3.org/TR/cssom-1/#dom-cssstylesheet-insertrule
+func (s *CSSStyleSheet) InsertRule(text string, index int) (int, error) {
+	index, err := s.sheet.InsertRule(text, index)
+	if err != nil {
+		return 0, err
+	}
+
+	s.rules = slices.Insert(s.rules, index, &CSSStyleRule{parent: s})
+	s.document.Restyle()
+	return index, nil
+}
+
+// https://www.w3.org/TR/cssom-1/#dom-cssstylesheet-deleterule
+func (s *CSSStyleSheet) DeleteRule(index int) error {
+	if index < 0 || index >= len(s.rules) {
+		return fmt.Errorf("rule index %d is out of range", index)
+	}
+
+	// the removed rule keeps working on its own
+	rule := s.rules[index]
+	rule.detached = s.sheet.Rules[index]
+	rule.parent = nil
+
+	if err := s.sheet.DeleteRule(index); err != nil {
+		return err
+	}
+	s.rules = slices.Delete(s.rules, index, index+1)
+	s.document.Restyle()
+	return nil
+}
+
+// https://www.w3.org/TR/cssom-1/#the-cssstylerule-interface
+type CSSStyleRule struct {
+	parent *CSSStyleSheet
+	// the rule once it was deleted from its stylesheet
+	detached plex_css.Rule
+}
+
+func (r *CSSStyleRule) GetParentStyleSheet() *CSSStyleSheet {
+	return r.parent
+}
+
+func (r *CSSStyleRule) rule() *plex_css.Rule {
+	if r.parent == nil {
+		return &r.detached
+	}
+	return &r.parent.sheet.Rules[slices.Index(r.parent.rules, r)]
+}
+
+func (r *CSSStyleRule) restyle() {
+	if r.parent != nil {
+		r.parent.document.Restyle()
+	}
+}
+
+func (r *CSSStyleRule) CssText() string {
+	return r.rule().CssText()
+}
+
+func (r *CSSStyleRule) SelectorText() string {
+	return plex_css.SerializeSelectorList(r.rule().Selector)
+}
+
+// An invalid selector leaves the rule unchanged.
+func (r *CSSStyleRule) SetSelectorText(text string) error {
+	if err := r.rule().SetSelectorText(text); err != nil {
+		return err
+	}
+	r.restyle()
+	return nil
+}
+
+func (r *CSSStyleRule) Style() *CSSStyleDeclaration {
+	return &CSSStyleDeclaration{
+		declarations: func() []plex_css.Declaration {
+			return r.rule().Block
+		},
+		update: func(block []plex_css.Declaration) {
+			r.rule().Block = block
+			r.restyle()
+		},
+	}
+}
+
+/*
+The declarations of a style rule or of a style attribute.
+
+Source: https://www.w3.org/TR/cssom-1/#the-cssstyledeclaration-interface
+*/
+type CSSStyleDeclaration struct {
+	declarations func() []plex_css.Declaration
+	update       func(block []plex_css.Declaration)
+}
+
+// Sets a property, priority is either "" or "important".
+func (s *CSSStyleDeclaration) SetProperty(name string, value string, priority string) error {
+	block, err := plex_css.SetProperty(s.declarations(), name, value, priority)
+	if err != nil {
+		return err
+	}
+	s.update(block)
+	return nil
+}
+
+func (s *CSSStyleDeclaration) GetPropertyValue(name string) string {
+	return plex_css.GetPropertyValue(s.declarations(), name)
+}
+
+func (s *CSSStyleDeclaration) GetPropertyPriority(name string) string {
+	return plex_css.GetPropertyPriority(s.declarations(), name)
+}
+
+// Removes the property and returns the value it had.
+func (s *CSSStyleDeclaration) RemoveProperty(name string) string {
+	block, value := plex_css.RemoveProperty(s.declarations(), name)
+	if len(block) != len(s.declarations()) {
+		s.update(block)
+	}
+	return value
+}
+
+func (s *CSSStyleDeclaration) Length() int {
+	return len(s.declarations())
+}
+
+func (s *CSSStyleDeclaration) CssText() string {
+	return plex_css.SerializeDeclarations(s.declarations())
+}
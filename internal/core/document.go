@@ -0,0 +1,106 @@
+package plex
+
+import (
+	plex_css "visualsource/plex/internal/css"
+)
+
+/*
+A parsed page together with the stylesheets that apply to it. Changes made through the
+CSSOM objects of the document restyle it.
+
+Source: https://dom.spec.whatwg.org/#interface-document
+*/
+type Document struct {
+	root        Node
+	styleSheets []*CSSStyleSheet
+	viewport    Viewport
+	styleTree   StyledNode
+	background  plex_css.CssColor
+	// content boxes of the query containers in the last layout
+	containerSizes map[*ElementNode]containerSize
+	// problems found by the last layout
+	Diagnostics []plex_css.Diagnostic
+	// called after the style tree is computed again, to lay out and paint the page
+	OnRestyle func(document *Document)
+}
+
+/*
+Creates a document for the DOM tree. The given stylesheets come first, followed by the
+contents of the <style> elements of the document.
+*/
+func CreateDocument(root Node, stylesheets []plex_css.Stylesheet, viewport Viewport) *Document {
+	document := &Document{root: root, viewport: viewport}
+
+	if el, ok := root.(*ElementNode); ok {
+		stylesheets = append(stylesheets, styleElementSheets(el)...)
+		adoptNodes(el, document)
+	}
+	for _, stylesheet := range stylesheets {
+		document.styleSheets = append(document.styleSheets, createCSSStyleSheet(stylesheet, document))
+	}
+
+	document.computeStyles()
+	return document
+}
+
+// Sets the document the element and its descendants belong to.
+func adoptNodes(el *ElementNode, document *Document) {
+	el.ownerDocument = document
+	for _, child := range el.children {
+		if childEl, ok := child.(*ElementNode); ok {
+			adoptNodes(childEl, document)
+		}
+	}
+}
+
+func (d *Document) GetRoot() Node {
+	return d.root
+}
+
+// https://www.w3.org/TR/cssom-1/#dom-documentorshadowroot-stylesheets
+func (d *Document) StyleSheets() []*CSSStyleSheet {
+	return d.styleSheets
+}
+
+func (d *Document) GetStyleTree() *StyledNode {
+	return &d.styleTree
+}
+
+func (d *Document) GetBackgroundColor() plex_css.CssColor {
+	return d.background
+}
+
+func (d *Document) GetViewport() Viewport {
+	return d.viewport
+}
+
+// Resizes the viewport, which restyles the document as media queries and viewport units depend on it.
+func (d *Document) SetViewport(viewport Viewport) {
+	if viewport == d.viewport {
+		return
+	}
+	d.viewport = viewport
+	d.Restyle()
+}
+
+// Computes the style tree again and lets OnRestyle know about it.
+func (d *Document) Restyle() {
+	d.computeStyles()
+	if d.OnRestyle != nil {
+		d.OnRestyle(d)
+	}
+}
+
+func (d *Document) stylesheets() []plex_css.Stylesheet {
+	stylesheets := []plex_css.Stylesheet{}
+	for _, stylesheet := range d.styleSheets {
+		stylesheets = append(stylesheets, stylesheet.sheet)
+	}
+	return stylesheets
+}
+
+func (d *Document) computeStyles() {
+	stylesheets := d.stylesheets()
+	d.styleTree = containerStyleTree(d.root, stylesheets, d.viewport, d.containerSizes)
+	d.background = canvasColor(&d.styleTree, plex_css.CSS_COLOR_KEYWORDS["white"])
+}
@@ -1,6 +1,7 @@
 package plex
 
 import (
+	"slices"
 	"strings"
 
 	plex_css "visualsource/plex/internal/css"
@@ -15,6 +16,8 @@ const (
 	NodeType_Text    NodeType = 0
 	NodeType_Element NodeType = 1
 	NodeType_Comment NodeType = 2
+	// Generated by a ::before or ::after pseudo-element, never part of the parsed document.
+	NodeType_PseudoElement NodeType = 3
 )
 
 type Node interface {
@@ -60,6 +63,12 @@ type ElementNode struct {
 	tagName  string
 	attr     AttributeMap
 	children []Node
+	// declarations of the style attribute, parsed on first use and dropped when it changes
+	inlineStyle *[]plex_css.Declaration
+	// the document restyled when the inline style changes
+	ownerDocument *Document
+	// set when the tree is styled, combinators look at ancestors and siblings
+	parent *ElementNode
 }
 
 func (n *ElementNode) QuerySelector(selector *plex_css.Selector) (*ElementNode, error) {
@@ -137,31 +146,121 @@ func (n *ElementNode) GetId() string {
 
 func (n *ElementNode) GetClassList() mapset.Set[string] {
 	class := n.GetAttribute("class")
-	items := strings.Split(class, " ")
+	items := strings.Fields(class)
 
 	return mapset.NewSet(items...)
 }
 
 // https://www.w3.org/TR/selectors-4/#match-against-element
 func (n *ElementNode) Matches(selector *plex_css.Selector) bool {
+	// selectors targeting a pseudo-element never match the element itself
+	if selector.GetPseudoElement() != "" {
+		return false
+	}
+
+	return n.matchesComplex(selector)
+}
+
+// Reports whether the selector targets the given pseudo-element of this element.
+func (n *ElementNode) MatchesPseudoElement(selector *plex_css.Selector, pseudo string) bool {
+	if selector.GetPseudoElement() != pseudo {
+		return false
+	}
+
+	return n.matchesComplex(selector)
+}
+
+// Matches the rightmost compound against the element and the compounds before it against its ancestors and siblings.
+func (n *ElementNode) matchesComplex(selector *plex_css.Selector) bool {
+	if !n.matchesCompound(selector) {
+		return false
+	}
+	if selector.Previous == nil {
+		return true
+	}
+
+	switch selector.Combinator {
+	case plex_css.Combinator_Child:
+		return n.parent != nil && n.parent.matchesComplex(selector.Previous)
+	case plex_css.Combinator_Descendant:
+		for ancestor := n.parent; ancestor != nil; ancestor = ancestor.parent {
+			if ancestor.matchesComplex(selector.Previous) {
+				return true
+			}
+		}
+	case plex_css.Combinator_NextSibling:
+		siblings := n.previousSiblings()
+		return len(siblings) > 0 && siblings[0].matchesComplex(selector.Previous)
+	case plex_css.Combinator_SubsequentSibling:
+		for _, sibling := range n.previousSiblings() {
+			if sibling.matchesComplex(selector.Previous) {
+				return true
+			}
+		}
+	}
+	// the column combinator needs tables
+	return false
+}
 
-	matchesClasses := false
-	if selector.Classes != nil {
-		matchesClasses = n.GetClassList().IsSubset(selector.Classes)
+// Returns the element siblings before the element, the closest first.
+func (n *ElementNode) previousSiblings() []*ElementNode {
+	siblings := []*ElementNode{}
+	if n.parent == nil {
+		return siblings
 	}
+	for _, child := range n.parent.children {
+		if child == Node(n) {
+			break
+		}
+		if el, ok := child.(*ElementNode); ok {
+			siblings = append([]*ElementNode{el}, siblings...)
+		}
+	}
+	return siblings
+}
 
-	matchesId := selector.Id != "" && selector.Id == n.GetId()
-	matchesTag := false
+func (n *ElementNode) matchesCompound(selector *plex_css.Selector) bool {
+	if selector.TagName != "" && selector.TagName != "*" && selector.TagName != n.tagName {
+		return false
+	}
 
-	if selector.TagName != "" && selector.TagName == n.tagName {
-		matchesTag = true
-	} else if selector.TagName == "*" {
-		matchesTag = true
+	if selector.Id != "" && selector.Id != n.GetId() {
+		return false
 	}
 
-	// TODO: match attr and psuedo class/elements
+	if selector.Classes != nil && !selector.Classes.IsSubset(n.GetClassList()) {
+		return false
+	}
+
+	// the nesting selector matches like :is() with the parent rule's selectors
+	if selector.Nesting != nil && !slices.ContainsFunc(selector.Nesting, func(parent plex_css.Selector) bool {
+		return n.Matches(&parent)
+	}) {
+		return false
+	}
 
-	return matchesTag || matchesClasses || matchesId
+	// the supported pseudo-classes need user interaction, which is not tracked
+	if len(selector.PseudoClasses) > 0 {
+		return false
+	}
+
+	// TODO: match attr
+
+	return true
+}
+
+func (n *ElementNode) GetParent() *ElementNode {
+	return n.parent
+}
+
+// Sets the parent of the descendants of the element.
+func linkParents(el *ElementNode) {
+	for _, child := range el.children {
+		if childEl, ok := child.(*ElementNode); ok {
+			childEl.parent = el
+			linkParents(childEl)
+		}
+	}
 }
 
 func (n *ElementNode) GetTagName() string {
@@ -178,6 +277,9 @@ func (n *ElementNode) GetChildren() []Node {
 
 func (n *ElementNode) SetAttribute(key string, value string) {
 	n.attr[key] = value
+	if key == "style" {
+		n.inlineStyle = nil
+	}
 }
 
 func (n *ElementNode) GetAttribute(key string) string {
@@ -186,6 +288,52 @@ func (n *ElementNode) GetAttribute(key string) string {
 
 func (n *ElementNode) RemoveAttribute(key string) {
 	delete(n.attr, key)
+	if key == "style" {
+		n.inlineStyle = nil
+	}
+}
+
+/*
+Returns the declarations of the style attribute. Invalid declarations are dropped like in
+a stylesheet.
+
+Source: https://www.w3.org/TR/css-style-attr/#syntax
+*/
+func (n *ElementNode) GetInlineStyle() []plex_css.Declaration {
+	if n.inlineStyle != nil {
+		return *n.inlineStyle
+	}
+
+	declarations := []plex_css.Declaration{}
+	if n.HasAttribute("style") {
+		parser := plex_css.CssParser{}
+		parsed, err := parser.ParseDeclarationsList(n.GetAttribute("style"))
+		if err == nil {
+			declarations = parsed
+		}
+	}
+
+	n.inlineStyle = &declarations
+	return declarations
+}
+
+// The declarations of the style attribute, changing them rewrites the attribute.
+// https://www.w3.org/TR/cssom-1/#dom-elementcssinlinestyle-style
+func (n *ElementNode) Style() *CSSStyleDeclaration {
+	return &CSSStyleDeclaration{
+		declarations: n.GetInlineStyle,
+		update: func(block []plex_css.Declaration) {
+			n.SetAttribute("style", plex_css.SerializeDeclarations(block))
+			n.inlineStyle = &block
+			if n.ownerDocument != nil {
+				n.ownerDocument.Restyle()
+			}
+		},
+	}
+}
+
+func (n *ElementNode) GetOwnerDocument() *Document {
+	return n.ownerDocument
 }
 
 func (n *ElementNode) HasAttribute(key string) bool {
@@ -230,3 +378,36 @@ func CreateCommentNode(content string) CommentNode {
 		content: content,
 	}
 }
+
+// #region-start PseudoElementNode
+
+type PseudoElementNode struct {
+	name     string
+	origin   *ElementNode
+	children []Node
+}
+
+func (n *PseudoElementNode) GetType() NodeType {
+	return NodeType_PseudoElement
+}
+
+func (n *PseudoElementNode) GetChildren() []Node {
+	return n.children
+}
+
+func (n *PseudoElementNode) GetName() string {
+	return n.name
+}
+
+// The element that generated this pseudo-element.
+func (n *PseudoElementNode) GetOrigin() *ElementNode {
+	return n.origin
+}
+
+func CreatePseudoElementNode(name string, origin *ElementNode, children []Node) PseudoElementNode {
+	return PseudoElementNode{
+		name:     name,
+		origin:   origin,
+		children: children,
+	}
+}
@@ -2,23 +2,244 @@ package plex
 
 import (
 	"fmt"
+	"reflect"
+	"strings"
+	plex_css "visualsource/plex/internal/css"
 
 	"github.com/veandco/go-sdl2/sdl"
 	"github.com/veandco/go-sdl2/ttf"
 )
 
-type FontCache map[string]*ttf.Font
+/*
+The font faces known to the engine, declared by @font-face rules. A face's file is only
+opened once some text needs it, and stays open until the cache is closed.
 
-func (c FontCache) RenderText(fontFamily string, fontSize int) {
+Source: https://www.w3.org/TR/css-fonts-4/#font-face-rule
+*/
+type FontCache struct {
+	faces []plex_css.FontFace
+	fonts map[fontKey]*ttf.Font
+	// metrics of the faces that were measured, by file
+	metrics map[string]FontMetrics
+	// files of the fonts local() sources refer to, by full font name
+	LocalFonts map[string]string
+	// opens font files, ttf.OpenFont unless replaced
+	OpenFont func(path string, size int) (*ttf.Font, error)
+}
 
+type fontKey struct {
+	path string
+	size int
 }
 
-// https://stackoverflow.com/questions/22886500/how-to-render-text-in-sdl2
-func (c FontCache) RenderTextWraped(renderer *sdl.Renderer, target *sdl.FRect, text string, color sdl.Color, fontFamily string, fontSize int, containerWidth int) error {
-	font, ok := c[fontFamily]
+func CreateFontCache() *FontCache {
+	return &FontCache{
+		fonts:      map[fontKey]*ttf.Font{},
+		metrics:    map[string]FontMetrics{},
+		LocalFonts: map[string]string{},
+		OpenFont:   ttf.OpenFont,
+	}
+}
+
+// Adds a face unless the same face is already known. Returns whether it was added.
+func (c *FontCache) RegisterFontFace(face plex_css.FontFace) bool {
+	for _, known := range c.faces {
+		if reflect.DeepEqual(known, face) {
+			return false
+		}
+	}
+	c.faces = append(c.faces, face)
+	return true
+}
+
+// Adds the @font-face rules of a stylesheet. Returns how many faces were added.
+func (c *FontCache) RegisterStylesheet(stylesheet plex_css.Stylesheet) int {
+	added := 0
+	for _, face := range stylesheet.FontFaces {
+		if c.RegisterFontFace(face) {
+			added++
+		}
+	}
+	return added
+}
+
+// Closes the opened font files, faces stay registered and are opened again when needed.
+func (c *FontCache) Close() {
+	for key, font := range c.fonts {
+		font.Close()
+		delete(c.fonts, key)
+	}
+}
+
+/*
+Selects the face used for the text. The families of the font are tried in order, then the
+initial font family; the first one with a face covering the whole text is used, otherwise
+the first one with any face.
+
+Source: https://www.w3.org/TR/css-fonts-4/#font-style-matching
+*/
+func (c *FontCache) MatchFontFace(font FontDescriptor, text string) (plex_css.FontFace, bool) {
+	var candidates []plex_css.FontFace
+	for _, family := range append(font.Families(), INITIAL_FONT.Family) {
+		faces := c.familyFaces(family)
+		if len(faces) == 0 {
+			continue
+		}
+
+		faces = matchFontStyle(faces, font.Italic)
+		faces = matchFontWeight(faces, font.Weight)
+
+		// later faces take precedence
+		for i := len(faces) - 1; i >= 0; i-- {
+			if coversText(&faces[i], text) {
+				return faces[i], true
+			}
+		}
+		if candidates == nil {
+			candidates = faces
+		}
+	}
+	if len(candidates) == 0 {
+		return plex_css.FontFace{}, false
+	}
+
+	first := []rune(text)[0]
+	for i := len(candidates) - 1; i >= 0; i-- {
+		if candidates[i].Covers(first) {
+			return candidates[i], true
+		}
+	}
+	return candidates[len(candidates)-1], true
+}
+
+func (c *FontCache) familyFaces(family string) []plex_css.FontFace {
+	faces := []plex_css.FontFace{}
+	for _, face := range c.faces {
+		if strings.EqualFold(face.Family, family) {
+			faces = append(faces, face)
+		}
+	}
+	return faces
+}
+
+func coversText(face *plex_css.FontFace, text string) bool {
+	for _, c := range text {
+		if !face.Covers(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// Italic text prefers italic faces, then oblique ones; other text prefers the opposite order.
+func matchFontStyle(faces []plex_css.FontFace, italic bool) []plex_css.FontFace {
+	order := []string{"normal", "oblique", "italic"}
+	if italic {
+		order = []string{"italic", "oblique", "normal"}
+	}
+
+	for _, style := range order {
+		matching := []plex_css.FontFace{}
+		for _, face := range faces {
+			if face.Style == style {
+				matching = append(matching, face)
+			}
+		}
+		if len(matching) > 0 {
+			return matching
+		}
+	}
+	return faces
+}
+
+/*
+Keeps the faces closest to the weight. Weights between 400 and 500 look for heavier faces up
+to 500 first, lighter weights look for lighter faces first and heavier weights for heavier ones.
+*/
+func matchFontWeight(faces []plex_css.FontFace, weight int) []plex_css.FontFace {
+	type rank struct{ tier, distance int }
+	rankOf := func(face plex_css.FontFace) rank {
+		low, high := face.Weight[0], face.Weight[1]
+		heavier := low > weight
+		switch {
+		case weight >= low && weight <= high:
+			return rank{0, 0}
+		case weight >= 400 && weight <= 500:
+			if heavier && low <= 500 {
+				return rank{1, low - weight}
+			} else if !heavier {
+				return rank{2, weight - high}
+			}
+			return rank{3, low - weight}
+		case weight < 400 && !heavier, weight > 500 && heavier:
+			return rank{1, max(low-weight, weight-high)}
+		}
+		return rank{2, max(low-weight, weight-high)}
+	}
 
+	best := rankOf(faces[0])
+	for _, face := range faces[1:] {
+		if r := rankOf(face); r.tier < best.tier || (r.tier == best.tier && r.distance < best.distance) {
+			best = r
+		}
+	}
+
+	matching := []plex_css.FontFace{}
+	for _, face := range faces {
+		if rankOf(face) == best {
+			matching = append(matching, face)
+		}
+	}
+	return matching
+}
+
+// Opens the first source of the face that can be loaded, the font is kept for later use.
+func (c *FontCache) loadFontFace(face *plex_css.FontFace, size int) (*ttf.Font, string, error) {
+	for _, source := range face.Sources {
+		path := source.Url
+		if source.Local != "" {
+			path = c.LocalFonts[source.Local]
+		}
+		if path == "" || !source.IsSupported() {
+			continue
+		}
+
+		key := fontKey{path: path, size: size}
+		if font, ok := c.fonts[key]; ok {
+			return font, path, nil
+		}
+		font, err := c.OpenFont(path, size)
+		if err != nil {
+			continue
+		}
+		c.fonts[key] = font
+		return font, path, nil
+	}
+	return nil, "", fmt.Errorf("no source of font family '%s' could be loaded", face.Family)
+}
+
+// Returns the font the text is rendered with, opening its file when it is first needed.
+func (c *FontCache) Font(font FontDescriptor, text string) (*ttf.Font, error) {
+	if c == nil {
+		return nil, fmt.Errorf("no font cache")
+	}
+	face, ok := c.MatchFontFace(font, text)
 	if !ok {
-		return fmt.Errorf("no font family")
+		return nil, fmt.Errorf("no font face for font family '%s'", font.Family)
+	}
+	f, _, err := c.loadFontFace(&face, max(int(font.Size), 1))
+	return f, err
+}
+
+func (c *FontCache) RenderText(fontFamily string, fontSize int) {
+
+}
+
+// https://stackoverflow.com/questions/22886500/how-to-render-text-in-sdl2
+func (c *FontCache) RenderTextWraped(renderer *sdl.Renderer, target *sdl.FRect, text string, color sdl.Color, fontDescriptor FontDescriptor, containerWidth int) error {
+	font, err := c.Font(fontDescriptor, text)
+	if err != nil {
+		return err
 	}
 
 	surface, err := font.RenderUTF8Blended(text, color)
@@ -33,22 +254,67 @@ func (c FontCache) RenderTextWraped(renderer *sdl.Renderer, target *sdl.FRect, t
 	}
 	defer texture.Destroy()
 
-	source := sdl.Rect{}
-	if err = renderer.CopyF(texture, &source, target); err != nil {
+	if err = renderer.CopyF(texture, nil, target); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (c FontCache) LoadLocalFont(filePath string, familyName string, fontSize int) error {
-	font, err := ttf.OpenFont(filePath, fontSize)
+// Registers a font file as a face of the family with the initial descriptors.
+func (c *FontCache) LoadLocalFont(filePath string, familyName string, fontSize int) error {
+	font, err := c.OpenFont(filePath, fontSize)
 	if err != nil {
 		return err
 	}
-	defer font.Close()
+	c.fonts[fontKey{path: filePath, size: fontSize}] = font
 
-	c[familyName] = font
+	c.RegisterFontFace(plex_css.FontFace{
+		Family:  familyName,
+		Sources: []plex_css.FontFaceSource{{Url: filePath}},
+		Weight:  [2]int{400, 400},
+		Style:   "normal",
+		Display: "auto",
+	})
 
 	return nil
 }
+
+// Size fonts are opened at to read their metrics.
+const metricsFontSize = 100
+
+// Reads the metrics of the font-relative units from the face of the font, families without a face use the approximate metrics.
+func (c *FontCache) MeasureFont(font FontDescriptor) FontMetrics {
+	face, ok := c.MatchFontFace(font, "x")
+	if !ok {
+		return APPROXIMATE_FONT_METRICS
+	}
+	f, path, err := c.loadFontFace(&face, metricsFontSize)
+	if err != nil {
+		return APPROXIMATE_FONT_METRICS
+	}
+
+	if metrics, ok := c.metrics[path]; ok {
+		return metrics
+	}
+	metrics := measureTtfFont(f)
+	c.metrics[path] = metrics
+	return metrics
+}
+
+// The ideographic advance keeps its 1em fallback, SDL_ttf can not tell whether a font provides the glyph.
+func measureTtfFont(f *ttf.Font) FontMetrics {
+	metrics := APPROXIMATE_FONT_METRICS
+
+	if x, err := f.GlyphMetrics('x'); err == nil && x.MaxY > 0 {
+		metrics.XHeight = float32(x.MaxY) / metricsFontSize
+	}
+	if zero, err := f.GlyphMetrics('0'); err == nil && zero.Advance > 0 {
+		metrics.ZeroAdvance = float32(zero.Advance) / metricsFontSize
+	}
+	if capital, err := f.GlyphMetrics('H'); err == nil && capital.MaxY > 0 {
+		metrics.CapHeight = float32(capital.MaxY) / metricsFontSize
+	}
+
+	return metrics
+}
@@ -2,6 +2,7 @@ package plex
 
 import (
 	"fmt"
+	"slices"
 	"unicode"
 )
 
@@ -12,6 +13,11 @@ var EQUAL = [1]rune{'='}
 var ELEMENT_CLOSED_BRACKET = [2]rune{'<', '/'}
 var COMMENT_START = [4]rune{'<', '!', '-', '-'}
 var COMMENT_END = [3]rune{'-', '-', '>'}
+var DOCTYPE_START = [2]rune{'<', '!'}
+
+// elements without content or an end tag
+// https://html.spec.whatwg.org/multipage/syntax.html#void-elements
+var VOID_ELEMENTS = []string{"area", "base", "br", "col", "embed", "hr", "img", "input", "link", "meta", "source", "track", "wbr"}
 
 type HtmlParser struct {
 	parser Parser
@@ -46,6 +52,9 @@ func (hp *HtmlParser) parseNode() (Node, error) {
 	if hp.parser.StartsWith(COMMENT_START[:]) {
 		return hp.parseComment()
 	}
+	if hp.parser.StartsWith(DOCTYPE_START[:]) {
+		return hp.parseDoctype()
+	}
 	if hp.parser.StartsWith(OPEN_BRACKET[:]) {
 		return hp.parseElement()
 	}
@@ -74,6 +83,12 @@ func (hp *HtmlParser) parseComment() (Node, error) {
 	return &node, nil
 }
 
+// The doctype only selects the rendering mode, it does not produce a node.
+func (hp *HtmlParser) parseDoctype() (Node, error) {
+	hp.parser.ConsumeWhile(func(r rune) bool { return r != CLOSED_BRACKET })
+	return nil, hp.parser.ExpectRune(CLOSED_BRACKET)
+}
+
 func (hp *HtmlParser) parseElement() (Node, error) {
 
 	err := hp.parser.Expect(OPEN_BRACKET[:])
@@ -89,11 +104,21 @@ func (hp *HtmlParser) parseElement() (Node, error) {
 		return nil, err
 	}
 
+	// the slash of a self-closing tag is ignored
+	if hp.parser.NextChar() == '/' {
+		hp.parser.ConsumeChar()
+	}
+
 	err = hp.parser.ExpectRune(CLOSED_BRACKET)
 	if err != nil {
 		return nil, err
 	}
 
+	if slices.Contains(VOID_ELEMENTS, string(tagName)) {
+		result := CreateElementNode(string(tagName), attrs, []Node{})
+		return &result, nil
+	}
+
 	children, err := hp.parseNodes()
 
 	if err != nil {
@@ -129,12 +154,24 @@ func (hp *HtmlParser) parseText() (Node, error) {
 }
 
 func (hp *HtmlParser) ParseAttr() (string, string, error) {
-	name := hp.ParseName()
+	name := hp.parser.ConsumeWhile(func(r rune) bool {
+		return !unicode.IsSpace(r) && r != '=' && r != '>' && r != '/' && r != '"' && r != '\''
+	})
+	if len(name) == 0 {
+		return "", "", fmt.Errorf("was expecting an attribute name but found '%s'", string(hp.parser.NextChar()))
+	}
+
+	// an attribute without a value is the empty string
+	hp.parser.ConsumeWhitespace()
+	if hp.parser.NextChar() != '=' {
+		return string(name), "", nil
+	}
 
 	err := hp.parser.Expect(EQUAL[:])
 	if err != nil {
 		return "", "", err
 	}
+	hp.parser.ConsumeWhitespace()
 
 	value, err := hp.ParseAttrValue()
 
@@ -168,7 +205,7 @@ func (hp *HtmlParser) ParseAttributes() (AttributeMap, error) {
 	for {
 		hp.parser.ConsumeWhitespace()
 
-		if hp.parser.NextChar() == '>' {
+		if hp.parser.NextChar() == '>' || hp.parser.NextChar() == '/' {
 			break
 		}
 
@@ -199,7 +236,9 @@ func (hp *HtmlParser) parseNodes() ([]Node, error) {
 			return nil, err
 		}
 
-		nodes = append(nodes, node)
+		if node != nil {
+			nodes = append(nodes, node)
+		}
 
 	}
 
@@ -0,0 +1,69 @@
+package plex_test
+
+import (
+	"testing"
+	plex "visualsource/plex/internal/core"
+)
+
+func parseHtml(t *testing.T, document string) *plex.ElementNode {
+	parser := plex.HtmlParser{}
+	dom, err := parser.Parse(document)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	root, ok := dom.(*plex.ElementNode)
+	if !ok {
+		t.Fatalf("expected an element, got %v", dom)
+	}
+	return root
+}
+
+func TestHtmlParser_DOCTYPE(t *testing.T) {
+	root := parseHtml(t, "<!DOCTYPE html><html><body></body></html>")
+
+	if root.GetTagName() != "html" || len(root.GetChildren()) != 1 {
+		t.Fatalf("expected the doctype to be skipped, got %v", root)
+	}
+}
+
+func TestHtmlParser_VOID_ELEMENTS(t *testing.T) {
+	root := parseHtml(t, `<p>a<br>b<img src="x.png"/>c<hr /></p>`)
+
+	tags := []string{}
+	for _, child := range root.GetChildren() {
+		if el, ok := child.(*plex.ElementNode); ok {
+			tags = append(tags, el.GetTagName())
+			if len(el.GetChildren()) != 0 {
+				t.Fatalf("expected <%s> to have no children", el.GetTagName())
+			}
+		}
+	}
+	if len(root.GetChildren()) != 6 || len(tags) != 3 || tags[0] != "br" || tags[1] != "img" || tags[2] != "hr" {
+		t.Fatalf("expected the void elements to be siblings of the text, got %v", root.GetChildren())
+	}
+}
+
+func TestHtmlParser_ATTRIBUTES(t *testing.T) {
+	root := parseHtml(t, `<input type = "checkbox" checked disabled value='a b'>`)
+
+	expected := map[string]string{"type": "checkbox", "checked": "", "disabled": "", "value": "a b"}
+	for name, value := range expected {
+		if !root.HasAttribute(name) || root.GetAttribute(name) != value {
+			t.Fatalf("expected %s=%q, got %q", name, value, root.GetAttribute(name))
+		}
+	}
+}
+
+func TestHtmlParser_SELF_CLOSING(t *testing.T) {
+	root := parseHtml(t, `<div><span class="a"/>text</span></div>`)
+
+	// the slash does not close elements that are not void
+	if len(root.GetChildren()) != 1 {
+		t.Fatalf("expected the slash of the self-closing tag to be ignored, got %v", root.GetChildren())
+	}
+	span, ok := root.GetChildren()[0].(*plex.ElementNode)
+	if !ok || span.GetTagName() != "span" || span.GetAttribute("class") != "a" || span.GetTextContent() != "text" {
+		t.Fatalf("expected a span with its class and text, got %v", root.GetChildren()[0])
+	}
+}
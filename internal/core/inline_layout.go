@@ -0,0 +1,333 @@
+package plex
+
+import (
+	"strings"
+	plex_css "visualsource/plex/internal/css"
+
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// A run of text placed on a single line box.
+type TextFragment struct {
+	Text string
+	// range of runes of the text node covered by this fragment
+	Offset int
+	Length int
+	Box    sdl.FRect
+	Line   int
+	Style  TextStyle
+	// the ::first-line or ::first-letter styles applied to this fragment
+	Pseudo plex_css.CssPropertyMap
+	// background painted behind the fragment by its pseudo-element
+	Background plex_css.CssColor
+}
+
+// State of an inline formatting context while its line boxes are filled.
+// https://www.w3.org/TR/CSS2/visuren.html#inline-formatting
+type inlineContext struct {
+	measurer   TextMeasurer
+	left       float32
+	right      float32
+	x          float32
+	y          float32
+	lineHeight float32
+	line       int
+	floats     []sdl.FRect
+	// styles of the owning block, consumed by the first line and the first letter
+	firstLine   plex_css.CssPropertyMap
+	firstLetter plex_css.CssPropertyMap
+}
+
+func createInlineContext(content sdl.FRect, firstLine plex_css.CssPropertyMap, firstLetter plex_css.CssPropertyMap) inlineContext {
+	return inlineContext{
+		measurer:    DefaultTextMeasurer,
+		left:        content.X,
+		right:       content.X + content.W,
+		x:           content.X,
+		y:           content.Y,
+		line:        0,
+		firstLine:   firstLine,
+		firstLetter: firstLetter,
+	}
+}
+
+// The left edge of the current line once floats are taken into account.
+func (c *inlineContext) lineLeft() float32 {
+	left := c.left
+	for _, float := range c.floats {
+		if c.y >= float.Y && c.y < float.Y+float.H {
+			left = MaxFloat32(left, float.X+float.W)
+		}
+	}
+	return left
+}
+
+func (c *inlineContext) newLine() {
+	c.y += c.lineHeight
+	c.lineHeight = 0
+	c.line++
+	c.x = c.lineLeft()
+}
+
+// The height taken by all line boxes and floats.
+func (c *inlineContext) height(top float32) float32 {
+	bottom := c.y + c.lineHeight
+	for _, float := range c.floats {
+		bottom = MaxFloat32(bottom, float.Y+float.H)
+	}
+	return bottom - top
+}
+
+func (c *inlineContext) lineStyle(style TextStyle, context lengthContext) (TextStyle, plex_css.CssPropertyMap) {
+	if c.line == 0 && len(c.firstLine) > 0 {
+		return style.Apply(c.firstLine, context), c.firstLine
+	}
+	return style, nil
+}
+
+// Lays out the inline-level children of an anonymous block into line boxes.
+func (l *LayoutBox) layoutAnonymousBlock(containing Dimensions) {
+	l.dimensions.Content.X = containing.Content.X
+	l.dimensions.Content.Y = containing.Content.Y + containing.Content.H
+	l.dimensions.Content.W = containing.Content.W
+
+	ctx := createInlineContext(l.dimensions.Content, l.firstLine, l.firstLetter)
+	for i := range l.children {
+		l.children[i].layoutInlineLevel(&ctx)
+	}
+
+	l.dimensions.Content.H = ctx.height(l.dimensions.Content.Y)
+}
+
+// Lays out an inline box that is not inside a block container.
+func (l *LayoutBox) layoutInline(containing Dimensions) {
+	if l.node.IsNone() {
+		return
+	}
+
+	ctx := createInlineContext(sdl.FRect{
+		X: containing.Content.X,
+		Y: containing.Content.Y + containing.Content.H,
+		W: containing.Content.W,
+	}, nil, nil)
+
+	l.layoutInlineLevel(&ctx)
+}
+
+func (l *LayoutBox) layoutInlineLevel(ctx *inlineContext) {
+	if l.node.IsNone() {
+		return
+	}
+
+	style := l.node.Unwrap()
+	switch node := style.node.(type) {
+	case *TextNode:
+		l.layoutText(ctx, node.GetTextContent())
+		l.dimensions.Content = fragmentsBounds(l.fragments)
+	case *ElementNode, *PseudoElementNode:
+		if l.isOutsideMarker() {
+			l.layoutOutsideMarker(ctx)
+			return
+		}
+
+		l.calcuateInlineWidth(ctx.right - ctx.left)
+		l.calcuateInlinePosition(ctx.right - ctx.left)
+
+		ctx.x += l.dimensions.Margin.Left + l.dimensions.Border.Left + l.dimensions.Padding.Left
+		startX := ctx.x
+		startY := ctx.y
+
+		for i := range l.children {
+			l.children[i].layoutInlineLevel(ctx)
+		}
+
+		l.dimensions.Content.X = startX
+		l.dimensions.Content.Y = startY
+		if ctx.y == startY {
+			l.dimensions.Content.W = ctx.x - startX
+		} else {
+			// the box was split across lines, use the bounding box of its lines
+			l.dimensions.Content.X = ctx.left
+			l.dimensions.Content.W = ctx.right - ctx.left
+		}
+		l.dimensions.Content.H = ctx.y + MaxFloat32(ctx.lineHeight, l.textStyle.Font.Size) - startY
+
+		ctx.x += l.dimensions.Padding.Right + l.dimensions.Border.Right + l.dimensions.Margin.Right
+	}
+}
+
+// Breaks the text into words and places them on line boxes, wrapping when a word does not fit.
+func (l *LayoutBox) layoutText(ctx *inlineContext, text string) {
+	l.fragments = []TextFragment{}
+	runes := []rune(text)
+
+	start := 0
+	for start < len(runes) {
+		// skip collapsible white space at the start of a line
+		if ctx.x == ctx.lineLeft() {
+			for start < len(runes) && isCollapsibleSpace(runes[start]) {
+				start++
+			}
+			if start >= len(runes) {
+				return
+			}
+		}
+
+		if len(ctx.firstLetter) > 0 {
+			letter, _ := splitFirstLetter(string(runes[start:]))
+			firstLetter := ctx.firstLetter
+			ctx.firstLetter = nil
+			if letter != "" {
+				l.layoutFirstLetter(ctx, letter, start, firstLetter)
+				start += len([]rune(letter))
+				continue
+			}
+		}
+
+		end := start
+		for end < len(runes) && !isCollapsibleSpace(runes[end]) {
+			end++
+		}
+		for end < len(runes) && isCollapsibleSpace(runes[end]) {
+			end++
+		}
+
+		word := collapseWhiteSpace(string(runes[start:end]))
+		style, pseudo := ctx.lineStyle(l.textStyle, l.lengthContext())
+		w, h := ctx.measurer.MeasureText(word, style)
+
+		if ctx.x+w > ctx.right && ctx.x > ctx.lineLeft() {
+			ctx.newLine()
+			continue
+		}
+
+		l.appendFragment(ctx, word, start, end, style, pseudo, w, h)
+		start = end
+	}
+}
+
+func (l *LayoutBox) appendFragment(ctx *inlineContext, text string, offset int, end int, style TextStyle, pseudo plex_css.CssPropertyMap, w float32, h float32) {
+	// merge with the previous fragment when it sits on the same line
+	if last := len(l.fragments) - 1; last >= 0 && l.fragments[last].Line == ctx.line && l.fragments[last].Pseudo == nil && pseudo == nil {
+		l.fragments[last].Text += text
+		l.fragments[last].Length = end - l.fragments[last].Offset
+		l.fragments[last].Box.W += w
+	} else {
+		l.fragments = append(l.fragments, TextFragment{
+			Text:       text,
+			Offset:     offset,
+			Length:     end - offset,
+			Box:        sdl.FRect{X: ctx.x, Y: ctx.y, W: w, H: h},
+			Line:       ctx.line,
+			Style:      style,
+			Pseudo:     pseudo,
+			Background: computeStyle(pseudo, nil, l.lengthContext()).BackgroundColor,
+		})
+	}
+
+	ctx.x += w
+	ctx.lineHeight = MaxFloat32(ctx.lineHeight, h)
+}
+
+/*
+Places the ::first-letter of the block. A floated first letter becomes a drop cap
+that the following lines wrap around.
+
+Source: https://www.w3.org/TR/css-pseudo-4/#first-letter-pseudo
+*/
+func (l *LayoutBox) layoutFirstLetter(ctx *inlineContext, letter string, offset int, props plex_css.CssPropertyMap) {
+	style, _ := ctx.lineStyle(l.textStyle, l.lengthContext())
+	style = style.Apply(props, l.lengthContext())
+	w, h := ctx.measurer.MeasureText(letter, style)
+
+	letterStyle := computeStyle(props, nil, l.lengthContext())
+	width := ctx.right - ctx.left
+	padding := letterStyle.Padding.Left.Resolve(width)
+	margin := letterStyle.Margin.Left.Resolve(width)
+
+	length := len([]rune(letter))
+	if letterStyle.Float == FloatType_None {
+		l.appendFragment(ctx, letter, offset, offset+length, style, props, w, h)
+		return
+	}
+
+	box := sdl.FRect{X: ctx.lineLeft() + margin + padding, Y: ctx.y + margin + padding, W: w, H: h}
+	l.fragments = append(l.fragments, TextFragment{
+		Text:       letter,
+		Offset:     offset,
+		Length:     length,
+		Box:        box,
+		Line:       ctx.line,
+		Style:      style,
+		Pseudo:     props,
+		Background: letterStyle.BackgroundColor,
+	})
+
+	ctx.floats = append(ctx.floats, sdl.FRect{
+		X: box.X - margin - padding,
+		Y: box.Y - margin - padding,
+		W: box.W + (margin+padding)*2,
+		H: box.H + (margin+padding)*2,
+	})
+	ctx.x = ctx.lineLeft()
+}
+
+// https://www.w3.org/TR/css-lists-3/#list-style-position-property
+func (l *LayoutBox) isOutsideMarker() bool {
+	style := l.node.Unwrap()
+	pseudo, ok := style.node.(*PseudoElementNode)
+	if !ok || pseudo.GetName() != plex_css.PseudoElement_Marker {
+		return false
+	}
+
+	return !style.style.ListStyleInside
+}
+
+// Outside markers are placed in the margin of the list item and do not take up space on the line.
+func (l *LayoutBox) layoutOutsideMarker(ctx *inlineContext) {
+	marker := createInlineContext(sdl.FRect{X: ctx.left, Y: ctx.y, W: ctx.right - ctx.left}, nil, nil)
+	for i := range l.children {
+		l.children[i].layoutInlineLevel(&marker)
+	}
+
+	width := marker.x - marker.left
+	for i := range l.children {
+		for j := range l.children[i].fragments {
+			l.children[i].fragments[j].Box.X -= width
+		}
+	}
+
+	l.dimensions.Content = sdl.FRect{X: ctx.left - width, Y: ctx.y, W: width, H: marker.height(ctx.y)}
+	ctx.lineHeight = MaxFloat32(ctx.lineHeight, l.dimensions.Content.H)
+}
+
+func fragmentsBounds(fragments []TextFragment) sdl.FRect {
+	if len(fragments) == 0 {
+		return sdl.FRect{}
+	}
+
+	bounds := fragments[0].Box
+	for _, fragment := range fragments[1:] {
+		right := MaxFloat32(bounds.X+bounds.W, fragment.Box.X+fragment.Box.W)
+		bottom := MaxFloat32(bounds.Y+bounds.H, fragment.Box.Y+fragment.Box.H)
+		bounds.X = MinFloat32(bounds.X, fragment.Box.X)
+		bounds.Y = MinFloat32(bounds.Y, fragment.Box.Y)
+		bounds.W = right - bounds.X
+		bounds.H = bottom - bounds.Y
+	}
+	return bounds
+}
+
+func isCollapsibleSpace(r rune) bool {
+	return r == ' ' || r == '\t' || r == '\n' || r == '\r'
+}
+
+// https://www.w3.org/TR/css-text-3/#white-space-phase-1
+func collapseWhiteSpace(text string) string {
+	fields := strings.Fields(text)
+	result := strings.Join(fields, " ")
+	if len(text) > 0 && isCollapsibleSpace(rune(text[len(text)-1])) {
+		result += " "
+	}
+	return result
+}
@@ -16,6 +16,15 @@ type LayoutBox struct {
 	boxType    BoxType
 	node       optional.Option[StyledNode]
 	children   []LayoutBox
+	// inherited text properties used by the inline layout
+	textStyle TextStyle
+	// ::selection styles for the text inside this box
+	selection plex_css.CssPropertyMap
+	// ::first-line and ::first-letter styles of the block owning this anonymous block
+	firstLine   plex_css.CssPropertyMap
+	firstLetter plex_css.CssPropertyMap
+	// line fragments of a text box
+	fragments []TextFragment
 }
 
 func (l *LayoutBox) layout(containing Dimensions) {
@@ -24,94 +33,38 @@ func (l *LayoutBox) layout(containing Dimensions) {
 		l.layoutBlock(containing)
 	case BoxType_Inline:
 		l.layoutInline(containing)
+	case BoxType_AnonymousBlock:
+		l.layoutAnonymousBlock(containing)
 	}
 }
 
-func (l *LayoutBox) layoutInline(containing Dimensions) {
-	if l.node.IsNone() {
-		return
-	}
-	l.calcuateInlineWidth(containing)
-	l.calcuateInlinePosition(containing)
-	l.calcuateInlineHeight()
-}
-
 // https://www.w3.org/TR/CSS2/visudet.html#inline-width
-func (l *LayoutBox) calcuateInlineWidth(containing Dimensions) {
+func (l *LayoutBox) calcuateInlineWidth(containingWidth float32) {
 	// The 'width' property does not apply.
 	// A computed value of 'auto' for 'margin-left' or 'margin-right' becomes a used value of '0'.
-	style := l.node.Unwrap()
-
-	zero := optional.Some[plex_css.CssValue](&plex_css.CssDimention{Value: 0, Unit: plex_css.CssUnit_PX})
-	marginLeft := style.props.ResolveLookupToCssValue("margin-left", "margin").Or(zero).Unwrap()
-	marginRight := style.props.ResolveLookupToCssValue("margin-right", "margin").Or(zero).Unwrap()
-	borderLeft := style.props.ResolveLookupToCssValue("border-left-width", "border-width").Unwrap()
-	borderRight := style.props.ResolveLookupToCssValue("border-right-width", "border-width").Unwrap()
-	paddingLeft := style.props.ResolveLookupToCssValue("padding-left", "padding").Unwrap()
-	paddingRight := style.props.ResolveLookupToCssValue("padding-right", "padding").Unwrap()
-
-	items := []*plex_css.CssValue{&marginLeft, &marginRight, &borderLeft, &borderRight, &paddingLeft, &paddingRight}
-	var total float32 = 0.0
-	for _, i := range items {
-		if plex_css.IsCssValue(*i, plex_css.TCssValue_DIMENTION) {
-			v := (*i).(*plex_css.CssDimention)
-			total += v.AsPx()
-		}
-	}
-
-	//underflow := containing.Content.W - total
-
-	if plex_css.IsCssKeyword(marginLeft, "auto") {
-		marginLeft = &plex_css.CssDimention{
-			Value: 0.0,
-			Unit:  plex_css.CssUnit_PX,
-		}
-	}
-	if plex_css.IsCssKeyword(marginRight, "auto") {
-		marginRight = &plex_css.CssDimention{
-			Value: 0.0,
-			Unit:  plex_css.CssUnit_PX,
-		}
-	}
-
-	l.dimensions.Content.W = total
-	l.dimensions.Padding.Left = plex_css.ResolveDimentionToPXFloat(paddingLeft)
-	l.dimensions.Padding.Right = plex_css.ResolveDimentionToPXFloat(paddingRight)
-	l.dimensions.Border.Left = plex_css.ResolveDimentionToPXFloat(borderLeft)
-	l.dimensions.Border.Right = plex_css.ResolveDimentionToPXFloat(borderRight)
-	l.dimensions.Margin.Left = plex_css.ResolveDimentionToPXFloat(marginLeft)
-	l.dimensions.Margin.Right = plex_css.ResolveDimentionToPXFloat(marginRight)
-}
-
-func (l *LayoutBox) calcuateInlineHeight() {
-	// The 'height' property does not apply.
-	// based on font size
+	style := l.node.Unwrap().style
+
+	l.dimensions.Padding.Left = style.Padding.Left.Resolve(containingWidth)
+	l.dimensions.Padding.Right = style.Padding.Right.Resolve(containingWidth)
+	l.dimensions.Border.Left = style.Border.Left
+	l.dimensions.Border.Right = style.Border.Right
+	l.dimensions.Margin.Left = style.Margin.Left.Resolve(containingWidth)
+	l.dimensions.Margin.Right = style.Margin.Right.Resolve(containingWidth)
 }
 
-func (l *LayoutBox) calcuateInlinePosition(containing Dimensions) {
-	style := l.node.Unwrap()
-	zero := plex_css.CreateCssDimention(0, plex_css.CssUnit_PX)
-
-	l.dimensions.Margin.Top = style.props.ResolveLookupAsDimention("margin-top", "margin").Or(zero).UnwrapAsPtr().AsPx()
-	l.dimensions.Margin.Bottom = style.props.ResolveLookupAsDimention("margin-bottom", "margin").Or(zero).UnwrapAsPtr().AsPx()
+// Vertical margins and paddings are percentages of the containing block's width.
+// https://www.w3.org/TR/CSS2/box.html#margin-properties
+func (l *LayoutBox) calcuateInlinePosition(containingWidth float32) {
+	style := l.node.Unwrap().style
 
-	l.dimensions.Border.Top = style.props.ResolveLookupAsDimention("border-top-width", "border-width").Or(zero).UnwrapAsPtr().AsPx()
-	l.dimensions.Border.Bottom = style.props.ResolveLookupAsDimention("border-bottom-width", "border-with").Or(zero).UnwrapAsPtr().AsPx()
+	l.dimensions.Margin.Top = style.Margin.Top.Resolve(containingWidth)
+	l.dimensions.Margin.Bottom = style.Margin.Bottom.Resolve(containingWidth)
 
-	l.dimensions.Padding.Top = style.props.ResolveLookupAsDimention("padding-top", "padding").Or(zero).UnwrapAsPtr().AsPx()
-	l.dimensions.Padding.Bottom = style.props.ResolveLookupAsDimention("padding-bottom", "padding").Or(zero).UnwrapAsPtr().AsPx()
-
-	l.dimensions.Content.H = 32
-	/*l.dimensions.Content.X = containing.Content.X +
-		l.dimensions.Margin.Left +
-		l.dimensions.Border.Left +
-		l.dimensions.Padding.Left
+	l.dimensions.Border.Top = style.Border.Top
+	l.dimensions.Border.Bottom = style.Border.Bottom
 
-	l.dimensions.Content.Y = containing.Content.H +
-		containing.Content.Y +
-		l.dimensions.Margin.Top +
-		l.dimensions.Border.Top +
-		l.dimensions.Padding.Top*/
+	l.dimensions.Padding.Top = style.Padding.Top.Resolve(containingWidth)
+	l.dimensions.Padding.Bottom = style.Padding.Bottom.Resolve(containingWidth)
 }
 
 // Lay out a block-level element and its descendants.
@@ -127,16 +80,18 @@ func (l *LayoutBox) layoutBlock(containing Dimensions) {
 }
 
 func (l *LayoutBox) calculateBlockHeight() {
-	style := l.node.Unwrap()
-	prop := style.props.GetProp("height")
-	if prop.IsNone() {
-		return
-	}
+	style := l.node.Unwrap().style
+	height := style.Height
 
-	c := prop.Unwrap()
+	// size containment lays the box out as if it had no content
+	// https://www.w3.org/TR/css-contain-2/#containment-size
+	if style.ContainerType == ContainerType_Size {
+		l.dimensions.Content.H = 0
+	}
 
-	if i, ok := c.GetValue().(*plex_css.CssDimention); ok {
-		l.dimensions.Content.H = i.AsPx()
+	// percentages of a containing block with an auto height behave as auto
+	if !height.Auto && !height.Percent && height.Calc == nil {
+		l.dimensions.Content.H = height.Value
 	}
 }
 
@@ -148,17 +103,7 @@ func (l *LayoutBox) layoutBlockChildren() {
 }
 
 func (l *LayoutBox) calculateBlockPosition(containing Dimensions) {
-	style := l.node.Unwrap()
-	zero := plex_css.CreateCssDimention(0, plex_css.CssUnit_PX)
-
-	l.dimensions.Margin.Top = style.props.ResolveLookupAsDimention("margin-top", "margin").Or(zero).UnwrapAsPtr().AsPx()
-	l.dimensions.Margin.Bottom = style.props.ResolveLookupAsDimention("margin-bottom", "margin").Or(zero).UnwrapAsPtr().AsPx()
-
-	l.dimensions.Border.Top = style.props.ResolveLookupAsDimention("border-top-width", "border-width").Or(zero).UnwrapAsPtr().AsPx()
-	l.dimensions.Border.Bottom = style.props.ResolveLookupAsDimention("border-bottom-width", "border-with").Or(zero).UnwrapAsPtr().AsPx()
-
-	l.dimensions.Padding.Top = style.props.ResolveLookupAsDimention("padding-top", "padding").Or(zero).UnwrapAsPtr().AsPx()
-	l.dimensions.Padding.Bottom = style.props.ResolveLookupAsDimention("padding-bottom", "padding").Or(zero).UnwrapAsPtr().AsPx()
+	l.calcuateInlinePosition(containing.Content.W)
 
 	l.dimensions.Content.X = containing.Content.X +
 		l.dimensions.Margin.Left +
@@ -172,122 +117,61 @@ func (l *LayoutBox) calculateBlockPosition(containing Dimensions) {
 		l.dimensions.Padding.Top
 }
 
+// https://www.w3.org/TR/CSS2/visudet.html#blockwidth
 func (l *LayoutBox) calculateBlockWidth(containing Dimensions) {
-	style := l.node.Unwrap()
-
-	var width plex_css.CssValue
-	widthDec := style.props.GetProp("width") //.Or(optional.Some[CssValue]("auto")).Unwrap()
-	if widthDec.IsSome() {
-		v := widthDec.Unwrap()
-		width = v.GetValueAt(0).Or(optional.Some[plex_css.CssValue](&plex_css.CssKeyword{Value: "auto"})).Unwrap()
-	} else {
-		width = &plex_css.CssKeyword{Value: "auto"}
-	}
-
-	zero := optional.Some[plex_css.CssValue](&plex_css.CssDimention{Value: 0, Unit: plex_css.CssUnit_PX})
-
-	marginLeft := style.props.ResolveLookupToCssValue("margin-left", "margin").Or(zero).Unwrap()
-	marginRight := style.props.ResolveLookupToCssValue("margin-right", "margin").Or(zero).Unwrap()
-	borderLeft := style.props.ResolveLookupToCssValue("border-left-width", "border-width").Unwrap()
-	borderRight := style.props.ResolveLookupToCssValue("border-right-width", "border-width").Unwrap()
-	paddingLeft := style.props.ResolveLookupToCssValue("padding-left", "padding").Unwrap()
-	paddingRight := style.props.ResolveLookupToCssValue("padding-right", "padding").Unwrap()
-
-	items := []*plex_css.CssValue{&marginLeft, &marginRight, &borderLeft, &borderRight, &paddingLeft, &paddingRight, &width}
-	var total float32 = 0.0
-	for _, i := range items {
-		if plex_css.IsCssValue(*i, plex_css.TCssValue_DIMENTION) {
-			v := (*i).(*plex_css.CssDimention)
-			total += v.AsPx()
-		}
-	}
-
-	if !plex_css.IsCssKeyword(width, "auto") && total > containing.Content.W {
-		if plex_css.IsCssKeyword(marginLeft, "auto") {
-			marginLeft = &plex_css.CssDimention{
-				Value: 0.0,
-				Unit:  plex_css.CssUnit_PX,
-			}
-		}
-		if plex_css.IsCssKeyword(marginRight, "auto") {
-			marginRight = &plex_css.CssDimention{
-				Value: 0.0,
-				Unit:  plex_css.CssUnit_PX,
-			}
-		}
+	style := l.node.Unwrap().style
+	base := containing.Content.W
+
+	width := style.Width.Resolve(base)
+	marginLeft := style.Margin.Left.Resolve(base)
+	marginRight := style.Margin.Right.Resolve(base)
+	paddingLeft := style.Padding.Left.Resolve(base)
+	paddingRight := style.Padding.Right.Resolve(base)
+	borderLeft := style.Border.Left
+	borderRight := style.Border.Right
+
+	widthIsAuto := style.Width.Auto
+	marginLeftIsAuto := style.Margin.Left.Auto
+	marginRightIsAuto := style.Margin.Right.Auto
+
+	total := marginLeft + marginRight + borderLeft + borderRight + paddingLeft + paddingRight + width
+
+	// auto margins of a box wider than its container become 0
+	if !widthIsAuto && total > base {
+		marginLeftIsAuto = false
+		marginRightIsAuto = false
 	}
 
-	underflow := containing.Content.W - total
-
-	widthIsAuto := plex_css.IsCssKeyword(width, "auto")
-	marginLeftIsAuto := plex_css.IsCssKeyword(marginLeft, "auto")
-	marginRightIsAuto := plex_css.IsCssKeyword(marginRight, "auto")
-
-	if !widthIsAuto && !marginLeftIsAuto && !marginRightIsAuto {
-		marginRight = &plex_css.CssDimention{
-			Value: 0.0,
-			Unit:  plex_css.CssUnit_PX,
-		}
-	} else if !widthIsAuto && !marginLeftIsAuto && marginRightIsAuto {
-		marginRight = &plex_css.CssDimention{
-			Value: underflow,
-			Unit:  plex_css.CssUnit_PX,
-		}
-	} else if !widthIsAuto && marginLeftIsAuto && !marginRightIsAuto {
-		marginLeft = &plex_css.CssDimention{
-			Value: underflow,
-			Unit:  plex_css.CssUnit_PX,
-		}
-	} else if !widthIsAuto && marginLeftIsAuto && marginRightIsAuto {
-		marginLeft = &plex_css.CssDimention{
-			Value: underflow / 2.0,
-			Unit:  plex_css.CssUnit_PX,
-		}
-		marginRight = &plex_css.CssDimention{
-			Value: underflow / 2.0,
-			Unit:  plex_css.CssUnit_PX,
-		}
-	} else if widthIsAuto {
-		if marginLeftIsAuto {
-			marginLeft = &plex_css.CssDimention{
-				Value: 0.0,
-				Unit:  plex_css.CssUnit_PX,
-			}
-		}
-		if marginRightIsAuto {
-			marginRight = &plex_css.CssDimention{
-				Value: 0.0,
-				Unit:  plex_css.CssUnit_PX,
-			}
-		}
-
+	underflow := base - total
+
+	switch {
+	case !widthIsAuto && !marginLeftIsAuto && !marginRightIsAuto:
+		// over-constrained, the right margin absorbs the difference
+		marginRight += underflow
+	case !widthIsAuto && !marginLeftIsAuto && marginRightIsAuto:
+		marginRight = underflow
+	case !widthIsAuto && marginLeftIsAuto && !marginRightIsAuto:
+		marginLeft = underflow
+	case !widthIsAuto && marginLeftIsAuto && marginRightIsAuto:
+		marginLeft = underflow / 2.0
+		marginRight = underflow / 2.0
+	case widthIsAuto:
 		if underflow >= 0.0 {
-			width = &plex_css.CssDimention{
-				Value: underflow,
-				Unit:  plex_css.CssUnit_PX,
-			}
+			width = underflow
 		} else {
-			width = &plex_css.CssDimention{
-				Value: underflow,
-				Unit:  plex_css.CssUnit_PX,
-			}
-			a := marginRight.(*plex_css.CssDimention)
-
-			// right margin has been set to a css length value
-			marginRight = &plex_css.CssDimention{
-				Value: a.AsPx() + underflow,
-				Unit:  plex_css.CssUnit_PX,
-			}
+			// the width can not be negative, the right margin is adjusted instead
+			width = 0
+			marginRight += underflow
 		}
 	}
 
-	l.dimensions.Content.W = plex_css.ResolveDimentionToPXFloat(width)
-	l.dimensions.Padding.Left = plex_css.ResolveDimentionToPXFloat(paddingLeft)
-	l.dimensions.Padding.Right = plex_css.ResolveDimentionToPXFloat(paddingRight)
-	l.dimensions.Border.Left = plex_css.ResolveDimentionToPXFloat(borderLeft)
-	l.dimensions.Border.Right = plex_css.ResolveDimentionToPXFloat(borderRight)
-	l.dimensions.Margin.Left = plex_css.ResolveDimentionToPXFloat(marginLeft)
-	l.dimensions.Margin.Right = plex_css.ResolveDimentionToPXFloat(marginRight)
+	l.dimensions.Content.W = width
+	l.dimensions.Padding.Left = paddingLeft
+	l.dimensions.Padding.Right = paddingRight
+	l.dimensions.Border.Left = borderLeft
+	l.dimensions.Border.Right = borderRight
+	l.dimensions.Margin.Left = marginLeft
+	l.dimensions.Margin.Right = marginRight
 }
 
 func (l *LayoutBox) appendInlineContainer(item LayoutBox) {
@@ -296,17 +180,13 @@ func (l *LayoutBox) appendInlineContainer(item LayoutBox) {
 	case BoxType_Block:
 		// if where are no children
 		if len(l.children) <= 0 {
-			l.children = append(l.children, LayoutBox{
-				boxType: BoxType_AnonymousBlock,
-			})
+			l.children = append(l.children, l.createAnonymousBlock())
 			// if there is a child but is not a AnonymousBlock
 		} else if l.children[len(l.children)-1].boxType != BoxType_AnonymousBlock {
-			l.children = append(l.children, LayoutBox{
-				boxType: BoxType_AnonymousBlock,
-			})
+			l.children = append(l.children, l.createAnonymousBlock())
 		}
 
-		last := l.children[len(l.children)-1]
+		last := &l.children[len(l.children)-1]
 
 		last.children = append(last.children, item)
 	default:
@@ -314,6 +194,22 @@ func (l *LayoutBox) appendInlineContainer(item LayoutBox) {
 	}
 }
 
+func (l *LayoutBox) createAnonymousBlock() LayoutBox {
+	return LayoutBox{
+		boxType:   BoxType_AnonymousBlock,
+		textStyle: l.textStyle,
+		selection: l.selection,
+	}
+}
+
+// What the lengths of the pseudo-element styles applied to the box resolve against.
+func (l *LayoutBox) lengthContext() lengthContext {
+	if l.node.IsNone() {
+		return createLengthContext(DefaultViewport)
+	}
+	return l.node.Unwrap().lengths
+}
+
 func createNewLayoutBox(boxType BoxType, dim Dimensions, node optional.Option[StyledNode]) LayoutBox {
 	return LayoutBox{
 		boxType:    boxType,
@@ -324,28 +220,56 @@ func createNewLayoutBox(boxType BoxType, dim Dimensions, node optional.Option[St
 }
 
 func LayoutTree(node StyledNode, containing Dimensions) LayoutBox {
-	root := buildLayoutTree(node)
+	root := buildLayoutTree(node, plex_css.CssPropertyMap{})
 	root.layout(containing)
 	return root
 }
 
-func buildLayoutTree(node StyledNode) LayoutBox {
+func buildLayoutTree(node StyledNode, selection plex_css.CssPropertyMap) LayoutBox {
 
 	boxType, err := node.GetDisplay().ToBoxType()
 	if err != nil {
 		panic(fmt.Sprintf("Failed to create node: %s\n", err))
 	}
 	root := createNewLayoutBox(boxType, Dimensions{}, optional.Some(node))
+	root.textStyle = node.style.TextStyle()
 
-	for _, child := range node.children {
+	// ::selection styles are inherited by descendants that do not set their own
+	if props, ok := node.pseudo[plex_css.PseudoElement_Selection]; ok {
+		selection = props
+	}
+	root.selection = selection
+
+	children := []StyledNode{}
+	node.marker.IfSome(func(v StyledNode) {
+		children = append(children, v)
+	})
+	node.before.IfSome(func(v StyledNode) {
+		children = append(children, v)
+	})
+	children = append(children, node.children...)
+	node.after.IfSome(func(v StyledNode) {
+		children = append(children, v)
+	})
+	node.placeholder.IfSome(func(v StyledNode) {
+		children = append(children, v)
+	})
+
+	for _, child := range children {
 		switch child.GetDisplay() {
-		case DisplayType_Block:
-			item := buildLayoutTree(child)
+		case DisplayType_Block, DisplayType_ListItem:
+			item := buildLayoutTree(child, selection)
 			root.children = append(root.children, item)
 		case DisplayType_Inline:
-			root.appendInlineContainer(buildLayoutTree(child))
+			root.appendInlineContainer(buildLayoutTree(child, selection))
 		}
 	}
 
+	// the first line of a block is formatted by its leading anonymous block
+	if boxType == BoxType_Block && len(root.children) > 0 && root.children[0].boxType == BoxType_AnonymousBlock {
+		root.children[0].firstLine = node.pseudo[plex_css.PseudoElement_FirstLine]
+		root.children[0].firstLetter = node.pseudo[plex_css.PseudoElement_FirstLetter]
+	}
+
 	return root
 }
@@ -1,10 +1,17 @@
 package plex_test
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 	plex "visualsource/plex/internal/core"
+	plex_css "visualsource/plex/internal/css"
 
 	"github.com/veandco/go-sdl2/sdl"
+	"github.com/veandco/go-sdl2/ttf"
 )
 
 func TestDimensionsPadding(t *testing.T) {
@@ -94,6 +101,212 @@ func TestDimensionsMarginBox(t *testing.T) {
 	t.Logf("%v", r)
 }
 
+func TestComputedStyle_CASE_INSENSITIVE(t *testing.T) {
+	p := plex_css.CssParser{}
+	declarations, err := p.ParseDeclarationsList("DISPLAY: Block; Float: LEFT; margin: 0 AUTO; width: 10px")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	props := plex_css.CssPropertyMap{}
+	for _, dec := range declarations {
+		props[dec.Name] = dec
+	}
+
+	rootNode := plex.CreateElementNode("div", plex.AttributeMap{}, []plex.Node{})
+	styled := plex.CreateStyleNode(&rootNode, props, []plex.StyledNode{})
+	style := styled.GetStyle()
+
+	if style.Display != plex.DisplayType_Block || style.Float != plex.FloatType_Left || !style.Margin.Left.Auto {
+		t.Fatalf("expected a left floated block with auto margins, got %v", style)
+	}
+}
+
+func TestComputedStyle(t *testing.T) {
+	p := plex_css.CssParser{}
+	declarations, err := p.ParseDeclarationsList("display: block; margin: 1px 2px 3px; width: 50%; border-left: 4px solid red; float: left")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	props := plex_css.CssPropertyMap{}
+	for _, dec := range declarations {
+		props[dec.Name] = dec
+	}
+
+	rootNode := plex.CreateElementNode("div", plex.AttributeMap{}, []plex.Node{})
+	styled := plex.CreateStyleNode(&rootNode, props, []plex.StyledNode{})
+	style := styled.GetStyle()
+
+	if style.Display != plex.DisplayType_Block || style.Float != plex.FloatType_Left {
+		t.Fatalf("expected a left floated block, got %v", style)
+	}
+
+	if style.Margin.Top.Value != 1 || style.Margin.Right.Value != 2 || style.Margin.Bottom.Value != 3 || style.Margin.Left.Value != 2 {
+		t.Fatalf("expected margins 1px 2px 3px 2px, got %v", style.Margin)
+	}
+
+	if !style.Width.Percent || style.Width.Resolve(200) != 100 {
+		t.Fatalf("expected width to resolve to 50%% of the containing block, got %v", style.Width)
+	}
+
+	if style.Border.Left != 4 || style.Border.Top != 0 || style.BorderColor.Left != plex_css.CSS_COLOR_KEYWORDS["red"] {
+		t.Fatalf("expected only a red left border, got %v %v", style.Border, style.BorderColor)
+	}
+}
+
+func TestCustomProperties(t *testing.T) {
+	p := plex_css.CssParser{}
+	stylesheet, err := p.ParseStylesheet(`
+		div { --brand: #336699; --gap: 4px; --a: var(--b); --b: var(--a) }
+		p {
+			color: var(--brand);
+			margin: var(--gap) 2px;
+			padding-left: var(--missing, 7px);
+			border-left-color: var(--a, red);
+			background-color: var(--a);
+			width: var(--brand);
+		}
+	`, plex_css.Origin_Author)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	paragraph := plex.CreateElementNode("p", plex.AttributeMap{}, []plex.Node{})
+	root := plex.CreateElementNode("div", plex.AttributeMap{}, []plex.Node{&paragraph})
+	styled := plex.StyleTree(&root, []plex_css.Stylesheet{stylesheet}, plex.DefaultViewport)
+	style := styled.GetChildren()[0].GetStyle()
+
+	if style.Color != (plex_css.CssColor{R: 0x33, G: 0x66, B: 0x99, A: 255}) {
+		t.Fatalf("expected the inherited --brand color, got %v", style.Color)
+	}
+	if style.Margin.Top.Value != 4 || style.Margin.Right.Value != 2 || style.Margin.Bottom.Value != 4 {
+		t.Fatalf("expected var() in the margin shorthand to be substituted, got %v", style.Margin)
+	}
+	if style.Padding.Left.Value != 7 {
+		t.Fatalf("expected the fallback of an undefined variable, got %v", style.Padding.Left)
+	}
+	if style.BorderColor.Left != plex_css.CSS_COLOR_KEYWORDS["red"] {
+		t.Fatalf("expected the fallback of a cyclic variable, got %v", style.BorderColor.Left)
+	}
+	if style.BackgroundColor.A != 0 || !style.Width.Auto {
+		t.Fatalf("expected invalid substitutions to take their initial value, got %v %v", style.BackgroundColor, style.Width)
+	}
+}
+
+func TestComputedStyle_MATH(t *testing.T) {
+	p := plex_css.CssParser{}
+	declarations, err := p.ParseDeclarationsList("width: calc(100% - 20px); margin-left: clamp(5px, 10%, 15px); padding-top: calc(2px * 3); font-size: calc(150% + 2px)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	props := plex_css.CssPropertyMap{}
+	for _, dec := range declarations {
+		props[dec.Name] = dec
+	}
+
+	rootNode := plex.CreateElementNode("div", plex.AttributeMap{}, []plex.Node{})
+	styled := plex.CreateStyleNode(&rootNode, props, []plex.StyledNode{})
+	style := styled.GetStyle()
+
+	if style.Width.Resolve(200) != 180 || style.Width.Resolve(100) != 80 {
+		t.Fatalf("expected the width to resolve against the containing block, got %v", style.Width)
+	}
+	if style.Margin.Left.Resolve(40) != 5 || style.Margin.Left.Resolve(300) != 15 {
+		t.Fatalf("expected the margin to be clamped, got %v", style.Margin.Left)
+	}
+	if style.Padding.Top.Calc != nil || style.Padding.Top.Value != 6 {
+		t.Fatalf("expected a calculation without percentages to be computed, got %v", style.Padding.Top)
+	}
+	if style.Font.Size != 26 {
+		t.Fatalf("expected the font size to be relative to the parent's, got %v", style.Font.Size)
+	}
+}
+
+func TestComputedStyle_UNITS(t *testing.T) {
+	p := plex_css.CssParser{}
+	stylesheet, err := p.ParseStylesheet(`
+		html { font-size: 20px; line-height: 1.5 }
+		div { font-size: 2em; width: 10vw; height: 50vmin; padding: 1rem 2ex 1ch 2lh; margin: 1in 72pt 2.54cm 6pc }
+		p { font-size: 50%; width: calc(100% - 1em); margin-left: 1rlh; border-top: 10mm solid; border-left: 40Q solid }
+	`, plex_css.Origin_Author)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	paragraph := plex.CreateElementNode("p", plex.AttributeMap{}, []plex.Node{})
+	div := plex.CreateElementNode("div", plex.AttributeMap{}, []plex.Node{&paragraph})
+	root := plex.CreateElementNode("html", plex.AttributeMap{}, []plex.Node{&div})
+	styled := plex.StyleTree(&root, []plex_css.Stylesheet{stylesheet}, plex.Viewport{Width: 1000, Height: 500})
+
+	divStyled := styled.GetChildren()[0]
+	style := divStyled.GetStyle()
+	if style.Font.Size != 40 || style.LineHeight != 60 {
+		t.Fatalf("expected em to resolve against the parent's font, got %v %v", style.Font.Size, style.LineHeight)
+	}
+	if style.Width.Value != 100 || style.Height.Value != 250 {
+		t.Fatalf("expected viewport units, got %v %v", style.Width, style.Height)
+	}
+	if style.Padding.Top.Value != 20 || style.Padding.Right.Value != 40 || style.Padding.Bottom.Value != 20 || style.Padding.Left.Value != 120 {
+		t.Fatalf("expected font relative units, got %v", style.Padding)
+	}
+	if style.Margin.Top.Value != 96 || style.Margin.Right.Value != 96 || style.Margin.Bottom.Value != 96 || style.Margin.Left.Value != 96 {
+		t.Fatalf("expected absolute units, got %v", style.Margin)
+	}
+
+	style = divStyled.GetChildren()[0].GetStyle()
+	if style.Font.Size != 20 || style.LineHeight != 30 {
+		t.Fatalf("expected the computed font size to be inherited, got %v %v", style.Font.Size, style.LineHeight)
+	}
+	if style.Width.Resolve(100) != 80 || style.Margin.Left.Value != 30 {
+		t.Fatalf("expected em inside calc() and rlh to be resolved, got %v %v", style.Width.Resolve(100), style.Margin.Left)
+	}
+	if style.Border.Top < 37.7 || style.Border.Top > 37.8 || style.Border.Left < 37.7 || style.Border.Left > 37.8 {
+		t.Fatalf("expected 10mm and 40Q borders, got %v", style.Border)
+	}
+}
+
+// Records the font size every run of text is measured with.
+type recordingTextMeasurer struct {
+	sizes map[string]float32
+}
+
+func (m recordingTextMeasurer) MeasureText(text string, style plex.TextStyle) (float32, float32) {
+	m.sizes[strings.TrimSpace(text)] = style.Font.Size
+	return float32(len(text)) * style.Font.Size * 0.5, style.Font.Size * 1.2
+}
+
+func TestLayout_PSEUDO_ELEMENT_UNITS(t *testing.T) {
+	parser := plex.HtmlParser{}
+	dom, err := parser.Parse(`<html><body><p>Some text</p></body></html>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := plex_css.CssParser{}
+	stylesheet, err := p.ParseStylesheet(`
+		html, body, p { display: block }
+		html { font-size: 20px }
+		p::first-line { font-size: 2rem }
+		p::first-letter { font-size: 10vw }
+	`, plex_css.Origin_Author)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	measurer := recordingTextMeasurer{sizes: map[string]float32{}}
+	defer func(previous plex.TextMeasurer) { plex.DefaultTextMeasurer = previous }(plex.DefaultTextMeasurer)
+	plex.DefaultTextMeasurer = measurer
+
+	styled := plex.StyleTree(dom, []plex_css.Stylesheet{stylesheet}, plex.Viewport{Width: 1000, Height: 500})
+	plex.LayoutTree(styled, plex.Dimensions{Content: sdl.FRect{W: 1000, H: 500}})
+
+	// rem resolves against the root element and vw against the document's viewport
+	if measurer.sizes["ome"] != 40 || measurer.sizes["text"] != 40 || measurer.sizes["S"] != 100 {
+		t.Fatalf("expected the first line at 40px and the first letter at 100px, got %v", measurer.sizes)
+	}
+}
+
 /*func TestCalculateBlockWidth(t *testing.T){
 
 	rootNode := plex.CreateElementNode("html", plex.AttributeMap{"id": "root"}, []plex.Node{})
@@ -107,3 +320,655 @@ func TestDimensionsMarginBox(t *testing.T) {
 
 	//layout.CalculateBlockWidth()
 }*/
+
+func findStyledElement(node *plex.StyledNode, tagName string) *plex.StyledNode {
+	if element, ok := node.GetNode().(*plex.ElementNode); ok && element.GetTagName() == tagName {
+		return node
+	}
+	for i := range node.GetChildren() {
+		if found := findStyledElement(&node.GetChildren()[i], tagName); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func TestColor_PAGES(t *testing.T) {
+	pages := map[string]plex_css.CssColor{
+		"CSS Color 4_ color property.htm":                plex_css.CSS_COLOR_KEYWORDS["green"],
+		"CSS Color 4_ color property, initial value.htm": plex_css.CSS_COLOR_KEYWORDS["black"],
+	}
+
+	for page, expected := range pages {
+		content, err := os.ReadFile(filepath.Join("../../tests", page))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		parser := plex.HtmlParser{}
+		dom, err := parser.Parse(string(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		styled, _ := plex.ParseStylesFromDocument(dom, []plex_css.Stylesheet{}, plex.DefaultViewport)
+		paragraph := findStyledElement(&styled, "p")
+		if paragraph == nil {
+			t.Fatalf("expected '%s' to contain a paragraph", page)
+		}
+		if paragraph.GetStyle().Color != expected {
+			t.Fatalf("expected '%s' to be %v got %v", page, expected, paragraph.GetStyle().Color)
+		}
+	}
+}
+
+func TestComputedStyle_CURRENT_COLOR(t *testing.T) {
+	palette := plex_css.SystemColors
+	plex_css.SystemColors = plex_css.SystemColorPalette{"canvas": {R: 10, G: 20, B: 30, A: 255}}
+	defer func() { plex_css.SystemColors = palette }()
+
+	p := plex_css.CssParser{}
+	stylesheet, err := p.ParseStylesheet(`
+		div { color: red; fill: currentColor }
+		p {
+			border: 1px solid;
+			outline-color: currentcolor;
+			text-decoration-color: color-mix(in srgb, currentcolor, blue);
+			background-color: Canvas;
+		}
+		span { color: currentcolor; background-color: transparent; fill: none }
+	`, plex_css.Origin_Author)
+	if err != nil || len(stylesheet.Diagnostics) != 0 {
+		t.Fatal(err, stylesheet.Diagnostics)
+	}
+
+	span := plex.CreateElementNode("span", plex.AttributeMap{}, []plex.Node{})
+	paragraph := plex.CreateElementNode("p", plex.AttributeMap{}, []plex.Node{&span})
+	root := plex.CreateElementNode("div", plex.AttributeMap{}, []plex.Node{&paragraph})
+	styled := plex.StyleTree(&root, []plex_css.Stylesheet{stylesheet}, plex.DefaultViewport)
+
+	red := plex_css.CSS_COLOR_KEYWORDS["red"]
+	style := styled.GetChildren()[0].GetStyle()
+	if style.Color != red || style.BorderColor.Top != red || style.OutlineColor != red {
+		t.Fatalf("expected currentcolor to be the inherited color, got %v %v %v", style.Color, style.BorderColor, style.OutlineColor)
+	}
+	if style.TextDecorationColor != (plex_css.CssColor{R: 128, G: 0, B: 128, A: 255}) {
+		t.Fatalf("expected currentcolor to resolve inside color-mix(), got %v", style.TextDecorationColor)
+	}
+	if style.BackgroundColor != (plex_css.CssColor{R: 10, G: 20, B: 30, A: 255}) || style.Fill != red {
+		t.Fatalf("expected system colors from the palette and an inherited fill, got %v %v", style.BackgroundColor, style.Fill)
+	}
+
+	style = styled.GetChildren()[0].GetChildren()[0].GetStyle()
+	if style.Color != red || style.BackgroundColor.A != 0 || style.Fill.A != 0 {
+		t.Fatalf("expected 'color: currentcolor' to inherit, got %v %v %v", style.Color, style.BackgroundColor, style.Fill)
+	}
+}
+
+func TestInlineStyle(t *testing.T) {
+	p := plex_css.CssParser{}
+	stylesheet, err := p.ParseStylesheet(`
+		#box { background-color: blue; padding: 1px !important; color: green }
+	`, plex_css.Origin_Author)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	div := plex.CreateElementNode("div", plex.AttributeMap{"id": "box", "style": "background: red; padding: 4px; color: blue !important"}, []plex.Node{})
+	styled := plex.StyleTree(&div, []plex_css.Stylesheet{stylesheet}, plex.DefaultViewport)
+	style := styled.GetStyle()
+
+	if style.BackgroundColor != plex_css.CSS_COLOR_KEYWORDS["red"] || style.Color != plex_css.CSS_COLOR_KEYWORDS["blue"] {
+		t.Fatalf("expected the style attribute to win over author rules, got %v %v", style.BackgroundColor, style.Color)
+	}
+	if style.Padding.Left.Value != 1 {
+		t.Fatalf("expected important author rules to win over the style attribute, got %v", style.Padding.Left)
+	}
+
+	div.SetAttribute("style", "width: 50px")
+	styled = plex.StyleTree(&div, []plex_css.Stylesheet{stylesheet}, plex.DefaultViewport)
+	style = styled.GetStyle()
+	if style.Width.Value != 50 || style.BackgroundColor != plex_css.CSS_COLOR_KEYWORDS["blue"] {
+		t.Fatalf("expected the changed style attribute to be parsed again, got %v %v", style.Width, style.BackgroundColor)
+	}
+
+	div.RemoveAttribute("style")
+	styled = plex.StyleTree(&div, []plex_css.Stylesheet{stylesheet}, plex.DefaultViewport)
+	style = styled.GetStyle()
+	if !style.Width.Auto {
+		t.Fatalf("expected the removed style attribute to no longer apply, got %v", style.Width)
+	}
+}
+
+func TestDocument_CSSOM(t *testing.T) {
+	parser := plex.HtmlParser{}
+	dom, err := parser.Parse(`<html><head><style>p { color: red }</style></head><body><p id="text">Text</p></body></html>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	document := plex.CreateDocument(dom, []plex_css.Stylesheet{}, plex.DefaultViewport)
+	restyles := 0
+	document.OnRestyle = func(*plex.Document) { restyles++ }
+
+	paragraphStyle := func() *plex.ComputedStyle {
+		return findStyledElement(document.GetStyleTree(), "p").GetStyle()
+	}
+
+	sheets := document.StyleSheets()
+	if len(sheets) != 1 || len(sheets[0].CssRules()) != 1 {
+		t.Fatalf("expected the <style> element to be a stylesheet of the document")
+	}
+	sheet := sheets[0]
+	rule := sheet.CssRules()[0]
+
+	if err := rule.Style().SetProperty("color", "green", ""); err != nil {
+		t.Fatal(err)
+	}
+	if paragraphStyle().Color != plex_css.CSS_COLOR_KEYWORDS["green"] {
+		t.Fatalf("expected the changed rule to apply, got %v", paragraphStyle().Color)
+	}
+
+	if _, err := sheet.InsertRule("#text { color: blue }", 1); err != nil {
+		t.Fatal(err)
+	}
+	if paragraphStyle().Color != plex_css.CSS_COLOR_KEYWORDS["blue"] {
+		t.Fatalf("expected the inserted rule to apply, got %v", paragraphStyle().Color)
+	}
+
+	if err := sheet.DeleteRule(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := rule.SetSelectorText("div"); err != nil || rule.SelectorText() != "div" {
+		t.Fatalf("expected the selector to change, %v", err)
+	}
+	if paragraphStyle().Color == plex_css.CSS_COLOR_KEYWORDS["green"] {
+		t.Fatalf("expected the rule to no longer match the paragraph")
+	}
+
+	el := findStyledElement(document.GetStyleTree(), "p").GetNode().(*plex.ElementNode)
+	if err := el.Style().SetProperty("color", "purple", "important"); err != nil {
+		t.Fatal(err)
+	}
+	if paragraphStyle().Color != plex_css.CSS_COLOR_KEYWORDS["purple"] || el.GetAttribute("style") != "color: purple !important;" {
+		t.Fatalf("expected the inline style to apply, got %v %q", paragraphStyle().Color, el.GetAttribute("style"))
+	}
+	if value := el.Style().RemoveProperty("color"); value != "purple" || el.HasAttribute("style") && el.GetAttribute("style") != "" {
+		t.Fatalf("expected the inline color to be removed, got %q", value)
+	}
+
+	if restyles != 6 {
+		t.Fatalf("expected every change to restyle the document, got %d restyles", restyles)
+	}
+}
+
+func TestDocument_MEDIA_RESIZE(t *testing.T) {
+	parser := plex.HtmlParser{}
+	dom, err := parser.Parse(`<html><head><style>
+		p { color: red }
+		@media (max-width: 600px) { p { color: green } }
+		@media (orientation: portrait) { p { background-color: blue } }
+	</style></head><body><p>Text</p></body></html>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	document := plex.CreateDocument(dom, []plex_css.Stylesheet{}, plex.Viewport{Width: 800, Height: 600})
+	restyles := 0
+	document.OnRestyle = func(*plex.Document) { restyles++ }
+
+	style := findStyledElement(document.GetStyleTree(), "p").GetStyle()
+	if style.Color != plex_css.CSS_COLOR_KEYWORDS["red"] || style.BackgroundColor.A != 0 {
+		t.Fatalf("expected the @media rules not to apply to a wide viewport, got %v %v", style.Color, style.BackgroundColor)
+	}
+
+	document.SetViewport(plex.Viewport{Width: 500, Height: 600})
+	style = findStyledElement(document.GetStyleTree(), "p").GetStyle()
+	if style.Color != plex_css.CSS_COLOR_KEYWORDS["green"] || style.BackgroundColor != plex_css.CSS_COLOR_KEYWORDS["blue"] {
+		t.Fatalf("expected the @media rules to apply after the resize, got %v %v", style.Color, style.BackgroundColor)
+	}
+
+	document.SetViewport(plex.Viewport{Width: 500, Height: 600})
+	if restyles != 1 {
+		t.Fatalf("expected only a change of size to restyle, got %d restyles", restyles)
+	}
+}
+
+func TestLoadLocalStylesheet_IMPORT(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"theme.css": `
+			@import "partials/base.css" layer(base);
+			@import url("partials/small.css") screen and (max-width: 500px);
+			@import url(partials/unsupported.css) supports(not-a-property: 1px);
+			p { color: green }
+			@import "late.css";
+		`,
+		"partials/base.css": `
+			@import "../theme.css";
+			@import "colors.css" supports(display: block);
+			p { color: red; margin-top: 1px }
+		`,
+		"partials/colors.css":      `div { color: blue }`,
+		"partials/small.css":       `p { margin-left: 2px }`,
+		"partials/unsupported.css": `p { margin-right: 3px }`,
+		"late.css":                 `p { margin-bottom: 4px }`,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stylesheet, err := plex.LoadLocalStylesheet(filepath.Join(dir, "theme.css"), plex_css.Origin_Author)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	selectors := []string{}
+	for _, rule := range stylesheet.Rules {
+		selectors = append(selectors, plex_css.SerializeSelectorList(rule.Selector)+"@"+rule.Layer)
+	}
+	if strings.Join(selectors, " ") != "div@base p@base p@ p@" {
+		t.Fatalf("expected the imported rules before the importing ones, got %v", selectors)
+	}
+
+	messages := []string{}
+	for _, diagnostic := range stylesheet.Diagnostics {
+		messages = append(messages, diagnostic.Message)
+	}
+	if !slices.ContainsFunc(messages, func(message string) bool { return strings.HasPrefix(message, "import cycle") }) {
+		t.Fatalf("expected the import cycle to be reported, got %v", messages)
+	}
+
+	paragraph := plex.CreateElementNode("p", plex.AttributeMap{}, []plex.Node{})
+	for _, test := range []struct {
+		viewport   plex.Viewport
+		marginLeft float32
+	}{{plex.DefaultViewport, 0}, {plex.Viewport{Width: 400, Height: 600}, 2}} {
+		styled := plex.StyleTree(&paragraph, []plex_css.Stylesheet{stylesheet}, test.viewport)
+		style := styled.GetStyle()
+		if style.Color != plex_css.CSS_COLOR_KEYWORDS["green"] || style.Margin.Top.Value != 1 {
+			t.Fatalf("expected layered imports to lose against unlayered rules, got %v %v", style.Color, style.Margin.Top)
+		}
+		if style.Margin.Left.Value != test.marginLeft || style.Margin.Right.Value != 0 || style.Margin.Bottom.Value != 0 {
+			t.Fatalf("expected only the matching imports to apply, got %v", style.Margin)
+		}
+	}
+}
+
+func TestLoadLocalStylesheet_IMPORT_LAYER_ORDER(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"main.css": `
+			@layer components, reset;
+			@import url(reset.css) layer(reset);
+			@import url(comp.css) layer(components);
+		`,
+		"reset.css": `p { color: green }`,
+		"comp.css":  `@layer buttons { p { color: red } }`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stylesheet, err := plex.LoadLocalStylesheet(filepath.Join(dir, "main.css"), plex_css.Origin_Author)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(stylesheet.Layers, []string{"components", "reset", "components.buttons"}) {
+		t.Fatalf("expected the @layer statement to order the layers before the imports, got %v", stylesheet.Layers)
+	}
+
+	paragraph := plex.CreateElementNode("p", plex.AttributeMap{}, []plex.Node{})
+	styled := plex.StyleTree(&paragraph, []plex_css.Stylesheet{stylesheet}, plex.DefaultViewport)
+	if color := styled.GetStyle().Color; color != plex_css.CSS_COLOR_KEYWORDS["green"] {
+		t.Fatalf("expected the reset layer declared last to win, got %v", color)
+	}
+}
+
+func TestFontCache_FONT_FACE(t *testing.T) {
+	stylesheet, err := plex.LoadLocalStylesheet("../../resources/useragent.css", plex_css.Origin_UserAgent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fonts := plex.CreateFontCache()
+	opened := []string{}
+	fonts.OpenFont = func(path string, size int) (*ttf.Font, error) {
+		opened = append(opened, fmt.Sprintf("%s@%d", filepath.Base(path), size))
+		return &ttf.Font{}, nil
+	}
+	if added := fonts.RegisterStylesheet(stylesheet); added != 8 {
+		t.Fatalf("expected the 8 faces of the user agent stylesheet, got %d", added)
+	}
+	if added := fonts.RegisterStylesheet(stylesheet); added != 0 {
+		t.Fatalf("expected known faces to be ignored, got %d", added)
+	}
+
+	tests := []struct {
+		font plex.FontDescriptor
+		file string
+	}{
+		{plex.FontDescriptor{Family: "Ubuntu", Weight: 400}, "Ubuntu-Regular.ttf"},
+		{plex.FontDescriptor{Family: "ubuntu", Weight: 700, Italic: true}, "Ubuntu-BoldItalic.ttf"},
+		{plex.FontDescriptor{Family: "Ubuntu", Weight: 600}, "Ubuntu-Bold.ttf"},
+		{plex.FontDescriptor{Family: "Ubuntu", Weight: 450}, "Ubuntu-Medium.ttf"},
+		{plex.FontDescriptor{Family: "Ubuntu", Weight: 350}, "Ubuntu-Light.ttf"},
+		{plex.FontDescriptor{Family: "Ubuntu", Weight: 900, Italic: true}, "Ubuntu-BoldItalic.ttf"},
+		{plex.FontDescriptor{Family: "Unknown", Weight: 400}, "Ubuntu-Regular.ttf"},
+	}
+	for _, test := range tests {
+		face, ok := fonts.MatchFontFace(test.font, "text")
+		if !ok || filepath.Base(face.Sources[0].Url) != test.file {
+			t.Errorf("expected %+v to use %s, got %v", test.font, test.file, face.Sources)
+		}
+	}
+
+	// faces are only opened when text needs them
+	if len(opened) != 0 {
+		t.Fatalf("expected no font to be opened while matching, got %v", opened)
+	}
+	font := plex.FontDescriptor{Family: "Ubuntu", Size: 16, Weight: 700}
+	for i := 0; i < 2; i++ {
+		if _, err := fonts.Font(font, "text"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(opened) != 1 || opened[0] != "Ubuntu-Bold.ttf@16" {
+		t.Fatalf("expected the bold face to be opened once, got %v", opened)
+	}
+}
+
+func TestFontCache_FAMILY_FALLBACK(t *testing.T) {
+	parser := plex_css.CssParser{}
+	stylesheet, err := parser.ParseStylesheet(`
+		@font-face { font-family: MyFace; src: url(my-face.ttf) }
+		@font-face { font-family: Ubuntu; src: url(ubuntu.ttf) }
+	`, plex_css.Origin_Author)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fonts := plex.CreateFontCache()
+	fonts.RegisterStylesheet(stylesheet)
+
+	tests := map[string]string{
+		`font-family: "Missing", "MyFace", serif`: "my-face.ttf",
+		`font-family: Missing Family, MyFace`:     "my-face.ttf",
+		`font-family: MyFace, Ubuntu`:             "my-face.ttf",
+		`font-family: Missing, serif`:             "ubuntu.ttf",
+	}
+	for source, file := range tests {
+		declarations, err := parser.ParseDeclarationsList(source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		props := plex_css.CssPropertyMap{}
+		for _, dec := range declarations {
+			props[dec.Name] = dec
+		}
+
+		node := plex.CreateElementNode("p", plex.AttributeMap{}, []plex.Node{})
+		styled := plex.CreateStyleNode(&node, props, []plex.StyledNode{})
+		face, ok := fonts.MatchFontFace(styled.GetStyle().Font, "text")
+		if !ok || filepath.Base(face.Sources[0].Url) != file {
+			t.Errorf("expected %q to use %s, got %v", source, file, face.Sources)
+		}
+	}
+}
+
+func TestStyleTree_NESTING(t *testing.T) {
+	parser := plex.HtmlParser{}
+	dom, err := parser.Parse(`<html><head><style>
+		.card {
+			color: red;
+			& > h2 { color: green }
+			p { color: blue }
+			h2 + & { color: white }
+			h2 ~ p { background-color: green }
+			&.active { background-color: blue }
+			&:hover { background-color: red }
+		}
+	</style></head><body><h2>Title</h2><div class="card active"><h2>Heading</h2><section><p>Text</p></section></div></body></html>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	document := plex.CreateDocument(dom, []plex_css.Stylesheet{}, plex.Viewport{Width: 800, Height: 600})
+	card := findStyledElement(document.GetStyleTree(), "div").GetStyle()
+	if card.Color != plex_css.CSS_COLOR_KEYWORDS["white"] || card.BackgroundColor != plex_css.CSS_COLOR_KEYWORDS["blue"] {
+		t.Fatalf("expected the card to match '&.active' and 'h2 + &' but not '&:hover', got %v %v", card.Color, card.BackgroundColor)
+	}
+
+	heading := findStyledElement(findStyledElement(document.GetStyleTree(), "div"), "h2").GetStyle()
+	if heading.Color != plex_css.CSS_COLOR_KEYWORDS["green"] {
+		t.Fatalf("expected the heading to match '& > h2', got %v", heading.Color)
+	}
+
+	text := findStyledElement(document.GetStyleTree(), "p").GetStyle()
+	if text.Color != plex_css.CSS_COLOR_KEYWORDS["blue"] || text.BackgroundColor.A != 0 {
+		t.Fatalf("expected the paragraph to match '& p' only, got %v %v", text.Color, text.BackgroundColor)
+	}
+
+	title := findStyledElement(document.GetStyleTree(), "h2").GetStyle()
+	if title.Color == plex_css.CSS_COLOR_KEYWORDS["green"] {
+		t.Fatalf("expected the heading outside of the card not to match")
+	}
+}
+
+func TestCascade_LAYERS(t *testing.T) {
+	parser := plex.HtmlParser{}
+	dom, err := parser.Parse(`<html><head><style>
+		@layer base, components;
+		@layer components {
+			#app .btn { color: red; background-color: red !important }
+			@layer theme { .btn { margin-top: 1px; margin-left: 1px } }
+		}
+		.btn { color: green; background-color: green !important }
+		@layer base { .btn { margin-top: 2px; margin-left: 2px; margin-right: 2px } }
+		@layer components { .btn { margin-left: 3px } }
+	</style></head><body><div id="app"><p class="btn">Text</p></div></body></html>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	document := plex.CreateDocument(dom, []plex_css.Stylesheet{}, plex.Viewport{Width: 800, Height: 600})
+	style := findStyledElement(document.GetStyleTree(), "p").GetStyle()
+
+	// unlayered styles win for normal declarations and lose for important ones
+	if style.Color != plex_css.CSS_COLOR_KEYWORDS["green"] || style.BackgroundColor != plex_css.CSS_COLOR_KEYWORDS["red"] {
+		t.Fatalf("expected the unlayered color and the layered important background, got %v %v", style.Color, style.BackgroundColor)
+	}
+
+	// base < components.theme < components
+	if style.Margin.Top.Value != 1 || style.Margin.Left.Value != 3 || style.Margin.Right.Value != 2 {
+		t.Fatalf("expected the margins to follow the layer order, got %v", style.Margin)
+	}
+}
+
+func TestCascade_ORDER(t *testing.T) {
+	sources := []struct {
+		css    string
+		origin uint
+	}{
+		{`p { margin-top: 1px !important; margin-left: 1px }`, plex_css.Origin_UserAgent},
+		{`p { margin-top: 2px !important; margin-left: 2px; margin-bottom: 4px }`, plex_css.Origin_User},
+		{`
+			@layer a, b;
+			@layer a { p { margin-right: 1px !important; padding-top: 5px } }
+			@layer b { p { margin-right: 2px !important; padding-top: revert-layer } }
+			p { margin-top: 3px !important; margin-left: 3px; margin-bottom: revert }
+			#text { color: red }
+			p { background-color: green !important; padding-left: 1px }
+			p { padding-left: 2px; padding-right: 1px }
+		`, plex_css.Origin_Author},
+		{`p { padding-right: 2px }`, plex_css.Origin_Author},
+	}
+	stylesheets := []plex_css.Stylesheet{}
+	for _, source := range sources {
+		p := plex_css.CssParser{}
+		stylesheet, err := p.ParseStylesheet(source.css, source.origin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stylesheets = append(stylesheets, stylesheet)
+	}
+
+	paragraph := plex.CreateElementNode("p", plex.AttributeMap{"id": "text", "style": "color: green; background-color: red"}, []plex.Node{})
+	styled := plex.StyleTree(&paragraph, stylesheets, plex.DefaultViewport)
+	style := styled.GetStyle()
+
+	// important declarations reverse the order of the origins
+	if style.Margin.Left.Value != 3 || style.Margin.Top.Value != 1 {
+		t.Fatalf("expected the author to win normal and the user agent important declarations, got %v %v", style.Margin.Left, style.Margin.Top)
+	}
+
+	// the style attribute wins over author rules but not over important ones
+	if style.Color != plex_css.CSS_COLOR_KEYWORDS["green"] || style.BackgroundColor != plex_css.CSS_COLOR_KEYWORDS["green"] {
+		t.Fatalf("expected the inline color and the important author background, got %v %v", style.Color, style.BackgroundColor)
+	}
+
+	// important declarations reverse the order of the layers
+	if style.Margin.Right.Value != 1 {
+		t.Fatalf("expected the important declaration of the first layer to win, got %v", style.Margin.Right)
+	}
+
+	// revert rolls back to the user origin, revert-layer to the previous layer
+	if style.Margin.Bottom.Value != 4 || style.Padding.Top.Value != 5 {
+		t.Fatalf("expected revert and revert-layer to roll back the cascade, got %v %v", style.Margin.Bottom, style.Padding.Top)
+	}
+
+	// the later rule wins, in the same stylesheet and across stylesheets
+	if style.Padding.Left.Value != 2 || style.Padding.Right.Value != 2 {
+		t.Fatalf("expected the later declarations to win, got %v %v", style.Padding.Left, style.Padding.Right)
+	}
+}
+
+func TestDocument_CONTAINER_QUERIES(t *testing.T) {
+	parser := plex.HtmlParser{}
+	dom, err := parser.Parse(`<html><head><style>
+		html, body, div, section, p { display: block }
+		.page { container-type: size; height: 300px }
+		.card { container: card / inline-size; width: 400px }
+		p { margin-left: 10cqw; margin-top: 10cqh }
+		@container card (min-width: 300px) { p { color: red } }
+		@container card (width > 500px) { p { background-color: blue } }
+		@container (height > 100px) { p { background-color: green } }
+		@container sidebar (width > 0px) { p { color: blue } }
+	</style></head><body><div class="page"><section class="card"><p>Text</p></section></div></body></html>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	document := plex.CreateDocument(dom, []plex_css.Stylesheet{}, plex.Viewport{Width: 800, Height: 600})
+	style := findStyledElement(document.GetStyleTree(), "p").GetStyle()
+	if style.Color != plex_css.CSS_COLOR_KEYWORDS["black"] || style.Margin.Left.Value != 80 {
+		t.Fatalf("expected container queries not to apply before layout, got %v %v", style.Color, style.Margin.Left)
+	}
+
+	document.Layout(plex.Dimensions{Content: sdl.FRect{W: 800, H: 600}})
+	if len(document.Diagnostics) != 0 {
+		t.Fatalf("expected the container sizes to settle, got %v", document.Diagnostics)
+	}
+	style = findStyledElement(document.GetStyleTree(), "p").GetStyle()
+	if style.Color != plex_css.CSS_COLOR_KEYWORDS["red"] {
+		t.Fatalf("expected the card query to match, got %v", style.Color)
+	}
+
+	// the card can not be queried for its height, the page is
+	if style.BackgroundColor != plex_css.CSS_COLOR_KEYWORDS["green"] {
+		t.Fatalf("expected the height query to match the page, got %v", style.BackgroundColor)
+	}
+
+	// cqw resolves against the card and cqh against the page
+	if style.Margin.Left.Value != 40 || style.Margin.Top.Value != 30 {
+		t.Fatalf("expected container units to resolve against the nearest containers, got %v %v", style.Margin.Left, style.Margin.Top)
+	}
+}
+
+func TestDocument_CONTAINER_QUERIES_UNSTABLE(t *testing.T) {
+	parser := plex.HtmlParser{}
+	dom, err := parser.Parse(`<html><head><style>
+		html, body, div { display: block }
+		head { display: none }
+		div { container-type: inline-size }
+		@container (max-width: 499px) { div { width: 600px } }
+		@container (min-width: 500px) { div { width: 300px } }
+	</style></head><body><div><div><div><div><div><div><div></div></div></div></div></div></div></div></body></html>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// each restyle settles the width of one more nested container, too few for all seven
+	document := plex.CreateDocument(dom, []plex_css.Stylesheet{}, plex.Viewport{Width: 800, Height: 600})
+	document.Layout(plex.Dimensions{Content: sdl.FRect{W: 800, H: 600}})
+	if len(document.Diagnostics) != 1 {
+		t.Fatalf("expected the changing container sizes to be reported, got %v", document.Diagnostics)
+	}
+
+	// laying out again continues from the last container sizes
+	document.Layout(plex.Dimensions{Content: sdl.FRect{W: 800, H: 600}})
+	if len(document.Diagnostics) != 0 {
+		t.Fatalf("expected the container sizes to settle, got %v", document.Diagnostics)
+	}
+}
+
+func TestDocument_PAGINATE(t *testing.T) {
+	parser := plex.HtmlParser{}
+	dom, err := parser.Parse(`<html><head><style>
+		html, body, div { display: block }
+		head { display: none }
+		div { height: 80px }
+		#a { height: 10px }
+		@media print { #a { height: 80px } }
+		@media screen { div { height: 500px } }
+		.keep { break-inside: avoid; height: auto }
+		.keep div { height: 70px }
+		#d { break-before: right }
+		@page {
+			margin: 50px;
+			@top-center { content: "Page " counter(page, decimal) " of " counter(pages) / "page number" }
+		}
+		@page :first { margin-top: 100px }
+	</style></head><body>
+		<div id="a"></div><div id="b"></div><div class="keep"><div></div><div></div></div><div id="d"></div>
+	</body></html>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	document := plex.CreateDocument(dom, []plex_css.Stylesheet{}, plex.Viewport{Width: 800, Height: 600})
+	pages := document.Paginate(plex.Viewport{Width: 400, Height: 300})
+	if len(pages) != 5 {
+		t.Fatalf("expected 5 pages, got %v", len(pages))
+	}
+
+	// the first page has a taller top margin, so only #a fits
+	if pages[0].Margin.Top != 100 || pages[1].Margin.Top != 50 || pages[0].Bottom != 80 {
+		t.Fatalf("expected :first to apply to the first page only, got %v %v %v", pages[0].Margin, pages[1].Margin, pages[0].Bottom)
+	}
+
+	// the page does not break inside .keep, which moves to the next page
+	if pages[1].Top != 80 || pages[1].Bottom != 160 || pages[2].Bottom != 300 {
+		t.Fatalf("expected .keep to start a new page, got %v-%v %v", pages[1].Top, pages[1].Bottom, pages[2].Bottom)
+	}
+
+	// #d starts on a right page, so a blank left page is inserted
+	if !pages[3].Blank || !pages[3].Left || pages[4].Blank || pages[4].Left || pages[4].Top != 300 {
+		t.Fatalf("expected a blank page before #d, got %+v %+v", pages[3], pages[4])
+	}
+
+	boxes := pages[1].MarginBoxes
+	if len(boxes) != 1 || boxes[0].Name != "top-center" || boxes[0].Text != "Page 2 of 5" {
+		t.Fatalf("expected a numbered header, got %+v", boxes)
+	}
+	if boxes[0].Box != (sdl.FRect{X: 150, Y: 0, W: 100, H: 50}) {
+		t.Fatalf("expected the header to take the middle of the top margin, got %v", boxes[0].Box)
+	}
+}
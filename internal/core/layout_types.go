@@ -21,11 +21,12 @@ const (
 	DisplayType_Inline DisplayType = iota
 	DisplayType_Block
 	DisplayType_None
+	DisplayType_ListItem
 )
 
 func (b DisplayType) ToBoxType() (BoxType, error) {
 	switch b {
-	case DisplayType_Block:
+	case DisplayType_Block, DisplayType_ListItem:
 		return BoxType_Block, nil
 	case DisplayType_Inline:
 		return BoxType_Inline, nil
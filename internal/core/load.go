@@ -1,39 +1,95 @@
 package plex
 
 import (
+	"fmt"
 	"os"
+	"path/filepath"
+	"slices"
 	plex_css "visualsource/plex/internal/css"
 
 	"github.com/gookit/goutil/dump"
 	"github.com/veandco/go-sdl2/sdl"
 )
 
-func LoadLocalStylesheet(filepath string) (plex_css.Stylesheet, error) {
-	content, err := os.ReadFile(filepath)
+/*
+Loads a stylesheet and the stylesheets it imports. Imports resolve relative to the importing
+file and an import of a stylesheet that is already being loaded is dropped.
+*/
+func LoadLocalStylesheet(path string, origin uint) (plex_css.Stylesheet, error) {
+	return loadLocalStylesheet(path, origin, []string{})
+}
+
+func loadLocalStylesheet(path string, origin uint, importing []string) (plex_css.Stylesheet, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return plex_css.Stylesheet{}, err
+	}
+
+	content, err := os.ReadFile(path)
 	if err != nil {
 		return plex_css.Stylesheet{}, err
 	}
 
 	parser := plex_css.CssParser{}
 
-	result, err := parser.ParseStylesheet(string(content), 1)
+	result, err := parser.ParseStylesheet(string(content), origin)
 
 	if err != nil {
 		return plex_css.Stylesheet{}, err
 	}
 
+	resolveFontFaces(&result, filepath.Dir(path))
+
+	importing = append(importing, path)
+	imported := []plex_css.Stylesheet{}
+	for _, rule := range result.Imports {
+		if !rule.IsSupported() {
+			continue
+		}
+
+		href := rule.Href
+		if !filepath.IsAbs(href) {
+			href = filepath.Join(filepath.Dir(path), href)
+		}
+		if slices.Contains(importing, href) {
+			result.Diagnostics = append(result.Diagnostics, plex_css.Diagnostic{Message: fmt.Sprintf("import cycle through '%s'", rule.Href)})
+			continue
+		}
+
+		stylesheet, err := loadLocalStylesheet(href, origin, importing)
+		if err != nil {
+			result.Diagnostics = append(result.Diagnostics, plex_css.Diagnostic{Message: err.Error()})
+			continue
+		}
+		imported = append(imported, rule.Scope(stylesheet))
+	}
+	result.PrependImports(imported)
+
 	return result, nil
 }
 
-func LoadLocalHtmlDocument(filepath string, renderer *sdl.Renderer, stylesheets []plex_css.Stylesheet) error {
+// Makes the relative urls of the font faces of a stylesheet relative to its directory.
+func resolveFontFaces(stylesheet *plex_css.Stylesheet, dir string) {
+	for i := range stylesheet.FontFaces {
+		sources := stylesheet.FontFaces[i].Sources
+		for j := range sources {
+			if sources[j].Url != "" && !filepath.IsAbs(sources[j].Url) {
+				sources[j].Url = filepath.Join(dir, sources[j].Url)
+			}
+		}
+	}
+}
+
+// Loads, lays out and paints the document. The page is painted again whenever the document is restyled.
+func LoadLocalHtmlDocument(path string, renderer *sdl.Renderer, stylesheets []plex_css.Stylesheet, fonts *FontCache) (*Document, error) {
 	window, err := renderer.GetWindow()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	content, err := os.ReadFile(filepath)
+	content, err := os.ReadFile(path)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	doc := string(content)
@@ -41,16 +97,35 @@ func LoadLocalHtmlDocument(filepath string, renderer *sdl.Renderer, stylesheets
 
 	dom, err := parser.Parse(doc)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	document := CreateDocument(dom, stylesheets, GetViewport(window))
+
+	// font faces change the metrics of font-relative units
+	dir := filepath.Dir(path)
+	added := 0
+	for _, stylesheet := range document.StyleSheets() {
+		resolveFontFaces(&stylesheet.sheet, dir)
+		added += fonts.RegisterStylesheet(stylesheet.sheet)
+	}
+	if added > 0 {
+		document.computeStyles()
 	}
 
+	document.OnRestyle = func(document *Document) {
+		paintDocument(document, renderer, window, fonts)
+	}
+	paintDocument(document, renderer, window, fonts)
+
+	return document, nil
+}
+
+func paintDocument(document *Document, renderer *sdl.Renderer, window *sdl.Window, fonts *FontCache) {
 	dim := GetWindowDimentions(window)
-	style, bgColor := ParseStylesFromDocument(dom, stylesheets)
-	layout := LayoutTree(style, dim)
+	layout := document.Layout(dim)
 
 	dump.P(layout)
 
-	Print(&layout, renderer, window, bgColor)
-
-	return nil
+	Print(&layout, renderer, window, document.GetBackgroundColor(), PaintOptions{Fonts: fonts})
 }
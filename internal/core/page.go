@@ -0,0 +1,425 @@
+package plex
+
+import (
+	"slices"
+	"strings"
+	plex_css "visualsource/plex/internal/css"
+
+	"github.com/moznion/go-optional"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+/*
+A page of a paginated document. The document is laid out once in the page area of the first
+page, every page shows the next slice of that layout.
+
+Source: https://www.w3.org/TR/css-page-3/#page-model
+*/
+type Page struct {
+	// 1-based, the value of counter(page)
+	Number int
+	// documents are left-to-right, so the first page is a right page
+	Left bool
+	// a page without content, inserted so that a left or right break starts on the requested side
+	Blank bool
+	// size of the page box in px
+	Width  float32
+	Height float32
+	Margin EdgeSizes
+	// the slice of the laid out document shown in the page area, in document coordinates
+	Top         float32
+	Bottom      float32
+	MarginBoxes []MarginBox
+	layout      *LayoutBox
+}
+
+// The area of the page box the document is shown in.
+func (p *Page) PageArea() sdl.FRect {
+	return sdl.FRect{
+		X: p.Margin.Left,
+		Y: p.Margin.Top,
+		W: max(p.Width-p.Margin.Left-p.Margin.Right, 0),
+		H: max(p.Height-p.Margin.Top-p.Margin.Bottom, 0),
+	}
+}
+
+// A margin box of a page with generated content, such as a running header.
+// https://www.w3.org/TR/css-page-3/#margin-boxes
+type MarginBox struct {
+	Name  string
+	Text  string
+	Box   sdl.FRect
+	Style TextStyle
+}
+
+// The @page rules of the stylesheets that apply to print media, cascaded for each page.
+type pageStyles struct {
+	stylesheets []plex_css.Stylesheet
+	layers      layerOrder
+	// the size of pages whose 'size' is auto
+	paper Viewport
+}
+
+func createPageStyles(stylesheets []plex_css.Stylesheet, paper Viewport) pageStyles {
+	env := paper.mediaEnvironment()
+	result := []plex_css.Stylesheet{}
+	for _, stylesheet := range stylesheets {
+		pages := []plex_css.PageRule{}
+		for _, page := range stylesheet.Pages {
+			if page.MatchesMedia(&env) && page.IsSupported() {
+				pages = append(pages, page)
+			}
+		}
+		stylesheet.Pages = pages
+		result = append(result, stylesheet)
+	}
+	return pageStyles{stylesheets: result, layers: createLayerOrder(stylesheets), paper: paper}
+}
+
+// Cascades the declarations of the matching @page rules, or of their margin rules for a margin box.
+// https://www.w3.org/TR/css-page-3/#cascading-and-page-context
+func (s *pageStyles) cascade(page plex_css.PageContext, marginBox string) plex_css.CssPropertyMap {
+	declarations := []cascadedDeclaration{}
+	for sheet, stylesheet := range s.stylesheets {
+		for index, rule := range stylesheet.Pages {
+			specificity, ok := rule.Match(page)
+			if !ok {
+				continue
+			}
+
+			block := rule.Block
+			if marginBox != "" {
+				block = nil
+				for _, margin := range rule.MarginRules {
+					if margin.Name == marginBox {
+						block = append(block, margin.Block...)
+					}
+				}
+			}
+
+			for i, dec := range block {
+				declarations = append(declarations, cascadedDeclaration{
+					declaration: dec,
+					origin:      stylesheet.Origin,
+					layer:       s.layers.rank(stylesheet.Origin, rule.Layer),
+					specificity: specificity,
+					order:       [3]int{sheet, index, i},
+				})
+			}
+		}
+	}
+	return cascade(declarations)
+}
+
+// Sizes the page box and its margins. Margin percentages are relative to the page box.
+func (s *pageStyles) page(number int, blank bool) Page {
+	left := number%2 == 0
+	context := plex_css.PageContext{First: number == 1, Left: left, Blank: blank}
+	props := s.cascade(context, "")
+
+	page := Page{Number: number, Left: left, Blank: blank, Width: s.paper.Width, Height: s.paper.Height}
+	if size, err := plex_css.ParsePageSize(props["size"].Value); err == nil {
+		if size.Width > 0 {
+			page.Width, page.Height = size.Width, size.Height
+		}
+		portrait := page.Height >= page.Width
+		if size.Orientation == "landscape" && portrait || size.Orientation == "portrait" && !portrait {
+			page.Width, page.Height = page.Height, page.Width
+		}
+	}
+
+	style := computeStyle(props, nil, createLengthContext(s.paper))
+	page.Margin = EdgeSizes{
+		Left:   style.Margin.Left.Resolve(page.Width),
+		Right:  style.Margin.Right.Resolve(page.Width),
+		Top:    style.Margin.Top.Resolve(page.Height),
+		Bottom: style.Margin.Bottom.Resolve(page.Height),
+	}
+	return page
+}
+
+/*
+Generates the margin boxes of a page that have content. The boxes along a side share its
+length equally.
+
+Source: https://www.w3.org/TR/css-page-3/#margin-box-dimensions
+*/
+func (s *pageStyles) marginBoxes(page *Page, pages int, root *StyledNode, styles *computedStyleCache) []MarginBox {
+	context := plex_css.PageContext{First: page.Number == 1, Left: page.Left, Blank: page.Blank}
+	state := createGeneratedContentState()
+	state.counters["page"] = []int{page.Number}
+	state.counters["pages"] = []int{pages}
+
+	boxes := []MarginBox{}
+	for _, name := range plex_css.PAGE_MARGIN_BOXES {
+		specified := s.cascade(context, name)
+		content := specified["content"]
+		if len(content.Value) == 0 || plex_css.IsCssKeyword(content.GetValue(), "none") || plex_css.IsCssKeyword(content.GetValue(), "normal") {
+			continue
+		}
+
+		// the page context inherits from the root element
+		styled := createStyledNode(nil, specified, root, styles)
+		boxes = append(boxes, MarginBox{
+			Name:  name,
+			Text:  state.resolveContent(nil, styled.props, content.Value),
+			Box:   page.marginBoxArea(name),
+			Style: styled.style.TextStyle(),
+		})
+	}
+	return boxes
+}
+
+func (p *Page) marginBoxArea(name string) sdl.FRect {
+	side, position, _ := strings.Cut(name, "-")
+	corner := strings.HasSuffix(name, "-corner")
+	area := p.PageArea()
+
+	// the index of the box along its side
+	thirds := map[string]float32{"left": 0, "top": 0, "center": 1, "middle": 1, "right": 2, "bottom": 2}
+	index := thirds[position]
+
+	switch {
+	case corner:
+		x := float32(0)
+		if strings.Contains(position, "right") {
+			x = area.X + area.W
+		}
+		y := float32(0)
+		w := p.Margin.Left
+		if x > 0 {
+			w = p.Margin.Right
+		}
+		h := p.Margin.Top
+		if side == "bottom" {
+			y, h = area.Y+area.H, p.Margin.Bottom
+		}
+		return sdl.FRect{X: x, Y: y, W: w, H: h}
+	case side == "top":
+		return sdl.FRect{X: area.X + index*area.W/3, Y: 0, W: area.W / 3, H: p.Margin.Top}
+	case side == "bottom":
+		return sdl.FRect{X: area.X + index*area.W/3, Y: area.Y + area.H, W: area.W / 3, H: p.Margin.Bottom}
+	case side == "left":
+		return sdl.FRect{X: 0, Y: area.Y + index*area.H/3, W: p.Margin.Left, H: area.H / 3}
+	}
+	return sdl.FRect{X: area.X + area.W, Y: area.Y + index*area.H/3, W: p.Margin.Right, H: area.H / 3}
+}
+
+// A position between two boxes or two lines where a page can end.
+// https://www.w3.org/TR/css-break-3/#possible-breaks
+type breakPoint struct {
+	y float32
+	// page, left or right for a forced break
+	forced BreakType
+	avoid  bool
+}
+
+// Collects the break points between the block children of the box and between the lines of anonymous blocks.
+func collectBreakPoints(box *LayoutBox, avoid bool, points *[]breakPoint) {
+	if box.node.IsSome() && box.node.UnwrapAsPtr().style.BreakInside == BreakType_Avoid {
+		avoid = true
+	}
+
+	if box.boxType == BoxType_AnonymousBlock {
+		lines := []float32{}
+		collectLineTops(box, &lines)
+		slices.Sort(lines)
+		for i, y := range slices.Compact(lines) {
+			if i > 0 {
+				*points = append(*points, breakPoint{y: y, avoid: avoid})
+			}
+		}
+		return
+	}
+
+	for i := range box.children {
+		child := &box.children[i]
+		if i > 0 {
+			// a forced break wins over an avoided one, break-before over break-after
+			point := breakPoint{y: child.dimensions.MarginBox().Y, avoid: avoid}
+			for _, value := range []BreakType{breakBefore(child), breakAfter(&box.children[i-1])} {
+				switch {
+				case value >= BreakType_Page && point.forced == BreakType_Auto:
+					point.forced = value
+				case value == BreakType_Avoid:
+					point.avoid = true
+				}
+			}
+			*points = append(*points, point)
+		}
+		collectBreakPoints(child, avoid, points)
+	}
+}
+
+func collectLineTops(box *LayoutBox, lines *[]float32) {
+	for _, fragment := range box.fragments {
+		*lines = append(*lines, fragment.Box.Y)
+	}
+	for i := range box.children {
+		collectLineTops(&box.children[i], lines)
+	}
+}
+
+// Breaks before the first child of a box are propagated to the box.
+// https://www.w3.org/TR/css-break-3/#break-propagation
+func breakBefore(box *LayoutBox) BreakType {
+	if box.boxType == BoxType_AnonymousBlock || box.node.IsNone() {
+		return BreakType_Auto
+	}
+	value := box.node.UnwrapAsPtr().style.BreakBefore
+	if value == BreakType_Auto && len(box.children) > 0 {
+		return breakBefore(&box.children[0])
+	}
+	return value
+}
+
+func breakAfter(box *LayoutBox) BreakType {
+	if box.boxType == BoxType_AnonymousBlock || box.node.IsNone() {
+		return BreakType_Auto
+	}
+	value := box.node.UnwrapAsPtr().style.BreakAfter
+	if value == BreakType_Auto && len(box.children) > 0 {
+		return breakAfter(&box.children[len(box.children)-1])
+	}
+	return value
+}
+
+/*
+Chooses where a page starting at top and fitting content up to limit ends: at the first forced
+break, else at the end of the document, else at the last break point that is not avoided, else
+at the last avoided one. Content without any break point is cut at the limit.
+
+Source: https://www.w3.org/TR/css-break-3/#breaking-rules
+*/
+func chooseBreak(points []breakPoint, top float32, limit float32, end float32) (float32, BreakType) {
+	forced := breakPoint{y: limit + 1}
+	for _, point := range points {
+		if point.forced != BreakType_Auto && point.y > top && point.y <= limit && point.y < forced.y {
+			forced = point
+		}
+	}
+	if forced.y <= limit {
+		return forced.y, forced.forced
+	}
+	if end <= limit {
+		return end, BreakType_Auto
+	}
+
+	best, avoided := top, top
+	for _, point := range points {
+		if point.y <= top || point.y > limit {
+			continue
+		}
+		if point.avoid {
+			avoided = max(avoided, point.y)
+		} else {
+			best = max(best, point.y)
+		}
+	}
+	switch {
+	case best > top:
+		return best, BreakType_Auto
+	case avoided > top:
+		return avoided, BreakType_Auto
+	}
+	return limit, BreakType_Auto
+}
+
+/*
+Lays the document out into pages for print media. Pages whose 'size' is auto get the size of
+the paper. Page type names are not supported, so only the :first, :left, :right and :blank
+selectors tell pages apart.
+
+Source: https://www.w3.org/TR/css-page-3/
+*/
+func (d *Document) Paginate(paper Viewport) []Page {
+	paper.Media = "print"
+	styles := createPageStyles(d.stylesheets(), paper)
+	first := styles.page(1, false)
+	area := first.PageArea()
+
+	// the initial containing block of paged media is the page area of the first page
+	printed := &Document{root: d.root, styleSheets: d.styleSheets, viewport: Viewport{Width: area.W, Height: area.H, Media: "print"}}
+	printed.computeStyles()
+	layout := printed.Layout(Dimensions{Content: sdl.FRect{W: area.W}})
+
+	points := []breakPoint{}
+	collectBreakPoints(&layout, false, &points)
+	margin := layout.dimensions.MarginBox()
+	top, end := margin.Y, margin.Y+margin.H
+
+	pages := []Page{}
+	side := BreakType_Auto
+	for number := 1; number == 1 || top < end; number++ {
+		left := number%2 == 0
+		if side == BreakType_Left && !left || side == BreakType_Right && left {
+			blank := styles.page(number, true)
+			blank.Top, blank.Bottom = top, top
+			pages = append(pages, blank)
+			side = BreakType_Auto
+			continue
+		}
+
+		page := styles.page(number, false)
+		page.Top = top
+		page.Bottom, side = chooseBreak(points, top, top+max(page.PageArea().H, 1), end)
+		pages = append(pages, page)
+		top = page.Bottom
+	}
+
+	cache := createComputedStyleCache(printed.viewport)
+	for i := range pages {
+		pages[i].layout = &layout
+		pages[i].MarginBoxes = styles.marginBoxes(&pages[i], len(pages), &printed.styleTree, cache)
+	}
+	return pages
+}
+
+/*
+Builds the display list of a page in page coordinates: the slice of the document shown in the
+page area, followed by the text of the margin boxes. Backgrounds crossing the edges of the
+slice are cut, text is shown on the page its line starts on.
+*/
+func (p *Page) displayList() []RenderCommand {
+	list := []RenderCommand{}
+	area := p.PageArea()
+	dx, dy := area.X, area.Y-p.Top
+
+	if p.layout != nil && !p.Blank {
+		for _, cmd := range buildDisplayList(p.layout, optional.None[TextSelection]()) {
+			switch v := cmd.(type) {
+			case RenderSolidColor:
+				top, bottom := max(v.Box.Y, p.Top), min(v.Box.Y+v.Box.H, p.Bottom)
+				if bottom <= top {
+					continue
+				}
+				v.Box = sdl.FRect{X: v.Box.X + dx, Y: top + dy, W: v.Box.W, H: bottom - top}
+				list = append(list, v)
+			case RenderText:
+				if v.Box.Y < p.Top || v.Box.Y >= p.Bottom {
+					continue
+				}
+				v.Box.X += dx
+				v.Box.Y += dy
+				list = append(list, v)
+			}
+		}
+	}
+
+	for _, margin := range p.MarginBoxes {
+		list = append(list, RenderText{Text: margin.Text, Style: margin.Style, Box: margin.Box})
+	}
+	return list
+}
+
+// Paints a page onto the renderer, the page box filling it from the top left corner.
+func PrintPage(page *Page, renderer *sdl.Renderer, pageBgColor plex_css.CssColor, options PaintOptions) {
+	renderer.SetDrawColor(uint8(pageBgColor.R), uint8(pageBgColor.G), uint8(pageBgColor.B), 255)
+	renderer.FillRectF(&sdl.FRect{W: page.Width, H: page.Height})
+
+	for _, cmd := range page.displayList() {
+		printItem(renderer, options.Fonts, page.Width, page.Height, cmd)
+	}
+
+	renderer.Present()
+}
@@ -12,121 +12,170 @@ type RenderSolidColor struct {
 	Box   sdl.FRect
 }
 
-type RenderCommand interface {
+type RenderText struct {
+	Text  string
+	Style TextStyle
+	Box   sdl.FRect
 }
 
-func resolveColor(box *LayoutBox, key ...string) optional.Option[plex_css.CssColor] {
-	if box.boxType == BoxType_AnonymousBlock {
-		return nil
-	}
-
-	if box.node.IsNone() {
-		return nil
-	}
-
-	style := box.node.Unwrap()
-
-	dec := style.props.Lookup(key...)
+type RenderCommand interface {
+}
 
-	if dec.IsNone() {
-		return nil
-	}
+// A range of selected runes inside a text node.
+type TextSelection struct {
+	Node  *TextNode
+	Start int
+	End   int
+}
 
-	prop := dec.Unwrap()
+// Colors used for selected text when ::selection does not set them.
+var SELECTION_BACKGROUND = plex_css.CssColor{R: 51, G: 153, B: 255, A: 255}
+var SELECTION_COLOR = plex_css.CSS_COLOR_KEYWORDS["white"]
 
-	return plex_css.ResolveCssValueToColor(prop.GetValue())
+type PaintOptions struct {
+	Fonts     *FontCache
+	Selection optional.Option[TextSelection]
 }
 
 func renderBackground(list *[]RenderCommand, box *LayoutBox) {
-	if box.node.IsNone() {
+	if box.boxType == BoxType_AnonymousBlock || box.node.IsNone() {
 		return
 	}
-	bgColor := resolveColor(box, "background-color", "background")
 
-	if bgColor.IsNone() {
+	color := box.node.Unwrap().style.BackgroundColor
+	if color.A == 0 {
 		return
 	}
 
 	*list = append(*list, RenderSolidColor{
-		Color: bgColor.Unwrap(),
+		Color: color,
 		Box:   box.dimensions.BorderBox(),
 	})
 }
 
 func renderBorder(list *[]RenderCommand, box *LayoutBox) {
-	resolvedColor := resolveColor(box, "border-color")
-	if resolvedColor.IsNone() {
+	if box.boxType == BoxType_AnonymousBlock || box.node.IsNone() {
 		return
 	}
 
-	color := resolvedColor.Unwrap()
+	colors := box.node.Unwrap().style.BorderColor
 	borderBox := box.dimensions.BorderBox()
-	// Left Border
-	*list = append(*list, RenderSolidColor{
-		Color: color,
-		Box: sdl.FRect{
-			X: borderBox.X,
-			Y: borderBox.Y,
-			W: box.dimensions.Border.Left,
-			H: borderBox.H,
+	sides := []RenderSolidColor{
+		// Left Border
+		{
+			Color: colors.Left,
+			Box:   sdl.FRect{X: borderBox.X, Y: borderBox.Y, W: box.dimensions.Border.Left, H: borderBox.H},
 		},
-	})
-
-	// Right Border
-	*list = append(*list, RenderSolidColor{
-		Color: color,
-		Box: sdl.FRect{
-			X: borderBox.X + borderBox.W - box.dimensions.Border.Right,
-			Y: borderBox.Y,
-			W: box.dimensions.Border.Right,
-			H: borderBox.H,
+		// Right Border
+		{
+			Color: colors.Right,
+			Box:   sdl.FRect{X: borderBox.X + borderBox.W - box.dimensions.Border.Right, Y: borderBox.Y, W: box.dimensions.Border.Right, H: borderBox.H},
 		},
-	})
-
-	// Top Border
-	*list = append(*list, RenderSolidColor{
-		Color: color,
-		Box: sdl.FRect{
-			X: borderBox.X,
-			Y: borderBox.Y,
-			W: borderBox.W,
-			H: box.dimensions.Border.Top,
+		// Top Border
+		{
+			Color: colors.Top,
+			Box:   sdl.FRect{X: borderBox.X, Y: borderBox.Y, W: borderBox.W, H: box.dimensions.Border.Top},
 		},
-	})
-
-	// Bottom Border
-	*list = append(*list, RenderSolidColor{
-		Color: color,
-		Box: sdl.FRect{
-			X: borderBox.X,
-			Y: borderBox.Y + borderBox.H - box.dimensions.Border.Bottom,
-			W: borderBox.W,
-			H: box.dimensions.Border.Bottom,
+		// Bottom Border
+		{
+			Color: colors.Bottom,
+			Box:   sdl.FRect{X: borderBox.X, Y: borderBox.Y + borderBox.H - box.dimensions.Border.Bottom, W: borderBox.W, H: box.dimensions.Border.Bottom},
 		},
-	})
+	}
 
+	for _, side := range sides {
+		if side.Box.W > 0 && side.Box.H > 0 && side.Color.A > 0 {
+			*list = append(*list, side)
+		}
+	}
 }
 
-func renderLayout(list *[]RenderCommand, layout *LayoutBox) {
+func renderText(list *[]RenderCommand, box *LayoutBox, selection optional.Option[TextSelection]) {
+	if len(box.fragments) == 0 {
+		return
+	}
+
+	var selected *TextSelection
+	if selection.IsSome() {
+		if node, ok := box.node.Unwrap().node.(*TextNode); ok && selection.UnwrapAsPtr().Node == node {
+			selected = selection.UnwrapAsPtr()
+		}
+	}
+
+	for _, fragment := range box.fragments {
+		// ::first-line and ::first-letter backgrounds sit behind their text
+		if fragment.Background.A > 0 {
+			*list = append(*list, RenderSolidColor{Color: fragment.Background, Box: fragment.Box})
+		}
+
+		if selected == nil || selected.End <= fragment.Offset || selected.Start >= fragment.Offset+fragment.Length {
+			*list = append(*list, RenderText{Text: fragment.Text, Style: fragment.Style, Box: fragment.Box})
+			continue
+		}
+
+		renderSelectedFragment(list, box, fragment, selected)
+	}
+}
+
+// Splits a fragment around the selection and paints the selected part with the ::selection colors.
+// https://www.w3.org/TR/css-pseudo-4/#highlight-painting
+func renderSelectedFragment(list *[]RenderCommand, box *LayoutBox, fragment TextFragment, selection *TextSelection) {
+	runes := []rune(fragment.Text)
+	start := min(max(selection.Start-fragment.Offset, 0), len(runes))
+	end := min(max(selection.End-fragment.Offset, 0), len(runes))
+
+	background := SELECTION_BACKGROUND
+	highlight := fragment.Style
+	highlight.Color = SELECTION_COLOR
+	box.selection.GetProp("color").IfSome(func(v plex_css.Declaration) {
+		highlight = highlight.Apply(plex_css.CssPropertyMap{"color": v}, box.lengthContext())
+	})
+	background = computeColor(box.selection, "background-color", highlight.Color, background)
+
+	x := fragment.Box.X
+	for i, part := range []string{string(runes[:start]), string(runes[start:end]), string(runes[end:])} {
+		if part == "" {
+			continue
+		}
+
+		w, _ := DefaultTextMeasurer.MeasureText(part, fragment.Style)
+		target := sdl.FRect{X: x, Y: fragment.Box.Y, W: w, H: fragment.Box.H}
+
+		if i == 1 {
+			*list = append(*list, RenderSolidColor{Color: background, Box: target})
+			*list = append(*list, RenderText{Text: part, Style: highlight, Box: target})
+		} else {
+			*list = append(*list, RenderText{Text: part, Style: fragment.Style, Box: target})
+		}
+		x += w
+	}
+}
+
+func renderLayout(list *[]RenderCommand, layout *LayoutBox, selection optional.Option[TextSelection]) {
 	renderBackground(list, layout)
 	renderBorder(list, layout)
-
-	// Render Text HERE
+	renderText(list, layout, selection)
 
 	for _, child := range layout.children {
-		renderLayout(list, &child)
+		renderLayout(list, &child, selection)
 	}
 }
 
-func buildDisplayList(layout *LayoutBox) []RenderCommand {
+func buildDisplayList(layout *LayoutBox, selection optional.Option[TextSelection]) []RenderCommand {
 	cmdList := []RenderCommand{}
 
-	renderLayout(&cmdList, layout)
+	renderLayout(&cmdList, layout, selection)
 
 	return cmdList
 }
 
-func printItem(renderer *sdl.Renderer, width float32, height float32, cmd RenderCommand) {
+func printItem(renderer *sdl.Renderer, fonts *FontCache, width float32, height float32, cmd RenderCommand) {
+
+	if v, ok := cmd.(RenderText); ok {
+		color := sdl.Color{R: uint8(v.Style.Color.R), G: uint8(v.Style.Color.G), B: uint8(v.Style.Color.B), A: uint8(v.Style.Color.A)}
+		// text without a loaded font is skipped
+		fonts.RenderTextWraped(renderer, &v.Box, v.Text, color, v.Style.Font, int(width))
+	}
 
 	if v, ok := cmd.(RenderSolidColor); ok {
 		renderer.SetDrawColor(uint8(v.Color.R), uint8(v.Color.G), uint8(v.Color.B), uint8(v.Color.A))
@@ -140,8 +189,8 @@ func printItem(renderer *sdl.Renderer, width float32, height float32, cmd Render
 
 }
 
-func Print(layout *LayoutBox, renderer *sdl.Renderer, window *sdl.Window, windowBgColor plex_css.CssColor) {
-	displayList := buildDisplayList(layout)
+func Print(layout *LayoutBox, renderer *sdl.Renderer, window *sdl.Window, windowBgColor plex_css.CssColor, options PaintOptions) {
+	displayList := buildDisplayList(layout, options.Selection)
 
 	w, h := window.GetSize()
 
@@ -155,7 +204,7 @@ func Print(layout *LayoutBox, renderer *sdl.Renderer, window *sdl.Window, window
 	})
 
 	for _, child := range displayList {
-		printItem(renderer, fw, fh, child)
+		printItem(renderer, options.Fonts, fw, fh, child)
 	}
 
 	renderer.Present()
@@ -1,7 +1,6 @@
 package plex
 
 import (
-	"sort"
 	plex_css "visualsource/plex/internal/css"
 
 	"github.com/moznion/go-optional"
@@ -11,40 +10,98 @@ import (
 type StyledNode struct {
 	node     Node
 	props    plex_css.CssPropertyMap
+	style    *ComputedStyle
 	children []StyledNode
+	// generated ::before, ::after, ::marker and ::placeholder boxes
+	before      optional.Option[StyledNode]
+	after       optional.Option[StyledNode]
+	marker      optional.Option[StyledNode]
+	placeholder optional.Option[StyledNode]
+	// styles of the ::first-line, ::first-letter and ::selection pseudo-elements
+	pseudo map[string]plex_css.CssPropertyMap
+	// what the lengths of those styles resolve against
+	lengths lengthContext
 }
 
 func (n *StyledNode) GetDisplay() DisplayType {
+	return n.style.Display
+}
 
-	value := n.props.GetProp("display")
-
-	if value.IsNone() {
-		return DisplayType_Inline
-	}
-
-	display := value.Unwrap()
+func (n *StyledNode) GetStyle() *ComputedStyle {
+	return n.style
+}
 
-	if item, ok := display.GetValue().(*plex_css.CssKeyword); ok {
-		switch item.Value {
-		case "block":
-			return DisplayType_Block
-		case "none":
-			return DisplayType_None
-		case "inline":
-			fallthrough
-		default:
-			return DisplayType_Inline
-		}
-	}
+func (n *StyledNode) GetChildren() []StyledNode {
+	return n.children
+}
 
-	return DisplayType_Inline
+func (n *StyledNode) GetNode() Node {
+	return n.node
 }
 
 func CreateStyleNode(node Node, props plex_css.CssPropertyMap, children []StyledNode) StyledNode {
+	context := createLengthContext(DefaultViewport)
+	style := computeStyle(props, nil, context)
 	return StyledNode{
 		node:     node,
 		props:    props,
+		style:    &style,
 		children: children,
+		lengths:  context,
+	}
+}
+
+// Creates a node whose cascaded values are defaulted and computed against its parent's.
+func createStyledNode(node Node, specified plex_css.CssPropertyMap, parent *StyledNode, styles *computedStyleCache) StyledNode {
+	var parentProps plex_css.CssPropertyMap
+	var parentStyle *ComputedStyle
+	if parent != nil {
+		parentProps = parent.props
+		parentStyle = parent.style
+	}
+
+	props := defaultValues(resolveVariables(specified, parentProps), parentProps)
+	style := styles.intern(computeStyle(props, parentStyle, styles.context))
+	inheritComputedValues(props, style)
+
+	// 'rem' and 'rlh' resolve against the root element
+	if parent == nil {
+		styles.context.root = style.Font
+		styles.context.rootLineHeight = style.LineHeight
+	}
+
+	return StyledNode{
+		node:     node,
+		props:    props,
+		style:    style,
+		children: []StyledNode{},
+		lengths:  styles.context,
+	}
+}
+
+/*
+Replaces relative font sizes and line heights by their computed px value, so descendants
+inherit the computed value instead of resolving it again. Unitless line heights are inherited
+as numbers.
+
+Source: https://www.w3.org/TR/css-cascade-5/#inheriting
+*/
+func inheritComputedValues(props plex_css.CssPropertyMap, style *ComputedStyle) {
+	props["font-size"] = plex_css.Declaration{
+		Name:  "font-size",
+		Value: []plex_css.CssValue{&plex_css.CssDimention{Value: style.Font.Size, Unit: plex_css.CssUnit_PX}},
+	}
+
+	lineHeight := props["line-height"]
+	if len(lineHeight.Value) == 0 || plex_css.IsCssKeyword(lineHeight.GetValue(), "normal") {
+		return
+	}
+	if mathType, ok := plex_css.MathTypeOf(lineHeight.GetValue()); ok && mathType == plex_css.MathType_Number {
+		return
+	}
+	props["line-height"] = plex_css.Declaration{
+		Name:  "line-height",
+		Value: []plex_css.CssValue{&plex_css.CssDimention{Value: style.LineHeight, Unit: plex_css.CssUnit_PX}},
 	}
 }
 
@@ -52,32 +109,42 @@ type MatchedRule struct {
 	specificity plex_css.Specificity
 	rule        plex_css.Rule
 	Orgin       uint
+	// position of the rule in its stylesheet
+	index int
 }
 
 // #region-start utility
 
-func matchRule(el *ElementNode, rule plex_css.Rule) optional.Option[MatchedRule] {
+func matchRule(el *ElementNode, rule plex_css.Rule, pseudo string) optional.Option[MatchedRule] {
 
+	matched := optional.None[MatchedRule]()
 	for _, selector := range rule.Selector {
-		if el.Matches(&selector) {
-			return optional.Some(MatchedRule{
-				specificity: selector.GetSpecificity(),
+		if !el.MatchesPseudoElement(&selector, pseudo) {
+			continue
+		}
+
+		// the most specific matching selector of the list is used
+		specificity := selector.GetSpecificity()
+		if matched.IsNone() || matched.UnwrapAsPtr().specificity.Less(&specificity) {
+			matched = optional.Some(MatchedRule{
+				specificity: specificity,
 				rule:        rule,
 			})
 		}
 	}
 
-	return nil
+	return matched
 }
 
-func matchRules(el *ElementNode, stylesheet *plex_css.Stylesheet) []MatchedRule {
+func matchRules(el *ElementNode, stylesheet *plex_css.Stylesheet, pseudo string) []MatchedRule {
 	rules := []MatchedRule{}
 
-	for _, rule := range stylesheet.Rules {
-		result := matchRule(el, rule)
+	for i, rule := range stylesheet.Rules {
+		result := matchRule(el, rule, pseudo)
 		if result.IsSome() {
 			item := result.Unwrap()
 			item.Orgin = stylesheet.Origin
+			item.index = i
 			rules = append(rules, item)
 		}
 	}
@@ -86,46 +153,245 @@ func matchRules(el *ElementNode, stylesheet *plex_css.Stylesheet) []MatchedRule
 }
 
 func specifiedValues(el *ElementNode, stylesheets []plex_css.Stylesheet) plex_css.CssPropertyMap {
-	values := plex_css.CssPropertyMap{}
+	return specifiedPseudoValues(el, stylesheets, "")
+}
 
-	rules := []MatchedRule{}
+// Collects the declarations for the given pseudo-element of el. An empty pseudo selects the element itself.
+func specifiedPseudoValues(el *ElementNode, stylesheets []plex_css.Stylesheet, pseudo string) plex_css.CssPropertyMap {
+	layers := createLayerOrder(stylesheets)
+	declarations := []cascadedDeclaration{}
+
+	for sheet, stylesheet := range stylesheets {
+		for _, matched := range matchRules(el, &stylesheet, pseudo) {
+			for i, dec := range matched.rule.Block {
+				declarations = append(declarations, cascadedDeclaration{
+					declaration: dec,
+					origin:      matched.Orgin,
+					layer:       layers.rank(matched.Orgin, matched.rule.Layer),
+					specificity: matched.specificity,
+					order:       [3]int{sheet, matched.index, i},
+				})
+			}
+		}
+	}
+
+	// the style attribute only styles the element itself
+	if pseudo == "" {
+		for i, dec := range el.GetInlineStyle() {
+			declarations = append(declarations, cascadedDeclaration{
+				declaration: dec,
+				origin:      plex_css.Origin_Author,
+				inline:      true,
+				layer:       layers.rank(plex_css.Origin_Author, ""),
+				order:       [3]int{len(stylesheets), 0, i},
+			})
+		}
+	}
+
+	return cascade(declarations)
+}
+
+// Computes the styles of a document displayed in a viewport of the given size. Without a layout
+// no query container has a size, so the rules of @container blocks do not apply.
+func StyleTree(root Node, stylesheet []plex_css.Stylesheet, viewport Viewport) StyledNode {
+	return containerStyleTree(root, stylesheet, viewport, nil)
+}
+
+// Computes the styles of a document whose query containers were laid out with the given sizes.
+func containerStyleTree(root Node, stylesheet []plex_css.Stylesheet, viewport Viewport, sizes map[*ElementNode]containerSize) StyledNode {
+	if el, ok := root.(*ElementNode); ok {
+		linkParents(el)
+	}
+
+	state := createGeneratedContentState()
+	styles := createComputedStyleCache(viewport)
+	styles.queries = containerQueries{
+		sizes:       sizes,
+		env:         viewport.mediaEnvironment(),
+		stylesheets: conditionalRules(stylesheet, viewport.mediaEnvironment()),
+	}
+	return styleTree(root, styles.queries.rules(), &state, styles, nil)
+}
+
+/*
+Drops the rules of @media blocks that do not match the device and of @supports blocks whose
+condition does not hold, the order of the others is kept.
+*/
+func conditionalRules(stylesheets []plex_css.Stylesheet, env plex_css.MediaEnvironment) []plex_css.Stylesheet {
+	result := []plex_css.Stylesheet{}
 	for _, stylesheet := range stylesheets {
-		rules = append(rules, matchRules(el, &stylesheet)...)
+		rules := []plex_css.Rule{}
+		for _, rule := range stylesheet.Rules {
+			if rule.MatchesMedia(&env) && rule.IsSupported() {
+				rules = append(rules, rule)
+			}
+		}
+		stylesheet.Rules = rules
+		result = append(result, stylesheet)
+	}
+	return result
+}
+
+func styleTree(root Node, stylesheet []plex_css.Stylesheet, state *generatedContentState, styles *computedStyleCache, parent *StyledNode) StyledNode {
+
+	node, ok := (root).(*ElementNode)
+	if !ok {
+		return createStyledNode(root, plex_css.CssPropertyMap{}, parent, styles)
+	}
+
+	styled := createStyledNode(root, specifiedValues(node, stylesheet), parent, styles)
+	styled.pseudo = typographicPseudoValues(node, &styled, stylesheet)
+
+	isListItem := styled.GetDisplay() == DisplayType_ListItem
+	if isListItem {
+		state.incrementListItem(styled.props)
+	}
+	state.applyCounters(styled.props)
+
+	if isListItem {
+		styled.marker = generateMarker(node, &styled, stylesheet, state, styles)
 	}
+	// ::before and ::after are children of the element, the counters they create end with it
+	scope := state.enterScope()
+	styled.before = generatePseudoElement(node, plex_css.PseudoElement_Before, &styled, stylesheet, state, styles)
 
-	sort.Slice(rules, func(i, j int) bool {
-		a := rules[i]
-		b := rules[j]
-		return a.Orgin < b.Orgin || a.specificity.Less(&b.specificity)
-	})
+	ancestors, context := styles.queries.ancestors, styles.context
+	childSheets := stylesheet
+	if styles.enterContainer(node, &styled) {
+		childSheets = styles.queries.rules()
+	}
+	for _, child := range node.GetChildren() {
+		styled.children = append(styled.children, styleTree(child, childSheets, state, styles, &styled))
+	}
+	styles.queries.ancestors, styles.context = ancestors, context
+
+	styled.after = generatePseudoElement(node, plex_css.PseudoElement_After, &styled, stylesheet, state, styles)
+	state.leaveScope(scope)
+	styled.placeholder = generatePlaceholder(node, &styled, stylesheet, styles)
+
+	return styled
+}
+
+// Computes the styles of the pseudo-elements that style part of the element's own content,
+// keeping only the properties that apply to each of them.
+func typographicPseudoValues(el *ElementNode, styled *StyledNode, stylesheet []plex_css.Stylesheet) map[string]plex_css.CssPropertyMap {
+	pseudos := map[string]plex_css.CssPropertyMap{}
 
-	for _, matched := range rules {
-		for _, dec := range matched.rule.Block {
-			values[dec.Name] = dec
+	for _, pseudo := range []string{plex_css.PseudoElement_FirstLine, plex_css.PseudoElement_FirstLetter, plex_css.PseudoElement_Selection} {
+		props := restrictPseudoValues(specifiedPseudoValues(el, stylesheet, pseudo), pseudo)
+		if len(props) > 0 {
+			pseudos[pseudo] = resolveVariables(props, styled.props)
 		}
 	}
 
-	return values
+	return pseudos
 }
 
-func StyleTree(root Node, stylesheet []plex_css.Stylesheet) StyledNode {
+func restrictPseudoValues(props plex_css.CssPropertyMap, pseudo string) plex_css.CssPropertyMap {
+	for name := range props {
+		if !plex_css.IsPropertyAllowedOnPseudoElement(pseudo, name) {
+			delete(props, name)
+		}
+	}
+	return props
+}
 
-	var specified plex_css.CssPropertyMap
-	children := []StyledNode{}
-	if node, ok := (root).(*ElementNode); ok {
+/*
+Generates the ::marker box of a list item from its 'content' or its 'list-style-type'.
 
-		specified = specifiedValues(node, stylesheet)
+Source: https://www.w3.org/TR/css-lists-3/#marker-pseudo
+*/
+func generateMarker(el *ElementNode, item *StyledNode, stylesheet []plex_css.Stylesheet, state *generatedContentState, styles *computedStyleCache) optional.Option[StyledNode] {
+	specified := restrictPseudoValues(specifiedPseudoValues(el, stylesheet, plex_css.PseudoElement_Marker), plex_css.PseudoElement_Marker)
+	marker := createStyledNode(nil, specified, item, styles)
+	props := marker.props
 
-		for _, child := range node.GetChildren() {
-			children = append(children, StyleTree(child, stylesheet))
+	var text string
+	content := props.GetProp("content")
+	dec := content.Unwrap()
+	if content.IsSome() && len(dec.Value) > 0 && !plex_css.IsCssKeyword(dec.GetValue(), "normal") {
+		if plex_css.IsCssKeyword(dec.GetValue(), "none") {
+			return nil
 		}
+		text = state.resolveContent(el, props, dec.Value)
 	} else {
-		specified = plex_css.CssPropertyMap{}
+		style := "disc"
+		item.props.ResolveLookupToCssValue("list-style-type", "list-style").IfSome(func(v plex_css.CssValue) {
+			switch value := v.(type) {
+			case *plex_css.CssKeyword:
+				style = value.Value
+			case *plex_css.CssString:
+				style = ""
+				text = value.Value
+			}
+		})
+
+		switch style {
+		case "":
+		case "none":
+			return nil
+		case "disc", "circle", "square":
+			text = formatCounter(0, style) + " "
+		default:
+			text = formatCounter(state.counterValue("list-item"), style) + ". "
+		}
 	}
 
-	return StyledNode{
-		node:     root,
-		props:    specified,
-		children: children,
+	textNode := CreateTextNode(text)
+	return optional.Some(createGeneratedNode(plex_css.PseudoElement_Marker, el, marker, &textNode, styles))
+}
+
+/*
+Generates the ::placeholder box of an empty input or textarea.
+
+Source: https://www.w3.org/TR/css-pseudo-4/#placeholder-pseudo
+*/
+func generatePlaceholder(el *ElementNode, input *StyledNode, stylesheet []plex_css.Stylesheet, styles *computedStyleCache) optional.Option[StyledNode] {
+	if el.GetTagName() != "input" && el.GetTagName() != "textarea" {
+		return nil
 	}
+
+	if !el.HasAttribute("placeholder") || el.GetAttribute("value") != "" || el.GetTextContent() != "" {
+		return nil
+	}
+
+	specified := restrictPseudoValues(specifiedPseudoValues(el, stylesheet, plex_css.PseudoElement_Placeholder), plex_css.PseudoElement_Placeholder)
+	placeholder := createStyledNode(nil, specified, input, styles)
+
+	textNode := CreateTextNode(el.GetAttribute("placeholder"))
+	return optional.Some(createGeneratedNode(plex_css.PseudoElement_Placeholder, el, placeholder, &textNode, styles))
+}
+
+// https://www.w3.org/TR/css-content-3/#content-property
+func generatePseudoElement(el *ElementNode, pseudo string, origin *StyledNode, stylesheet []plex_css.Stylesheet, state *generatedContentState, styles *computedStyleCache) optional.Option[StyledNode] {
+	specified := specifiedPseudoValues(el, stylesheet, pseudo)
+	if specified.GetProp("content").IsNone() {
+		return nil
+	}
+
+	styled := createStyledNode(nil, specified, origin, styles)
+	props := styled.props
+
+	dec := props["content"]
+	if len(dec.Value) == 0 || plex_css.IsCssKeyword(dec.GetValue(), "none") || plex_css.IsCssKeyword(dec.GetValue(), "normal") {
+		return nil
+	}
+
+	if styled.GetDisplay() == DisplayType_None {
+		return nil
+	}
+
+	state.applyCounters(props)
+
+	text := CreateTextNode(state.resolveContent(el, props, dec.Value))
+	return optional.Some(createGeneratedNode(pseudo, el, styled, &text, styles))
+}
+
+// Attaches a pseudo-element node holding the generated text to its styled box.
+func createGeneratedNode(pseudo string, el *ElementNode, styled StyledNode, text *TextNode, styles *computedStyleCache) StyledNode {
+	node := CreatePseudoElementNode(pseudo, el, []Node{text})
+
+	styled.node = &node
+	styled.children = []StyledNode{createStyledNode(text, plex_css.CssPropertyMap{}, &styled, styles)}
+	return styled
 }
@@ -0,0 +1,104 @@
+package plex
+
+import (
+	"unicode"
+	"unicode/utf8"
+	plex_css "visualsource/plex/internal/css"
+)
+
+// Measures runs of text for the inline layout.
+type TextMeasurer interface {
+	MeasureText(text string, style TextStyle) (float32, float32)
+}
+
+// Estimates text metrics from the font size alone. Used when no fonts are loaded.
+type approximateTextMeasurer struct{}
+
+func (m approximateTextMeasurer) MeasureText(text string, style TextStyle) (float32, float32) {
+	return float32(utf8.RuneCountInString(text)) * style.Font.Size * 0.5, style.Font.Size * 1.2
+}
+
+// The measurer used by LayoutTree.
+var DefaultTextMeasurer TextMeasurer = approximateTextMeasurer{}
+
+/*
+The metrics of a font behind the font-relative units, as fractions of the font size.
+
+Source: https://www.w3.org/TR/css-values-4/#font-relative-lengths
+*/
+type FontMetrics struct {
+	// 'ex', the height of a lowercase x
+	XHeight float32
+	// 'ch', the advance of the digit zero
+	ZeroAdvance float32
+	// 'cap', the height of capital letters
+	CapHeight float32
+	// 'ic', the advance of the CJK water ideograph
+	IdeographAdvance float32
+}
+
+// Measures the first available font of a descriptor.
+type FontMetricsProvider interface {
+	MeasureFont(font FontDescriptor) FontMetrics
+}
+
+// The fallbacks the spec gives for fonts that can not be measured, with the cap height of a typical latin font.
+var APPROXIMATE_FONT_METRICS = FontMetrics{XHeight: 0.5, ZeroAdvance: 0.5, CapHeight: 0.7, IdeographAdvance: 1}
+
+func (m approximateTextMeasurer) MeasureFont(font FontDescriptor) FontMetrics {
+	return APPROXIMATE_FONT_METRICS
+}
+
+// The metrics font-relative units are computed with.
+var DefaultFontMetrics FontMetricsProvider = approximateTextMeasurer{}
+
+// The subset of inherited properties the inline layout and text painter need.
+type TextStyle struct {
+	Font  FontDescriptor
+	Color plex_css.CssColor
+}
+
+func (c *ComputedStyle) TextStyle() TextStyle {
+	return TextStyle{Font: c.Font, Color: c.Color}
+}
+
+// Applies the text properties of a ::first-line, ::first-letter or ::selection on top of the style,
+// resolving their lengths against the context of the element they belong to.
+func (t TextStyle) Apply(props plex_css.CssPropertyMap, context lengthContext) TextStyle {
+	t.Font = computeFont(props, t.Font, lengthResolver{context: context, font: t.Font})
+	t.Color = computeColor(props, "color", t.Color, t.Color)
+	return t
+}
+
+/*
+Splits text into its first typographic letter unit, including any surrounding punctuation, and the rest.
+
+Source: https://www.w3.org/TR/css-pseudo-4/#first-letter-pattern
+*/
+func splitFirstLetter(text string) (string, string) {
+	runes := []rune(text)
+	start := 0
+	for start < len(runes) && unicode.IsSpace(runes[start]) {
+		start++
+	}
+
+	i := start
+	for i < len(runes) && unicode.IsPunct(runes[i]) {
+		i++
+	}
+
+	if i >= len(runes) || unicode.IsSpace(runes[i]) {
+		return "", text
+	}
+	i++
+
+	// combining marks belong to the letter they follow
+	for i < len(runes) && unicode.Is(unicode.Mn, runes[i]) {
+		i++
+	}
+	for i < len(runes) && unicode.IsPunct(runes[i]) {
+		i++
+	}
+
+	return string(runes[start:i]), string(runes[i:])
+}
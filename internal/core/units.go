@@ -0,0 +1,133 @@
+package plex
+
+import (
+	plex_css "visualsource/plex/internal/css"
+)
+
+// The size of the initial containing block in px, and the media type of the device showing it.
+type Viewport struct {
+	Width  float32
+	Height float32
+	// screen when empty
+	Media string
+}
+
+// The viewport used when a style tree is computed without a window.
+var DefaultViewport = Viewport{Width: 800, Height: 600}
+
+// The device media queries are evaluated against, the viewport size and media type aside it is the default one.
+func (v Viewport) mediaEnvironment() plex_css.MediaEnvironment {
+	env := plex_css.DefaultMediaEnvironment
+	env.Width = v.Width
+	env.Height = v.Height
+	env.FontSize = INITIAL_FONT.Size
+	if v.Media != "" {
+		env.Type = v.Media
+	}
+	return env
+}
+
+// What root and viewport relative lengths resolve against while computing a style tree.
+type lengthContext struct {
+	viewport Viewport
+	// font and line height of the root element, the initial ones while computing the root
+	root           FontDescriptor
+	rootLineHeight float32
+	// content box of the nearest query container in each axis, the viewport without one
+	container containerSize
+}
+
+func createLengthContext(viewport Viewport) lengthContext {
+	return lengthContext{
+		viewport:       viewport,
+		container:      containerSize{Width: viewport.Width, Height: viewport.Height},
+		root:           INITIAL_FONT,
+		rootLineHeight: normalLineHeight(INITIAL_FONT),
+	}
+}
+
+/*
+Converts lengths to px. Font-relative units resolve against font, 'lh' against lineHeight.
+Percentages depend on the property and are left to the caller.
+
+Source: https://www.w3.org/TR/css-values-4/#relative-lengths
+*/
+type lengthResolver struct {
+	context    lengthContext
+	font       FontDescriptor
+	lineHeight float32
+}
+
+func (r lengthResolver) px(d *plex_css.CssDimention) float32 {
+	viewport := r.context.viewport
+	container := r.context.container
+
+	switch d.Unit {
+	case plex_css.CssUnit_NO_UNIT:
+		return d.Value
+	case plex_css.CssUnit_EM:
+		return d.Value * r.font.Size
+	case plex_css.CssUnit_REM:
+		return d.Value * r.context.root.Size
+	case plex_css.CssUnit_EX:
+		return d.Value * DefaultFontMetrics.MeasureFont(r.font).XHeight * r.font.Size
+	case plex_css.CssUnit_CH:
+		return d.Value * DefaultFontMetrics.MeasureFont(r.font).ZeroAdvance * r.font.Size
+	case plex_css.CssUnit_CAP:
+		return d.Value * DefaultFontMetrics.MeasureFont(r.font).CapHeight * r.font.Size
+	case plex_css.CssUnit_IC:
+		return d.Value * DefaultFontMetrics.MeasureFont(r.font).IdeographAdvance * r.font.Size
+	case plex_css.CssUnit_LH:
+		return d.Value * r.lineHeight
+	case plex_css.CssUnit_RLH:
+		return d.Value * r.context.rootLineHeight
+	// the inline axis is horizontal and the block axis vertical
+	case plex_css.CssUnit_VW, plex_css.CssUnit_VI:
+		return d.Value * viewport.Width / 100
+	case plex_css.CssUnit_VH, plex_css.CssUnit_VB:
+		return d.Value * viewport.Height / 100
+	case plex_css.CssUnit_VMIN:
+		return d.Value * min(viewport.Width, viewport.Height) / 100
+	case plex_css.CssUnit_VMAX:
+		return d.Value * max(viewport.Width, viewport.Height) / 100
+	case plex_css.CssUnit_CQW, plex_css.CssUnit_CQI:
+		return d.Value * container.Width / 100
+	case plex_css.CssUnit_CQH, plex_css.CssUnit_CQB:
+		return d.Value * container.Height / 100
+	case plex_css.CssUnit_CQMIN:
+		return d.Value * min(container.Width, container.Height) / 100
+	case plex_css.CssUnit_CQMAX:
+		return d.Value * max(container.Width, container.Height) / 100
+	default:
+		return d.AsPx()
+	}
+}
+
+// Evaluates a math function, percentages resolve against base.
+func (r lengthResolver) math(value *plex_css.CssFunction, base float32) float32 {
+	return plex_css.EvaluateMath(value, func(d *plex_css.CssDimention) float32 {
+		if d.Unit == plex_css.CssUnit_PRESENT {
+			return d.Value * base / 100
+		}
+		return r.px(d)
+	})
+}
+
+// Replaces the relative lengths of a calculation by px, leaving only its percentages to resolve at layout.
+func (r lengthResolver) absolutize(v plex_css.CssValue) plex_css.CssValue {
+	switch value := v.(type) {
+	case *plex_css.CssDimention:
+		if plex_css.IsRelativeLengthUnit(value.Unit) {
+			return &plex_css.CssDimention{Value: r.px(value), Unit: plex_css.CssUnit_PX}
+		}
+	case *plex_css.CssExpression:
+		return &plex_css.CssExpression{Left: r.absolutize(value.Left), Op: value.Op, Right: r.absolutize(value.Right)}
+	case *plex_css.CssFunction:
+		args := make([]plex_css.CssValue, len(value.Args))
+		for i, arg := range value.Args {
+			args[i] = r.absolutize(arg)
+		}
+		return &plex_css.CssFunction{Name: value.Name, Args: args}
+	}
+	return v
+}
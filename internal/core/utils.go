@@ -37,36 +37,44 @@ func GetWindowDimentions(window *sdl.Window) Dimensions {
 	}
 }
 
-func ParseStylesFromDocument(node Node, stylesheets []plex_css.Stylesheet) (StyledNode, plex_css.CssColor) {
-	cssParser := plex_css.CssParser{}
+func GetViewport(window *sdl.Window) Viewport {
+	w, h := window.GetSize()
+	return Viewport{Width: float32(w), Height: float32(h)}
+}
 
+func ParseStylesFromDocument(node Node, stylesheets []plex_css.Stylesheet, viewport Viewport) (StyledNode, plex_css.CssColor) {
 	var styletree StyledNode
 	color := plex_css.CSS_COLOR_KEYWORDS["white"]
 
 	if document, ok := node.(*ElementNode); ok {
-		selector := plex_css.Selector{TagName: "style"}
-		styleTags := document.QuerySelectorAll(&selector)
+		stylesheets = append(stylesheets, styleElementSheets(document)...)
+		styletree = StyleTree(node, stylesheets, viewport)
+		color = canvasColor(&styletree, color)
+	}
 
-		for _, style := range styleTags {
-			css, err := cssParser.ParseStylesheet(style.GetTextContent(), 1)
-			if err == nil {
-				stylesheets = append(stylesheets, css)
-			}
-		}
+	return styletree, color
+}
 
-		styletree = StyleTree(node, stylesheets)
+// Parses the contents of the document's <style> elements.
+func styleElementSheets(document *ElementNode) []plex_css.Stylesheet {
+	cssParser := plex_css.CssParser{}
+	stylesheets := []plex_css.Stylesheet{}
 
-		bgColor := styletree.props.Lookup("background-color")
-		bgColor.IfSome(func(v plex_css.Declaration) {
-			if c, ok := v.GetValue().(*plex_css.CssColor); ok {
-				color = *c
-			} else if c, ok := v.GetValue().(*plex_css.CssKeyword); ok {
-				c.ResolveColor().IfSome(func(v plex_css.CssColor) {
-					color = v
-				})
-			}
-		})
+	selector := plex_css.Selector{TagName: "style"}
+	for _, style := range document.QuerySelectorAll(&selector) {
+		css, err := cssParser.ParseStylesheet(style.GetTextContent(), plex_css.Origin_Author)
+		if err == nil {
+			stylesheets = append(stylesheets, css)
+		}
 	}
 
-	return styletree, color
+	return stylesheets
+}
+
+// The root background paints the whole canvas.
+func canvasColor(styletree *StyledNode, fallback plex_css.CssColor) plex_css.CssColor {
+	if background := styletree.style.BackgroundColor; background.A > 0 {
+		return background
+	}
+	return fallback
 }
@@ -0,0 +1,109 @@
+package plex
+
+import (
+	"slices"
+	plex_css "visualsource/plex/internal/css"
+)
+
+/*
+Computes the custom properties of an element and substitutes var() in its other declarations.
+Custom properties inherit. A declaration whose substitution fails is invalid at computed-value
+time and is dropped so it defaults like an unset property.
+
+Source: https://www.w3.org/TR/css-variables-1/#substitute-a-var
+*/
+func resolveVariables(specified plex_css.CssPropertyMap, parent plex_css.CssPropertyMap) plex_css.CssPropertyMap {
+	resolver := variableResolver{
+		specified: specified,
+		computed:  plex_css.CssPropertyMap{},
+		cyclic:    map[string]bool{},
+		done:      map[string]bool{},
+	}
+
+	for name, dec := range parent {
+		if plex_css.IsCustomProperty(name) {
+			resolver.computed[name] = dec
+		}
+	}
+
+	for name := range specified {
+		if plex_css.IsCustomProperty(name) {
+			resolver.resolve(name)
+		}
+	}
+
+	values := resolver.computed
+	for name, dec := range specified {
+		if plex_css.IsCustomProperty(name) {
+			continue
+		}
+		if !dec.ContainsVar() {
+			values[name] = dec
+			continue
+		}
+
+		if resolved, err := plex_css.SubstituteVariables(dec, resolver.lookup); err == nil {
+			values[name] = resolved
+		}
+	}
+
+	return values
+}
+
+type variableResolver struct {
+	specified plex_css.CssPropertyMap
+	// custom property values, starting out with the inherited ones
+	computed plex_css.CssPropertyMap
+	// custom properties being resolved, innermost last
+	stack []string
+	// custom properties that depend on themselves
+	cyclic map[string]bool
+	done   map[string]bool
+}
+
+// The value of a custom property, false when it has the guaranteed-invalid value.
+func (r *variableResolver) lookup(name string) ([]plex_css.Token, bool) {
+	if i := slices.Index(r.stack, name); i >= 0 {
+		// every property in a dependency cycle is invalid at computed-value time
+		for _, member := range r.stack[i:] {
+			r.cyclic[member] = true
+		}
+		return nil, false
+	}
+
+	r.resolve(name)
+	dec, ok := r.computed[name]
+	return dec.GetTokens(), ok
+}
+
+// https://www.w3.org/TR/css-variables-1/#cycles
+func (r *variableResolver) resolve(name string) {
+	if r.done[name] {
+		return
+	}
+	r.done[name] = true
+
+	dec, ok := r.specified[name]
+	if !ok {
+		return
+	}
+
+	switch dec.GetCssWideKeyword() {
+	case plex_css.Keyword_Inherit, plex_css.Keyword_Unset, plex_css.Keyword_Revert, plex_css.Keyword_RevertLayer:
+		// custom properties are inherited, the parent's value is already in place
+		return
+	case plex_css.Keyword_Initial:
+		delete(r.computed, name)
+		return
+	}
+
+	r.stack = append(r.stack, name)
+	resolved, err := plex_css.SubstituteVariables(dec, r.lookup)
+	r.stack = r.stack[:len(r.stack)-1]
+
+	if err != nil || r.cyclic[name] {
+		delete(r.computed, name)
+		return
+	}
+	r.computed[name] = resolved
+}
@@ -1,6 +1,8 @@
 package plex_css
 
+// https://www.w3.org/TR/css-color-4/#named-colors
 var CSS_COLOR_KEYWORDS = map[string]CssColor{
+	"transparent":          {R: 0, G: 0, B: 0, A: 0},
 	"aliceblue":            {R: 240, G: 248, B: 255, A: 255},
 	"antiquewhite":         {R: 250, G: 235, B: 215, A: 255},
 	"aqua":                 {R: 0, G: 255, B: 255, A: 255},
@@ -151,31 +153,35 @@ var CSS_COLOR_KEYWORDS = map[string]CssColor{
 	"yellowgreen":          {R: 154, G: 205, B: 50, A: 255},
 }
 
-func f(n, hue, light, sat int) int {
-	k := (n + hue/30) % 12
-	a := sat * min(light, 1-light)
-	return light - a*max(-1, min(k-3, 9-k, 1))
+// https://www.w3.org/TR/css-color-4/#css-system-colors
+var SYSTEM_COLOR_KEYWORDS = []string{
+	"accentcolor", "accentcolortext", "activetext", "buttonborder", "buttonface", "buttontext",
+	"canvas", "canvastext", "field", "fieldtext", "graytext", "highlight", "highlighttext",
+	"linktext", "mark", "marktext", "selecteditem", "selecteditemtext", "visitedtext",
 }
 
-/*
-Converting an HSL color to sRGB is straightforward mathematically.
+// The colors of the user interface the system color keywords resolve to, by lowercase keyword.
+type SystemColorPalette map[string]CssColor
 
-It returns an array of three numbers representing the red, green, and blue channels of the colors,
-normalized to the range [0, 1].
-
-This code assumes that parse-time clamping of negative saturation has already been applied.
-
-Source:  https://www.w3.org/TR/css-color-4/#hsl-to-rgb
-*/
-func HslToRgb(hue, sat, light int) (int, int, int) {
-	hue = hue % 360
-
-	if hue < 0 {
-		hue += 360
-	}
-
-	sat /= 100
-	light /= 100
-
-	return f(0, hue, light, sat), f(8, hue, light, sat), f(4, hue, light, sat)
+// A light theme, embedders replace it to follow the platform's.
+var SystemColors = SystemColorPalette{
+	"accentcolor":      {R: 0, G: 117, B: 255, A: 255},
+	"accentcolortext":  {R: 255, G: 255, B: 255, A: 255},
+	"activetext":       {R: 255, G: 0, B: 0, A: 255},
+	"buttonborder":     {R: 118, G: 118, B: 118, A: 255},
+	"buttonface":       {R: 239, G: 239, B: 239, A: 255},
+	"buttontext":       {R: 0, G: 0, B: 0, A: 255},
+	"canvas":           {R: 255, G: 255, B: 255, A: 255},
+	"canvastext":       {R: 0, G: 0, B: 0, A: 255},
+	"field":            {R: 255, G: 255, B: 255, A: 255},
+	"fieldtext":        {R: 0, G: 0, B: 0, A: 255},
+	"graytext":         {R: 109, G: 109, B: 109, A: 255},
+	"highlight":        {R: 51, G: 153, B: 255, A: 255},
+	"highlighttext":    {R: 255, G: 255, B: 255, A: 255},
+	"linktext":         {R: 0, G: 0, B: 238, A: 255},
+	"mark":             {R: 255, G: 255, B: 0, A: 255},
+	"marktext":         {R: 0, G: 0, B: 0, A: 255},
+	"selecteditem":     {R: 0, G: 117, B: 255, A: 255},
+	"selecteditemtext": {R: 255, G: 255, B: 255, A: 255},
+	"visitedtext":      {R: 85, G: 26, B: 139, A: 255},
 }
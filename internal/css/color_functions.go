@@ -0,0 +1,647 @@
+package plex_css
+
+import (
+	"errors"
+	"math"
+	"slices"
+	"strings"
+)
+
+var errComputedColor = errors.New("the color depends on currentcolor or a system color")
+var errInvalidColor = errors.New("invalid color")
+
+// What a color may depend on that is only known once the style is computed, nil while parsing.
+type colorContext struct {
+	// the value of the 'color' property
+	currentColor *CssColor
+	palette      SystemColorPalette
+}
+
+// How a channel of a color function is written. percent is the number 100% stands for,
+// 0 when percentages are not allowed. Values are clamped to [min, max] when parsed.
+type colorChannel struct {
+	percent  float64
+	hue      bool
+	min, max float64
+}
+
+var HUE_CHANNEL = colorChannel{hue: true, min: math.Inf(-1), max: math.Inf(1)}
+var ALPHA_CHANNEL = colorChannel{percent: 1, min: 0, max: 1}
+
+/*
+The channels of a color function, in the order they are written. The coordinates of the color
+are the channel values divided by scale. names are the channel keywords of the relative color
+syntax.
+
+Source: https://www.w3.org/TR/css-color-5/#relative-colors
+*/
+type colorSyntax struct {
+	space    string
+	channels [3]colorChannel
+	names    [3]string
+	scale    float64
+	// accepts the comma separated syntax of CSS Color 3
+	legacy bool
+}
+
+var RGB_SYNTAX = colorSyntax{
+	space: ColorSpace_SRGB,
+	channels: [3]colorChannel{
+		{percent: 255, min: 0, max: 255},
+		{percent: 255, min: 0, max: 255},
+		{percent: 255, min: 0, max: 255},
+	},
+	names:  [3]string{"r", "g", "b"},
+	scale:  255,
+	legacy: true,
+}
+
+var HSL_SYNTAX = colorSyntax{
+	space: ColorSpace_HSL,
+	channels: [3]colorChannel{
+		HUE_CHANNEL,
+		{percent: 100, min: 0, max: math.Inf(1)},
+		{percent: 100, min: 0, max: 100},
+	},
+	names:  [3]string{"h", "s", "l"},
+	scale:  1,
+	legacy: true,
+}
+
+// https://www.w3.org/TR/css-color-4/#color-syntax
+var COLOR_FUNCTION_SYNTAXES = map[string]colorSyntax{
+	"rgb":  RGB_SYNTAX,
+	"rgba": RGB_SYNTAX,
+	"hsl":  HSL_SYNTAX,
+	"hsla": HSL_SYNTAX,
+	"hwb": {
+		space: ColorSpace_HWB,
+		channels: [3]colorChannel{
+			HUE_CHANNEL,
+			{percent: 100, min: 0, max: 100},
+			{percent: 100, min: 0, max: 100},
+		},
+		names: [3]string{"h", "w", "b"},
+		scale: 1,
+	},
+	"lab": {
+		space: ColorSpace_Lab,
+		channels: [3]colorChannel{
+			{percent: 100, min: 0, max: 100},
+			{percent: 125, min: math.Inf(-1), max: math.Inf(1)},
+			{percent: 125, min: math.Inf(-1), max: math.Inf(1)},
+		},
+		names: [3]string{"l", "a", "b"},
+		scale: 1,
+	},
+	"lch": {
+		space: ColorSpace_LCH,
+		channels: [3]colorChannel{
+			{percent: 100, min: 0, max: 100},
+			{percent: 150, min: 0, max: math.Inf(1)},
+			HUE_CHANNEL,
+		},
+		names: [3]string{"l", "c", "h"},
+		scale: 1,
+	},
+	"oklab": {
+		space: ColorSpace_OKLab,
+		channels: [3]colorChannel{
+			{percent: 1, min: 0, max: 1},
+			{percent: 0.4, min: math.Inf(-1), max: math.Inf(1)},
+			{percent: 0.4, min: math.Inf(-1), max: math.Inf(1)},
+		},
+		names: [3]string{"l", "a", "b"},
+		scale: 1,
+	},
+	"oklch": {
+		space: ColorSpace_OKLCH,
+		channels: [3]colorChannel{
+			{percent: 1, min: 0, max: 1},
+			{percent: 0.4, min: 0, max: math.Inf(1)},
+			HUE_CHANNEL,
+		},
+		names: [3]string{"l", "c", "h"},
+		scale: 1,
+	},
+}
+
+func predefinedSyntax(space string, names [3]string) colorSyntax {
+	unbounded := colorChannel{percent: 1, min: math.Inf(-1), max: math.Inf(1)}
+	return colorSyntax{
+		space:    space,
+		channels: [3]colorChannel{unbounded, unbounded, unbounded},
+		names:    names,
+		scale:    1,
+	}
+}
+
+// The spaces of the color() function.
+// https://www.w3.org/TR/css-color-4/#predefined
+var PREDEFINED_COLOR_SPACES = map[string]colorSyntax{
+	"srgb":         predefinedSyntax(ColorSpace_SRGB, [3]string{"r", "g", "b"}),
+	"srgb-linear":  predefinedSyntax(ColorSpace_SRGBLinear, [3]string{"r", "g", "b"}),
+	"display-p3":   predefinedSyntax(ColorSpace_DisplayP3, [3]string{"r", "g", "b"}),
+	"a98-rgb":      predefinedSyntax(ColorSpace_A98RGB, [3]string{"r", "g", "b"}),
+	"prophoto-rgb": predefinedSyntax(ColorSpace_ProPhotoRGB, [3]string{"r", "g", "b"}),
+	"rec2020":      predefinedSyntax(ColorSpace_Rec2020, [3]string{"r", "g", "b"}),
+	"xyz":          predefinedSyntax(ColorSpace_XYZD65, [3]string{"x", "y", "z"}),
+	"xyz-d50":      predefinedSyntax(ColorSpace_XYZD50, [3]string{"x", "y", "z"}),
+	"xyz-d65":      predefinedSyntax(ColorSpace_XYZD65, [3]string{"x", "y", "z"}),
+}
+
+// https://www.w3.org/TR/css-color-4/#interpolation-space
+var INTERPOLATION_COLOR_SPACES = map[string]string{
+	"srgb":         ColorSpace_SRGB,
+	"srgb-linear":  ColorSpace_SRGBLinear,
+	"display-p3":   ColorSpace_DisplayP3,
+	"a98-rgb":      ColorSpace_A98RGB,
+	"prophoto-rgb": ColorSpace_ProPhotoRGB,
+	"rec2020":      ColorSpace_Rec2020,
+	"lab":          ColorSpace_Lab,
+	"oklab":        ColorSpace_OKLab,
+	"xyz":          ColorSpace_XYZD65,
+	"xyz-d50":      ColorSpace_XYZD50,
+	"xyz-d65":      ColorSpace_XYZD65,
+	"hsl":          ColorSpace_HSL,
+	"hwb":          ColorSpace_HWB,
+	"lch":          ColorSpace_LCH,
+	"oklch":        ColorSpace_OKLCH,
+}
+
+// https://www.w3.org/TR/css-color-4/#hue-interpolation
+var HUE_INTERPOLATION_METHODS = []string{"shorter", "longer", "increasing", "decreasing"}
+
+// Degrees per angle unit.
+var ANGLE_UNITS = map[string]float64{
+	"deg":  1,
+	"grad": 0.9,
+	"rad":  180 / math.Pi,
+	"turn": 360,
+}
+
+type channelKind uint8
+
+const (
+	channelNumber channelKind = iota
+	channelPercentage
+	channelAngle
+	channelNone
+)
+
+/*
+Parses a color function into a CssColor. Colors depending on currentcolor or system colors
+stay a function and are resolved when the style is computed. Returns nil for invalid colors.
+
+Source: https://www.w3.org/TR/css-color-4/#color-syntax
+*/
+func parseColorFunction(function *FunctionBlock) CssValue {
+	color, err := evaluateColorFunction(function, colorContext{})
+	if errors.Is(err, errComputedColor) {
+		return &CssFunction{
+			Name:   strings.ToLower(function.Name),
+			Args:   parseArguments(function.Args),
+			tokens: function.Args,
+		}
+	}
+	if err != nil {
+		return nil
+	}
+
+	result := color.toCssColor()
+	return &result
+}
+
+// Parses a <color> token: a named color, hex color or color function.
+func parseColor(token Token, context colorContext) (colorValue, error) {
+	switch t := token.(type) {
+	case *StringToken:
+		if t.Id != Token_Ident {
+			break
+		}
+		name := strings.ToLower(t.Value)
+		switch name {
+		case "currentcolor":
+			if context.currentColor == nil {
+				return colorValue{}, errComputedColor
+			}
+			return colorFromCssColor(*context.currentColor), nil
+		}
+		if color, ok := CSS_COLOR_KEYWORDS[name]; ok {
+			return colorFromCssColor(color), nil
+		}
+		if slices.Contains(SYSTEM_COLOR_KEYWORDS, name) {
+			if context.palette == nil {
+				return colorValue{}, errComputedColor
+			}
+			if color, ok := context.palette[name]; ok {
+				return colorFromCssColor(color), nil
+			}
+		}
+	case *FlagedStringToken:
+		if color, ok := parseHexValue(t.Value).(*CssColor); ok && t.Id == Token_Hash {
+			return colorFromCssColor(*color), nil
+		}
+	case *FunctionBlock:
+		if slices.Contains(COLOR_FUNCTIONS, strings.ToLower(t.Name)) {
+			return evaluateColorFunction(t, context)
+		}
+	}
+	return colorValue{}, errInvalidColor
+}
+
+func evaluateColorFunction(function *FunctionBlock, context colorContext) (colorValue, error) {
+	name := strings.ToLower(function.Name)
+	args := components(function.Args)
+
+	if name == "color-mix" {
+		return mixColors(args, context)
+	}
+
+	// rgb(from <color> r g b / alpha)
+	var origin *colorValue
+	if len(args) > 0 && isStringCaseInsensitive("from", &args[0]) {
+		if len(args) < 2 {
+			return colorValue{}, errInvalidColor
+		}
+		color, err := parseColor(args[1], context)
+		if err != nil {
+			return colorValue{}, err
+		}
+		origin = &color
+		args = args[2:]
+	}
+
+	syntax, ok := COLOR_FUNCTION_SYNTAXES[name]
+	if name == "color" {
+		if len(args) == 0 || args[0].GetId() != Token_Ident {
+			return colorValue{}, errInvalidColor
+		}
+		syntax, ok = PREDEFINED_COLOR_SPACES[strings.ToLower(args[0].(*StringToken).Value)]
+		args = args[1:]
+	}
+	if !ok {
+		return colorValue{}, errInvalidColor
+	}
+
+	if origin == nil && syntax.legacy && slices.ContainsFunc(args, func(t Token) bool { return t.GetId() == Token_Comma }) {
+		return parseLegacyColor(args, syntax)
+	}
+
+	alpha := 1.0
+	if origin != nil {
+		channels := relativeChannels(*origin, syntax)
+		args = substituteChannels(args, channels)
+		alpha = channels["alpha"]
+	}
+	return parseModernColor(args, syntax, alpha)
+}
+
+// <modern-rgb-syntax> = rgb( [ <number> | <percentage> | none ]{3} [ / [ <alpha-value> | none ] ]? )
+func parseModernColor(args []Token, syntax colorSyntax, alpha float64) (colorValue, error) {
+	channels := args
+	var alphaTokens []Token
+	if i := slices.IndexFunc(args, func(t Token) bool { return isDelim(t, '/') }); i >= 0 {
+		channels, alphaTokens = args[:i], args[i+1:]
+		if len(alphaTokens) != 1 {
+			return colorValue{}, errInvalidColor
+		}
+	}
+	if len(channels) != 3 {
+		return colorValue{}, errInvalidColor
+	}
+
+	result := colorValue{space: syntax.space, alpha: alpha}
+	for i, token := range channels {
+		value, kind, ok := parseChannel(token)
+		if !ok {
+			return colorValue{}, errInvalidColor
+		}
+		if result.coords[i], ok = syntax.channels[i].resolve(value, kind); !ok {
+			return colorValue{}, errInvalidColor
+		}
+		result.coords[i] /= syntax.scale
+	}
+
+	if alphaTokens != nil {
+		value, kind, ok := parseChannel(alphaTokens[0])
+		if !ok {
+			return colorValue{}, errInvalidColor
+		}
+		if result.alpha, ok = ALPHA_CHANNEL.resolve(value, kind); !ok {
+			return colorValue{}, errInvalidColor
+		}
+	}
+
+	return result, nil
+}
+
+// <legacy-rgb-syntax> = rgb( <percentage>#{3} , <alpha-value>? ) | rgb( <number>#{3} , <alpha-value>? )
+// <legacy-hsl-syntax> = hsl( <hue>, <percentage>, <percentage>, <alpha-value>? )
+func parseLegacyColor(args []Token, syntax colorSyntax) (colorValue, error) {
+	groups := splitByCommas(args)
+	if len(groups) != 3 && len(groups) != 4 {
+		return colorValue{}, errInvalidColor
+	}
+
+	result := colorValue{space: syntax.space, alpha: 1}
+	kinds := [3]channelKind{}
+	for i, group := range groups {
+		if len(group) != 1 {
+			return colorValue{}, errInvalidColor
+		}
+		value, kind, ok := parseChannel(group[0])
+		if !ok || kind == channelNone {
+			return colorValue{}, errInvalidColor
+		}
+
+		if i == 3 {
+			result.alpha, ok = ALPHA_CHANNEL.resolve(value, kind)
+			if !ok {
+				return colorValue{}, errInvalidColor
+			}
+			break
+		}
+
+		kinds[i] = kind
+		switch {
+		// rgb() channels are either all numbers or all percentages
+		case syntax.space == ColorSpace_SRGB && kind != kinds[0]:
+			return colorValue{}, errInvalidColor
+		case syntax.space == ColorSpace_HSL && i > 0 && kind != channelPercentage:
+			return colorValue{}, errInvalidColor
+		}
+
+		if result.coords[i], ok = syntax.channels[i].resolve(value, kind); !ok {
+			return colorValue{}, errInvalidColor
+		}
+		result.coords[i] /= syntax.scale
+	}
+
+	return result, nil
+}
+
+// Parses a number, percentage, angle, 'none' or a calculation resolving to a number or percentage.
+func parseChannel(token Token) (float64, channelKind, bool) {
+	switch t := token.(type) {
+	case *NumberToken:
+		switch t.Id {
+		case Token_Number:
+			return float64(t.Value), channelNumber, true
+		case Token_Percentage:
+			return float64(t.Value), channelPercentage, true
+		case Token_Dimension:
+			if degrees, ok := ANGLE_UNITS[strings.ToLower(t.Unit)]; ok {
+				return float64(t.Value) * degrees, channelAngle, true
+			}
+		}
+	case *StringToken:
+		if isStringCaseInsensitive("none", &token) {
+			return math.NaN(), channelNone, true
+		}
+	case *FunctionBlock:
+		if !slices.Contains(MATH_FUNCTIONS, strings.ToLower(t.Name)) {
+			break
+		}
+		value := parseMathFunction(t)
+		mathType, ok := MathTypeOf(value)
+		if !ok {
+			break
+		}
+		result := float64(EvaluateMath(value, func(d *CssDimention) float32 { return d.Value }))
+		switch mathType {
+		case MathType_Number:
+			return result, channelNumber, true
+		case MathType_Percentage:
+			return result, channelPercentage, true
+		}
+	}
+	return 0, 0, false
+}
+
+// Converts a channel value to a number, false when the channel does not accept its kind.
+func (c colorChannel) resolve(value float64, kind channelKind) (float64, bool) {
+	switch kind {
+	case channelNone:
+		return math.NaN(), true
+	case channelAngle:
+		if !c.hue {
+			return 0, false
+		}
+	case channelPercentage:
+		if c.hue || c.percent == 0 {
+			return 0, false
+		}
+		value = value / 100 * c.percent
+	}
+	return min(max(value, c.min), c.max), true
+}
+
+// The values the channel keywords of a relative color stand for.
+func relativeChannels(origin colorValue, syntax colorSyntax) map[string]float64 {
+	converted := origin.convert(syntax.space)
+	orZero := func(v float64) float64 {
+		if math.IsNaN(v) {
+			return 0
+		}
+		return v
+	}
+
+	channels := map[string]float64{"alpha": orZero(origin.alpha)}
+	for i, name := range syntax.names {
+		channels[name] = orZero(converted.coords[i]) * syntax.scale
+	}
+	return channels
+}
+
+// Replaces channel keywords by numbers, including inside calculations.
+func substituteChannels(tokens []Token, channels map[string]float64) []Token {
+	result := make([]Token, len(tokens))
+	for i, token := range tokens {
+		switch t := token.(type) {
+		case *StringToken:
+			if value, ok := channels[strings.ToLower(t.Value)]; ok && t.Id == Token_Ident {
+				token = &NumberToken{Id: Token_Number, Value: float32(value), DataType: NumberType_Number}
+			}
+		case *FunctionBlock:
+			token = &FunctionBlock{Name: t.Name, Args: substituteChannels(t.Args, channels)}
+		case *SimpleBlock:
+			token = &SimpleBlock{BlockType: t.BlockType, Tokens: substituteChannels(t.Tokens, channels)}
+		}
+		result[i] = token
+	}
+	return result
+}
+
+/*
+color-mix( in <colorspace> [ <hue-interpolation-method> hue ]? , [ <color> && <percentage>? ]#{2} )
+
+Source: https://www.w3.org/TR/css-color-5/#color-mix
+*/
+func mixColors(args []Token, context colorContext) (colorValue, error) {
+	groups := splitByCommas(args)
+	if len(groups) != 3 {
+		return colorValue{}, errInvalidColor
+	}
+
+	method := groups[0]
+	if len(method) < 2 || !isStringCaseInsensitive("in", &method[0]) || method[1].GetId() != Token_Ident {
+		return colorValue{}, errInvalidColor
+	}
+	space, ok := INTERPOLATION_COLOR_SPACES[strings.ToLower(method[1].(*StringToken).Value)]
+	if !ok {
+		return colorValue{}, errInvalidColor
+	}
+
+	hueMethod := "shorter"
+	if _, polar := POLAR_COLOR_SPACES[space]; polar && len(method) == 4 {
+		if !isStringCaseInsensitiveIn(method[2], HUE_INTERPOLATION_METHODS) || !isStringCaseInsensitive("hue", &method[3]) {
+			return colorValue{}, errInvalidColor
+		}
+		hueMethod = strings.ToLower(method[2].(*StringToken).Value)
+	} else if len(method) != 2 {
+		return colorValue{}, errInvalidColor
+	}
+
+	colors := [2]colorValue{}
+	percentages := [2]float64{math.NaN(), math.NaN()}
+	for i, group := range groups[1:] {
+		if len(group) != 1 && len(group) != 2 {
+			return colorValue{}, errInvalidColor
+		}
+
+		hasColor := false
+		for _, token := range group {
+			if value, kind, ok := parseChannel(token); ok && kind == channelPercentage && math.IsNaN(percentages[i]) {
+				if value < 0 || value > 100 {
+					return colorValue{}, errInvalidColor
+				}
+				percentages[i] = value
+				continue
+			}
+
+			if hasColor {
+				return colorValue{}, errInvalidColor
+			}
+			color, err := parseColor(token, context)
+			if err != nil {
+				return colorValue{}, err
+			}
+			colors[i] = color
+			hasColor = true
+		}
+		if !hasColor {
+			return colorValue{}, errInvalidColor
+		}
+	}
+
+	// https://www.w3.org/TR/css-color-5/#color-mix-percent-norm
+	switch {
+	case math.IsNaN(percentages[0]) && math.IsNaN(percentages[1]):
+		percentages = [2]float64{50, 50}
+	case math.IsNaN(percentages[0]):
+		percentages[0] = 100 - percentages[1]
+	case math.IsNaN(percentages[1]):
+		percentages[1] = 100 - percentages[0]
+	}
+	sum := percentages[0] + percentages[1]
+	if sum == 0 {
+		return colorValue{}, errInvalidColor
+	}
+
+	result := interpolateColors(colors[0], colors[1], space, hueMethod, percentages[1]/sum)
+	if sum < 100 {
+		result.alpha *= sum / 100
+	}
+	return result, nil
+}
+
+/*
+Interpolates between two colors in a color space with premultiplied alpha, progress is the
+weight of b.
+
+Source: https://www.w3.org/TR/css-color-4/#interpolation
+*/
+func interpolateColors(a colorValue, b colorValue, space string, hueMethod string, progress float64) colorValue {
+	a, b = a.convert(space), b.convert(space)
+
+	// a missing component takes the value of the other color
+	carry := func(x *float64, y *float64) {
+		if math.IsNaN(*x) {
+			*x = *y
+		}
+		if math.IsNaN(*y) {
+			*y = *x
+		}
+	}
+	for i := range a.coords {
+		carry(&a.coords[i], &b.coords[i])
+	}
+	carry(&a.alpha, &b.alpha)
+	if math.IsNaN(a.alpha) {
+		a.alpha, b.alpha = 1, 1
+	}
+
+	hue, polar := POLAR_COLOR_SPACES[space]
+	if polar {
+		a.coords[hue], b.coords[hue] = fixupHues(a.coords[hue], b.coords[hue], hueMethod)
+	}
+
+	lerp := func(x float64, y float64) float64 {
+		return x + (y-x)*progress
+	}
+
+	result := colorValue{space: space, alpha: lerp(a.alpha, b.alpha)}
+	for i := range result.coords {
+		if polar && i == hue {
+			result.coords[i] = lerp(a.coords[i], b.coords[i])
+			continue
+		}
+
+		result.coords[i] = lerp(a.coords[i]*a.alpha, b.coords[i]*b.alpha)
+		if result.alpha != 0 {
+			result.coords[i] /= result.alpha
+		}
+	}
+	return result
+}
+
+// https://www.w3.org/TR/css-color-4/#hue-interpolation
+func fixupHues(a float64, b float64, method string) (float64, float64) {
+	if math.IsNaN(a) || math.IsNaN(b) {
+		return a, b
+	}
+
+	normalize := func(hue float64) float64 {
+		hue = math.Mod(hue, 360)
+		if hue < 0 {
+			hue += 360
+		}
+		return hue
+	}
+	a, b = normalize(a), normalize(b)
+
+	switch method {
+	case "shorter":
+		if b-a > 180 {
+			a += 360
+		} else if b-a < -180 {
+			b += 360
+		}
+	case "longer":
+		if 0 < b-a && b-a < 180 {
+			a += 360
+		} else if -180 < b-a && b-a <= 0 {
+			b += 360
+		}
+	case "increasing":
+		if b < a {
+			b += 360
+		}
+	case "decreasing":
+		if a < b {
+			a += 360
+		}
+	}
+	return a, b
+}
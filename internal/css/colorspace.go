@@ -0,0 +1,592 @@
+package plex_css
+
+import "math"
+
+// The color spaces colors are written and interpolated in.
+// https://www.w3.org/TR/css-color-4/#predefined
+const (
+	ColorSpace_SRGB        = "srgb"
+	ColorSpace_SRGBLinear  = "srgb-linear"
+	ColorSpace_DisplayP3   = "display-p3"
+	ColorSpace_A98RGB      = "a98-rgb"
+	ColorSpace_ProPhotoRGB = "prophoto-rgb"
+	ColorSpace_Rec2020     = "rec2020"
+	ColorSpace_XYZD50      = "xyz-d50"
+	ColorSpace_XYZD65      = "xyz-d65"
+	ColorSpace_HSL         = "hsl"
+	ColorSpace_HWB         = "hwb"
+	ColorSpace_Lab         = "lab"
+	ColorSpace_LCH         = "lch"
+	ColorSpace_OKLab       = "oklab"
+	ColorSpace_OKLCH       = "oklch"
+)
+
+// The position of the hue in the polar color spaces.
+var POLAR_COLOR_SPACES = map[string]int{
+	ColorSpace_HSL:   0,
+	ColorSpace_HWB:   0,
+	ColorSpace_LCH:   2,
+	ColorSpace_OKLCH: 2,
+}
+
+/*
+A color with float components in one of the color spaces. Missing components, written
+as 'none', are NaN.
+
+Source: https://www.w3.org/TR/css-color-4/#color-syntax
+*/
+type colorValue struct {
+	space  string
+	coords [3]float64
+	alpha  float64
+}
+
+func colorFromCssColor(c CssColor) colorValue {
+	return colorValue{
+		space:  ColorSpace_SRGB,
+		coords: [3]float64{float64(c.R) / 255, float64(c.G) / 255, float64(c.B) / 255},
+		alpha:  float64(c.A) / 255,
+	}
+}
+
+type matrix3 [3][3]float64
+
+func (m matrix3) apply(v [3]float64) [3]float64 {
+	result := [3]float64{}
+	for i, row := range m {
+		result[i] = row[0]*v[0] + row[1]*v[1] + row[2]*v[2]
+	}
+	return result
+}
+
+// Conversion matrices and transfer functions.
+// https://www.w3.org/TR/css-color-4/#color-conversion-code
+
+var D50_WHITE = [3]float64{0.3457 / 0.3585, 1, (1 - 0.3457 - 0.3585) / 0.3585}
+
+var LINEAR_SRGB_TO_XYZ = matrix3{
+	{506752.0 / 1228815, 87881.0 / 245763, 12673.0 / 70218},
+	{87098.0 / 409605, 175762.0 / 245763, 12673.0 / 175545},
+	{7918.0 / 409605, 87881.0 / 737289, 1001167.0 / 1053270},
+}
+var XYZ_TO_LINEAR_SRGB = matrix3{
+	{12831.0 / 3959, -329.0 / 214, -1974.0 / 3959},
+	{-851781.0 / 878810, 1648619.0 / 878810, 36519.0 / 878810},
+	{705.0 / 12673, -2585.0 / 12673, 705.0 / 667},
+}
+var LINEAR_P3_TO_XYZ = matrix3{
+	{608311.0 / 1250200, 189793.0 / 714400, 198249.0 / 1000160},
+	{35783.0 / 156275, 247089.0 / 357200, 198249.0 / 2500400},
+	{0, 32229.0 / 714400, 5220557.0 / 5000800},
+}
+var XYZ_TO_LINEAR_P3 = matrix3{
+	{446124.0 / 178915, -333277.0 / 357830, -72051.0 / 178915},
+	{-14852.0 / 17905, 63121.0 / 35810, 423.0 / 17905},
+	{11844.0 / 330415, -50337.0 / 660830, 316169.0 / 330415},
+}
+var LINEAR_A98_TO_XYZ = matrix3{
+	{573536.0 / 994567, 263643.0 / 1420810, 187206.0 / 994567},
+	{591459.0 / 1989134, 6239551.0 / 9945670, 374412.0 / 4972835},
+	{53769.0 / 1989134, 351524.0 / 4972835, 4929758.0 / 4972835},
+}
+var XYZ_TO_LINEAR_A98 = matrix3{
+	{1829569.0 / 896150, -506331.0 / 896150, -308931.0 / 896150},
+	{-851781.0 / 878810, 1648619.0 / 878810, 36519.0 / 878810},
+	{16779.0 / 1248040, -147721.0 / 1248040, 1266979.0 / 1248040},
+}
+
+// relative to the D50 white point
+var LINEAR_PROPHOTO_TO_XYZ = matrix3{
+	{0.79776664490064230, 0.13518129740053308, 0.03134773412839220},
+	{0.28807482881940130, 0.71183523424187300, 0.00008993693872564},
+	{0, 0, 0.82510460251046020},
+}
+var XYZ_TO_LINEAR_PROPHOTO = matrix3{
+	{1.34578688164715830, -0.25557208737979464, -0.05110186497554526},
+	{-0.54463070512490190, 1.50824774284514680, 0.02052744743642139},
+	{0, 0, 1.21196754563894520},
+}
+var LINEAR_REC2020_TO_XYZ = matrix3{
+	{63426534.0 / 99577255, 20160776.0 / 139408157, 47086771.0 / 278816314},
+	{26158966.0 / 99577255, 472592308.0 / 697040785, 8267143.0 / 139408157},
+	{0, 19567812.0 / 697040785, 295819943.0 / 278816314},
+}
+var XYZ_TO_LINEAR_REC2020 = matrix3{
+	{30757411.0 / 17917100, -6372589.0 / 17917100, -4539589.0 / 17917100},
+	{-19765991.0 / 29648200, 47925759.0 / 29648200, 467509.0 / 29648200},
+	{792561.0 / 44930125, -1921689.0 / 44930125, 42328811.0 / 44930125},
+}
+
+// Bradford chromatic adaptation between the D65 and D50 white points
+var D65_TO_D50 = matrix3{
+	{1.0479297925449969, 0.022946870601609652, -0.05019226628920524},
+	{0.02962780877005599, 0.9904344267538799, -0.017073799063418826},
+	{-0.009243040646204504, 0.015055191490298152, 0.7518742814281371},
+}
+var D50_TO_D65 = matrix3{
+	{0.955473421488075, -0.02309845494876471, 0.06325924320057072},
+	{-0.0283697093338637, 1.0099953980813041, 0.021041441191917323},
+	{0.012314014864481998, -0.020507649298898964, 1.330365926242124},
+}
+
+var XYZ_TO_LMS = matrix3{
+	{0.8190224379967030, 0.3619062600528904, -0.1288737815209879},
+	{0.0329836539323885, 0.9292868615863434, 0.0361446663506424},
+	{0.0481771893596242, 0.2642395317527308, 0.6335478284694309},
+}
+var LMS_TO_OKLAB = matrix3{
+	{0.2104542683093140, 0.7936177747023054, -0.0040720430116193},
+	{1.9779985324311684, -2.4285922420485799, 0.4505937096174110},
+	{0.0259040424655478, 0.7827717124575296, -0.8086757549230774},
+}
+var LMS_TO_XYZ = matrix3{
+	{1.2268798758459243, -0.5578149944602171, 0.2813910456659647},
+	{-0.0405757452148008, 1.1122868032803170, -0.0717110580655164},
+	{-0.0763729366746601, -0.4214933324022432, 1.5869240198367816},
+}
+var OKLAB_TO_LMS = matrix3{
+	{1, 0.3963377773761749, 0.2158037573099136},
+	{1, -0.1055613458156586, -0.0638541728258133},
+	{1, -0.0894841775298119, -1.2914855480194092},
+}
+
+func mapChannels(v [3]float64, f func(float64) float64) [3]float64 {
+	return [3]float64{f(v[0]), f(v[1]), f(v[2])}
+}
+
+// sRGB and display-p3 share their transfer function.
+func srgbToLinear(c float64) float64 {
+	abs := math.Abs(c)
+	if abs <= 0.04045 {
+		return c / 12.92
+	}
+	return math.Copysign(math.Pow((abs+0.055)/1.055, 2.4), c)
+}
+
+func linearToSrgb(c float64) float64 {
+	abs := math.Abs(c)
+	if abs > 0.0031308 {
+		return math.Copysign(1.055*math.Pow(abs, 1/2.4)-0.055, c)
+	}
+	return 12.92 * c
+}
+
+func a98ToLinear(c float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(c), 563.0/256), c)
+}
+
+func linearToA98(c float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(c), 256.0/563), c)
+}
+
+func prophotoToLinear(c float64) float64 {
+	abs := math.Abs(c)
+	if abs <= 16.0/512 {
+		return c / 16
+	}
+	return math.Copysign(math.Pow(abs, 1.8), c)
+}
+
+func linearToProphoto(c float64) float64 {
+	abs := math.Abs(c)
+	if abs >= 1.0/512 {
+		return math.Copysign(math.Pow(abs, 1/1.8), c)
+	}
+	return 16 * c
+}
+
+const rec2020Alpha = 1.09929682680944
+const rec2020Beta = 0.018053968510807
+
+func rec2020ToLinear(c float64) float64 {
+	abs := math.Abs(c)
+	if abs < rec2020Beta*4.5 {
+		return c / 4.5
+	}
+	return math.Copysign(math.Pow((abs+rec2020Alpha-1)/rec2020Alpha, 1/0.45), c)
+}
+
+func linearToRec2020(c float64) float64 {
+	abs := math.Abs(c)
+	if abs > rec2020Beta {
+		return math.Copysign(rec2020Alpha*math.Pow(abs, 0.45)-(rec2020Alpha-1), c)
+	}
+	return 4.5 * c
+}
+
+// https://www.w3.org/TR/css-color-4/#color-conversion-code
+func xyzD50ToLab(xyz [3]float64) [3]float64 {
+	const epsilon = 216.0 / 24389
+	const kappa = 24389.0 / 27
+
+	f := [3]float64{}
+	for i := range xyz {
+		value := xyz[i] / D50_WHITE[i]
+		if value > epsilon {
+			f[i] = math.Cbrt(value)
+		} else {
+			f[i] = (kappa*value + 16) / 116
+		}
+	}
+
+	return [3]float64{116*f[1] - 16, 500 * (f[0] - f[1]), 200 * (f[1] - f[2])}
+}
+
+func labToXyzD50(lab [3]float64) [3]float64 {
+	const epsilon = 216.0 / 24389
+	const kappa = 24389.0 / 27
+
+	f1 := (lab[0] + 16) / 116
+	f0 := lab[1]/500 + f1
+	f2 := f1 - lab[2]/200
+
+	xyz := [3]float64{(116*f0 - 16) / kappa, lab[0] / kappa, (116*f2 - 16) / kappa}
+	if math.Pow(f0, 3) > epsilon {
+		xyz[0] = math.Pow(f0, 3)
+	}
+	if lab[0] > kappa*epsilon {
+		xyz[1] = math.Pow((lab[0]+16)/116, 3)
+	}
+	if math.Pow(f2, 3) > epsilon {
+		xyz[2] = math.Pow(f2, 3)
+	}
+
+	for i := range xyz {
+		xyz[i] *= D50_WHITE[i]
+	}
+	return xyz
+}
+
+func xyzToOklab(xyz [3]float64) [3]float64 {
+	return LMS_TO_OKLAB.apply(mapChannels(XYZ_TO_LMS.apply(xyz), math.Cbrt))
+}
+
+func oklabToXyz(lab [3]float64) [3]float64 {
+	lms := mapChannels(OKLAB_TO_LMS.apply(lab), func(c float64) float64 { return c * c * c })
+	return LMS_TO_XYZ.apply(lms)
+}
+
+func labToLch(lab [3]float64) [3]float64 {
+	hue := math.Atan2(lab[2], lab[1]) * 180 / math.Pi
+	if hue < 0 {
+		hue += 360
+	}
+	return [3]float64{lab[0], math.Hypot(lab[1], lab[2]), hue}
+}
+
+func lchToLab(lch [3]float64) [3]float64 {
+	hue := lch[2] * math.Pi / 180
+	return [3]float64{lch[0], lch[1] * math.Cos(hue), lch[1] * math.Sin(hue)}
+}
+
+/*
+Converts HSL, with saturation and lightness in percent, to sRGB components in [0, 1].
+Assumes negative saturations were clamped when the color was parsed.
+
+Source: https://www.w3.org/TR/css-color-4/#hsl-to-rgb
+*/
+func HslToRgb(hue, sat, light float64) (float64, float64, float64) {
+	hue = math.Mod(hue, 360)
+	if hue < 0 {
+		hue += 360
+	}
+
+	sat /= 100
+	light /= 100
+
+	f := func(n float64) float64 {
+		k := math.Mod(n+hue/30, 12)
+		a := sat * min(light, 1-light)
+		return light - a*max(-1, min(k-3, 9-k, 1))
+	}
+
+	return f(0), f(8), f(4)
+}
+
+/*
+Converts sRGB components in [0, 1] to HSL with saturation and lightness in percent.
+The hue of achromatic colors is NaN.
+
+Source: https://www.w3.org/TR/css-color-4/#rgb-to-hsl
+*/
+func RgbToHsl(red, green, blue float64) (float64, float64, float64) {
+	valueMax := max(red, green, blue)
+	valueMin := min(red, green, blue)
+
+	hue := math.NaN()
+	sat := 0.0
+	light := (valueMin + valueMax) / 2
+
+	d := valueMax - valueMin
+	if d != 0 {
+		if light != 0 && light != 1 {
+			sat = (valueMax - light) / min(light, 1-light)
+		}
+
+		switch valueMax {
+		case red:
+			hue = (green - blue) / d
+			if green < blue {
+				hue += 6
+			}
+		case green:
+			hue = (blue-red)/d + 2
+		case blue:
+			hue = (red-green)/d + 4
+		}
+		hue *= 60
+	}
+
+	if sat < 0 {
+		hue += 180
+		sat = math.Abs(sat)
+	}
+	if hue >= 360 {
+		hue -= 360
+	}
+
+	return hue, sat * 100, light * 100
+}
+
+// https://www.w3.org/TR/css-color-4/#hwb-to-rgb
+func hwbToRgb(hue, white, black float64) [3]float64 {
+	white /= 100
+	black /= 100
+	if white+black >= 1 {
+		gray := white / (white + black)
+		return [3]float64{gray, gray, gray}
+	}
+
+	r, g, b := HslToRgb(hue, 100, 50)
+	return mapChannels([3]float64{r, g, b}, func(c float64) float64 {
+		return c*(1-white-black) + white
+	})
+}
+
+// https://www.w3.org/TR/css-color-4/#rgb-to-hwb
+func rgbToHwb(rgb [3]float64) [3]float64 {
+	hue, _, _ := RgbToHsl(rgb[0], rgb[1], rgb[2])
+	white := min(rgb[0], rgb[1], rgb[2])
+	black := 1 - max(rgb[0], rgb[1], rgb[2])
+	if white+black >= 1 {
+		hue = math.NaN()
+	}
+	return [3]float64{hue, white * 100, black * 100}
+}
+
+// sRGB, HSL and HWB convert between each other directly, keeping precision.
+func isSrgbBased(space string) bool {
+	return space == ColorSpace_SRGB || space == ColorSpace_HSL || space == ColorSpace_HWB
+}
+
+func toSrgb(space string, c [3]float64) [3]float64 {
+	switch space {
+	case ColorSpace_HSL:
+		r, g, b := HslToRgb(c[0], c[1], c[2])
+		return [3]float64{r, g, b}
+	case ColorSpace_HWB:
+		return hwbToRgb(c[0], c[1], c[2])
+	default:
+		return c
+	}
+}
+
+func fromSrgb(space string, rgb [3]float64) [3]float64 {
+	switch space {
+	case ColorSpace_HSL:
+		h, s, l := RgbToHsl(rgb[0], rgb[1], rgb[2])
+		return [3]float64{h, s, l}
+	case ColorSpace_HWB:
+		return rgbToHwb(rgb)
+	default:
+		return rgb
+	}
+}
+
+func toXyzD65(space string, c [3]float64) [3]float64 {
+	switch space {
+	case ColorSpace_SRGB:
+		return LINEAR_SRGB_TO_XYZ.apply(mapChannels(c, srgbToLinear))
+	case ColorSpace_SRGBLinear:
+		return LINEAR_SRGB_TO_XYZ.apply(c)
+	case ColorSpace_DisplayP3:
+		return LINEAR_P3_TO_XYZ.apply(mapChannels(c, srgbToLinear))
+	case ColorSpace_A98RGB:
+		return LINEAR_A98_TO_XYZ.apply(mapChannels(c, a98ToLinear))
+	case ColorSpace_ProPhotoRGB:
+		return D50_TO_D65.apply(LINEAR_PROPHOTO_TO_XYZ.apply(mapChannels(c, prophotoToLinear)))
+	case ColorSpace_Rec2020:
+		return LINEAR_REC2020_TO_XYZ.apply(mapChannels(c, rec2020ToLinear))
+	case ColorSpace_XYZD50:
+		return D50_TO_D65.apply(c)
+	case ColorSpace_HSL, ColorSpace_HWB:
+		return toXyzD65(ColorSpace_SRGB, toSrgb(space, c))
+	case ColorSpace_Lab:
+		return D50_TO_D65.apply(labToXyzD50(c))
+	case ColorSpace_LCH:
+		return toXyzD65(ColorSpace_Lab, lchToLab(c))
+	case ColorSpace_OKLab:
+		return oklabToXyz(c)
+	case ColorSpace_OKLCH:
+		return oklabToXyz(lchToLab(c))
+	default:
+		return c
+	}
+}
+
+func fromXyzD65(space string, xyz [3]float64) [3]float64 {
+	switch space {
+	case ColorSpace_SRGB:
+		return mapChannels(XYZ_TO_LINEAR_SRGB.apply(xyz), linearToSrgb)
+	case ColorSpace_SRGBLinear:
+		return XYZ_TO_LINEAR_SRGB.apply(xyz)
+	case ColorSpace_DisplayP3:
+		return mapChannels(XYZ_TO_LINEAR_P3.apply(xyz), linearToSrgb)
+	case ColorSpace_A98RGB:
+		return mapChannels(XYZ_TO_LINEAR_A98.apply(xyz), linearToA98)
+	case ColorSpace_ProPhotoRGB:
+		return mapChannels(XYZ_TO_LINEAR_PROPHOTO.apply(D65_TO_D50.apply(xyz)), linearToProphoto)
+	case ColorSpace_Rec2020:
+		return mapChannels(XYZ_TO_LINEAR_REC2020.apply(xyz), linearToRec2020)
+	case ColorSpace_XYZD50:
+		return D65_TO_D50.apply(xyz)
+	case ColorSpace_HSL, ColorSpace_HWB:
+		return fromSrgb(space, fromXyzD65(ColorSpace_SRGB, xyz))
+	case ColorSpace_Lab:
+		return xyzD50ToLab(D65_TO_D50.apply(xyz))
+	case ColorSpace_LCH:
+		return powerlessHue(labToLch(fromXyzD65(ColorSpace_Lab, xyz)), 0.0015)
+	case ColorSpace_OKLab:
+		return xyzToOklab(xyz)
+	case ColorSpace_OKLCH:
+		return powerlessHue(labToLch(xyzToOklab(xyz)), 0.000004)
+	default:
+		return xyz
+	}
+}
+
+// The hue of a color without chroma is missing.
+func powerlessHue(lch [3]float64, epsilon float64) [3]float64 {
+	if lch[1] < epsilon {
+		lch[2] = math.NaN()
+	}
+	return lch
+}
+
+// Converts the color to another color space. Missing components are treated as zero.
+func (c colorValue) convert(space string) colorValue {
+	if c.space == space {
+		return c
+	}
+
+	coords := mapChannels(c.coords, func(v float64) float64 {
+		if math.IsNaN(v) {
+			return 0
+		}
+		return v
+	})
+
+	result := colorValue{space: space, alpha: c.alpha}
+	if isSrgbBased(c.space) && isSrgbBased(space) {
+		result.coords = fromSrgb(space, toSrgb(c.space, coords))
+	} else {
+		result.coords = fromXyzD65(space, toXyzD65(c.space, coords))
+	}
+	return result
+}
+
+func (c colorValue) inSrgbGamut() bool {
+	const epsilon = 0.000075
+	for _, v := range c.convert(ColorSpace_SRGB).coords {
+		if v < -epsilon || v > 1+epsilon {
+			return false
+		}
+	}
+	return true
+}
+
+// https://www.w3.org/TR/css-color-4/#color-difference-OK
+func deltaEOK(a colorValue, b colorValue) float64 {
+	labA := a.convert(ColorSpace_OKLab).coords
+	labB := b.convert(ColorSpace_OKLab).coords
+	return math.Sqrt(math.Pow(labA[0]-labB[0], 2) + math.Pow(labA[1]-labB[1], 2) + math.Pow(labA[2]-labB[2], 2))
+}
+
+func clipToSrgb(c colorValue) colorValue {
+	rgb := c.convert(ColorSpace_SRGB)
+	rgb.coords = mapChannels(rgb.coords, func(v float64) float64 {
+		return min(max(v, 0), 1)
+	})
+	return rgb
+}
+
+/*
+Maps a color into the sRGB gamut by reducing its OKLCH chroma until clipping it is no longer
+noticeable.
+
+Source: https://www.w3.org/TR/css-color-4/#binsearch
+*/
+func gamutMapSrgb(c colorValue) colorValue {
+	const jnd = 0.02
+	const epsilon = 0.0001
+
+	if c.inSrgbGamut() {
+		return c.convert(ColorSpace_SRGB)
+	}
+
+	current := c.convert(ColorSpace_OKLCH)
+	if current.coords[0] >= 1 {
+		return colorValue{space: ColorSpace_SRGB, coords: [3]float64{1, 1, 1}, alpha: c.alpha}
+	}
+	if current.coords[0] <= 0 {
+		return colorValue{space: ColorSpace_SRGB, coords: [3]float64{0, 0, 0}, alpha: c.alpha}
+	}
+
+	clipped := clipToSrgb(current)
+	if deltaEOK(clipped, current) < jnd {
+		return clipped
+	}
+
+	low, high := 0.0, current.coords[1]
+	lowInGamut := true
+	for high-low > epsilon {
+		current.coords[1] = (low + high) / 2
+
+		if lowInGamut && current.inSrgbGamut() {
+			low = current.coords[1]
+			continue
+		}
+
+		clipped = clipToSrgb(current)
+		e := deltaEOK(clipped, current)
+		if e < jnd {
+			if jnd-e < epsilon {
+				return clipped
+			}
+			lowInGamut = false
+			low = current.coords[1]
+		} else {
+			high = current.coords[1]
+		}
+	}
+
+	return clipped
+}
+
+// Converts the color to 8 bit sRGB, gamut mapping colors sRGB can not show.
+func (c colorValue) toCssColor() CssColor {
+	rgb := gamutMapSrgb(c)
+	channel := func(v float64) int {
+		if math.IsNaN(v) {
+			return 0
+		}
+		return int(math.Round(min(max(v, 0), 1) * 255))
+	}
+
+	return CssColor{
+		R: channel(rgb.coords[0]),
+		G: channel(rgb.coords[1]),
+		B: channel(rgb.coords[2]),
+		A: channel(c.alpha),
+	}
+}
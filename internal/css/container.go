@@ -0,0 +1,179 @@
+package plex_css
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+/*
+The prelude of an @container rule: an optional container name and a condition on the size
+of the query container.
+
+Source: https://www.w3.org/TR/css-contain-3/#container-rule
+*/
+type ContainerQuery struct {
+	// empty when any container can be queried
+	Name      string
+	Condition MediaCondition
+	prelude   []Token
+}
+
+func (q *ContainerQuery) CssText() string {
+	return serializeTokens(q.prelude)
+}
+
+/*
+An ancestor established as a query container by 'container-type', together with the size of
+its content box.
+
+Source: https://www.w3.org/TR/css-contain-3/#query-container
+*/
+type QueryContainer struct {
+	// size or inline-size, normal containers can not be queried for their size
+	Type  string
+	Names []string
+	// size of the content box in px
+	Width  float32
+	Height float32
+	// font size em resolves against in the query
+	FontSize float32
+}
+
+// Size features of a query container, the inline axis is horizontal and the block axis vertical.
+// https://www.w3.org/TR/css-contain-3/#container-size-query
+var CONTAINER_FEATURES = map[string]mediaFeatureDefinition{
+	"width":        {kind: mediaLength, number: containerWidth},
+	"height":       {kind: mediaLength, number: containerHeight},
+	"inline-size":  {kind: mediaLength, number: containerWidth},
+	"block-size":   {kind: mediaLength, number: containerHeight},
+	"aspect-ratio": {kind: mediaRatio, number: containerRatio},
+	"orientation": {kind: mediaDiscrete, keywords: []string{"portrait", "landscape"}, keyword: func(env *MediaEnvironment) string {
+		if env.container.Height >= env.container.Width {
+			return "portrait"
+		}
+		return "landscape"
+	}},
+}
+
+func containerWidth(env *MediaEnvironment) float64  { return float64(env.container.Width) }
+func containerHeight(env *MediaEnvironment) float64 { return float64(env.container.Height) }
+func containerRatio(env *MediaEnvironment) float64 {
+	return float64(env.container.Width) / float64(env.container.Height)
+}
+
+// Container features that need size containment in both axes.
+var blockAxisFeatures = []string{"height", "block-size", "aspect-ratio", "orientation"}
+
+// Reports whether the container can be queried for the feature.
+func (c *QueryContainer) canQuery(feature string) bool {
+	switch {
+	case c.Type == "size":
+		return true
+	case c.Type == "inline-size":
+		return !slices.Contains(blockAxisFeatures, feature)
+	}
+	return false
+}
+
+// Container names exclude the keywords of the container condition.
+var CONTAINER_NAME_RESERVED = []string{"none", "and", "or", "not"}
+
+// https://www.w3.org/TR/css-contain-3/#container-name
+func isContainerName(v CssValue) bool {
+	keyword, ok := v.(*CssKeyword)
+	return ok && CUSTOM_IDENT.Matches([]CssValue{v}) && !slices.Contains(CONTAINER_NAME_RESERVED, strings.ToLower(keyword.Value))
+}
+
+/*
+Parses [ <container-name> ]? <container-condition>. A container condition has the syntax of a
+media condition, with container size features.
+
+Source: https://www.w3.org/TR/css-contain-3/#container-rule
+*/
+func ParseContainerQuery(tokens []Token) (ContainerQuery, error) {
+	query := ContainerQuery{prelude: trimWhitespace(tokens)}
+	parser := mediaParser{tokens: components(tokens)}
+
+	if name := parser.peekIdent(); name != "" && !parser.isBlockAt(0) && name != "not" {
+		if slices.Contains(CONTAINER_NAME_RESERVED, name) {
+			return ContainerQuery{}, fmt.Errorf("'%s' can not be a container name", name)
+		}
+		query.Name = parser.tokens[parser.pos].(*StringToken).Value
+		parser.pos++
+	}
+
+	condition, ok := parser.condition(true)
+	if !ok || !parser.done() {
+		return ContainerQuery{}, fmt.Errorf("invalid container condition")
+	}
+	query.Condition = condition
+	return query, nil
+}
+
+// Names the features the condition queries, a container that can not be queried for all of them is skipped.
+func conditionFeatures(condition MediaCondition) []string {
+	switch c := condition.(type) {
+	case mediaNot:
+		return conditionFeatures(c.condition)
+	case mediaAnd:
+		features := []string{}
+		for _, inner := range c {
+			features = append(features, conditionFeatures(inner)...)
+		}
+		return features
+	case mediaOr:
+		features := []string{}
+		for _, inner := range c {
+			features = append(features, conditionFeatures(inner)...)
+		}
+		return features
+	case mediaFeature:
+		return []string{c.name}
+	}
+	return nil
+}
+
+/*
+Evaluates the query against the nearest of the containers, given from the nearest ancestor
+outwards, that has the name of the query and can be queried for all of its features. Without
+such a container the query is unknown and does not match.
+
+Source: https://www.w3.org/TR/css-contain-3/#container-rule
+*/
+func (q *ContainerQuery) Matches(containers []QueryContainer, env MediaEnvironment) bool {
+	features := conditionFeatures(q.Condition)
+	for i := range containers {
+		container := &containers[i]
+		if q.Name != "" && !slices.Contains(container.Names, q.Name) {
+			continue
+		}
+		if !allFeatures(features, container.canQuery) {
+			continue
+		}
+
+		env.container = container
+		env.FontSize = container.FontSize
+		return q.Condition.evaluate(&env) == mediaTrue
+	}
+	return false
+}
+
+func allFeatures(features []string, canQuery func(feature string) bool) bool {
+	for _, feature := range features {
+		if !canQuery(feature) {
+			return false
+		}
+	}
+	return true
+}
+
+// Reports whether the query of every enclosing @container rule matches.
+func (r *Rule) MatchesContainers(containers []QueryContainer, env MediaEnvironment) bool {
+	for i := range r.Container {
+		if !r.Container[i].Matches(containers, env) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,96 @@
+package plex_css_test
+
+import (
+	"testing"
+	plex_css "visualsource/plex/internal/css"
+)
+
+func TestContainer_MATCHES(t *testing.T) {
+	env := plex_css.DefaultMediaEnvironment
+	containers := []plex_css.QueryContainer{
+		{Type: "inline-size", Names: []string{"card"}, Width: 400, Height: 120, FontSize: 20},
+		{Type: "size", Names: []string{"page", "main"}, Width: 800, Height: 600, FontSize: 16},
+	}
+
+	tests := map[string]bool{
+		"(width: 400px)":                          true,
+		"(min-width: 300px)":                      true,
+		"(max-inline-size: 300px)":                false,
+		"(width >= 20em)":                         true,
+		"(width > 20em)":                          false,
+		"card (300px < width < 500px)":            true,
+		"page (width: 800px)":                     true,
+		"main (orientation: landscape)":           true,
+		"sidebar (width > 0px)":                   false,
+		"(height: 600px)":                         true,
+		"(block-size > 500px) and (width: 800px)": true,
+		"(aspect-ratio: 4/3)":                     true,
+		"card (height > 0px)":                     false,
+		"not (width: 400px)":                      false,
+		"(width < 100px) or (width > 300px)":      true,
+		"(unknown-feature)":                       false,
+	}
+
+	for query, expected := range tests {
+		parser := plex_css.CssParser{}
+		stylesheet, err := parser.ParseStylesheet("@container "+query+" { p { color: red } }", plex_css.Origin_Author)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		if len(stylesheet.Rules) != 1 {
+			t.Fatalf("%q: expected the @container rule to be flattened into one rule, got %v", query, stylesheet)
+		}
+		if stylesheet.Rules[0].MatchesContainers(containers, env) != expected {
+			t.Errorf("expected %q to match: %v", query, expected)
+		}
+		if stylesheet.Rules[0].MatchesContainers(nil, env) {
+			t.Errorf("expected %q not to match without a container", query)
+		}
+	}
+}
+
+func TestContainer_PARSE(t *testing.T) {
+	parser := plex_css.CssParser{}
+	stylesheet, err := parser.ParseStylesheet(`
+		@container card (min-width: 400px) {
+			p { width: 50cqw; height: 10cqh }
+			@container (height > 10cqb) { span { color: red } }
+		}
+		@container none (width > 0px) { a { color: red } }
+		@container card { a { color: red } }
+		div { container: card main / size }
+		section { container: sidebar }
+		aside { container: none / inline-size / size }
+		nav { container-type: block-size }
+	`, plex_css.Origin_Author)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if len(parser.Diagnostics) != 4 {
+		t.Fatalf("expected the invalid rules and declarations to be reported, got %v", parser.Diagnostics)
+	}
+
+	if text := stylesheet.Rules[0].CssText(); text != "@container card (min-width: 400px) { p { width: 50cqw; height: 10cqh; } }" {
+		t.Fatalf("unexpected serialization %q", text)
+	}
+	if text := stylesheet.Rules[1].CssText(); text != "@container card (min-width: 400px) { @container (height > 10cqb) { span { color: red; } } }" {
+		t.Fatalf("unexpected serialization %q", text)
+	}
+
+	expected := map[string]string{
+		"container-name: card main": "container-type: size",
+		"container-name: sidebar":   "container-type: normal",
+	}
+	for _, rule := range stylesheet.Rules[2:4] {
+		if len(rule.Block) != 2 {
+			t.Fatalf("expected the container shorthand to expand to two longhands, got %v", rule.Block)
+		}
+		if expected[rule.Block[0].CssText()] != rule.Block[1].CssText() {
+			t.Fatalf("unexpected longhands %q and %q", rule.Block[0].CssText(), rule.Block[1].CssText())
+		}
+	}
+	if len(stylesheet.Rules[4].Block) != 0 || len(stylesheet.Rules[5].Block) != 0 {
+		t.Fatalf("expected invalid container declarations to be dropped")
+	}
+}
@@ -1,7 +1,10 @@
 package plex_css
 
 import (
-	"github.com/gookit/goutil/mathutil"
+	"math"
+	"slices"
+	"strings"
+
 	"github.com/moznion/go-optional"
 )
 
@@ -14,6 +17,10 @@ const (
 	TCssValue_DIMENTION
 	TCssValue_FUNCTION
 	TCssValue_EXPRESSION
+	TCssValue_STRING
+	TCssValue_URL
+	TCssValue_LIST
+	TCssValue_RATIO
 )
 
 const (
@@ -33,11 +40,40 @@ const (
 	CssUnit_VB
 	CssUnit_VMIN
 	CssUnit_VMAX
+	CssUnit_CQW
+	CssUnit_CQH
+	CssUnit_CQI
+	CssUnit_CQB
+	CssUnit_CQMIN
+	CssUnit_CQMAX
 	CssUnit_PX
+	CssUnit_CM
+	CssUnit_MM
+	CssUnit_Q
+	CssUnit_IN
+	CssUnit_PT
+	CssUnit_PC
+	CssUnit_DEG
+	CssUnit_GRAD
+	CssUnit_RAD
+	CssUnit_TURN
+	CssUnit_S
+	CssUnit_MS
+	CssUnit_HZ
+	CssUnit_KHZ
+	CssUnit_DPI
+	CssUnit_DPCM
+	CssUnit_DPPX
+	CssUnit_X
+	// flexible lengths of grid tracks
+	CssUnit_FR
+	// a dimension with a unit the engine does not know
+	CssUnit_UNKNOWN
 )
 
+// Units are ASCII case-insensitive.
 func strToUnit(value string) CssUnit {
-	switch value {
+	switch strings.ToLower(value) {
 	case "em":
 		return CssUnit_EM
 	case "ex":
@@ -60,44 +96,143 @@ func strToUnit(value string) CssUnit {
 		return CssUnit_VH
 	case "vi":
 		return CssUnit_VI
+	case "vb":
+		return CssUnit_VB
+	case "vmin":
+		return CssUnit_VMIN
+	case "vmax":
+		return CssUnit_VMAX
+	case "cqw":
+		return CssUnit_CQW
+	case "cqh":
+		return CssUnit_CQH
+	case "cqi":
+		return CssUnit_CQI
+	case "cqb":
+		return CssUnit_CQB
+	case "cqmin":
+		return CssUnit_CQMIN
+	case "cqmax":
+		return CssUnit_CQMAX
 	case "px":
 		return CssUnit_PX
+	case "cm":
+		return CssUnit_CM
+	case "mm":
+		return CssUnit_MM
+	case "q":
+		return CssUnit_Q
+	case "in":
+		return CssUnit_IN
+	case "pt":
+		return CssUnit_PT
+	case "pc":
+		return CssUnit_PC
+	case "deg":
+		return CssUnit_DEG
+	case "grad":
+		return CssUnit_GRAD
+	case "rad":
+		return CssUnit_RAD
+	case "turn":
+		return CssUnit_TURN
+	case "s":
+		return CssUnit_S
+	case "ms":
+		return CssUnit_MS
+	case "hz":
+		return CssUnit_HZ
+	case "khz":
+		return CssUnit_KHZ
+	case "dpi":
+		return CssUnit_DPI
+	case "dpcm":
+		return CssUnit_DPCM
+	case "dppx":
+		return CssUnit_DPPX
+	case "x":
+		return CssUnit_X
+	case "fr":
+		return CssUnit_FR
+	case "%":
+		return CssUnit_PRESENT
 	default:
-		return CssUnit_NO_UNIT
+		return CssUnit_UNKNOWN
 	}
 }
 
-// https://stackoverflow.com/questions/54197913/parse-hex-string-to-image-color
-func parseHexValue(s string) CssValue {
-	color := CssColor{}
+// Reports whether the unit is a relative or absolute <length> unit.
+// https://www.w3.org/TR/css-values-4/#lengths
+func IsLengthUnit(unit CssUnit) bool {
+	return unit >= CssUnit_EM && unit <= CssUnit_PC
+}
+
+// Reports whether the unit is resolved against the font, root element, viewport or query container.
+func IsRelativeLengthUnit(unit CssUnit) bool {
+	return unit >= CssUnit_EM && unit <= CssUnit_CQMAX
+}
+
+// https://www.w3.org/TR/css-values-4/#angles
+func IsAngleUnit(unit CssUnit) bool {
+	return unit >= CssUnit_DEG && unit <= CssUnit_TURN
+}
+
+// https://www.w3.org/TR/css-values-4/#time
+func IsTimeUnit(unit CssUnit) bool {
+	return unit == CssUnit_S || unit == CssUnit_MS
+}
 
-	hexToByte := func(b byte) byte {
+// https://www.w3.org/TR/css-values-4/#frequency
+func IsFrequencyUnit(unit CssUnit) bool {
+	return unit == CssUnit_HZ || unit == CssUnit_KHZ
+}
+
+// https://www.w3.org/TR/css-values-4/#resolution
+func IsResolutionUnit(unit CssUnit) bool {
+	return unit >= CssUnit_DPI && unit <= CssUnit_X
+}
+
+/*
+Parses the digits of a hex color, 3 or 4 digits are shorthands repeating every digit.
+Returns nil for any other length or non hex digits.
+
+Source: https://www.w3.org/TR/css-color-4/#hex-notation
+*/
+func parseHexValue(s string) CssValue {
+	digits := []int{}
+	for i := 0; i < len(s); i++ {
+		b := s[i]
 		switch {
 		case b >= '0' && b <= '9':
-			return b - '0'
+			digits = append(digits, int(b-'0'))
 		case b >= 'a' && b <= 'f':
-			return b - 'a' + 10
+			digits = append(digits, int(b-'a'+10))
 		case b >= 'A' && b <= 'F':
-			return b - 'A' + 10
+			digits = append(digits, int(b-'A'+10))
+		default:
+			return nil
+		}
+	}
+
+	channels := []int{}
+	switch len(digits) {
+	case 3, 4:
+		for _, digit := range digits {
+			channels = append(channels, digit*17)
+		}
+	case 6, 8:
+		for i := 0; i < len(digits); i += 2 {
+			channels = append(channels, digits[i]<<4+digits[i+1])
 		}
-		return 0
-	}
-
-	switch len(s) {
-	case 8: // Full RGBA
-		color.R = int(hexToByte(s[0])<<4 + hexToByte(s[1]))
-		color.G = int(hexToByte(s[2])<<4 + hexToByte(s[3]))
-		color.B = int(hexToByte(s[4])<<4 + hexToByte(s[5]))
-		color.A = int(hexToByte(s[6])<<4 + hexToByte(s[7]))
-		return &color
-	case 6: // Full RGB
-		color.R = int(hexToByte(s[0])<<4 + hexToByte(s[1]))
-		color.G = int(hexToByte(s[2])<<4 + hexToByte(s[3]))
-		color.B = int(hexToByte(s[4])<<4 + hexToByte(s[5]))
-		return &color
 	default:
 		return nil
 	}
+
+	color := CssColor{R: channels[0], G: channels[1], B: channels[2], A: 255}
+	if len(channels) == 4 {
+		color.A = channels[3]
+	}
+	return &color
 }
 
 func parseValue(tokens *[]Token, pos *int) CssValue {
@@ -115,6 +250,14 @@ func parseValue(tokens *[]Token, pos *int) CssValue {
 		v := token.(*StringToken)
 		(*pos)++
 		return &CssKeyword{Value: v.Value}
+	case Token_String:
+		v := token.(*StringToken)
+		(*pos)++
+		return &CssString{Value: v.Value}
+	case Token_Url:
+		v := token.(*StringToken)
+		(*pos)++
+		return &CssUrl{Url: v.Value}
 	case Token_Dimension:
 		v := token.(*NumberToken)
 		(*pos)++
@@ -140,18 +283,106 @@ func parseValue(tokens *[]Token, pos *int) CssValue {
 		f := token.(*FunctionBlock)
 		(*pos)++
 
-		args := ParseCssValue(f.Args)
+		if slices.Contains(MATH_FUNCTIONS, strings.ToLower(f.Name)) {
+			return parseMathFunction(f)
+		}
+		if slices.Contains(COLOR_FUNCTIONS, strings.ToLower(f.Name)) {
+			return parseColorFunction(f)
+		}
+
+		// url("a.png") is the same value as url(a.png)
+		if args := components(f.Args); strings.EqualFold(f.Name, "url") && len(args) == 1 && args[0].GetId() == Token_String {
+			return &CssUrl{Url: args[0].(*StringToken).Value}
+		}
 
 		return &CssFunction{
-			Name: f.Name,
-			Args: args,
+			Name:   f.Name,
+			Args:   parseArguments(f.Args),
+			tokens: f.Args,
 		}
 	default:
 		return nil
 	}
 }
 
+/*
+Parses the component values of a declaration, keeping how they are separated. Space separated
+values are returned in order. A comma or slash separated list is returned as one CssList whose
+items are the values between the separators, commas separating looser than slashes. Two numbers
+separated by a slash are a <ratio>.
+
+Source: https://www.w3.org/TR/css-values-4/#component-combinators
+*/
 func ParseCssValue(tokens []Token) []CssValue {
+	groups := splitByCommas(tokens)
+	if len(groups) == 1 {
+		return parseSlashList(tokens)
+	}
+
+	list := &CssList{Separator: ','}
+	for _, group := range groups {
+		list.Values = append(list.Values, groupValue(parseSlashList(group)))
+	}
+	return []CssValue{list}
+}
+
+// The arguments of a function are separated by commas, one value per argument.
+func parseArguments(tokens []Token) []CssValue {
+	args := []CssValue{}
+	if len(components(tokens)) == 0 {
+		return args
+	}
+	for _, group := range splitByCommas(tokens) {
+		args = append(args, groupValue(parseSlashList(group)))
+	}
+	return args
+}
+
+func parseSlashList(tokens []Token) []CssValue {
+	groups := [][]Token{{}}
+	for _, token := range tokens {
+		if isDelim(token, '/') {
+			groups = append(groups, []Token{})
+			continue
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], token)
+	}
+	if len(groups) == 1 {
+		return parseSpaceList(tokens)
+	}
+
+	items := []CssValue{}
+	for _, group := range groups {
+		items = append(items, groupValue(parseSpaceList(group)))
+	}
+	if ratio, ok := ratioOf(items); ok {
+		return []CssValue{ratio}
+	}
+	return []CssValue{&CssList{Separator: '/', Values: items}}
+}
+
+// A single value stands for itself, several values are a space separated list.
+func groupValue(values []CssValue) CssValue {
+	if len(values) == 1 {
+		return values[0]
+	}
+	return &CssList{Separator: ' ', Values: values}
+}
+
+// https://www.w3.org/TR/css-values-4/#ratio-value
+func ratioOf(items []CssValue) (*CssRatio, bool) {
+	if len(items) != 2 {
+		return nil, false
+	}
+	numerator, ok := items[0].(*CssDimention)
+	denominator, ok2 := items[1].(*CssDimention)
+	if !ok || !ok2 || numerator.Unit != CssUnit_NO_UNIT || denominator.Unit != CssUnit_NO_UNIT || numerator.Value < 0 || denominator.Value < 0 {
+		return nil, false
+	}
+	return &CssRatio{Numerator: numerator.Value, Denominator: denominator.Value}, true
+}
+
+func parseSpaceList(tokens []Token) []CssValue {
 	values := []CssValue{}
 	len := len(tokens)
 	pos := 0
@@ -161,42 +392,14 @@ func ParseCssValue(tokens []Token) []CssValue {
 			return values
 		}
 		switch tokens[pos].GetId() {
-		case Token_Whitespace, Token_Ident, TFunction, Token_Hash:
+		case Token_Whitespace, Token_Ident, TFunction, Token_Hash, Token_String, Token_Url, Token_Dimension, Token_Number, Token_Percentage:
 			value := parseValue(&tokens, &pos)
 			if value != nil {
 				values = append(values, value)
 			}
-		case Token_Dimension, Token_Number, Token_Percentage:
-			value := parseValue(&tokens, &pos)
-			if value == nil {
-				continue
-			}
-
-			for pos < len && tokens[pos].GetId() == Token_Whitespace {
-				pos++
-			}
-
-			if pos < len && tokens[pos].GetId() == Token_Delim {
-				v := tokens[pos].(*RuneToken)
-				if v.Value == '+' || v.Value == '-' || v.Value == '*' || v.Value == '/' {
-					pos++
-					for pos < len && tokens[pos].GetId() == Token_Whitespace {
-						pos++
-					}
-					right := parseValue(&tokens, &pos)
-					if right == nil {
-						continue
-					}
-
-					values = append(values, &CssExpression{
-						Left:  value,
-						Right: right,
-						Op:    uint8(v.Value),
-					})
-					continue
-				}
-			}
-			values = append(values, value)
+		default:
+			// tokens without a value representation are skipped
+			pos++
 		}
 	}
 }
@@ -214,10 +417,10 @@ func IsCssValue(v CssValue, t CssValueType) bool {
 
 func IsCssKeyword(v CssValue, keyword string) bool {
 	if !IsCssValue(v, TCssValue_KEYWORD) {
-		return true
+		return false
 	}
 	if i, ok := v.(*CssKeyword); ok {
-		return i.Value == keyword
+		return strings.EqualFold(i.Value, keyword)
 	}
 	return false
 }
@@ -266,6 +469,7 @@ func (c CssPropertyMap) ResolveLookupAsDimention(keys ...string) optional.Option
 	return nil
 }
 
+// A binary operation inside a math function.
 type CssExpression struct {
 	Left  CssValue
 	Op    uint8
@@ -280,54 +484,9 @@ type CssColor struct {
 	G, R, B, A int
 }
 
-/*
-Source: https://www.w3.org/TR/css-color-4/#rgb-to-hsl
-*/
-func (c *CssColor) RgbToHsl() (int, int, int) {
-	valueMax := max(c.R, c.G, c.B)
-	valueMin := min(c.R, c.G, c.B)
-
-	var hue int
-	sat := 0
-	light := (valueMin + valueMax) / 2
-
-	d := valueMax - valueMin
-
-	if d != 0 {
-		if light == 0 || light == 1 {
-			sat = 0
-		} else {
-			sat = (valueMax - light) / min(light, 1-light)
-		}
-		switch valueMax {
-		case c.R:
-			var m int
-			if c.G < c.B {
-				m = 6
-			} else {
-				m = 0
-			}
-
-			hue = (c.G-c.B)/d + m
-		case c.G:
-			hue = (c.B-c.R)/d + 2
-		case c.B:
-			hue = (c.R-c.G)/d + 4
-		}
-
-		hue = hue * 60
-	}
-
-	if sat < 0 {
-		hue += 180
-		sat = mathutil.Abs(sat)
-	}
-
-	if hue >= 360 {
-		hue -= 360
-	}
-
-	return hue, sat * 100, light * 100
+// Returns the hue in degrees, NaN for achromatic colors, with saturation and lightness in percent.
+func (c *CssColor) RgbToHsl() (float64, float64, float64) {
+	return RgbToHsl(float64(c.R)/255, float64(c.G)/255, float64(c.B)/255)
 }
 
 func (c *CssColor) GetType() CssValueType {
@@ -338,34 +497,102 @@ type CssKeyword struct {
 	Value string
 }
 
+// Resolves a named color or system color, keywords are ASCII case-insensitive.
 func (c *CssKeyword) ResolveColor() optional.Option[CssColor] {
-	v, ok := CSS_COLOR_KEYWORDS[c.Value]
-
-	if !ok {
-		return nil
+	name := strings.ToLower(c.Value)
+	if v, ok := CSS_COLOR_KEYWORDS[name]; ok {
+		return optional.Some(v)
+	}
+	if v, ok := SystemColors[name]; ok {
+		return optional.Some(v)
 	}
 
-	return optional.Some(v)
+	return nil
 }
 
 func (c *CssKeyword) GetType() CssValueType {
 	return TCssValue_KEYWORD
 }
 
-func ResolveCssValueToColor(c CssValue) optional.Option[CssColor] {
-	if i, ok := c.(*CssKeyword); ok {
-		color := i.ResolveColor()
-		if color != nil {
-			return color
+/*
+Resolves a <color> to its used value. currentColor is the value of the 'color' property,
+'currentcolor' in 'color' itself refers to the inherited one.
+
+Source: https://www.w3.org/TR/css-color-4/#resolving-color-values
+*/
+func ResolveCssValueToColor(c CssValue, currentColor CssColor) optional.Option[CssColor] {
+	switch value := c.(type) {
+	case *CssKeyword:
+		if strings.EqualFold(value.Value, "currentcolor") {
+			return optional.Some(currentColor)
+		}
+		return value.ResolveColor()
+	case *CssColor:
+		return optional.Some(*value)
+	case *CssFunction:
+		if value.tokens == nil {
+			return nil
+		}
+		context := colorContext{currentColor: &currentColor, palette: SystemColors}
+		color, err := evaluateColorFunction(&FunctionBlock{Name: value.Name, Args: value.tokens}, context)
+		if err == nil {
+			return optional.Some(color.toCssColor())
 		}
-	}
-	if d, ok := c.(*CssColor); ok {
-		return optional.Some(*d)
 	}
 
 	return nil
 }
 
+type CssString struct {
+	Value string
+}
+
+func (c *CssString) GetType() CssValueType {
+	return TCssValue_STRING
+}
+
+// The value of url(), quoted or not.
+// https://www.w3.org/TR/css-values-4/#urls
+type CssUrl struct {
+	Url string
+}
+
+func (c *CssUrl) GetType() CssValueType {
+	return TCssValue_URL
+}
+
+/*
+Values separated by commas, slashes or spaces. Space separated values of a declaration are
+not wrapped in a list, only those that are items of a comma or slash separated list are.
+
+Source: https://www.w3.org/TR/css-values-4/#component-combinators
+*/
+type CssList struct {
+	// ',', '/' or ' '
+	Separator rune
+	Values    []CssValue
+}
+
+func (c *CssList) GetType() CssValueType {
+	return TCssValue_LIST
+}
+
+// <number [0,∞]> / <number [0,∞]>
+// https://www.w3.org/TR/css-values-4/#ratios
+type CssRatio struct {
+	Numerator   float32
+	Denominator float32
+}
+
+func (c *CssRatio) GetType() CssValueType {
+	return TCssValue_RATIO
+}
+
+// A ratio whose numerator or denominator is zero is degenerate.
+func (c *CssRatio) IsDegenerate() bool {
+	return c.Numerator == 0 || c.Denominator == 0
+}
+
 type CssDimention struct {
 	Value float32
 	Unit  CssUnit
@@ -379,15 +606,75 @@ func ResolveDimentionToPXFloat(c CssValue) float32 {
 	return 0.0
 }
 
+/*
+Converts an absolute length to px, 1in is 96px. Relative lengths need the font or viewport
+they are resolved against and convert to 0.
+
+Source: https://www.w3.org/TR/css-values-4/#absolute-lengths
+*/
 func (c *CssDimention) AsPx() float32 {
 	switch c.Unit {
 	case CssUnit_PX:
 		return c.Value
+	case CssUnit_CM:
+		return c.Value * 96 / 2.54
+	case CssUnit_MM:
+		return c.Value * 96 / 25.4
+	case CssUnit_Q:
+		return c.Value * 96 / 101.6
+	case CssUnit_IN:
+		return c.Value * 96
+	case CssUnit_PT:
+		return c.Value * 96 / 72
+	case CssUnit_PC:
+		return c.Value * 96 / 6
 	default:
 		return 0.0
 	}
 }
 
+/*
+Converts an angle to degrees, a time to milliseconds and a resolution to dots per px. Other
+units convert to 0.
+
+Source: https://www.w3.org/TR/css-values-4/#other-units
+*/
+func (c *CssDimention) AsDeg() float32 {
+	switch c.Unit {
+	case CssUnit_DEG:
+		return c.Value
+	case CssUnit_GRAD:
+		return c.Value * 0.9
+	case CssUnit_RAD:
+		return c.Value * 180 / math.Pi
+	case CssUnit_TURN:
+		return c.Value * 360
+	}
+	return 0.0
+}
+
+func (c *CssDimention) AsMs() float32 {
+	switch c.Unit {
+	case CssUnit_MS:
+		return c.Value
+	case CssUnit_S:
+		return c.Value * 1000
+	}
+	return 0.0
+}
+
+func (c *CssDimention) AsDppx() float32 {
+	switch c.Unit {
+	case CssUnit_DPPX, CssUnit_X:
+		return c.Value
+	case CssUnit_DPI:
+		return c.Value / 96
+	case CssUnit_DPCM:
+		return c.Value * 2.54 / 96
+	}
+	return 0.0
+}
+
 func (c *CssDimention) GetType() CssValueType {
 	return TCssValue_DIMENTION
 }
@@ -399,6 +686,8 @@ func CreateCssDimention(value float32, t CssUnit) optional.Option[CssDimention]
 type CssFunction struct {
 	Name string
 	Args []CssValue
+	// the arguments as written, kept for colors resolved when the style is computed
+	tokens []Token
 }
 
 func (c *CssFunction) GetType() CssValueType {
@@ -0,0 +1,268 @@
+package plex_css
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Shorthands whose longhands recombine as one to four box values.
+var BOX_SHORTHANDS = []string{"margin", "padding", "inset", "border-width", "border-style", "border-color"}
+
+/*
+Parses a style rule and inserts it before the rule at index. Only style rules can be
+inserted, at-rules are kept apart from the rule list.
+
+Source: https://www.w3.org/TR/cssom-1/#insert-a-css-rule
+*/
+func (s *Stylesheet) InsertRule(text string, index int) (int, error) {
+	if index < 0 || index > len(s.Rules) {
+		return 0, fmt.Errorf("rule index %d is out of range", index)
+	}
+
+	parser := CssParser{}
+	rule, err := parser.ParseRule(text)
+	if err != nil {
+		return 0, err
+	}
+
+	s.Rules = slices.Insert(s.Rules, index, rule)
+	s.Diagnostics = append(s.Diagnostics, parser.Diagnostics...)
+	return index, nil
+}
+
+// https://www.w3.org/TR/cssom-1/#remove-a-css-rule
+func (s *Stylesheet) DeleteRule(index int) error {
+	if index < 0 || index >= len(s.Rules) {
+		return fmt.Errorf("rule index %d is out of range", index)
+	}
+
+	s.Rules = slices.Delete(s.Rules, index, index+1)
+	return nil
+}
+
+// Replaces the selectors of the rule, an invalid selector leaves the rule unchanged.
+func (r *Rule) SetSelectorText(text string) error {
+	tokenizer := Tokenizer{}
+	tokens, err := tokenizer.Parse(text)
+	if err != nil {
+		return err
+	}
+
+	// the selector parser does not expect the end of the input
+	tokens = slices.DeleteFunc(tokens, func(token Token) bool {
+		return token.GetId() == Token_EOF
+	})
+
+	selectors, err := ParseSelectorList(&tokens)
+	if err != nil {
+		return err
+	}
+	if len(selectors) == 0 {
+		return fmt.Errorf("invalid selector '%s'", text)
+	}
+
+	r.Selector = selectors
+	return nil
+}
+
+// Names of the declarations a property sets, the longhands for a shorthand.
+func propertyNames(name string) []string {
+	if definition, ok := SHORTHANDS[name]; ok {
+		return definition.Longhands
+	}
+	return []string{name}
+}
+
+func findDeclaration(block []Declaration, name string) int {
+	return slices.IndexFunc(block, func(dec Declaration) bool {
+		return dec.Name == name
+	})
+}
+
+/*
+Sets a property in a declaration block. A shorthand sets all of its longhands and existing
+declarations are updated in place. Values the property's grammar does not accept leave the
+block unchanged.
+
+Source: https://www.w3.org/TR/cssom-1/#dom-cssstyledeclaration-setproperty
+*/
+func SetProperty(block []Declaration, name string, value string, priority string) ([]Declaration, error) {
+	name = normalizePropertyName(name)
+	if strings.TrimSpace(value) == "" {
+		block, _ = RemoveProperty(block, name)
+		return block, nil
+	}
+
+	switch strings.ToLower(priority) {
+	case "":
+	case "important":
+		value += " !important"
+	default:
+		return block, fmt.Errorf("invalid priority '%s'", priority)
+	}
+
+	parser := CssParser{}
+	declarations, err := parser.ParseDeclarationsList(name + ": " + value)
+	if err != nil {
+		return block, err
+	}
+	if len(parser.Diagnostics) > 0 {
+		return block, fmt.Errorf("%s", parser.Diagnostics[0].Message)
+	}
+
+	// a ';' in the value would sneak in other declarations
+	names := propertyNames(name)
+	if len(declarations) != len(names) {
+		return block, fmt.Errorf("invalid value for '%s'", name)
+	}
+	for _, dec := range declarations {
+		if !slices.Contains(names, dec.Name) {
+			return block, fmt.Errorf("invalid value for '%s'", name)
+		}
+	}
+
+	result := slices.Clone(block)
+	for _, dec := range declarations {
+		if i := findDeclaration(result, dec.Name); i >= 0 {
+			result[i] = dec
+		} else {
+			result = append(result, dec)
+		}
+	}
+	return result, nil
+}
+
+/*
+Returns the value of a property in a declaration block or "" when it is not set. Shorthands
+only have a value when the longhands can be recombined.
+
+Source: https://www.w3.org/TR/cssom-1/#dom-cssstyledeclaration-getpropertyvalue
+*/
+func GetPropertyValue(block []Declaration, name string) string {
+	name = normalizePropertyName(name)
+
+	definition, ok := SHORTHANDS[name]
+	if !ok {
+		i := findDeclaration(block, name)
+		if i < 0 || block[i].shorthand != "" {
+			return ""
+		}
+		return block[i].ValueText()
+	}
+
+	longhands := []*Declaration{}
+	for _, longhand := range definition.Longhands {
+		i := findDeclaration(block, longhand)
+		if i < 0 {
+			return ""
+		}
+		longhands = append(longhands, &block[i])
+	}
+
+	for _, dec := range longhands {
+		if dec.Important != longhands[0].Important {
+			return ""
+		}
+	}
+
+	// longhands waiting for var() substitution keep the shorthand's text
+	if longhands[0].shorthand != "" {
+		for _, dec := range longhands {
+			if dec.shorthand != name {
+				return ""
+			}
+		}
+		return strings.TrimSpace(serializeTokens(longhands[0].tokens))
+	}
+
+	values := []string{}
+	for _, dec := range longhands {
+		if dec.shorthand != "" {
+			return ""
+		}
+		values = append(values, dec.ValueText())
+	}
+
+	if keyword := longhands[0].GetCssWideKeyword(); keyword != "" {
+		for _, value := range values {
+			if value != values[0] {
+				return ""
+			}
+		}
+		return values[0]
+	}
+
+	if slices.Contains(BOX_SHORTHANDS, name) {
+		return serializeBox(values)
+	}
+	return ""
+}
+
+// Returns "important" when the property is set with !important.
+func GetPropertyPriority(block []Declaration, name string) string {
+	name = normalizePropertyName(name)
+
+	for _, longhand := range propertyNames(name) {
+		i := findDeclaration(block, longhand)
+		if i < 0 || !block[i].Important {
+			return ""
+		}
+	}
+	return "important"
+}
+
+/*
+Removes a property, or all the longhands of a shorthand, from a declaration block and
+returns the value it had.
+
+Source: https://www.w3.org/TR/cssom-1/#dom-cssstyledeclaration-removeproperty
+*/
+func RemoveProperty(block []Declaration, name string) ([]Declaration, string) {
+	name = normalizePropertyName(name)
+	value := GetPropertyValue(block, name)
+
+	names := propertyNames(name)
+	result := slices.DeleteFunc(slices.Clone(block), func(dec Declaration) bool {
+		return slices.Contains(names, dec.Name)
+	})
+	return result, value
+}
+
+/*
+Serializes a declaration block, as used for the value of a style attribute. Longhands
+waiting for var() substitution are written as the shorthand they came from.
+
+Source: https://www.w3.org/TR/cssom-1/#serialize-a-css-declaration-block
+*/
+func SerializeDeclarations(block []Declaration) string {
+	texts := []string{}
+	shorthands := []string{}
+	for i := range block {
+		dec := block[i]
+		if dec.shorthand != "" {
+			if slices.Contains(shorthands, dec.shorthand) {
+				continue
+			}
+			shorthands = append(shorthands, dec.shorthand)
+			dec.Name = dec.shorthand
+		}
+		texts = append(texts, dec.CssText()+";")
+	}
+	return strings.Join(texts, " ")
+}
+
+// Shortest form of top, right, bottom and left values.
+func serializeBox(values []string) string {
+	top, right, bottom, left := values[0], values[1], values[2], values[3]
+	switch {
+	case left != right:
+		return strings.Join(values, " ")
+	case top != bottom:
+		return top + " " + right + " " + bottom
+	case top != right:
+		return top + " " + right
+	default:
+		return top
+	}
+}
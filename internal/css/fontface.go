@@ -0,0 +1,379 @@
+package plex_css
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/*
+A font face declared by @font-face. Sources are tried in order until one can be loaded.
+
+Source: https://www.w3.org/TR/css-fonts-4/#font-face-rule
+*/
+type FontFace struct {
+	Family  string
+	Sources []FontFaceSource
+	// the lightest and boldest weight the face covers
+	Weight [2]int
+	// normal, italic or oblique
+	Style string
+	// the code points the face covers, every code point when empty
+	UnicodeRange []UnicodeRange
+	Display      string
+	rule         AtRule
+}
+
+// https://www.w3.org/TR/css-fonts-4/#src-desc
+type FontFaceSource struct {
+	// the file of a url() source
+	Url string
+	// the full name of a local() source
+	Local  string
+	Format string
+	Tech   []string
+}
+
+// https://www.w3.org/TR/css-fonts-4/#unicode-range-desc
+type UnicodeRange struct {
+	Start rune
+	End   rune
+}
+
+func (r UnicodeRange) Contains(c rune) bool {
+	return c >= r.Start && c <= r.End
+}
+
+func (f *FontFace) Covers(c rune) bool {
+	if len(f.UnicodeRange) == 0 {
+		return true
+	}
+	for _, r := range f.UnicodeRange {
+		if r.Contains(c) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *FontFace) CssText() string {
+	return f.rule.CssText()
+}
+
+// Formats the engine can read, font files are opened with FreeType.
+var FONT_FORMATS = []string{"truetype", "opentype", "woff", "collection"}
+
+// Font technologies the engine can render, SDL_ttf draws plain outlines without variations, palettes or color glyphs.
+var FONT_TECHNOLOGIES = []string{}
+
+// Reports whether a source's format and technologies, when it gives them, can be loaded.
+func (s *FontFaceSource) IsSupported() bool {
+	if s.Format != "" && !isStringIn(s.Format, FONT_FORMATS) {
+		return false
+	}
+	for _, tech := range s.Tech {
+		if !isStringIn(tech, FONT_TECHNOLOGIES) {
+			return false
+		}
+	}
+	return true
+}
+
+func isStringIn(value string, values []string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// A face without a family or without sources is invalid.
+func ParseFontFace(atRule AtRule) (FontFace, error) {
+	face := FontFace{Weight: [2]int{400, 400}, Style: "normal", Display: "auto", rule: atRule}
+	if atRule.Block.BlockType != Token_Clearly_Close {
+		return FontFace{}, fmt.Errorf("@font-face needs a block")
+	}
+
+	parser := CssParser{}
+	parser.input = atRule.Block.Tokens
+	parser.len = len(atRule.Block.Tokens)
+
+	// invalid descriptors are ignored and keep their initial value
+	for _, descriptor := range parser.ConsumeDescriptorsList() {
+		tokens := components(descriptor.tokens)
+
+		switch strings.ToLower(descriptor.Name) {
+		case "font-family":
+			if family, err := parseFamilyName(tokens); err == nil {
+				face.Family = family
+			}
+		case "src":
+			face.Sources = parseFontSources(descriptor.tokens)
+		case "font-weight":
+			if weight, err := parseFontWeightRange(tokens); err == nil {
+				face.Weight = weight
+			}
+		case "font-style":
+			if style, err := parseFontStyleDescriptor(tokens); err == nil {
+				face.Style = style
+			}
+		case "unicode-range":
+			if ranges, err := parseUnicodeRanges(descriptor.tokens); err == nil {
+				face.UnicodeRange = ranges
+			}
+		case "font-display":
+			if len(tokens) == 1 && isStringCaseInsensitiveIn(tokens[0], []string{"auto", "block", "swap", "fallback", "optional"}) {
+				face.Display = strings.ToLower(tokens[0].(*StringToken).Value)
+			}
+		}
+	}
+
+	if face.Family == "" {
+		return FontFace{}, fmt.Errorf("@font-face without a font-family")
+	}
+	if len(face.Sources) == 0 {
+		return FontFace{}, fmt.Errorf("@font-face without a valid src")
+	}
+	return face, nil
+}
+
+// A family name is a string or a sequence of identifiers.
+// https://www.w3.org/TR/css-fonts-4/#family-name-syntax
+func parseFamilyName(tokens []Token) (string, error) {
+	if len(tokens) == 1 && tokens[0].GetId() == Token_String {
+		return tokens[0].(*StringToken).Value, nil
+	}
+
+	names := []string{}
+	for _, token := range tokens {
+		if token.GetId() != Token_Ident {
+			return "", fmt.Errorf("invalid family name")
+		}
+		names = append(names, token.(*StringToken).Value)
+	}
+	if len(names) == 0 {
+		return "", fmt.Errorf("invalid family name")
+	}
+	return strings.Join(names, " "), nil
+}
+
+// Sources that fail to parse are dropped, the others are kept.
+// https://www.w3.org/TR/css-fonts-4/#font-face-src-parsing
+func parseFontSources(tokens []Token) []FontFaceSource {
+	sources := []FontFaceSource{}
+	for _, part := range splitByCommas(tokens) {
+		if source, ok := parseFontSource(components(part)); ok {
+			sources = append(sources, source)
+		}
+	}
+	return sources
+}
+
+func parseFontSource(tokens []Token) (FontFaceSource, bool) {
+	source := FontFaceSource{}
+	if len(tokens) == 0 {
+		return source, false
+	}
+
+	switch t := tokens[0].(type) {
+	case *StringToken:
+		if t.Id != Token_Url {
+			return source, false
+		}
+		source.Url = t.Value
+	case *FunctionBlock:
+		switch strings.ToLower(t.Name) {
+		case "url":
+			if len(t.Args) != 1 || t.Args[0].GetId() != Token_String {
+				return source, false
+			}
+			source.Url = t.Args[0].(*StringToken).Value
+		case "local":
+			name, err := parseFamilyName(t.Args)
+			if err != nil || len(tokens) > 1 {
+				return source, false
+			}
+			source.Local = name
+			return source, true
+		default:
+			return source, false
+		}
+	default:
+		return source, false
+	}
+
+	for _, token := range tokens[1:] {
+		function, ok := token.(*FunctionBlock)
+		if !ok || len(function.Args) == 0 {
+			return source, false
+		}
+
+		switch strings.ToLower(function.Name) {
+		case "format":
+			if source.Format != "" || source.Tech != nil || len(function.Args) != 1 {
+				return source, false
+			}
+			format, ok := function.Args[0].(*StringToken)
+			if !ok || (format.Id != Token_String && format.Id != Token_Ident) {
+				return source, false
+			}
+			source.Format = strings.ToLower(format.Value)
+		case "tech":
+			if source.Tech != nil {
+				return source, false
+			}
+			for i, arg := range function.Args {
+				if i%2 == 1 {
+					if arg.GetId() != Token_Comma {
+						return source, false
+					}
+					continue
+				}
+				if arg.GetId() != Token_Ident {
+					return source, false
+				}
+				source.Tech = append(source.Tech, strings.ToLower(arg.(*StringToken).Value))
+			}
+		default:
+			return source, false
+		}
+	}
+	return source, true
+}
+
+// https://www.w3.org/TR/css-fonts-4/#font-prop-desc
+func parseFontWeightRange(tokens []Token) ([2]int, error) {
+	if len(tokens) == 1 && isStringCaseInsensitive("auto", &tokens[0]) {
+		return [2]int{400, 400}, nil
+	}
+	if len(tokens) == 0 || len(tokens) > 2 {
+		return [2]int{}, fmt.Errorf("invalid font-weight")
+	}
+
+	weights := []int{}
+	for _, token := range tokens {
+		switch {
+		case isStringCaseInsensitive("normal", &token):
+			weights = append(weights, 400)
+		case isStringCaseInsensitive("bold", &token):
+			weights = append(weights, 700)
+		case token.GetId() == Token_Number:
+			weight := token.(*NumberToken).Value
+			if weight < 1 || weight > 1000 {
+				return [2]int{}, fmt.Errorf("font-weight out of range")
+			}
+			weights = append(weights, int(weight))
+		default:
+			return [2]int{}, fmt.Errorf("invalid font-weight")
+		}
+	}
+
+	// a reversed range is swapped
+	if len(weights) == 1 {
+		return [2]int{weights[0], weights[0]}, nil
+	}
+	return [2]int{min(weights[0], weights[1]), max(weights[0], weights[1])}, nil
+}
+
+// Oblique angles are accepted but not kept.
+func parseFontStyleDescriptor(tokens []Token) (string, error) {
+	if len(tokens) == 0 || tokens[0].GetId() != Token_Ident {
+		return "", fmt.Errorf("invalid font-style")
+	}
+
+	style := strings.ToLower(tokens[0].(*StringToken).Value)
+	switch {
+	case style == "auto" && len(tokens) == 1:
+		return "normal", nil
+	case (style == "normal" || style == "italic") && len(tokens) == 1:
+		return style, nil
+	case style == "oblique" && len(tokens) <= 3:
+		for _, angle := range tokens[1:] {
+			if angle.GetId() != Token_Dimension {
+				return "", fmt.Errorf("invalid oblique angle")
+			}
+		}
+		return style, nil
+	}
+	return "", fmt.Errorf("invalid font-style")
+}
+
+/*
+Parses a list of unicode ranges. The tokenizer does not know unicode ranges, they are read
+back from the text of the tokens.
+
+Source: https://www.w3.org/TR/css-syntax-3/#urange-syntax
+*/
+func parseUnicodeRanges(tokens []Token) ([]UnicodeRange, error) {
+	ranges := []UnicodeRange{}
+	for _, part := range splitByCommas(tokens) {
+		text := ""
+		for _, token := range trimWhitespace(part) {
+			switch t := token.(type) {
+			case *StringToken:
+				if t.Id != Token_Ident {
+					return nil, fmt.Errorf("invalid unicode range")
+				}
+				text += t.Value
+			case *NumberToken:
+				text += t.Repr + t.Unit
+			case *RuneToken:
+				if t.Id != Token_Delim {
+					return nil, fmt.Errorf("invalid unicode range")
+				}
+				text += string(t.Value)
+			default:
+				return nil, fmt.Errorf("invalid unicode range")
+			}
+		}
+
+		r, err := parseUnicodeRange(text)
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, nil
+}
+
+func parseUnicodeRange(text string) (UnicodeRange, error) {
+	text = strings.ToLower(text)
+	if !strings.HasPrefix(text, "u+") {
+		return UnicodeRange{}, fmt.Errorf("unicode range must start with 'u+'")
+	}
+	text = text[2:]
+
+	start, end, isRange := strings.Cut(text, "-")
+	if len(start) == 0 || len(start) > 6 {
+		return UnicodeRange{}, fmt.Errorf("invalid unicode range 'u+%s'", text)
+	}
+
+	// trailing question marks stand for any hex digit
+	if wildcard := strings.Index(start, "?"); wildcard >= 0 {
+		if isRange || strings.Trim(start[wildcard:], "?") != "" {
+			return UnicodeRange{}, fmt.Errorf("invalid unicode range 'u+%s'", text)
+		}
+		end = strings.ReplaceAll(start, "?", "f")
+		start = strings.ReplaceAll(start, "?", "0")
+	} else if !isRange {
+		end = start
+	}
+
+	first, err := strconv.ParseUint(start, 16, 32)
+	if err != nil {
+		return UnicodeRange{}, err
+	}
+	if len(end) == 0 || len(end) > 6 {
+		return UnicodeRange{}, fmt.Errorf("invalid unicode range 'u+%s'", text)
+	}
+	last, err := strconv.ParseUint(end, 16, 32)
+	if err != nil {
+		return UnicodeRange{}, err
+	}
+
+	if last > 0x10FFFF || first > last {
+		return UnicodeRange{}, fmt.Errorf("invalid unicode range 'u+%s'", text)
+	}
+	return UnicodeRange{Start: rune(first), End: rune(last)}, nil
+}
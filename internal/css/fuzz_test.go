@@ -0,0 +1,110 @@
+package plex_css_test
+
+import (
+	"testing"
+	plex_css "visualsource/plex/internal/css"
+)
+
+// Inputs the fuzz targets start from: valid stylesheets and the syntax errors the parser recovers from.
+var fuzzSeeds = []string{
+	"p { color: red; margin: 1px 2px !important }",
+	"a:hover > b.c#d[href^='x' i]::before { content: \"→\" / \"arrow\"; }",
+	"@import url(a.css) layer(base) supports(display: grid) screen;",
+	"@media screen and (min-width: 480px) { @supports (display: grid) { p { display: grid } } }",
+	"@layer a, b; @layer a { p { color: red } }",
+	"@container card (width > 30em) { p { width: 50cqw } }",
+	"@page :first { margin: 2cm; @top-center { content: counter(page) } }",
+	"@font-face { font-family: X; src: url(x.woff2) format(woff2) tech(variations) }",
+	"div { & > p { color: blue } .x & { color: red } }",
+	"p { width: calc(100% - 2 * (1em + 3px)); color: rgb(from red r g b / 50%) }",
+	"p { --x: { a: b }; color: var(--x, var(--y, red)) }",
+	"color:;",
+	"color",
+	"p { color: }",
+	"@media screen;",
+	"@media screen { p { color: red",
+	"p { color: red",
+	"a[href",
+	"p { color: rgb(1, 2",
+	"url(",
+	"url(  a b )",
+	"url(a\\",
+	"\"abc\ndef\"",
+	"'abc\\",
+	"\\",
+	"\\0 \\110000 \\D800",
+	"#\\",
+	"1e+ 1e-3 .5 +.5 -.5e 1.",
+	"<!-- p { color: red } -->",
+	"} ; { ] ) ( [ {",
+	"@",
+	"@;",
+	"@page { size: a4 landscape",
+	"p{color:red!important}",
+}
+
+func FuzzTokenizer(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, input string) {
+		tokenizer := plex_css.Tokenizer{}
+		tokens, err := tokenizer.Parse(input)
+		if err != nil {
+			t.Fatalf("expected tokenizing to recover from every error, got %s", err)
+		}
+		if len(tokens) == 0 || tokens[len(tokens)-1].GetId() != plex_css.Token_EOF {
+			t.Fatalf("expected the tokens to end with EOF, got %v", tokens)
+		}
+	})
+}
+
+func FuzzParseStylesheet(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, input string) {
+		parser := plex_css.CssParser{}
+		stylesheet, err := parser.ParseStylesheet(input, plex_css.Origin_Author)
+		if err != nil {
+			t.Fatalf("expected parsing to recover from every error, got %s", err)
+		}
+
+		// what was parsed serializes into a stylesheet that parses again
+		if _, err := parser.ParseStylesheet(stylesheet.CssText(), plex_css.Origin_Author); err != nil {
+			t.Fatalf("expected the serialized stylesheet to parse, got %s", err)
+		}
+	})
+}
+
+func FuzzParseDeclarationsList(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, input string) {
+		parser := plex_css.CssParser{}
+		declarations, err := parser.ParseDeclarationsList(input)
+		if err != nil {
+			t.Fatalf("expected parsing to recover from every error, got %s", err)
+		}
+		for i := range declarations {
+			declarations[i].CssText()
+		}
+	})
+}
+
+func FuzzParseRule(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, input string) {
+		parser := plex_css.CssParser{}
+		if rule, err := parser.ParseRule(input); err == nil {
+			rule.CssText()
+		}
+	})
+}
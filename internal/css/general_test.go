@@ -85,3 +85,310 @@ func TestParseCssValue_FUNC(t *testing.T) {
 
 	dump.P(value)
 }
+
+func TestSpecificity_COMPARE(t *testing.T) {
+	a := plex_css.Specificity{A: 0, B: 2, C: 0}
+	b := plex_css.Specificity{A: 0, B: 1, C: 5}
+
+	if !a.Greater(&b) || !b.Less(&a) {
+		t.Fatalf("expected (0,2,0) to be more specific than (0,1,5)")
+	}
+
+	if a.Less(&b) || b.Greater(&a) {
+		t.Fatalf("specificity must compare lexicographically")
+	}
+
+	c := plex_css.Specificity{A: 0, B: 2, C: 0}
+	if a.Compare(&c) != 0 || a.Less(&c) || a.Greater(&c) {
+		t.Fatalf("expected equal specificity")
+	}
+}
+
+func TestProperties_INITIAL_VALUES(t *testing.T) {
+	definition, ok := plex_css.GetPropertyDefinition("color")
+	if !ok || !definition.Inherited {
+		t.Fatalf("expected color to be an inherited property")
+	}
+
+	initial := plex_css.GetInitialValue("margin-left")
+	if len(initial) != 1 || initial[0].(*plex_css.CssDimention).AsPx() != 0 {
+		t.Fatalf("expected margin-left to be initially 0, got %v", initial)
+	}
+
+	if plex_css.GetInitialValue("unknown-property") != nil {
+		t.Fatalf("expected no initial value for unknown properties")
+	}
+}
+
+func TestDeclaration_CSS_WIDE_KEYWORD(t *testing.T) {
+	p := plex_css.CssParser{}
+	declarations, err := p.ParseDeclarationsList("color: INHERIT; margin-top: revert-layer; width: auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{plex_css.Keyword_Inherit, plex_css.Keyword_RevertLayer, ""}
+	for i, dec := range declarations {
+		if keyword := dec.GetCssWideKeyword(); keyword != expected[i] {
+			t.Fatalf("expected %q for %s, got %q", expected[i], dec.Name, keyword)
+		}
+	}
+}
+
+func parseMathValue(t *testing.T, source string) plex_css.CssValue {
+	parser := plex_css.CssParser{}
+	declarations, _ := parser.ParseDeclarationsList("width: " + source)
+	if len(declarations) != 1 {
+		t.Fatalf("expected '%s' to be a valid width, got %v", source, parser.Diagnostics)
+	}
+	return declarations[0].GetValue()
+}
+
+func TestMath_EVALUATE(t *testing.T) {
+	tests := map[string]float32{
+		"calc(2px + 3px * 4)":            14,
+		"calc((2px + 3px) * 4)":          20,
+		"calc(100% - 20px)":              80,
+		"calc(100px / 4 - 5% * 2)":       15,
+		"min(10px, 50%, 30px)":           10,
+		"max(10px, 50%)":                 50,
+		"clamp(10px, 80%, 60px)":         60,
+		"clamp(70px, 50%, 60px)":         70,
+		"round(17px, 5px)":               15,
+		"round(up, 11px, 5px)":           15,
+		"round(down, 14px, 5px)":         10,
+		"round(to-zero, -14px, 5px)":     -10,
+		"mod(-7px, 5px)":                 3,
+		"rem(-7px, 5px)":                 -2,
+		"calc(abs(-4px) * sign(-2))":     -4,
+		"calc(1px * max(2, 3) + 0px)":    3,
+		"calc(10px / 0 * 0 + 1px * 0)":   0,
+		"calc(50% + min(10px, 2% * 10))": 60,
+	}
+
+	for source, expected := range tests {
+		value := parseMathValue(t, source)
+		// lengths are in px and percentages resolve against 100px
+		result := plex_css.EvaluateMath(value, func(d *plex_css.CssDimention) float32 {
+			return d.Value
+		})
+		if result != expected {
+			t.Fatalf("expected '%s' to be %v got %v", source, expected, result)
+		}
+	}
+}
+
+func TestMath_TYPE_CHECK(t *testing.T) {
+	invalid := []string{
+		"width: calc(10px + 2)",
+		"width: calc(10px * 2px)",
+		"width: calc(2 / 10px)",
+		"width: calc(10px +)",
+		"width: calc(10px 2px)",
+		"width: clamp(1px, 2px)",
+		"width: round(10px)",
+		"width: calc(2 * 3)",
+		"font-weight: calc(100px)",
+	}
+	for _, source := range invalid {
+		parser := plex_css.CssParser{}
+		parser.ParseDeclarationsList(source)
+		if len(parser.Diagnostics) != 1 {
+			t.Fatalf("expected '%s' to be invalid", source)
+		}
+	}
+
+	parser := plex_css.CssParser{}
+	parser.ParseDeclarationsList("width: calc(100% - 2px); font-weight: calc(200 * 2); line-height: calc(1 + 0.5); margin: min(1px, 2%) calc(pi * 1px)")
+	if len(parser.Diagnostics) != 0 {
+		t.Fatalf("expected valid math functions, got %v", parser.Diagnostics)
+	}
+}
+
+func parseColorValue(t *testing.T, source string) plex_css.CssValue {
+	parser := plex_css.CssParser{}
+	declarations, _ := parser.ParseDeclarationsList("color: " + source)
+	if len(declarations) != 1 {
+		t.Fatalf("expected '%s' to be a valid color, got %v", source, parser.Diagnostics)
+	}
+	return declarations[0].GetValue()
+}
+
+func TestColor_PARSE(t *testing.T) {
+	tests := map[string]plex_css.CssColor{
+		"#0f0":                                           {R: 0, G: 255, B: 0, A: 255},
+		"#0f08":                                          {R: 0, G: 255, B: 0, A: 136},
+		"#00FF0080":                                      {R: 0, G: 255, B: 0, A: 128},
+		"rgb(0, 128, 0)":                                 {R: 0, G: 128, B: 0, A: 255},
+		"rgba(0%, 50%, 0%, 0.5)":                         {R: 0, G: 128, B: 0, A: 128},
+		"RGB(0 128 0 / 50%)":                             {R: 0, G: 128, B: 0, A: 128},
+		"rgb(300 -10 none)":                              {R: 255, G: 0, B: 0, A: 255},
+		"rgb(calc(100 + 28) 0 0)":                        {R: 128, G: 0, B: 0, A: 255},
+		"hsl(120, 100%, 25%)":                            {R: 0, G: 128, B: 0, A: 255},
+		"hsl(120deg 100% 50%)":                           {R: 0, G: 255, B: 0, A: 255},
+		"hsla(0.5turn 100 50 / 0.5)":                     {R: 0, G: 255, B: 255, A: 128},
+		"hwb(120 0% 50%)":                                {R: 0, G: 128, B: 0, A: 255},
+		"hwb(0 60% 60%)":                                 {R: 128, G: 128, B: 128, A: 255},
+		"lab(46.2775% -47.5621 48.5837)":                 {R: 0, G: 128, B: 0, A: 255},
+		"lch(46.2775% 67.9830 134.3913)":                 {R: 0, G: 128, B: 0, A: 255},
+		"oklab(51.975% -0.1403 0.10768)":                 {R: 0, G: 128, B: 0, A: 255},
+		"oklch(51.975% 0.17686 142.495)":                 {R: 0, G: 128, B: 0, A: 255},
+		"lab(100 0 0)":                                   {R: 255, G: 255, B: 255, A: 255},
+		"color(srgb 0 0.5 0)":                            {R: 0, G: 128, B: 0, A: 255},
+		"color(srgb-linear 0 21.586% 0)":                 {R: 0, G: 128, B: 0, A: 255},
+		"color(xyz-d65 0.07719 0.15438 0.02573)":         {R: 0, G: 128, B: 0, A: 255},
+		"color(xyz-d50 0.08314 0.15475 0.02093)":         {R: 0, G: 128, B: 0, A: 255},
+		"color-mix(in srgb, red, blue)":                  {R: 128, G: 0, B: 128, A: 255},
+		"color-mix(in srgb, red 25%, blue)":              {R: 64, G: 0, B: 191, A: 255},
+		"color-mix(in srgb, 75% red, blue)":              {R: 191, G: 0, B: 64, A: 255},
+		"color-mix(in srgb, red 30%, blue 30%)":          {R: 128, G: 0, B: 128, A: 153},
+		"color-mix(in srgb, transparent, blue)":          {R: 0, G: 0, B: 255, A: 128},
+		"color-mix(in hsl, red, blue)":                   {R: 255, G: 0, B: 255, A: 255},
+		"color-mix(in hsl longer hue, red, blue)":        {R: 0, G: 255, B: 0, A: 255},
+		"color-mix(in oklch, white, white)":              {R: 255, G: 255, B: 255, A: 255},
+		"rgb(from red r g b / 50%)":                      {R: 255, G: 0, B: 0, A: 128},
+		"rgb(from #123456 calc(r + 1) g b)":              {R: 19, G: 52, B: 86, A: 255},
+		"hsl(from rgb(0 255 0 / 0.5) calc(h + 120) s l)": {R: 0, G: 0, B: 255, A: 128},
+		"color(from green srgb r calc(g * 2) b)":         {R: 0, G: 255, B: 0, A: 255},
+	}
+
+	for source, expected := range tests {
+		value := parseColorValue(t, source)
+		color, ok := value.(*plex_css.CssColor)
+		if !ok || *color != expected {
+			t.Fatalf("expected '%s' to be %v got %v", source, expected, dump.Format(value))
+		}
+	}
+}
+
+func TestColor_GAMUT_MAPPING(t *testing.T) {
+	// display-p3 green is outside of sRGB, mapping keeps its hue instead of clipping it
+	color := parseColorValue(t, "color(display-p3 0 1 0)").(*plex_css.CssColor)
+	if color.G < 240 || color.R > 20 || color.B > 90 {
+		t.Fatalf("expected a saturated green, got %v", *color)
+	}
+
+	color = parseColorValue(t, "oklch(60% 0.4 30)").(*plex_css.CssColor)
+	if color.R < 230 || color.G > 80 || color.B > 80 {
+		t.Fatalf("expected a saturated red, got %v", *color)
+	}
+}
+
+func TestColor_CURRENT_COLOR(t *testing.T) {
+	value := parseColorValue(t, "color-mix(in srgb, currentcolor, red)")
+	if function, ok := value.(*plex_css.CssFunction); !ok || function.Name != "color-mix" {
+		t.Fatalf("expected colors depending on currentcolor to be resolved later, got %v", dump.Format(value))
+	}
+
+	for _, source := range []string{"CurrentColor", "Transparent", "CanvasText", "rgb(from LinkText r g b)"} {
+		parseColorValue(t, source)
+	}
+	parser := plex_css.CssParser{}
+	parser.ParseDeclarationsList("color: Canvass")
+	if len(parser.Diagnostics) != 1 {
+		t.Fatalf("expected unknown system colors to be invalid")
+	}
+}
+
+func TestColor_INVALID(t *testing.T) {
+	invalid := []string{
+		"#12345",
+		"#ggg",
+		"rgb(0, 0 0)",
+		"rgb(0%, 0, 0)",
+		"rgb(none, 0, 0)",
+		"rgb(0 0)",
+		"rgb(0 0 0 0)",
+		"rgb(0 0 0 / 1 2)",
+		"hsl(120, 50, 50%)",
+		"hsl(120 50% 50deg)",
+		"hwb(0, 0%, 0%)",
+		"lab(50 0)",
+		"color(unknown 0 0 0)",
+		"color-mix(in srgb, red)",
+		"color-mix(in srgb, red -10%, blue)",
+		"color-mix(in srgb, red 0%, blue 0%)",
+		"color-mix(in srgb longer hue, red, blue)",
+		"color-mix(in rgb, red, blue)",
+		"rgb(from red r g)",
+		"rgb(from nothing r g b)",
+	}
+	for _, source := range invalid {
+		parser := plex_css.CssParser{}
+		parser.ParseDeclarationsList("color: " + source)
+		if len(parser.Diagnostics) != 1 {
+			t.Fatalf("expected '%s' to be invalid", source)
+		}
+	}
+}
+
+func TestParseCssValue_STRUCTURE(t *testing.T) {
+	props := parseDeclarationMap(t, `
+		--family: "Ubuntu", Open Sans, sans-serif;
+		--content: "→" / "arrow";
+		--image: url(a.png) url("b c.png");
+		--area: 1 / 2 / span 3;
+		--ratio: 16 / 9;
+		--units: 90deg 1.5s 200ms 96dpi 2x 1fr 10khz;
+		--args: counter(page, upper-roman)
+	`)
+
+	family := props["--family"]
+	list, ok := family.GetValue().(*plex_css.CssList)
+	if len(family.Value) != 1 || !ok || list.Separator != ',' || len(list.Values) != 3 {
+		t.Fatalf("expected a comma separated list, got %v", family.Value)
+	}
+	if s, ok := list.Values[0].(*plex_css.CssString); !ok || s.Value != "Ubuntu" {
+		t.Fatalf("expected the first family to be a string, got %v", list.Values[0])
+	}
+	if words, ok := list.Values[1].(*plex_css.CssList); !ok || words.Separator != ' ' || len(words.Values) != 2 {
+		t.Fatalf("expected the second family to be a space separated list, got %v", list.Values[1])
+	}
+
+	content := props["--content"]
+	if list, ok := content.GetValue().(*plex_css.CssList); !ok || list.Separator != '/' || len(list.Values) != 2 {
+		t.Fatalf("expected a slash separated list, got %v", content.Value)
+	}
+
+	image := props["--image"]
+	if len(image.Value) != 2 {
+		t.Fatalf("expected two urls, got %v", image.Value)
+	}
+	for i, expected := range []string{"a.png", "b c.png"} {
+		if url, ok := image.Value[i].(*plex_css.CssUrl); !ok || url.Url != expected {
+			t.Errorf("expected url(%s), got %v", expected, image.Value[i])
+		}
+	}
+
+	area := props["--area"]
+	if list, ok := area.GetValue().(*plex_css.CssList); !ok || list.Separator != '/' || len(list.Values) != 3 {
+		t.Fatalf("expected three grid lines, got %v", area.Value)
+	}
+
+	ratio := props["--ratio"]
+	if r, ok := ratio.GetValue().(*plex_css.CssRatio); !ok || r.Numerator != 16 || r.Denominator != 9 {
+		t.Fatalf("expected a ratio, got %v", ratio.Value)
+	}
+
+	units := props["--units"]
+	expected := []plex_css.CssUnit{plex_css.CssUnit_DEG, plex_css.CssUnit_S, plex_css.CssUnit_MS, plex_css.CssUnit_DPI, plex_css.CssUnit_X, plex_css.CssUnit_FR, plex_css.CssUnit_KHZ}
+	if len(units.Value) != len(expected) {
+		t.Fatalf("expected %d dimensions, got %v", len(expected), units.Value)
+	}
+	for i, unit := range expected {
+		if d, ok := units.Value[i].(*plex_css.CssDimention); !ok || d.Unit != unit {
+			t.Errorf("expected unit %v, got %v", unit, units.Value[i])
+		}
+	}
+	if d := units.Value[1].(*plex_css.CssDimention); d.AsMs() != 1500 {
+		t.Errorf("expected 1.5s to be 1500ms, got %v", d.AsMs())
+	}
+	if d := units.Value[3].(*plex_css.CssDimention); d.AsDppx() != 1 {
+		t.Errorf("expected 96dpi to be 1dppx, got %v", d.AsDppx())
+	}
+
+	args := props["--args"]
+	if f, ok := args.GetValue().(*plex_css.CssFunction); !ok || len(f.Args) != 2 {
+		t.Fatalf("expected one value per argument, got %v", args.Value)
+	}
+}
@@ -0,0 +1,258 @@
+package plex_css
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+/*
+A property value grammar in combinator form. Given the component values of a declaration
+and a start position it returns every position a match can end at.
+
+Source: https://www.w3.org/TR/css-values-4/#value-defs
+*/
+type Grammar func(values []CssValue, pos int) []int
+
+// Reports whether the grammar matches all of the values.
+func (g Grammar) Matches(values []CssValue) bool {
+	return slices.Contains(g(values, 0), len(values))
+}
+
+// A single component value accepted by match.
+func Type(match func(CssValue) bool) Grammar {
+	return func(values []CssValue, pos int) []int {
+		if pos < len(values) && match(values[pos]) {
+			return []int{pos + 1}
+		}
+		return nil
+	}
+}
+
+// One of the keywords.
+func Keyword(keywords ...string) Grammar {
+	return Type(func(v CssValue) bool {
+		return isKeywordIn(v, keywords...)
+	})
+}
+
+// Juxtaposed components that must appear in order: a b c
+func Seq(grammars ...Grammar) Grammar {
+	return func(values []CssValue, pos int) []int {
+		positions := []int{pos}
+		for _, grammar := range grammars {
+			next := []int{}
+			for _, position := range positions {
+				next = append(next, grammar(values, position)...)
+			}
+			positions = unique(next)
+			if len(positions) == 0 {
+				return nil
+			}
+		}
+		return positions
+	}
+}
+
+// Exactly one of the alternatives: a | b | c
+func OneOf(grammars ...Grammar) Grammar {
+	return func(values []CssValue, pos int) []int {
+		ends := []int{}
+		for _, grammar := range grammars {
+			ends = append(ends, grammar(values, pos)...)
+		}
+		return unique(ends)
+	}
+}
+
+// One or more of the alternatives in any order, each at most once: a || b || c
+func AnyOf(grammars ...Grammar) Grammar {
+	return func(values []CssValue, pos int) []int {
+		ends := []int{}
+
+		var walk func(pos int, used uint)
+		walk = func(pos int, used uint) {
+			for i, grammar := range grammars {
+				if used&(1<<i) != 0 {
+					continue
+				}
+				for _, end := range grammar(values, pos) {
+					if end > pos {
+						ends = append(ends, end)
+						walk(end, used|1<<i)
+					}
+				}
+			}
+		}
+		walk(pos, 0)
+
+		return unique(ends)
+	}
+}
+
+// a?
+func Optional(grammar Grammar) Grammar {
+	return Repeat(grammar, 0, 1)
+}
+
+// a{min,max}, a max of -1 is unbounded.
+func Repeat(grammar Grammar, min int, max int) Grammar {
+	return func(values []CssValue, pos int) []int {
+		ends := []int{}
+		if min == 0 {
+			ends = append(ends, pos)
+		}
+
+		positions := []int{pos}
+		for n := 1; max < 0 || n <= max; n++ {
+			next := []int{}
+			for _, position := range positions {
+				for _, end := range grammar(values, position) {
+					if end > position {
+						next = append(next, end)
+					}
+				}
+			}
+
+			positions = unique(next)
+			if len(positions) == 0 {
+				break
+			}
+			if n >= min {
+				ends = append(ends, positions...)
+			}
+		}
+
+		return unique(ends)
+	}
+}
+
+// A comma separated list of one or more a: a#
+func CommaList(grammar Grammar) Grammar {
+	return Seq(grammar, Repeat(Seq(COMMA, grammar), 0, -1))
+}
+
+func unique(positions []int) []int {
+	slices.Sort(positions)
+	return slices.Compact(positions)
+}
+
+// Separators kept between component values while matching a grammar.
+type delimValue struct {
+	Value rune
+}
+
+const tCssValue_DELIM CssValueType = 255
+
+func (d *delimValue) GetType() CssValueType {
+	return tCssValue_DELIM
+}
+
+func isDelimValue(value rune) func(CssValue) bool {
+	return func(v CssValue) bool {
+		d, ok := v.(*delimValue)
+		return ok && d.Value == value
+	}
+}
+
+var COMMA = Type(isDelimValue(','))
+var SLASH = Type(isDelimValue('/'))
+
+var LENGTH = Type(isLength)
+var PERCENTAGE = Type(isPercentage)
+var LENGTH_PERCENTAGE = Type(isLengthPercentage)
+var NON_NEGATIVE_LENGTH_PERCENTAGE = Type(func(v CssValue) bool {
+	return isLengthPercentage(v) && isNonNegative(v)
+})
+var NUMBER = Type(isNumber)
+var NON_NEGATIVE_NUMBER = Type(func(v CssValue) bool {
+	return isNumber(v) && isNonNegative(v)
+})
+var INTEGER = Type(isInteger)
+
+// <number [0,∞]> [ / <number [0,∞]> ]?
+// https://www.w3.org/TR/css-values-4/#ratios
+var RATIO = Seq(NON_NEGATIVE_NUMBER, Optional(Seq(SLASH, NON_NEGATIVE_NUMBER)))
+var COLOR = Type(isColorValue)
+var IMAGE = Type(isImageValue)
+var STRING = Type(func(v CssValue) bool {
+	return IsCssValue(v, TCssValue_STRING)
+})
+
+// https://www.w3.org/TR/css-values-4/#custom-idents
+var CUSTOM_IDENT = Type(func(v CssValue) bool {
+	keyword, ok := v.(*CssKeyword)
+	return ok && !isKeywordIn(&CssKeyword{Value: strings.ToLower(keyword.Value)},
+		Keyword_Inherit, Keyword_Initial, Keyword_Unset, Keyword_Revert, Keyword_RevertLayer, "default")
+})
+
+/*
+Splits the tokens of a declaration into the values a grammar matches, keeping commas and
+slashes. Returns false when a token has no value representation.
+*/
+func grammarValues(tokens []Token) ([]CssValue, bool) {
+	values := []CssValue{}
+	for _, token := range components(tokens) {
+		switch {
+		case token.GetId() == Token_Comma:
+			values = append(values, &delimValue{Value: ','})
+		case isDelim(token, '/'):
+			values = append(values, &delimValue{Value: '/'})
+		default:
+			value := componentValue(token)
+			if value == nil {
+				return nil, false
+			}
+			values = append(values, value)
+		}
+	}
+	return values, true
+}
+
+/*
+Checks a declaration against the grammar of its property. Unknown properties and values
+the grammar does not match are invalid and must be ignored.
+
+Source: https://www.w3.org/TR/css-syntax-3/#css-parse-something-according-to-a-css-grammar
+*/
+func ValidateDeclaration(dec *Declaration) error {
+	// custom properties accept any value
+	if IsCustomProperty(dec.Name) {
+		return nil
+	}
+
+	_, shorthand := SHORTHANDS[dec.Name]
+	definition, longhand := PROPERTIES[dec.Name]
+	if !shorthand && !longhand {
+		return fmt.Errorf("unknown property '%s'", dec.Name)
+	}
+
+	// values with var() are checked once the variables are substituted
+	if dec.ContainsVar() {
+		return nil
+	}
+
+	if shorthand {
+		_, err := expandShorthand(*dec, dec.tokens)
+		return err
+	}
+
+	if dec.GetCssWideKeyword() != "" {
+		return nil
+	}
+
+	values := dec.Value
+	if dec.tokens != nil {
+		parsed, ok := grammarValues(dec.tokens)
+		if !ok {
+			return fmt.Errorf("invalid value for property '%s'", dec.Name)
+		}
+		values = parsed
+	}
+
+	if definition.Grammar == nil || len(values) == 0 || !definition.Grammar.Matches(values) {
+		return fmt.Errorf("invalid value for property '%s'", dec.Name)
+	}
+
+	return nil
+}
@@ -0,0 +1,240 @@
+package plex_css
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+/*
+An @import of another stylesheet. The imported rules can be placed in a cascade layer and
+depend on a feature query and a media query list.
+
+Source: https://www.w3.org/TR/css-cascade-5/#at-import
+*/
+type ImportRule struct {
+	Href string
+	// whether the rules are placed in a layer, an empty Layer is an anonymous layer
+	Layered bool
+	Layer   string
+	// nil when the import has no supports()
+	Supports SupportsCondition
+	Media    MediaQueryList
+	prelude  []Token
+	// the number of layers the importing stylesheet declared before the @import
+	layersBefore int
+}
+
+func (r *ImportRule) CssText() string {
+	return "@import " + serializeTokens(r.prelude) + ";"
+}
+
+// Reports whether the import has to be loaded, which depends on its supports().
+func (r *ImportRule) IsSupported() bool {
+	return r.Supports == nil || r.Supports.Matches()
+}
+
+// https://www.w3.org/TR/css-cascade-5/#import-syntax
+func ParseImportRule(atRule AtRule) (ImportRule, error) {
+	rule := ImportRule{prelude: atRule.Prelude}
+	if atRule.Block.BlockType == Token_Clearly_Close {
+		return ImportRule{}, fmt.Errorf("@import can not have a block")
+	}
+
+	tokens := atRule.Prelude
+	next := func() Token {
+		for len(tokens) > 0 && tokens[0].GetId() == Token_Whitespace {
+			tokens = tokens[1:]
+		}
+		if len(tokens) == 0 {
+			return nil
+		}
+		return tokens[0]
+	}
+
+	switch t := next().(type) {
+	case *StringToken:
+		if t.Id != Token_String && t.Id != Token_Url {
+			return ImportRule{}, fmt.Errorf("expected the url of the imported stylesheet")
+		}
+		rule.Href = t.Value
+	case *FunctionBlock:
+		if !strings.EqualFold(t.Name, "url") || len(t.Args) != 1 || t.Args[0].GetId() != Token_String {
+			return ImportRule{}, fmt.Errorf("expected the url of the imported stylesheet")
+		}
+		rule.Href = t.Args[0].(*StringToken).Value
+	default:
+		return ImportRule{}, fmt.Errorf("expected the url of the imported stylesheet")
+	}
+	tokens = tokens[1:]
+
+	switch t := next().(type) {
+	case *StringToken:
+		if t.Id == Token_Ident && strings.EqualFold(t.Value, "layer") {
+			rule.Layered = true
+			tokens = tokens[1:]
+		}
+	case *FunctionBlock:
+		if strings.EqualFold(t.Name, "layer") {
+			name, err := parseLayerName(t.Args)
+			if err != nil {
+				return ImportRule{}, err
+			}
+			rule.Layered = true
+			rule.Layer = name
+			tokens = tokens[1:]
+		}
+	}
+
+	if function, ok := next().(*FunctionBlock); ok && strings.EqualFold(function.Name, "supports") {
+		condition, err := ParseSupportsCondition(function.Args)
+		if err != nil {
+			// supports() also takes a bare declaration
+			condition = supportsDeclaration{tokens: function.Args}
+		}
+		rule.Supports = condition
+		tokens = tokens[1:]
+	}
+
+	rule.Media = ParseMediaQueryList(tokens)
+	return rule, nil
+}
+
+// Parses a layer name made of identifiers separated by dots.
+// https://www.w3.org/TR/css-cascade-5/#typedef-layer-name
+func parseLayerName(tokens []Token) (string, error) {
+	parts := []string{}
+	for i, token := range components(tokens) {
+		if i%2 == 1 {
+			if !isDelim(token, '.') {
+				return "", fmt.Errorf("expected '.' in the layer name")
+			}
+			continue
+		}
+		ident, ok := token.(*StringToken)
+		if !ok || ident.Id != Token_Ident {
+			return "", fmt.Errorf("expected an identifier in the layer name")
+		}
+		parts = append(parts, ident.Value)
+	}
+
+	if len(parts) == 0 || len(components(tokens))%2 == 0 {
+		return "", fmt.Errorf("invalid layer name")
+	}
+	return strings.Join(parts, "."), nil
+}
+
+// Parses the comma separated layer names of an @layer statement.
+func parseLayerNameList(tokens []Token) ([]string, error) {
+	names := []string{}
+	for _, part := range splitByCommas(tokens) {
+		name, err := parseLayerName(part)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// Names a layer nested in another one, an empty name is the parent layer itself.
+func joinLayerNames(parent string, name string) string {
+	switch {
+	case parent == "":
+		return name
+	case name == "":
+		return parent
+	}
+	return parent + "." + name
+}
+
+var anonymousLayers atomic.Int64
+
+const anonymousLayerPrefix = "\x00anonymous-"
+
+// Anonymous layers get a name no stylesheet can refer to.
+func anonymousLayerName() string {
+	return fmt.Sprintf("%s%d", anonymousLayerPrefix, anonymousLayers.Add(1))
+}
+
+/*
+Places the rules and @page rules of an imported stylesheet in the layer and under the media
+query list of the import.
+*/
+func (r *ImportRule) Scope(imported Stylesheet) Stylesheet {
+	if r.Layered {
+		layer := r.Layer
+		if layer == "" {
+			layer = anonymousLayerName()
+		}
+
+		layers := []string{layer}
+		for _, name := range imported.Layers {
+			layers = append(layers, joinLayerNames(layer, name))
+		}
+		imported.Layers = layers
+
+		for i := range imported.Rules {
+			imported.Rules[i].Layer = joinLayerNames(layer, imported.Rules[i].Layer)
+		}
+		for i := range imported.Pages {
+			imported.Pages[i].Layer = joinLayerNames(layer, imported.Pages[i].Layer)
+		}
+	}
+
+	if len(r.Media.Queries) > 0 {
+		for i := range imported.Rules {
+			imported.Rules[i].Media = append([]MediaQueryList{r.Media}, imported.Rules[i].Media...)
+		}
+		for i := range imported.Pages {
+			imported.Pages[i].Media = append([]MediaQueryList{r.Media}, imported.Pages[i].Media...)
+		}
+	}
+	imported.layersBefore = r.layersBefore
+	return imported
+}
+
+/*
+Puts the rules of the imported stylesheets before the rules of the stylesheet, in the order
+they were imported. The layers keep the order they were declared in, the layers of an imported
+stylesheet come after the ones declared by @layer statements before its @import.
+
+Source: https://www.w3.org/TR/css-cascade-5/#import-processing
+*/
+func (s *Stylesheet) PrependImports(imported []Stylesheet) {
+	rules := []Rule{}
+	atRules := []AtRule{}
+	layers := []string{}
+	fontFaces := []FontFace{}
+	pages := []PageRule{}
+	declare := func(names ...string) {
+		for _, name := range names {
+			if !slices.Contains(layers, name) {
+				layers = append(layers, name)
+			}
+		}
+	}
+	declared := 0
+	for _, stylesheet := range imported {
+		before := min(stylesheet.layersBefore, len(s.Layers))
+		if before > declared {
+			declare(s.Layers[declared:before]...)
+			declared = before
+		}
+		declare(stylesheet.Layers...)
+
+		rules = append(rules, stylesheet.Rules...)
+		atRules = append(atRules, stylesheet.AtRules...)
+		fontFaces = append(fontFaces, stylesheet.FontFaces...)
+		pages = append(pages, stylesheet.Pages...)
+		s.Diagnostics = append(s.Diagnostics, stylesheet.Diagnostics...)
+	}
+	declare(s.Layers[declared:]...)
+
+	s.Rules = append(rules, s.Rules...)
+	s.AtRules = append(atRules, s.AtRules...)
+	s.Layers = layers
+	s.FontFaces = append(fontFaces, s.FontFaces...)
+	s.Pages = append(pages, s.Pages...)
+}
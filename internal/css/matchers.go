@@ -0,0 +1,202 @@
+package plex_css
+
+import (
+	"slices"
+	"strings"
+)
+
+// Value types shared by the property grammars.
+// https://www.w3.org/TR/css-values-4/#value-defs
+
+var LINE_STYLE_KEYWORDS = []string{"none", "hidden", "dotted", "dashed", "solid", "double", "groove", "ridge", "inset", "outset"}
+
+var FONT_STRETCH_KEYWORDS = []string{
+	"ultra-condensed",
+	"extra-condensed",
+	"condensed",
+	"semi-condensed",
+	"semi-expanded",
+	"expanded",
+	"extra-expanded",
+	"ultra-expanded",
+}
+
+var FONT_SIZE_KEYWORDS = []string{"xx-small", "x-small", "small", "medium", "large", "x-large", "xx-large", "xxx-large", "larger", "smaller"}
+
+// functions producing a <color>
+// https://www.w3.org/TR/css-color-5/#typedef-color
+var COLOR_FUNCTIONS = []string{"rgb", "rgba", "hsl", "hsla", "hwb", "lab", "lch", "oklab", "oklch", "color", "color-mix"}
+
+// functions producing an <image>
+// https://www.w3.org/TR/css-images-4/#typedef-image
+var IMAGE_FUNCTIONS = []string{"url", "linear-gradient", "radial-gradient", "conic-gradient", "repeating-linear-gradient", "repeating-radial-gradient", "repeating-conic-gradient", "image", "image-set", "cross-fade"}
+
+// https://www.w3.org/TR/css-values-4/#math
+var MATH_FUNCTIONS = []string{"calc", "min", "max", "clamp", "round", "mod", "rem", "abs", "sign"}
+
+func isKeywordIn(v CssValue, keywords ...string) bool {
+	keyword, ok := v.(*CssKeyword)
+	if !ok {
+		return false
+	}
+	for _, k := range keywords {
+		if strings.EqualFold(keyword.Value, k) {
+			return true
+		}
+	}
+	return false
+}
+
+func isFunctionIn(v CssValue, names ...string) bool {
+	function, ok := v.(*CssFunction)
+	if !ok {
+		return false
+	}
+	for _, name := range names {
+		if function.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func containsKeyword(values []CssValue, keyword string) bool {
+	for _, value := range values {
+		if IsCssKeyword(value, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+func IsMathFunction(v CssValue) bool {
+	return isFunctionIn(v, MATH_FUNCTIONS...)
+}
+
+// Reports whether v is a math function resolving to one of the types.
+func isMathType(v CssValue, types ...MathType) bool {
+	if !IsMathFunction(v) {
+		return false
+	}
+	result, ok := MathTypeOf(v)
+	return ok && slices.Contains(types, result)
+}
+
+func isNumberValue(v CssValue) bool {
+	dimention, ok := v.(*CssDimention)
+	return ok && dimention.Unit == CssUnit_NO_UNIT
+}
+
+func isNumber(v CssValue) bool {
+	return isNumberValue(v) || isMathType(v, MathType_Number)
+}
+
+// Math functions resolving to a number are rounded to an integer.
+func isInteger(v CssValue) bool {
+	if !isNumberValue(v) {
+		return isMathType(v, MathType_Number)
+	}
+	value := v.(*CssDimention).Value
+	return value == float32(int(value))
+}
+
+// A unitless zero is a valid <length>.
+func isLength(v CssValue) bool {
+	if IsMathFunction(v) {
+		return isMathType(v, MathType_Length)
+	}
+
+	dimention, ok := v.(*CssDimention)
+	if !ok {
+		return false
+	}
+	return IsLengthUnit(dimention.Unit) || (dimention.Unit == CssUnit_NO_UNIT && dimention.Value == 0)
+}
+
+func isPercentage(v CssValue) bool {
+	dimention, ok := v.(*CssDimention)
+	return (ok && dimention.Unit == CssUnit_PRESENT) || isMathType(v, MathType_Percentage)
+}
+
+func isLengthPercentage(v CssValue) bool {
+	return isLength(v) || isPercentage(v) || isMathType(v, MathType_LengthPercentage)
+}
+
+// Math functions are clamped to the allowed range when they are computed.
+func isNonNegative(v CssValue) bool {
+	if IsMathFunction(v) {
+		return true
+	}
+	dimention, ok := v.(*CssDimention)
+	return ok && dimention.Value >= 0
+}
+
+// https://www.w3.org/TR/css-box-4/#margin-physical
+func isMarginValue(v CssValue) bool {
+	return isLengthPercentage(v) || IsCssKeyword(v, "auto")
+}
+
+// https://www.w3.org/TR/css-box-4/#padding-physical
+func isPaddingValue(v CssValue) bool {
+	return isLengthPercentage(v) && isNonNegative(v)
+}
+
+// https://www.w3.org/TR/css-backgrounds-3/#typedef-line-width
+func isLineWidth(v CssValue) bool {
+	return (isLength(v) && isNonNegative(v)) || isKeywordIn(v, "thin", "medium", "thick")
+}
+
+// https://www.w3.org/TR/css-backgrounds-3/#typedef-line-style
+func isLineStyle(v CssValue) bool {
+	return isKeywordIn(v, LINE_STYLE_KEYWORDS...)
+}
+
+func isColorValue(v CssValue) bool {
+	if keyword, ok := v.(*CssKeyword); ok {
+		name := strings.ToLower(keyword.Value)
+		_, named := CSS_COLOR_KEYWORDS[name]
+		return named || name == "currentcolor" || slices.Contains(SYSTEM_COLOR_KEYWORDS, name)
+	}
+	return IsCssValue(v, TCssValue_COLOR) || isFunctionIn(v, COLOR_FUNCTIONS...)
+}
+
+func isImageValue(v CssValue) bool {
+	return IsCssValue(v, TCssValue_URL) || isFunctionIn(v, IMAGE_FUNCTIONS...)
+}
+
+// https://www.w3.org/TR/css-backgrounds-3/#typedef-bg-position
+func isPositionValue(v CssValue) bool {
+	return isLengthPercentage(v) || isKeywordIn(v, "left", "right", "top", "bottom", "center")
+}
+
+// https://www.w3.org/TR/css-backgrounds-3/#typedef-bg-size
+func isBackgroundSize(v CssValue) bool {
+	return (isLengthPercentage(v) && isNonNegative(v)) || isKeywordIn(v, "auto", "cover", "contain")
+}
+
+// https://www.w3.org/TR/css-fonts-4/#font-weight-prop
+func isFontWeight(v CssValue) bool {
+	if isMathType(v, MathType_Number) {
+		return true
+	}
+	if isNumberValue(v) {
+		weight := v.(*CssDimention).Value
+		return weight >= 1 && weight <= 1000
+	}
+	return isKeywordIn(v, "normal", "bold", "bolder", "lighter")
+}
+
+// https://www.w3.org/TR/css-fonts-4/#font-size-prop
+func isFontSize(v CssValue) bool {
+	return (isLengthPercentage(v) && isNonNegative(v)) || isKeywordIn(v, FONT_SIZE_KEYWORDS...)
+}
+
+// https://www.w3.org/TR/css-inline-3/#line-height-property
+func isLineHeight(v CssValue) bool {
+	return ((isNumber(v) || isLengthPercentage(v)) && isNonNegative(v)) || IsCssKeyword(v, "normal")
+}
+
+// https://www.w3.org/TR/css-lists-3/#list-style-type-property
+func isListStyleType(v CssValue) bool {
+	return IsCssValue(v, TCssValue_KEYWORD) || IsCssValue(v, TCssValue_STRING) || isFunctionIn(v, "symbols")
+}
@@ -0,0 +1,413 @@
+package plex_css
+
+import (
+	"math"
+	"slices"
+	"strings"
+)
+
+/*
+The type of a math function, used to check that its operands can be combined.
+
+Source: https://www.w3.org/TR/css-values-4/#calc-type-checking
+*/
+type MathType uint8
+
+const (
+	MathType_Number MathType = iota
+	MathType_Length
+	MathType_Percentage
+	// a sum of lengths and percentages, the percentages resolve against a length
+	MathType_LengthPercentage
+)
+
+// constants allowed inside math functions
+// https://www.w3.org/TR/css-values-4/#calc-constants
+var MATH_CONSTANTS = map[string]float64{
+	"e":         math.E,
+	"pi":        math.Pi,
+	"infinity":  math.Inf(1),
+	"-infinity": math.Inf(-1),
+	"nan":       math.NaN(),
+}
+
+// https://www.w3.org/TR/css-values-4/#funcdef-round
+var ROUNDING_STRATEGIES = []string{"nearest", "up", "down", "to-zero"}
+
+/*
+Parses the arguments of a math function into a tree of CssExpression operators and
+CssFunction calls. Returns nil when the arguments are not a valid calculation.
+
+Source: https://www.w3.org/TR/css-values-4/#calc-syntax
+*/
+func parseMathFunction(function *FunctionBlock) CssValue {
+	name := strings.ToLower(function.Name)
+	args := splitByCommas(components(function.Args))
+
+	values := []CssValue{}
+	for i, arg := range args {
+		// round() takes an optional rounding strategy first
+		if name == "round" && i == 0 && len(arg) == 1 && isStringCaseInsensitiveIn(arg[0], ROUNDING_STRATEGIES) {
+			values = append(values, &CssKeyword{Value: strings.ToLower(arg[0].(*StringToken).Value)})
+			continue
+		}
+
+		value := parseCalcSum(arg)
+		if value == nil {
+			return nil
+		}
+		values = append(values, value)
+	}
+
+	result := &CssFunction{Name: name, Args: values}
+	if _, ok := MathTypeOf(result); !ok {
+		return nil
+	}
+	return result
+}
+
+func splitByCommas(tokens []Token) [][]Token {
+	groups := [][]Token{{}}
+	for _, token := range tokens {
+		if token.GetId() == Token_Comma {
+			groups = append(groups, []Token{})
+			continue
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], token)
+	}
+	return groups
+}
+
+func isStringCaseInsensitiveIn(token Token, values []string) bool {
+	for _, value := range values {
+		if isStringCaseInsensitive(value, &token) {
+			return true
+		}
+	}
+	return false
+}
+
+// <calc-sum> = <calc-product> [ [ '+' | '-' ] <calc-product> ]*
+func parseCalcSum(tokens []Token) CssValue {
+	start := 0
+	var left CssValue
+	var op rune
+
+	for i := 0; i <= len(tokens); i++ {
+		if i < len(tokens) && !isDelim(tokens[i], '+') && !isDelim(tokens[i], '-') {
+			continue
+		}
+
+		operand := parseCalcProduct(tokens[start:i])
+		if operand == nil {
+			return nil
+		}
+		if left == nil {
+			left = operand
+		} else {
+			left = &CssExpression{Left: left, Op: uint8(op), Right: operand}
+		}
+
+		if i < len(tokens) {
+			op = tokens[i].(*RuneToken).Value
+		}
+		start = i + 1
+	}
+
+	return left
+}
+
+// <calc-product> = <calc-value> [ [ '*' | '/' ] <calc-value> ]*
+func parseCalcProduct(tokens []Token) CssValue {
+	if len(tokens) == 0 || len(tokens)%2 == 0 {
+		return nil
+	}
+
+	left := parseCalcValue(tokens[0])
+	for i := 1; i < len(tokens) && left != nil; i += 2 {
+		if !isDelim(tokens[i], '*') && !isDelim(tokens[i], '/') {
+			return nil
+		}
+
+		right := parseCalcValue(tokens[i+1])
+		if right == nil {
+			return nil
+		}
+		left = &CssExpression{Left: left, Op: uint8(tokens[i].(*RuneToken).Value), Right: right}
+	}
+
+	return left
+}
+
+// <calc-value> = <number> | <dimension> | <percentage> | <calc-keyword> | ( <calc-sum> )
+func parseCalcValue(token Token) CssValue {
+	switch t := token.(type) {
+	case *NumberToken:
+		return componentValue(t)
+	case *StringToken:
+		if t.Id != Token_Ident {
+			return nil
+		}
+		if _, ok := MATH_CONSTANTS[strings.ToLower(t.Value)]; ok {
+			return &CssKeyword{Value: strings.ToLower(t.Value)}
+		}
+	case *SimpleBlock:
+		if t.BlockType == Token_Pren_Close {
+			return parseCalcSum(components(t.Tokens))
+		}
+	case *FunctionBlock:
+		if slices.Contains(MATH_FUNCTIONS, strings.ToLower(t.Name)) {
+			return parseMathFunction(t)
+		}
+	}
+	return nil
+}
+
+/*
+Returns the type a calculation resolves to, false when its operands can not be combined.
+
+Source: https://www.w3.org/TR/css-values-4/#calc-type-checking
+*/
+func MathTypeOf(v CssValue) (MathType, bool) {
+	switch value := v.(type) {
+	case *CssDimention:
+		switch value.Unit {
+		case CssUnit_NO_UNIT:
+			return MathType_Number, true
+		case CssUnit_PRESENT:
+			return MathType_Percentage, true
+		default:
+			return MathType_Length, IsLengthUnit(value.Unit)
+		}
+	case *CssKeyword:
+		_, ok := MATH_CONSTANTS[value.Value]
+		return MathType_Number, ok
+	case *CssExpression:
+		left, ok := MathTypeOf(value.Left)
+		if !ok {
+			return 0, false
+		}
+		right, ok := MathTypeOf(value.Right)
+		if !ok {
+			return 0, false
+		}
+
+		switch value.Op {
+		case '+', '-':
+			return addMathTypes(left, right)
+		case '*':
+			if left == MathType_Number {
+				return right, true
+			}
+			return left, right == MathType_Number
+		case '/':
+			return left, right == MathType_Number
+		}
+		return 0, false
+	case *CssFunction:
+		return mathFunctionType(value)
+	default:
+		return 0, false
+	}
+}
+
+// Operands of a sum must have the same type, lengths and percentages combine.
+func addMathTypes(a MathType, b MathType) (MathType, bool) {
+	switch {
+	case a == b:
+		return a, true
+	case a == MathType_Number || b == MathType_Number:
+		return 0, false
+	default:
+		return MathType_LengthPercentage, true
+	}
+}
+
+func mathFunctionType(function *CssFunction) (MathType, bool) {
+	args := function.Args
+	if function.Name == "round" && len(args) > 0 && isKeywordIn(args[0], ROUNDING_STRATEGIES...) {
+		args = args[1:]
+	}
+
+	switch function.Name {
+	case "calc", "abs", "sign":
+		if len(args) != 1 {
+			return 0, false
+		}
+	case "clamp":
+		if len(args) != 3 {
+			return 0, false
+		}
+	case "round":
+		if len(args) == 1 {
+			// the step defaults to 1, which is only valid for numbers
+			result, ok := MathTypeOf(args[0])
+			return result, ok && result == MathType_Number
+		}
+		if len(args) != 2 {
+			return 0, false
+		}
+	case "mod", "rem":
+		if len(args) != 2 {
+			return 0, false
+		}
+	case "min", "max":
+		if len(args) == 0 {
+			return 0, false
+		}
+	default:
+		return 0, false
+	}
+
+	result, ok := MathTypeOf(args[0])
+	for _, arg := range args[1:] {
+		if !ok {
+			break
+		}
+		var argType MathType
+		argType, ok = MathTypeOf(arg)
+		if ok {
+			result, ok = addMathTypes(result, argType)
+		}
+	}
+
+	if function.Name == "sign" {
+		return MathType_Number, ok
+	}
+	return result, ok
+}
+
+// Reports whether a percentage appears anywhere in the calculation.
+func MathContainsPercentage(v CssValue) bool {
+	switch value := v.(type) {
+	case *CssDimention:
+		return value.Unit == CssUnit_PRESENT
+	case *CssExpression:
+		return MathContainsPercentage(value.Left) || MathContainsPercentage(value.Right)
+	case *CssFunction:
+		for _, arg := range value.Args {
+			if MathContainsPercentage(arg) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+/*
+Evaluates a calculation. resolve converts every number, dimension and percentage into the
+canonical unit. A NaN result becomes 0 and infinities clamp to the largest float.
+
+Source: https://www.w3.org/TR/css-values-4/#calc-ieee
+*/
+func EvaluateMath(v CssValue, resolve func(*CssDimention) float32) float32 {
+	result := evaluateMath(v, resolve)
+	switch {
+	case math.IsNaN(result):
+		return 0
+	case math.IsInf(result, 1):
+		return math.MaxFloat32
+	case math.IsInf(result, -1):
+		return -math.MaxFloat32
+	default:
+		return float32(result)
+	}
+}
+
+func evaluateMath(v CssValue, resolve func(*CssDimention) float32) float64 {
+	switch value := v.(type) {
+	case *CssDimention:
+		return float64(resolve(value))
+	case *CssKeyword:
+		return MATH_CONSTANTS[value.Value]
+	case *CssExpression:
+		left := evaluateMath(value.Left, resolve)
+		right := evaluateMath(value.Right, resolve)
+		switch value.Op {
+		case '+':
+			return left + right
+		case '-':
+			return left - right
+		case '*':
+			return left * right
+		case '/':
+			return left / right
+		}
+	case *CssFunction:
+		return evaluateMathFunction(value, resolve)
+	}
+	return math.NaN()
+}
+
+// https://www.w3.org/TR/css-values-4/#math
+func evaluateMathFunction(function *CssFunction, resolve func(*CssDimention) float32) float64 {
+	strategy := "nearest"
+	args := []float64{}
+	for _, arg := range function.Args {
+		if keyword, ok := arg.(*CssKeyword); ok && function.Name == "round" && isKeywordIn(keyword, ROUNDING_STRATEGIES...) {
+			strategy = keyword.Value
+			continue
+		}
+		args = append(args, evaluateMath(arg, resolve))
+	}
+
+	switch function.Name {
+	case "calc":
+		return args[0]
+	case "min":
+		result := args[0]
+		for _, arg := range args[1:] {
+			result = math.Min(result, arg)
+		}
+		return result
+	case "max":
+		result := args[0]
+		for _, arg := range args[1:] {
+			result = math.Max(result, arg)
+		}
+		return result
+	case "clamp":
+		// the minimum wins over the maximum
+		return math.Max(args[0], math.Min(args[1], args[2]))
+	case "round":
+		step := 1.0
+		if len(args) > 1 {
+			step = args[1]
+		}
+		return roundToStep(args[0], step, strategy)
+	case "mod":
+		// the result takes the sign of the divisor
+		return args[0] - args[1]*math.Floor(args[0]/args[1])
+	case "rem":
+		// the result takes the sign of the dividend
+		return math.Mod(args[0], args[1])
+	case "abs":
+		return math.Abs(args[0])
+	case "sign":
+		if args[0] == 0 || math.IsNaN(args[0]) {
+			return args[0]
+		}
+		return math.Copysign(1, args[0])
+	}
+	return math.NaN()
+}
+
+// https://www.w3.org/TR/css-values-4/#round-func
+func roundToStep(value float64, step float64, strategy string) float64 {
+	if step == 0 {
+		return math.NaN()
+	}
+	step = math.Abs(step)
+
+	switch strategy {
+	case "up":
+		return math.Ceil(value/step) * step
+	case "down":
+		return math.Floor(value/step) * step
+	case "to-zero":
+		return math.Trunc(value/step) * step
+	default:
+		// ties round up
+		return math.Floor(value/step+0.5) * step
+	}
+}
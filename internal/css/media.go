@@ -0,0 +1,697 @@
+package plex_css
+
+import (
+	"slices"
+	"strings"
+)
+
+/*
+The output device media queries are evaluated against.
+
+Source: https://www.w3.org/TR/mediaqueries-5/#mq-features
+*/
+type MediaEnvironment struct {
+	Type string
+	// size of the viewport in px
+	Width  float32
+	Height float32
+	// device pixels per CSS pixel
+	Resolution float32
+	// the initial font size em and rem resolve against inside a media query
+	FontSize float32
+	// bits per color component, 0 on a monochrome device
+	Color                int
+	Hover                string
+	Pointer              string
+	PrefersColorScheme   string
+	PrefersReducedMotion string
+	// the query container size features are evaluated against, nil in media queries
+	container *QueryContainer
+}
+
+var DefaultMediaEnvironment = MediaEnvironment{
+	Type:                 "screen",
+	Width:                800,
+	Height:               600,
+	Resolution:           1,
+	FontSize:             16,
+	Color:                8,
+	Hover:                "hover",
+	Pointer:              "fine",
+	PrefersColorScheme:   "light",
+	PrefersReducedMotion: "no-preference",
+}
+
+// https://www.w3.org/TR/mediaqueries-4/#media-types
+var MEDIA_TYPES = []string{"all", "print", "screen", "tty", "tv", "projection", "handheld", "braille", "embossed", "aural", "speech"}
+
+type mediaFeatureKind uint8
+
+const (
+	mediaLength mediaFeatureKind = iota
+	mediaRatio
+	mediaResolution
+	mediaInteger
+	mediaDiscrete
+)
+
+type mediaFeatureDefinition struct {
+	kind mediaFeatureKind
+	// value of a range feature
+	number func(env *MediaEnvironment) float64
+	// value of a discrete feature
+	keyword  func(env *MediaEnvironment) string
+	keywords []string
+	// the keyword that is false in a boolean context
+	none string
+}
+
+func viewportWidth(env *MediaEnvironment) float64  { return float64(env.Width) }
+func viewportHeight(env *MediaEnvironment) float64 { return float64(env.Height) }
+func viewportRatio(env *MediaEnvironment) float64 {
+	return float64(env.Width) / float64(env.Height)
+}
+
+var MEDIA_FEATURES = map[string]mediaFeatureDefinition{
+	"width":               {kind: mediaLength, number: viewportWidth},
+	"height":              {kind: mediaLength, number: viewportHeight},
+	"device-width":        {kind: mediaLength, number: viewportWidth},
+	"device-height":       {kind: mediaLength, number: viewportHeight},
+	"aspect-ratio":        {kind: mediaRatio, number: viewportRatio},
+	"device-aspect-ratio": {kind: mediaRatio, number: viewportRatio},
+	"resolution": {kind: mediaResolution, number: func(env *MediaEnvironment) float64 {
+		return float64(env.Resolution)
+	}},
+	"color": {kind: mediaInteger, number: func(env *MediaEnvironment) float64 {
+		return float64(env.Color)
+	}},
+	"monochrome": {kind: mediaInteger, number: func(env *MediaEnvironment) float64 {
+		if env.Color == 0 {
+			return 1
+		}
+		return 0
+	}},
+	"color-index": {kind: mediaInteger, number: func(env *MediaEnvironment) float64 { return 0 }},
+	"grid":        {kind: mediaInteger, number: func(env *MediaEnvironment) float64 { return 0 }},
+	"orientation": {kind: mediaDiscrete, keywords: []string{"portrait", "landscape"}, keyword: func(env *MediaEnvironment) string {
+		if env.Height >= env.Width {
+			return "portrait"
+		}
+		return "landscape"
+	}},
+	"scan": {kind: mediaDiscrete, keywords: []string{"interlace", "progressive"}, keyword: func(env *MediaEnvironment) string {
+		return "progressive"
+	}},
+	"update": {kind: mediaDiscrete, keywords: []string{"none", "slow", "fast"}, none: "none", keyword: func(env *MediaEnvironment) string {
+		return "fast"
+	}},
+	"hover": {kind: mediaDiscrete, keywords: []string{"none", "hover"}, none: "none", keyword: func(env *MediaEnvironment) string {
+		return env.Hover
+	}},
+	"any-hover": {kind: mediaDiscrete, keywords: []string{"none", "hover"}, none: "none", keyword: func(env *MediaEnvironment) string {
+		return env.Hover
+	}},
+	"pointer": {kind: mediaDiscrete, keywords: []string{"none", "coarse", "fine"}, none: "none", keyword: func(env *MediaEnvironment) string {
+		return env.Pointer
+	}},
+	"any-pointer": {kind: mediaDiscrete, keywords: []string{"none", "coarse", "fine"}, none: "none", keyword: func(env *MediaEnvironment) string {
+		return env.Pointer
+	}},
+	"prefers-color-scheme": {kind: mediaDiscrete, keywords: []string{"light", "dark"}, keyword: func(env *MediaEnvironment) string {
+		return env.PrefersColorScheme
+	}},
+	"prefers-reduced-motion": {kind: mediaDiscrete, keywords: []string{"no-preference", "reduce"}, none: "no-preference", keyword: func(env *MediaEnvironment) string {
+		return env.PrefersReducedMotion
+	}},
+}
+
+// https://www.w3.org/TR/mediaqueries-4/#typedef-media-query-list
+type MediaQueryList struct {
+	Queries []MediaQuery
+}
+
+// https://www.w3.org/TR/mediaqueries-4/#typedef-media-query
+type MediaQuery struct {
+	Not bool
+	// media type, "all" for queries made of a condition only
+	Type      string
+	Condition MediaCondition
+	// the query as written
+	text string
+}
+
+// An empty list matches every device.
+func (l *MediaQueryList) Matches(env *MediaEnvironment) bool {
+	if len(l.Queries) == 0 {
+		return true
+	}
+	for i := range l.Queries {
+		if l.Queries[i].Matches(env) {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *MediaQueryList) CssText() string {
+	texts := []string{}
+	for _, query := range l.Queries {
+		texts = append(texts, query.text)
+	}
+	return strings.Join(texts, ", ")
+}
+
+// A query that evaluates to unknown does not match, not even when it is negated.
+func (q *MediaQuery) Matches(env *MediaEnvironment) bool {
+	result := mediaFalse
+	if q.Type == "all" || q.Type == strings.ToLower(env.Type) {
+		result = mediaTrue
+	}
+	if q.Condition != nil {
+		result = mediaAnd{q.Condition, mediaResultCondition(result)}.evaluate(env)
+	}
+	if q.Not {
+		result = result.negate()
+	}
+	return result == mediaTrue
+}
+
+// Reports whether every media query list the rule is nested in matches.
+func (r *Rule) MatchesMedia(env *MediaEnvironment) bool {
+	for i := range r.Media {
+		if !r.Media[i].Matches(env) {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+Media conditions evaluate with three-valued logic, unknown features and general enclosed
+syntax are unknown.
+
+Source: https://www.w3.org/TR/mediaqueries-4/#evaluating
+*/
+type mediaResult uint8
+
+const (
+	mediaFalse mediaResult = iota
+	mediaTrue
+	mediaUnknown
+)
+
+func (r mediaResult) negate() mediaResult {
+	switch r {
+	case mediaTrue:
+		return mediaFalse
+	case mediaFalse:
+		return mediaTrue
+	}
+	return mediaUnknown
+}
+
+func mediaBool(value bool) mediaResult {
+	if value {
+		return mediaTrue
+	}
+	return mediaFalse
+}
+
+type MediaCondition interface {
+	evaluate(env *MediaEnvironment) mediaResult
+}
+
+type mediaResultCondition mediaResult
+
+func (c mediaResultCondition) evaluate(env *MediaEnvironment) mediaResult {
+	return mediaResult(c)
+}
+
+type mediaNot struct {
+	condition MediaCondition
+}
+
+func (c mediaNot) evaluate(env *MediaEnvironment) mediaResult {
+	return c.condition.evaluate(env).negate()
+}
+
+type mediaAnd []MediaCondition
+
+func (c mediaAnd) evaluate(env *MediaEnvironment) mediaResult {
+	result := mediaTrue
+	for _, condition := range c {
+		switch condition.evaluate(env) {
+		case mediaFalse:
+			return mediaFalse
+		case mediaUnknown:
+			result = mediaUnknown
+		}
+	}
+	return result
+}
+
+type mediaOr []MediaCondition
+
+func (c mediaOr) evaluate(env *MediaEnvironment) mediaResult {
+	result := mediaFalse
+	for _, condition := range c {
+		switch condition.evaluate(env) {
+		case mediaTrue:
+			return mediaTrue
+		case mediaUnknown:
+			result = mediaUnknown
+		}
+	}
+	return result
+}
+
+type mediaComparison struct {
+	// one of <, <=, >, >= and =, with the feature on the left
+	op    string
+	value []Token
+}
+
+/*
+A media feature in boolean context when it has no value, in plain form with one value or in
+range form with one or two comparisons.
+
+Source: https://www.w3.org/TR/mediaqueries-4/#mq-features
+*/
+type mediaFeature struct {
+	name        string
+	value       []Token
+	comparisons []mediaComparison
+}
+
+func (f mediaFeature) evaluate(env *MediaEnvironment) mediaResult {
+	features := MEDIA_FEATURES
+	if env.container != nil {
+		features = CONTAINER_FEATURES
+	}
+	definition, ok := features[f.name]
+	if !ok {
+		return mediaUnknown
+	}
+
+	if definition.kind == mediaDiscrete {
+		switch {
+		case len(f.comparisons) > 0:
+			return mediaUnknown
+		case f.value == nil:
+			return mediaBool(definition.keyword(env) != definition.none)
+		}
+
+		if len(f.value) != 1 || !isStringCaseInsensitiveIn(f.value[0], definition.keywords) {
+			return mediaUnknown
+		}
+		return mediaBool(isStringCaseInsensitive(definition.keyword(env), &f.value[0]))
+	}
+
+	actual := definition.number(env)
+	comparisons := f.comparisons
+	switch {
+	case f.value != nil:
+		comparisons = []mediaComparison{{op: "=", value: f.value}}
+	case len(comparisons) == 0:
+		return mediaBool(actual != 0)
+	}
+
+	result := mediaTrue
+	for _, comparison := range comparisons {
+		expected, ok := mediaNumber(definition.kind, comparison.value, env)
+		if !ok {
+			return mediaUnknown
+		}
+		if !compareMediaValues(actual, comparison.op, expected) {
+			result = mediaFalse
+		}
+	}
+	return result
+}
+
+func compareMediaValues(actual float64, op string, expected float64) bool {
+	switch op {
+	case "<":
+		return actual < expected
+	case "<=":
+		return actual <= expected
+	case ">":
+		return actual > expected
+	case ">=":
+		return actual >= expected
+	}
+	return actual == expected
+}
+
+// Converts the value of a range feature to px, dppx, a ratio or an integer.
+func mediaNumber(kind mediaFeatureKind, value []Token, env *MediaEnvironment) (float64, bool) {
+	if kind == mediaRatio {
+		switch {
+		case len(value) == 1:
+			number, ok := value[0].(*NumberToken)
+			if !ok || number.Id != Token_Number || number.Value < 0 {
+				return 0, false
+			}
+			return float64(number.Value), true
+		case len(value) == 3 && isDelim(value[1], '/'):
+			numerator, ok := value[0].(*NumberToken)
+			denominator, ok2 := value[2].(*NumberToken)
+			if !ok || !ok2 || numerator.Id != Token_Number || denominator.Id != Token_Number || numerator.Value < 0 || denominator.Value < 0 {
+				return 0, false
+			}
+			// a degenerate ratio matches nothing
+			if numerator.Value == 0 && denominator.Value == 0 {
+				return 0, false
+			}
+			return float64(numerator.Value) / float64(denominator.Value), true
+		}
+		return 0, false
+	}
+
+	if len(value) != 1 {
+		return 0, false
+	}
+	number, ok := value[0].(*NumberToken)
+	if !ok {
+		return 0, false
+	}
+	amount := float64(number.Value)
+
+	switch kind {
+	case mediaLength:
+		if number.Id == Token_Number && number.Value == 0 {
+			return 0, true
+		}
+		if number.Id != Token_Dimension {
+			return 0, false
+		}
+		switch unit := strToUnit(number.Unit); unit {
+		case CssUnit_EM, CssUnit_REM, CssUnit_LH, CssUnit_RLH, CssUnit_CAP, CssUnit_IC:
+			return amount * float64(env.FontSize), true
+		case CssUnit_EX, CssUnit_CH:
+			return amount * float64(env.FontSize) / 2, true
+		// container units of a query fall back to the viewport
+		case CssUnit_VW, CssUnit_VI, CssUnit_CQW, CssUnit_CQI:
+			return amount * float64(env.Width) / 100, true
+		case CssUnit_VH, CssUnit_VB, CssUnit_CQH, CssUnit_CQB:
+			return amount * float64(env.Height) / 100, true
+		case CssUnit_VMIN, CssUnit_CQMIN:
+			return amount * float64(min(env.Width, env.Height)) / 100, true
+		case CssUnit_VMAX, CssUnit_CQMAX:
+			return amount * float64(max(env.Width, env.Height)) / 100, true
+		case CssUnit_PX, CssUnit_CM, CssUnit_MM, CssUnit_Q, CssUnit_IN, CssUnit_PT, CssUnit_PC:
+			dimention := CssDimention{Value: number.Value, Unit: unit}
+			return float64(dimention.AsPx()), true
+		}
+	case mediaResolution:
+		if number.Id != Token_Dimension {
+			return 0, false
+		}
+		switch strings.ToLower(number.Unit) {
+		case "dppx", "x":
+			return amount, true
+		case "dpi":
+			return amount / 96, true
+		case "dpcm":
+			return amount * 2.54 / 96, true
+		}
+	case mediaInteger:
+		if number.Id == Token_Number && number.DataType == NumberType_Integer {
+			return amount, true
+		}
+	}
+	return 0, false
+}
+
+/*
+Parses the prelude of an @media rule. Queries that fail to parse become "not all".
+
+Source: https://www.w3.org/TR/mediaqueries-4/#error-handling
+*/
+func ParseMediaQueryList(tokens []Token) MediaQueryList {
+	list := MediaQueryList{}
+	if len(components(tokens)) == 0 {
+		return list
+	}
+
+	for _, part := range splitByCommas(tokens) {
+		query, ok := parseMediaQuery(components(part))
+		if !ok {
+			query = MediaQuery{Not: true, Type: "all", text: "not all"}
+		} else {
+			query.text = strings.TrimSpace(serializeTokens(trimWhitespace(part)))
+		}
+		list.Queries = append(list.Queries, query)
+	}
+	return list
+}
+
+// Keywords that can not be a media type.
+var MEDIA_TYPE_RESERVED = []string{"only", "not", "and", "or", "layer"}
+
+func parseMediaQuery(tokens []Token) (MediaQuery, bool) {
+	parser := mediaParser{tokens: tokens}
+	query := MediaQuery{Type: "all"}
+
+	if parser.peekIdent() == "" || parser.peekIdent() == "not" && parser.isBlockAt(1) {
+		condition, ok := parser.condition(true)
+		if !ok || !parser.done() {
+			return MediaQuery{}, false
+		}
+		query.Condition = condition
+		return query, true
+	}
+
+	switch parser.peekIdent() {
+	case "not":
+		query.Not = true
+		parser.pos++
+	case "only":
+		parser.pos++
+	}
+
+	mediaType := parser.peekIdent()
+	if mediaType == "" || slices.Contains(MEDIA_TYPE_RESERVED, mediaType) {
+		return MediaQuery{}, false
+	}
+	parser.pos++
+	query.Type = mediaType
+	if !slices.Contains(MEDIA_TYPES, mediaType) {
+		// unknown media types are valid but never match
+		query.Condition = mediaResultCondition(mediaFalse)
+	}
+
+	if parser.done() {
+		return query, true
+	}
+	if parser.peekIdent() != "and" {
+		return MediaQuery{}, false
+	}
+	parser.pos++
+
+	condition, ok := parser.condition(false)
+	if !ok || !parser.done() {
+		return MediaQuery{}, false
+	}
+	if query.Condition != nil {
+		condition = mediaAnd{query.Condition, condition}
+	}
+	query.Condition = condition
+	return query, true
+}
+
+// Parses media conditions from component values without white space.
+type mediaParser struct {
+	tokens []Token
+	pos    int
+}
+
+func (p *mediaParser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+// Returns the lowercase value of the identifier at the current position or "".
+func (p *mediaParser) peekIdent() string {
+	if p.done() || p.tokens[p.pos].GetId() != Token_Ident {
+		return ""
+	}
+	return strings.ToLower(p.tokens[p.pos].(*StringToken).Value)
+}
+
+func (p *mediaParser) isBlockAt(offset int) bool {
+	if p.pos+offset >= len(p.tokens) {
+		return false
+	}
+	block, ok := p.tokens[p.pos+offset].(*SimpleBlock)
+	return ok && block.BlockType == Token_Pren_Close
+}
+
+// https://www.w3.org/TR/mediaqueries-4/#typedef-media-condition
+func (p *mediaParser) condition(allowOr bool) (MediaCondition, bool) {
+	if p.peekIdent() == "not" {
+		p.pos++
+		condition, ok := p.inParens()
+		return mediaNot{condition}, ok
+	}
+
+	first, ok := p.inParens()
+	if !ok {
+		return nil, false
+	}
+
+	conditions := []MediaCondition{first}
+	operator := ""
+	for keyword := p.peekIdent(); keyword == "and" || keyword == "or"; keyword = p.peekIdent() {
+		// and and or can not be mixed without parentheses
+		if operator != "" && operator != keyword || keyword == "or" && !allowOr {
+			return nil, false
+		}
+		operator = keyword
+		p.pos++
+
+		condition, ok := p.inParens()
+		if !ok {
+			return nil, false
+		}
+		conditions = append(conditions, condition)
+	}
+
+	switch operator {
+	case "and":
+		return mediaAnd(conditions), true
+	case "or":
+		return mediaOr(conditions), true
+	}
+	return first, true
+}
+
+// https://www.w3.org/TR/mediaqueries-4/#typedef-media-in-parens
+func (p *mediaParser) inParens() (MediaCondition, bool) {
+	if p.done() {
+		return nil, false
+	}
+
+	token := p.tokens[p.pos]
+	if token.GetId() == TFunction {
+		p.pos++
+		return mediaResultCondition(mediaUnknown), true
+	}
+	if !p.isBlockAt(0) {
+		return nil, false
+	}
+	p.pos++
+
+	contents := components(token.(*SimpleBlock).Tokens)
+	inner := mediaParser{tokens: contents}
+	if condition, ok := inner.condition(true); ok && inner.done() {
+		return condition, true
+	}
+	if feature, ok := parseMediaFeature(contents); ok {
+		return feature, true
+	}
+	// anything else in parentheses is general enclosed syntax
+	return mediaResultCondition(mediaUnknown), true
+}
+
+// https://www.w3.org/TR/mediaqueries-4/#typedef-media-feature
+func parseMediaFeature(tokens []Token) (MediaCondition, bool) {
+	if len(tokens) == 0 {
+		return nil, false
+	}
+
+	// boolean and plain features
+	if tokens[0].GetId() == Token_Ident {
+		name := strings.ToLower(tokens[0].(*StringToken).Value)
+		switch {
+		case len(tokens) == 1:
+			return mediaFeature{name: name}, true
+		case tokens[1].GetId() == Token_Colon:
+			if len(tokens) == 2 {
+				return nil, false
+			}
+			return plainMediaFeature(name, tokens[2:]), true
+		}
+	}
+
+	// range features
+	segments := [][]Token{{}}
+	operators := []string{}
+	for i := 0; i < len(tokens); i++ {
+		op := ""
+		switch {
+		case isDelim(tokens[i], '<'), isDelim(tokens[i], '>'):
+			op = string(tokens[i].(*RuneToken).Value)
+			if i+1 < len(tokens) && isDelim(tokens[i+1], '=') {
+				op += "="
+				i++
+			}
+		case isDelim(tokens[i], '='):
+			op = "="
+		default:
+			segments[len(segments)-1] = append(segments[len(segments)-1], tokens[i])
+			continue
+		}
+		operators = append(operators, op)
+		segments = append(segments, []Token{})
+	}
+
+	featureName := func(segment []Token) string {
+		if len(segment) != 1 || segment[0].GetId() != Token_Ident {
+			return ""
+		}
+		return strings.ToLower(segment[0].(*StringToken).Value)
+	}
+
+	switch len(operators) {
+	case 1:
+		if name := featureName(segments[0]); name != "" {
+			return mediaFeature{name: name, comparisons: []mediaComparison{{op: operators[0], value: segments[1]}}}, true
+		}
+		if name := featureName(segments[1]); name != "" {
+			return mediaFeature{name: name, comparisons: []mediaComparison{{op: flipMediaOperator(operators[0]), value: segments[0]}}}, true
+		}
+	case 2:
+		name := featureName(segments[1])
+		less := operators[0][0] == '<' && operators[1][0] == '<'
+		greater := operators[0][0] == '>' && operators[1][0] == '>'
+		if name != "" && (less || greater) {
+			return mediaFeature{name: name, comparisons: []mediaComparison{
+				{op: flipMediaOperator(operators[0]), value: segments[0]},
+				{op: operators[1], value: segments[2]},
+			}}, true
+		}
+	}
+	return nil, false
+}
+
+// min- and max- prefixes are the legacy form of range comparisons.
+func plainMediaFeature(name string, value []Token) MediaCondition {
+	for prefix, op := range map[string]string{"min-": ">=", "max-": "<="} {
+		base, found := strings.CutPrefix(name, prefix)
+		if !found {
+			continue
+		}
+		if definition, ok := MEDIA_FEATURES[base]; ok && definition.kind != mediaDiscrete {
+			return mediaFeature{name: base, comparisons: []mediaComparison{{op: op, value: value}}}
+		}
+		if definition, ok := CONTAINER_FEATURES[base]; ok && definition.kind != mediaDiscrete {
+			return mediaFeature{name: base, comparisons: []mediaComparison{{op: op, value: value}}}
+		}
+		return mediaResultCondition(mediaUnknown)
+	}
+	return mediaFeature{name: name, value: value}
+}
+
+func flipMediaOperator(op string) string {
+	switch op {
+	case "<":
+		return ">"
+	case "<=":
+		return ">="
+	case ">":
+		return "<"
+	case ">=":
+		return "<="
+	}
+	return op
+}
@@ -0,0 +1,117 @@
+package plex_css_test
+
+import (
+	"testing"
+	plex_css "visualsource/plex/internal/css"
+)
+
+func parseMediaRules(t *testing.T, query string) plex_css.Rule {
+	parser := plex_css.CssParser{}
+	stylesheet, err := parser.ParseStylesheet("@media "+query+" { p { color: red } }", plex_css.Origin_Author)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if len(stylesheet.Rules) != 1 || len(stylesheet.AtRules) != 0 {
+		t.Fatalf("%q: expected the @media rule to be flattened into one rule, got %v", query, stylesheet)
+	}
+	return stylesheet.Rules[0]
+}
+
+func TestMedia_MATCHES(t *testing.T) {
+	env := plex_css.DefaultMediaEnvironment
+	env.Width = 600
+	env.Height = 400
+
+	tests := map[string]bool{
+		"":                                     true,
+		"all":                                  true,
+		"screen":                               true,
+		"print":                                false,
+		"SCREEN":                               true,
+		"not print":                            true,
+		"only screen":                          true,
+		"unknown":                              false,
+		"not unknown":                          true,
+		"print, screen":                        true,
+		"screen and (min-width: 500px)":        true,
+		"screen and (max-width: 500px)":        false,
+		"(width: 600px)":                       true,
+		"(width)":                              true,
+		"(width >= 37.5em)":                    true,
+		"(400px <= width <= 800px)":            true,
+		"(400px <= width < 600px)":             false,
+		"(700px > width)":                      true,
+		"(orientation: landscape)":             true,
+		"(orientation: portrait)":              false,
+		"(aspect-ratio: 3/2)":                  true,
+		"(min-aspect-ratio: 16/9)":             false,
+		"(resolution: 96dpi)":                  true,
+		"(min-resolution: 2dppx)":              false,
+		"(color)":                              true,
+		"(monochrome)":                         false,
+		"(prefers-color-scheme: dark)":         false,
+		"(prefers-reduced-motion)":             false,
+		"(prefers-reduced-motion: reduce)":     false,
+		"not (prefers-color-scheme: dark)":     true,
+		"(width > 1000px) or (height: 400px)":  true,
+		"(width > 1000px) and (height: 400px)": false,
+		"not ((width > 1000px) or (color))":    false,
+		"(unknown-feature)":                    false,
+		"not (unknown-feature)":                false,
+		"(width: red)":                         false,
+		"screen and (color) or (width)":        false,
+		"and":                                  false,
+		"(50vw < width)":                       true,
+		"(width < 10cm)":                       false,
+	}
+
+	for query, expected := range tests {
+		rule := parseMediaRules(t, query)
+		if rule.MatchesMedia(&env) != expected {
+			t.Errorf("expected %q to match: %v", query, expected)
+		}
+	}
+}
+
+func TestMedia_NESTED(t *testing.T) {
+	parser := plex_css.CssParser{}
+	stylesheet, err := parser.ParseStylesheet(`
+		p { color: blue }
+		@media screen {
+			div { color: red }
+			@media (min-width: 700px) {
+				span { color: green }
+			}
+		}
+		@media print { a { color: red } }
+		@unknown foo;
+		h1 { color: white }
+	`, plex_css.Origin_Author)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if len(stylesheet.Rules) != 5 || len(stylesheet.AtRules) != 1 {
+		t.Fatalf("expected 5 rules and 1 at-rule, got %d and %d", len(stylesheet.Rules), len(stylesheet.AtRules))
+	}
+
+	env := plex_css.DefaultMediaEnvironment
+	env.Width = 600
+	matching := []string{}
+	for _, rule := range stylesheet.Rules {
+		if rule.MatchesMedia(&env) {
+			matching = append(matching, plex_css.SerializeSelectorList(rule.Selector))
+		}
+	}
+	if len(matching) != 3 || matching[0] != "p" || matching[1] != "div" || matching[2] != "h1" {
+		t.Fatalf("unexpected matching rules %v", matching)
+	}
+
+	expected := "@media screen { @media (min-width: 700px) { span { color: green; } } }"
+	if text := stylesheet.Rules[2].CssText(); text != expected {
+		t.Fatalf("expected %q, got %q", expected, text)
+	}
+	if text := stylesheet.AtRules[0].CssText(); text != "@unknown foo;" {
+		t.Fatalf("expected the unknown at-rule to be kept, got %q", text)
+	}
+}
@@ -0,0 +1,293 @@
+package plex_css
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+/*
+An @page rule. The embedded rule holds the declarations of the page context together with the
+conditions and layer the @page rule is nested in, its selector list is empty.
+
+Source: https://www.w3.org/TR/css-page-3/#at-page-rule
+*/
+type PageRule struct {
+	Rule
+	// the rule applies to every page when there are no selectors
+	Selectors   []PageSelector
+	MarginRules []PageMarginRule
+	prelude     []Token
+}
+
+// https://www.w3.org/TR/css-page-3/#typedef-page-selector
+type PageSelector struct {
+	// the page type name, empty when the selector has none
+	Name string
+	// first, left, right and blank
+	Pseudo []string
+}
+
+// A rule for one of the margin boxes of a page, such as @top-center.
+// https://www.w3.org/TR/css-page-3/#margin-at-rule
+type PageMarginRule struct {
+	Name  string
+	Block []Declaration
+}
+
+// What page selectors are matched against.
+type PageContext struct {
+	Name  string
+	First bool
+	Left  bool
+	Blank bool
+}
+
+// https://www.w3.org/TR/css-page-3/#margin-boxes
+var PAGE_MARGIN_BOXES = []string{
+	"top-left-corner", "top-left", "top-center", "top-right", "top-right-corner",
+	"right-top", "right-middle", "right-bottom",
+	"bottom-right-corner", "bottom-right", "bottom-center", "bottom-left", "bottom-left-corner",
+	"left-bottom", "left-middle", "left-top",
+}
+
+var PAGE_PSEUDO_CLASSES = []string{"first", "left", "right", "blank"}
+
+func (s *PageSelector) Matches(page PageContext) bool {
+	if s.Name != "" && s.Name != page.Name {
+		return false
+	}
+	for _, pseudo := range s.Pseudo {
+		switch {
+		case pseudo == "first" && !page.First,
+			pseudo == "blank" && !page.Blank,
+			pseudo == "left" && !page.Left,
+			pseudo == "right" && page.Left:
+			return false
+		}
+	}
+	return true
+}
+
+/*
+A page type name counts in the first component, :first and :blank in the second one and :left
+and :right in the third one.
+
+Source: https://www.w3.org/TR/css-page-3/#cascading-and-page-context
+*/
+func (s *PageSelector) Specificity() Specificity {
+	specificity := Specificity{}
+	if s.Name != "" {
+		specificity.A++
+	}
+	for _, pseudo := range s.Pseudo {
+		if pseudo == "first" || pseudo == "blank" {
+			specificity.B++
+		} else {
+			specificity.C++
+		}
+	}
+	return specificity
+}
+
+// Returns the specificity of the most specific selector of the rule matching the page.
+func (r *PageRule) Match(page PageContext) (Specificity, bool) {
+	if len(r.Selectors) == 0 {
+		return Specificity{}, true
+	}
+
+	matched := false
+	best := Specificity{}
+	for i := range r.Selectors {
+		if !r.Selectors[i].Matches(page) {
+			continue
+		}
+		if specificity := r.Selectors[i].Specificity(); !matched || best.Compare(&specificity) < 0 {
+			best = specificity
+		}
+		matched = true
+	}
+	return best, matched
+}
+
+func (r *PageRule) CssText() string {
+	text := "@page "
+	if len(r.prelude) > 0 {
+		text += serializeTokens(r.prelude) + " "
+	}
+	text += "{"
+	for i := range r.Block {
+		text += " " + r.Block[i].CssText() + ";"
+	}
+	for _, margin := range r.MarginRules {
+		text += " @" + margin.Name + " {"
+		for i := range margin.Block {
+			text += " " + margin.Block[i].CssText() + ";"
+		}
+		text += " }"
+	}
+	text += " }"
+	return r.wrapConditions(text)
+}
+
+/*
+Parses an @page rule. Invalid declarations and unknown at-rules in its block are dropped, an
+invalid selector list drops the whole rule.
+
+Source: https://www.w3.org/TR/css-page-3/#syntax-page-selector
+*/
+func (p *CssParser) pageRule(atRule AtRule) (PageRule, error) {
+	if atRule.Block.BlockType != Token_Clearly_Close {
+		return PageRule{}, fmt.Errorf("@page needs a block")
+	}
+
+	selectors, err := parsePageSelectors(atRule.Prelude)
+	if err != nil {
+		return PageRule{}, err
+	}
+	page := PageRule{Selectors: selectors, prelude: trimWhitespace(atRule.Prelude)}
+
+	parser := CssParser{}
+	parser.input = atRule.Block.Tokens
+	parser.len = len(atRule.Block.Tokens)
+	descriptors, atRules := parser.consumeDeclarations(false)
+
+	for _, descriptor := range descriptors {
+		// 'size' is a descriptor of the page context, everything else is a property
+		if strings.EqualFold(descriptor.Name, "size") {
+			if _, err := ParsePageSize(descriptor.Value); err != nil {
+				p.Diagnostics = append(p.Diagnostics, Diagnostic{Property: "size", Message: err.Error()})
+				continue
+			}
+			descriptor.Name = "size"
+			page.Block = append(page.Block, descriptor)
+			continue
+		}
+
+		declarations, err := expandDeclaration(descriptor)
+		if err != nil {
+			p.Diagnostics = append(p.Diagnostics, Diagnostic{Property: descriptor.Name, Message: err.Error()})
+			continue
+		}
+		page.Block = append(page.Block, declarations...)
+	}
+
+	for _, rule := range atRules {
+		name := strings.ToLower(rule.Name)
+		if !slices.Contains(PAGE_MARGIN_BOXES, name) || rule.Block.BlockType != Token_Clearly_Close {
+			p.Diagnostics = append(p.Diagnostics, Diagnostic{Message: fmt.Sprintf("unexpected @%s in @page", rule.Name)})
+			continue
+		}
+
+		marginParser := CssParser{}
+		marginParser.input = rule.Block.Tokens
+		marginParser.len = len(rule.Block.Tokens)
+		declarations, _ := marginParser.ConsumeDeclarationsList()
+		p.Diagnostics = append(p.Diagnostics, marginParser.Diagnostics...)
+		page.MarginRules = append(page.MarginRules, PageMarginRule{Name: name, Block: declarations})
+	}
+	return page, nil
+}
+
+// https://www.w3.org/TR/css-page-3/#typedef-page-selector-list
+func parsePageSelectors(tokens []Token) ([]PageSelector, error) {
+	if len(components(tokens)) == 0 {
+		return nil, nil
+	}
+
+	selectors := []PageSelector{}
+	for _, part := range splitByCommas(tokens) {
+		part = components(part)
+		selector := PageSelector{}
+		if len(part) > 0 && part[0].GetId() == Token_Ident {
+			selector.Name = part[0].(*StringToken).Value
+			part = part[1:]
+		}
+
+		for len(part) > 0 {
+			if len(part) < 2 || part[0].GetId() != Token_Colon || !isStringCaseInsensitiveIn(part[1], PAGE_PSEUDO_CLASSES) {
+				return nil, fmt.Errorf("invalid page selector")
+			}
+			selector.Pseudo = append(selector.Pseudo, strings.ToLower(part[1].(*StringToken).Value))
+			part = part[2:]
+		}
+
+		if selector.Name == "" && len(selector.Pseudo) == 0 {
+			return nil, fmt.Errorf("empty page selector")
+		}
+		selectors = append(selectors, selector)
+	}
+	return selectors, nil
+}
+
+// The size of a page box, 0 for a size taken from the output device.
+// https://www.w3.org/TR/css-page-3/#page-size-prop
+type PageSize struct {
+	Width  float32
+	Height float32
+	// portrait or landscape, empty when the page keeps the orientation of its size
+	Orientation string
+}
+
+const mmToPx = 96 / 25.4
+
+// The sizes of the <page-size> keywords in portrait orientation, in px.
+var PAGE_SIZES = map[string][2]float32{
+	"a5":     {148 * mmToPx, 210 * mmToPx},
+	"a4":     {210 * mmToPx, 297 * mmToPx},
+	"a3":     {297 * mmToPx, 420 * mmToPx},
+	"b5":     {176 * mmToPx, 250 * mmToPx},
+	"b4":     {250 * mmToPx, 353 * mmToPx},
+	"jis-b5": {182 * mmToPx, 257 * mmToPx},
+	"jis-b4": {257 * mmToPx, 364 * mmToPx},
+	"letter": {8.5 * 96, 11 * 96},
+	"legal":  {8.5 * 96, 14 * 96},
+	"ledger": {11 * 96, 17 * 96},
+}
+
+/*
+Parses <length [0,∞]>{1,2} | auto | [ <page-size> || [ portrait | landscape ] ]. Lengths have
+to be absolute, the page context has no font to resolve relative lengths against.
+
+Source: https://www.w3.org/TR/css-page-3/#page-size-prop
+*/
+func ParsePageSize(values []CssValue) (PageSize, error) {
+	size := PageSize{}
+	if len(values) == 0 || len(values) > 2 {
+		return size, fmt.Errorf("invalid page size")
+	}
+	if len(values) == 1 && IsCssKeyword(values[0], "auto") {
+		return size, nil
+	}
+
+	lengths := []float32{}
+	for _, value := range values {
+		switch v := value.(type) {
+		case *CssDimention:
+			if !IsLengthUnit(v.Unit) || IsRelativeLengthUnit(v.Unit) || v.Value <= 0 || size.Width != 0 || size.Orientation != "" {
+				return PageSize{}, fmt.Errorf("invalid page size")
+			}
+			lengths = append(lengths, v.AsPx())
+		case *CssKeyword:
+			keyword := strings.ToLower(v.Value)
+			switch {
+			case (keyword == "portrait" || keyword == "landscape") && size.Orientation == "" && len(lengths) == 0:
+				size.Orientation = keyword
+			case PAGE_SIZES[keyword] != [2]float32{} && size.Width == 0 && len(lengths) == 0:
+				size.Width, size.Height = PAGE_SIZES[keyword][0], PAGE_SIZES[keyword][1]
+			default:
+				return PageSize{}, fmt.Errorf("invalid page size")
+			}
+		default:
+			return PageSize{}, fmt.Errorf("invalid page size")
+		}
+	}
+
+	switch len(lengths) {
+	case 1:
+		size.Width, size.Height = lengths[0], lengths[0]
+	case 2:
+		size.Width, size.Height = lengths[0], lengths[1]
+	}
+	return size, nil
+}
@@ -0,0 +1,93 @@
+package plex_css_test
+
+import (
+	"testing"
+	plex_css "visualsource/plex/internal/css"
+)
+
+func TestPage_PARSE(t *testing.T) {
+	parser := plex_css.CssParser{}
+	stylesheet, err := parser.ParseStylesheet(`
+		@page { size: a4 landscape; margin: 2cm; @top-center { content: counter(page) } @unknown { color: red } }
+		@page :first, :left { margin-top: 10%; size: 10em }
+		@page cover:first:right { color: red }
+		@page :middle { color: red }
+		@media print { @page :blank { color: blue } }
+		p { color: red }
+	`, plex_css.Origin_Author)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(stylesheet.Pages) != 4 || len(stylesheet.Rules) != 1 {
+		t.Fatalf("expected 4 @page rules besides the style rule, got %v", stylesheet.Pages)
+	}
+	if len(stylesheet.Diagnostics) != 3 {
+		t.Fatalf("expected diagnostics for @unknown, the relative size and :middle, got %v", stylesheet.Diagnostics)
+	}
+
+	page := stylesheet.Pages[0]
+	if len(page.Selectors) != 0 || len(page.Block) != 5 || len(page.MarginRules) != 1 || page.MarginRules[0].Name != "top-center" {
+		t.Fatalf("expected the size, the margin longhands and a margin rule, got %+v", page)
+	}
+	size, err := plex_css.ParsePageSize(page.Block[0].Value)
+	if err != nil || size.Width != plex_css.PAGE_SIZES["a4"][0] || size.Orientation != "landscape" {
+		t.Fatalf("expected an a4 landscape page, got %+v %v", size, err)
+	}
+
+	if specificity, ok := stylesheet.Pages[1].Match(plex_css.PageContext{First: true, Left: true}); !ok || specificity != (plex_css.Specificity{B: 1}) {
+		t.Fatalf("expected :first to be the most specific selector, got %v %v", specificity, ok)
+	}
+	if _, ok := stylesheet.Pages[1].Match(plex_css.PageContext{}); ok {
+		t.Fatalf("expected :first, :left not to match a right page")
+	}
+	if specificity, ok := stylesheet.Pages[2].Match(plex_css.PageContext{Name: "cover", First: true}); !ok || specificity != (plex_css.Specificity{A: 1, B: 1, C: 1}) {
+		t.Fatalf("expected the named selector to match, got %v %v", specificity, ok)
+	}
+
+	env := plex_css.DefaultMediaEnvironment
+	if stylesheet.Pages[3].MatchesMedia(&env) {
+		t.Fatalf("expected @page in @media print not to match the screen")
+	}
+	env.Type = "print"
+	if !stylesheet.Pages[3].MatchesMedia(&env) {
+		t.Fatalf("expected @page in @media print to match print media")
+	}
+
+	expected := "@media print { @page :blank { color: blue; } }"
+	if text := stylesheet.Pages[3].CssText(); text != expected {
+		t.Fatalf("expected %q, got %q", expected, text)
+	}
+}
+
+func TestPage_SIZE(t *testing.T) {
+	tests := map[string]plex_css.PageSize{
+		"auto":             {},
+		"landscape":        {Orientation: "landscape"},
+		"100px":            {Width: 100, Height: 100},
+		"1in 2in":          {Width: 96, Height: 192},
+		"letter portrait":  {Width: 816, Height: 1056, Orientation: "portrait"},
+		"landscape letter": {Width: 816, Height: 1056, Orientation: "landscape"},
+	}
+	invalid := []string{"10em", "0px", "a4 a5", "100px landscape", "auto auto", "red"}
+
+	for text, expected := range tests {
+		parser := plex_css.CssParser{}
+		stylesheet, _ := parser.ParseStylesheet("@page { size: "+text+" }", plex_css.Origin_Author)
+		if len(stylesheet.Pages) != 1 || len(stylesheet.Pages[0].Block) != 1 {
+			t.Fatalf("%q: expected a valid size, got %v", text, stylesheet.Diagnostics)
+		}
+		size, err := plex_css.ParsePageSize(stylesheet.Pages[0].Block[0].Value)
+		if err != nil || size != expected {
+			t.Errorf("%q: expected %+v, got %+v %v", text, expected, size, err)
+		}
+	}
+
+	for _, text := range invalid {
+		parser := plex_css.CssParser{}
+		stylesheet, _ := parser.ParseStylesheet("@page { size: "+text+" }", plex_css.Origin_Author)
+		if len(stylesheet.Pages[0].Block) != 0 {
+			t.Errorf("expected %q to be an invalid size", text)
+		}
+	}
+}
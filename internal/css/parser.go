@@ -2,17 +2,36 @@ package plex_css
 
 import (
 	"fmt"
+	"slices"
 	"strings"
+
+	mapset "github.com/deckarep/golang-set/v2"
 )
 
 type CssParser struct {
 	pos   int
 	len   int
 	input []Token
+	// problems found by the last parse
+	Diagnostics []Diagnostic
+	// selectors of the style rule whose block is parsed, nil outside of style rules
+	nesting []Selector
+	// cascade layers declared by @layer rules, in order
+	layers []string
+	// the @page rules, in order
+	pages []PageRule
+	// the number of layers declared before each top-level @import, in order
+	importLayers []int
+	// <!-- and --> are ignored between the rules of a stylesheet, not in blocks
+	topLevel bool
 }
 
 func (p *CssParser) ParseStylesheet(value string, origin uint) (Stylesheet, error) {
 	p.pos = 0
+	p.Diagnostics = nil
+	p.layers = nil
+	p.pages = nil
+	p.importLayers = nil
 	tokenizer := Tokenizer{}
 
 	tokens, err := tokenizer.Parse(value)
@@ -21,21 +40,55 @@ func (p *CssParser) ParseStylesheet(value string, origin uint) (Stylesheet, erro
 	}
 	p.len = len(tokens)
 	p.input = tokens
+	p.topLevel = true
 
 	rules, atRules, err := p.ConsumeRulesList()
 	if err != nil {
 		return Stylesheet{}, err
 	}
 
+	imports := []ImportRule{}
+	fontFaces := []FontFace{}
+	otherRules := []AtRule{}
+	for _, atRule := range atRules {
+		switch strings.ToLower(atRule.Name) {
+		case "import":
+			rule, err := ParseImportRule(atRule)
+			layersBefore := p.importLayers[0]
+			p.importLayers = p.importLayers[1:]
+			if err != nil {
+				p.Diagnostics = append(p.Diagnostics, Diagnostic{Message: err.Error()})
+				continue
+			}
+			rule.layersBefore = layersBefore
+			imports = append(imports, rule)
+		case "font-face":
+			face, err := ParseFontFace(atRule)
+			if err != nil {
+				p.Diagnostics = append(p.Diagnostics, Diagnostic{Message: err.Error()})
+				continue
+			}
+			fontFaces = append(fontFaces, face)
+		default:
+			otherRules = append(otherRules, atRule)
+		}
+	}
+
 	return Stylesheet{
-		Rules:    rules,
-		AtRules:  atRules,
-		TopLevel: true,
-		Origin:   origin,
+		Rules:       rules,
+		AtRules:     otherRules,
+		Imports:     imports,
+		FontFaces:   fontFaces,
+		Pages:       p.pages,
+		Layers:      p.layers,
+		TopLevel:    true,
+		Origin:      origin,
+		Diagnostics: p.Diagnostics,
 	}, nil
 }
 func (p *CssParser) ParseDeclarationsList(value string) ([]Declaration, error) {
 	p.pos = 0
+	p.Diagnostics = nil
 	tokenizer := Tokenizer{}
 
 	tokens, err := tokenizer.Parse(value)
@@ -49,7 +102,52 @@ func (p *CssParser) ParseDeclarationsList(value string) ([]Declaration, error) {
 
 	return decs, nil
 }
-func (p *CssParser) ParseRule()                    {}
+
+/*
+Parses the text of a single style rule, as given to CSSStyleSheet.insertRule(). Anything
+after the rule is a syntax error.
+
+Source: https://www.w3.org/TR/css-syntax-3/#parse-rule
+*/
+func (p *CssParser) ParseRule(value string) (Rule, error) {
+	p.pos = 0
+	p.Diagnostics = nil
+	tokenizer := Tokenizer{}
+
+	tokens, err := tokenizer.Parse(value)
+	if err != nil {
+		return Rule{}, err
+	}
+	p.len = len(tokens)
+	p.input = tokens
+
+	p.skipWhitespace()
+	switch {
+	case p.eof() || p.isCurrent(Token_EOF):
+		return Rule{}, fmt.Errorf("expected a rule")
+	case p.isCurrent(Token_At_Keyword):
+		return Rule{}, fmt.Errorf("expected a style rule, found an at-rule")
+	}
+
+	rules, err := p.ConsumeQualifiedRule()
+	if err != nil {
+		return Rule{}, err
+	}
+	if len(rules) != 1 {
+		return Rule{}, fmt.Errorf("rules with nested rules can not be inserted")
+	}
+	rule := rules[0]
+	if len(rule.Selector) == 0 {
+		return Rule{}, fmt.Errorf("invalid selector")
+	}
+
+	p.skipWhitespace()
+	if !p.eof() && !p.isCurrent(Token_EOF) {
+		return Rule{}, fmt.Errorf("unexpected content after the rule")
+	}
+
+	return rule, nil
+}
 func (p *CssParser) ParseDeclaration()             {}
 func (p *CssParser) ParseStyleBlockContent()       {}
 func (p *CssParser) ParseComponentValue()          {}
@@ -60,32 +158,80 @@ func (p *CssParser) ConsumeRulesList() ([]Rule, []AtRule, error) {
 
 	rules := []Rule{}
 	atRules := []AtRule{}
+	// @import has to come before every rule but @charset and @layer statements
+	importsAllowed := true
 
 	for {
 		switch {
 		case p.isCurrent(Token_Whitespace):
 			p.pos++
-		case p.isCurrent(Token_EOF):
+		case p.eof() || p.isCurrent(Token_EOF):
 			return rules, atRules, nil
+		case p.topLevel && (p.isCurrent(Token_CDO) || p.isCurrent(Token_CDC)):
+			p.pos++
 		case p.isCurrent(Token_CDO) || p.isCurrent(Token_CDC):
 			result, err := p.ConsumeQualifiedRule()
 			if err == nil {
-				rules = append(rules, result)
+				rules = append(rules, result...)
+				importsAllowed = false
 			}
 		case p.isCurrent(Token_At_Keyword):
 			result, err := p.ConsumeAtRule()
-			if err == nil {
+			if err != nil {
+				continue
+			}
+
+			name := strings.ToLower(result.Name)
+			switch {
+			case name == "import" && !importsAllowed:
+				p.Diagnostics = append(p.Diagnostics, Diagnostic{Message: "@import must come before all other rules"})
+				continue
+			case name != "import" && name != "charset" && (name != "layer" || result.Block.BlockType == Token_Clearly_Close):
+				importsAllowed = false
+			}
+			if name == "import" && p.topLevel {
+				p.importLayers = append(p.importLayers, len(p.layers))
+			}
+
+			// @layer a, b; only declares the order of the layers
+			// https://www.w3.org/TR/css-cascade-5/#layer-empty
+			if name == "layer" && result.Block.BlockType != Token_Clearly_Close {
+				names, err := parseLayerNameList(result.Prelude)
+				if err != nil {
+					p.Diagnostics = append(p.Diagnostics, Diagnostic{Message: "invalid @layer statement: " + err.Error()})
+					continue
+				}
+				p.declareLayers(names...)
+				continue
+			}
+
+			if name == "page" {
+				page, err := p.pageRule(result)
+				if err != nil {
+					p.Diagnostics = append(p.Diagnostics, Diagnostic{Message: "invalid @page rule: " + err.Error()})
+					continue
+				}
+				p.pages = append(p.pages, page)
+				continue
+			}
+
+			if nested, ok := p.conditionalRules(result); ok {
+				rules = append(rules, nested...)
+			} else {
 				atRules = append(atRules, result)
 			}
 		default:
 			result, err := p.ConsumeQualifiedRule()
 			if err == nil {
-				rules = append(rules, result)
+				rules = append(rules, result...)
+				importsAllowed = false
 			}
 		}
 	}
 
 }
+
+// https://www.w3.org/TR/css-syntax-3/#consume-at-rule
 func (p *CssParser) ConsumeAtRule() (AtRule, error) {
 
 	rule := AtRule{}
@@ -101,8 +247,12 @@ func (p *CssParser) ConsumeAtRule() (AtRule, error) {
 		switch {
 		// handle statement at rule
 		case p.isCurrent(Token_Semicolon):
-		case p.isCurrent(Token_EOF):
-			return rule, fmt.Errorf("found EOF Token")
+			p.pos++
+			rule.Prelude = trimWhitespace(rule.Prelude)
+			return rule, nil
+		case p.eof() || p.isCurrent(Token_EOF):
+			rule.Prelude = trimWhitespace(rule.Prelude)
+			return rule, nil
 		// handle block at rule
 		case p.isCurrent(Token_Clearly_Open):
 			block, err := p.ConsumeSimpleBlock()
@@ -114,75 +264,300 @@ func (p *CssParser) ConsumeAtRule() (AtRule, error) {
 			}
 
 			rule.Block = block
+			rule.Prelude = trimWhitespace(rule.Prelude)
 			return rule, nil
 		// same as above
 		case p.isCurrent(TSimpleBlack):
 			if b, ok := p.input[p.pos].(*SimpleBlock); ok && b.BlockType == Token_Clearly_Close {
+				p.pos++
 				rule.Block = *b
+				rule.Prelude = trimWhitespace(rule.Prelude)
 				return rule, nil
 			}
+			fallthrough
 		default:
 			value, err := p.ConsumeComponentValue()
 			if err != nil {
 				return AtRule{}, err
 			}
-			if value.GetId() != Token_Whitespace {
-				rule.Prelude = append(rule.Prelude, value)
+			rule.Prelude = append(rule.Prelude, value)
+		}
+	}
+
+}
+
+/*
+Flattens the rules nested in a conditional group rule into the rule list, each of them
+remembers the condition it depends on. Reports false for other at-rules.
+
+Source: https://www.w3.org/TR/css-conditional-3/#conditional-group-rule
+*/
+func (p *CssParser) conditionalRules(atRule AtRule) ([]Rule, bool) {
+	if atRule.Block.BlockType != Token_Clearly_Close {
+		return nil, false
+	}
+
+	var scope func(rule *Rule)
+	// the layers declared in the block are sublayers of an @layer block
+	layer := ""
+	switch strings.ToLower(atRule.Name) {
+	case "media":
+		media := ParseMediaQueryList(atRule.Prelude)
+		scope = func(rule *Rule) {
+			rule.Media = append([]MediaQueryList{media}, rule.Media...)
+		}
+	case "supports":
+		// https://www.w3.org/TR/css-conditional-3/#at-supports
+		condition, err := ParseSupportsCondition(atRule.Prelude)
+		if err != nil {
+			// an @supports rule with an invalid prelude is ignored together with its rules
+			p.Diagnostics = append(p.Diagnostics, Diagnostic{Message: "invalid @supports condition: " + err.Error()})
+			return []Rule{}, true
+		}
+		query := SupportsQuery{Condition: condition, prelude: atRule.Prelude}
+		scope = func(rule *Rule) {
+			rule.Supports = append([]SupportsQuery{query}, rule.Supports...)
+		}
+	case "container":
+		query, err := ParseContainerQuery(atRule.Prelude)
+		if err != nil {
+			// like @supports, an @container rule with an invalid prelude is ignored together with its rules
+			p.Diagnostics = append(p.Diagnostics, Diagnostic{Message: "invalid @container query: " + err.Error()})
+			return []Rule{}, true
+		}
+		scope = func(rule *Rule) {
+			rule.Container = append([]ContainerQuery{query}, rule.Container...)
+		}
+	case "layer":
+		// https://www.w3.org/TR/css-cascade-5/#layer-block
+		layer = anonymousLayerName()
+		if len(atRule.Prelude) > 0 {
+			name, err := parseLayerName(atRule.Prelude)
+			if err != nil {
+				p.Diagnostics = append(p.Diagnostics, Diagnostic{Message: "invalid @layer name: " + err.Error()})
+				return []Rule{}, true
 			}
+			layer = name
 		}
+		p.declareLayers(layer)
+		scope = func(rule *Rule) {
+			rule.Layer = joinLayerNames(layer, rule.Layer)
+		}
+	default:
+		return nil, false
+	}
+
+	blockParser := CssParser{nesting: p.nesting}
+	blockParser.input = atRule.Block.Tokens
+	blockParser.len = len(atRule.Block.Tokens)
+
+	var rules []Rule
+	if p.nesting == nil {
+		rules, _, _ = blockParser.ConsumeRulesList()
+	} else {
+		// conditional rules nested in a style rule hold declarations for the style rule's elements
+		var declarations []Declaration
+		declarations, rules = blockParser.ConsumeStyleBlockContents()
+		if len(declarations) > 0 {
+			rules = append([]Rule{p.nestedDeclarationsRule(declarations)}, rules...)
+		}
+	}
+	p.Diagnostics = append(p.Diagnostics, blockParser.Diagnostics...)
+	for _, name := range blockParser.layers {
+		p.declareLayers(joinLayerNames(layer, name))
 	}
 
+	for i := range rules {
+		scope(&rules[i])
+	}
+	for _, page := range blockParser.pages {
+		scope(&page.Rule)
+		p.pages = append(p.pages, page)
+	}
+	return rules, true
+}
+
+// Drops the white space around tokens.
+func trimWhitespace(tokens []Token) []Token {
+	for len(tokens) > 0 && tokens[0].GetId() == Token_Whitespace {
+		tokens = tokens[1:]
+	}
+	for len(tokens) > 0 && tokens[len(tokens)-1].GetId() == Token_Whitespace {
+		tokens = tokens[:len(tokens)-1]
+	}
+	return tokens
 }
-func (p *CssParser) ConsumeQualifiedRule() (Rule, error) {
 
-	rule := Rule{}
+// Returns the rule followed by the rules nested in it.
+func (p *CssParser) ConsumeQualifiedRule() ([]Rule, error) {
+
 	prelude := []Token{}
 
 	for {
 		switch {
-		case p.isCurrent(Token_EOF):
-			return Rule{}, fmt.Errorf("invalid rule")
+		case p.eof() || p.isCurrent(Token_EOF):
+			return nil, fmt.Errorf("invalid rule")
+		// a nested rule can not swallow the declarations that follow it
+		case p.nesting != nil && p.isCurrent(Token_Semicolon):
+			p.pos++
+			return nil, fmt.Errorf("unexpected ';' in the selector of a nested rule")
 		case p.isCurrent(Token_Clearly_Open):
 			block, err := p.ConsumeSimpleBlock()
 			if err != nil {
-				return Rule{}, err
+				return nil, err
 			}
 
-			declarationParser := CssParser{}
-			declarationParser.input = block.Tokens
-			declarationParser.len = len(block.Tokens)
-			declarations, _ := declarationParser.ConsumeDeclarationsList()
-			rule.Block = declarations
-
-			selector, err := ParseSimpleSelector(&prelude)
-
-			if err != nil {
-				return Rule{}, err
-			}
-
-			rule.Selector = append(rule.Selector, selector)
-
-			return rule, nil
+			return p.styleRule(prelude, block)
+		// blocks of nested rule lists are already consumed
 		case p.isCurrent(TSimpleBlack):
 			if b, ok := p.input[p.pos].(*SimpleBlock); ok && b.BlockType == Token_Clearly_Close {
-				declarationParser := CssParser{}
-				declarationParser.input = (*b).Tokens
-				declarationParser.len = len((*b).Tokens)
-				declarations, _ := declarationParser.ConsumeDeclarationsList()
-				rule.Block = declarations
-				return rule, nil
+				p.pos++
+				return p.styleRule(prelude, *b)
 			}
+			fallthrough
 		default:
 			result, err := p.ConsumeComponentValue()
 			if err != nil {
-				return Rule{}, err
+				return nil, err
 			}
 			prelude = append(prelude, result)
 		}
 	}
 }
-func (p *CssParser) ConsumeStyleBlockContents() {}
+
+/*
+Parses the prelude of a qualified rule as a selector list and its block as declarations and
+nested rules. The nested rules follow the rule, flattened with their selectors resolved against
+the rule's selectors.
+*/
+func (p *CssParser) styleRule(prelude []Token, block SimpleBlock) ([]Rule, error) {
+	selectors, err := ParseNestedSelectorList(&prelude, p.nesting)
+	if err != nil {
+		p.Diagnostics = append(p.Diagnostics, Diagnostic{Message: "invalid selector: " + err.Error()})
+		return nil, err
+	}
+
+	contentParser := CssParser{nesting: selectors}
+	contentParser.input = block.Tokens
+	contentParser.len = len(block.Tokens)
+	declarations, nested := contentParser.ConsumeStyleBlockContents()
+	p.Diagnostics = append(p.Diagnostics, contentParser.Diagnostics...)
+	p.declareLayers(contentParser.layers...)
+
+	return append([]Rule{{Selector: selectors, Block: declarations}}, nested...), nil
+}
+
+/*
+Consumes the contents of a style rule's block, its declarations and the rules nested in it.
+Declarations that follow a nested rule are placed in a rule of their own matching like &, so
+they keep their place in the cascade.
+
+Source: https://www.w3.org/TR/css-syntax-3/#consume-block-contents
+*/
+func (p *CssParser) ConsumeStyleBlockContents() ([]Declaration, []Rule) {
+	declarations := []Declaration{}
+	rules := []Rule{}
+	pending := []Declaration{}
+	afterRule := false
+
+	flush := func() {
+		if len(pending) > 0 {
+			rules = append(rules, p.nestedDeclarationsRule(pending))
+			pending = []Declaration{}
+		}
+	}
+	addRules := func(nested []Rule) {
+		flush()
+		rules = append(rules, nested...)
+		afterRule = true
+	}
+
+	for {
+		switch {
+		case p.isCurrent(Token_Whitespace) || p.isCurrent(Token_Semicolon):
+			p.pos++
+		case p.eof() || p.isCurrent(Token_EOF):
+			flush()
+			return declarations, rules
+		case p.isCurrent(Token_At_Keyword):
+			atRule, err := p.ConsumeAtRule()
+			if err != nil {
+				continue
+			}
+			// other at-rules are not allowed in style rules
+			if nested, ok := p.conditionalRules(atRule); ok {
+				addRules(nested)
+			}
+		default:
+			start := p.pos
+			if longhands, ok := p.consumeNestedDeclaration(); ok {
+				if afterRule {
+					pending = append(pending, longhands...)
+				} else {
+					declarations = append(declarations, longhands...)
+				}
+				continue
+			}
+
+			// what is not a declaration is read again as a nested rule
+			p.pos = start
+			if nested, err := p.ConsumeQualifiedRule(); err == nil {
+				addRules(nested)
+			}
+		}
+	}
+}
+
+/*
+Consumes a declaration of a style block. Returns false when the input is not a declaration,
+such as a nested rule starting with an identifier. Invalid declarations are dropped.
+*/
+func (p *CssParser) consumeNestedDeclaration() ([]Declaration, bool) {
+	if !p.isCurrent(Token_Ident) {
+		return nil, false
+	}
+	declaration, err := p.ConsumeDeclaration()
+	if err != nil {
+		return nil, false
+	}
+
+	// a value with a {} block is a rule such as "a:hover { ... }", custom properties can hold blocks
+	if !strings.HasPrefix(declaration.Name, "--") && slices.ContainsFunc(declaration.tokens, func(token Token) bool {
+		block, ok := token.(*SimpleBlock)
+		return ok && block.BlockType == Token_Clearly_Close
+	}) {
+		return nil, false
+	}
+
+	if err := ValidateDeclaration(&declaration); err != nil {
+		p.Diagnostics = append(p.Diagnostics, Diagnostic{Property: declaration.Name, Message: err.Error()})
+		return []Declaration{}, true
+	}
+	longhands, _ := expandShorthand(declaration, declaration.tokens)
+	return longhands, true
+}
+
+// https://www.w3.org/TR/css-nesting-1/#nested-declarations-rule
+func (p *CssParser) nestedDeclarationsRule(declarations []Declaration) Rule {
+	selector := Selector{
+		Classes:    mapset.NewSet[string](),
+		Attributes: map[string]SelectorAttribute{},
+		Nesting:    p.nesting,
+	}
+	return Rule{Selector: []Selector{selector}, Block: declarations}
+}
+
 func (p *CssParser) ConsumeDeclarationsList() ([]Declaration, []AtRule) {
+	return p.consumeDeclarations(true)
+}
+
+// Consumes the descriptors of an at-rule such as @font-face, they are not checked against properties.
+func (p *CssParser) ConsumeDescriptorsList() []Declaration {
+	descriptors, _ := p.consumeDeclarations(false)
+	return descriptors
+}
+
+func (p *CssParser) consumeDeclarations(properties bool) ([]Declaration, []AtRule) {
 
 	declarations := []Declaration{}
 	atRules := []AtRule{}
@@ -215,28 +590,56 @@ func (p *CssParser) ConsumeDeclarationsList() ([]Declaration, []AtRule) {
 					'color' is the ident and the ':' would be the value from ConsumeComponentValue(https://www.w3.org/TR/css-syntax-3/#consume-a-component-value)
 					and calling consumeDeclaration would return a declaration of color with no value
 			*/
-			if !p.isCurrent(Token_Semicolon) || !p.isCurrent(Token_EOF) {
-				dec, err := p.ConsumeDeclaration()
-				if err == nil {
-					declarations = append(declarations, dec)
-				}
+			dec, err := p.ConsumeDeclaration()
+			if err != nil {
+				p.Diagnostics = append(p.Diagnostics, Diagnostic{Property: dec.Name, Message: err.Error()})
+				p.consumeBadDeclaration()
+				continue
 			}
-		default:
-			p.pos++
-			if !p.isCurrent(Token_Semicolon) || !p.isCurrent(Token_EOF) {
-				// and throw away the returned value.
-				p.ConsumeComponentValue()
+			if !properties {
+				declarations = append(declarations, dec)
+				continue
+			}
+
+			longhands, err := expandDeclaration(dec)
+			if err != nil {
+				p.Diagnostics = append(p.Diagnostics, Diagnostic{Property: dec.Name, Message: err.Error()})
+				continue
 			}
+			declarations = append(declarations, longhands...)
+		default:
+			// This is a parse error.
+			p.consumeBadDeclaration()
 		}
 	}
 
 }
+
+// Skips the component values of a bad declaration up to the next ';', blocks included.
+// https://www.w3.org/TR/css-syntax-3/#consume-list-of-declarations
+func (p *CssParser) consumeBadDeclaration() {
+	for !p.eof() && !p.isCurrent(Token_Semicolon) && !p.isCurrent(Token_EOF) {
+		p.ConsumeComponentValue()
+	}
+}
+
+// Invalid declarations are dropped, shorthands are stored as their longhands.
+func expandDeclaration(dec Declaration) ([]Declaration, error) {
+	if err := ValidateDeclaration(&dec); err != nil {
+		return nil, err
+	}
+	longhands, _ := expandShorthand(dec, dec.tokens)
+	return longhands, nil
+}
+
+// https://www.w3.org/TR/css-syntax-3/#consume-declaration
 func (p *CssParser) ConsumeDeclaration() (Declaration, error) {
 
 	ident := p.input[p.pos]
 	var name string
 	if i, ok := ident.(*StringToken); ok {
-		name = string(i.Value)
+		// property names are ASCII case-insensitive, custom property names are not
+		name = normalizePropertyName(string(i.Value))
 	}
 
 	decValue := []Token{}
@@ -247,7 +650,7 @@ func (p *CssParser) ConsumeDeclaration() (Declaration, error) {
 	}
 
 	if !p.isCurrent(Token_Colon) {
-		return Declaration{}, fmt.Errorf("expected to find token ':' but got token: %d", p.input[p.pos].GetId())
+		return Declaration{Name: name}, fmt.Errorf("expected ':' after '%s'", name)
 	}
 	p.pos++ // eat ':'
 
@@ -256,7 +659,7 @@ func (p *CssParser) ConsumeDeclaration() (Declaration, error) {
 	}
 
 	// spec does says only to watch for EOF but should probably watch for ';' token?
-	for !p.eof() && !p.isCurrent(Token_Semicolon) {
+	for !p.eof() && !p.isCurrent(Token_Semicolon) && !p.isCurrent(Token_EOF) {
 		value, err := p.ConsumeComponentValue()
 		if err != nil {
 			return Declaration{}, err
@@ -265,33 +668,32 @@ func (p *CssParser) ConsumeDeclaration() (Declaration, error) {
 		decValue = append(decValue, value)
 	}
 
-	for decValue[len(decValue)-1].GetId() == Token_Whitespace {
+	for len(decValue) > 0 && decValue[len(decValue)-1].GetId() == Token_Whitespace {
 		decValue = decValue[:len(decValue)-1]
 	}
 
+	// the last two non white space tokens are '!' and 'important'
 	important := false
-	if len(decValue) > 2 {
-		markToken := decValue[len(decValue)-2]
-		importantToken := decValue[len(decValue)-1]
-
-		if isRune('!', &markToken) && isStringCaseInsensitive("important", &importantToken) {
+	if len(decValue) > 0 && isStringCaseInsensitive("important", &decValue[len(decValue)-1]) {
+		mark := trimWhitespace(decValue[:len(decValue)-1])
+		if len(mark) > 0 && isRune('!', &mark[len(mark)-1]) {
 			important = true
-			decValue = decValue[:len(decValue)-2]
+			decValue = trimWhitespace(mark[:len(mark)-1])
 		}
 	}
 
-	for decValue[len(decValue)-1].GetId() == Token_Whitespace {
-		decValue = decValue[:len(decValue)-1]
-	}
-
 	return Declaration{
 		Value:     ParseCssValue(decValue),
 		Name:      name,
 		Important: important,
+		tokens:    decValue,
 	}, nil
 }
 
 func (p *CssParser) ConsumeComponentValue() (Token, error) {
+	if p.eof() {
+		return nil, fmt.Errorf("unexpected end of input")
+	}
 
 	if p.isCurrent(Token_Clearly_Open) || p.isCurrent(Token_Square_Bracket_Open) || p.isCurrent(Token_Pren_Open) {
 		result, err := p.ConsumeSimpleBlock()
@@ -327,8 +729,9 @@ func (p *CssParser) ConsumeSimpleBlock() (SimpleBlock, error) {
 		case p.isCurrent(bracketEnd):
 			p.pos++ // eat end bracket
 			return SimpleBlock{Tokens: tokens, BlockType: bracketEnd}, nil
-		case p.eof():
-			return SimpleBlock{Tokens: tokens, BlockType: bracketEnd}, fmt.Errorf("found EOF")
+		// This is a parse error, the block is closed at the end of the input.
+		case p.eof() || p.isCurrent(Token_EOF):
+			return SimpleBlock{Tokens: tokens, BlockType: bracketEnd}, nil
 		default:
 			result, err := p.ConsumeComponentValue()
 
@@ -351,8 +754,9 @@ func (p *CssParser) ConsumeFunction() (Token, error) {
 	p.pos++
 
 	args := []Token{}
+	spaced := []Token{}
 
-	for !p.eof() && !p.isCurrent(Token_Pren_Close) {
+	for !p.eof() && !p.isCurrent(Token_Pren_Close) && !p.isCurrent(Token_EOF) {
 
 		result, err := p.ConsumeComponentValue()
 
@@ -362,14 +766,24 @@ func (p *CssParser) ConsumeFunction() (Token, error) {
 		if result.GetId() != Token_Whitespace {
 			args = append(args, result)
 		}
+		spaced = append(spaced, result)
 	}
-	p.pos++
-
-	if p.eof() {
-		return &FunctionBlock{Args: args, Name: name}, fmt.Errorf("found EOF")
+	// This is a parse error when the input ends first, the function is closed at the end of the input.
+	if p.isCurrent(Token_Pren_Close) {
+		p.pos++
 	}
 
-	return &FunctionBlock{Args: args, Name: name}, nil
+	return &FunctionBlock{Args: args, Name: name, spaced: spaced}, nil
+}
+
+// Records layer names the first time they are declared, later declarations keep their position.
+// https://www.w3.org/TR/css-cascade-5/#layer-ordering
+func (p *CssParser) declareLayers(names ...string) {
+	for _, name := range names {
+		if !slices.Contains(p.layers, name) {
+			p.layers = append(p.layers, name)
+		}
+	}
 }
 
 func (p *CssParser) isCurrent(t TokenType) bool {
@@ -379,6 +793,12 @@ func (p *CssParser) isCurrent(t TokenType) bool {
 	return p.input[p.pos].GetId() == t
 }
 
+func (p *CssParser) skipWhitespace() {
+	for p.isCurrent(Token_Whitespace) {
+		p.pos++
+	}
+}
+
 func (p *CssParser) eof() bool {
 	return p.pos >= p.len
 }
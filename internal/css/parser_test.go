@@ -1,6 +1,8 @@
 package plex_css_test
 
 import (
+	"reflect"
+	"strings"
 	"testing"
 	plex_css "visualsource/plex/internal/css"
 
@@ -78,3 +80,669 @@ func TestParseStylesheet(t *testing.T) {
 
 	dump.P(stylesheet)
 }
+
+func parseDeclarationMap(t *testing.T, value string) plex_css.CssPropertyMap {
+	parser := plex_css.CssParser{}
+	declarations, err := parser.ParseDeclarationsList(value)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	props := plex_css.CssPropertyMap{}
+	for _, dec := range declarations {
+		props[dec.Name] = dec
+	}
+	return props
+}
+
+func expectDimention(t *testing.T, props plex_css.CssPropertyMap, name string, value float32) {
+	dec, ok := props[name]
+	if !ok {
+		t.Fatalf("expected %s to be set", name)
+	}
+	if d, ok := dec.GetValue().(*plex_css.CssDimention); !ok || d.Value != value {
+		t.Fatalf("expected %s to be %v, got %v", name, value, dec.GetValue())
+	}
+}
+
+func expectKeyword(t *testing.T, props plex_css.CssPropertyMap, name string, keyword string) {
+	dec, ok := props[name]
+	if !ok || !plex_css.IsCssKeyword(dec.GetValue(), keyword) {
+		t.Fatalf("expected %s to be '%s', got %v", name, keyword, dec.Value)
+	}
+}
+
+func TestShorthand_BOX(t *testing.T) {
+	props := parseDeclarationMap(t, "margin: 4px 8px; padding: 1px 2px 3px !important")
+
+	if _, ok := props["margin"]; ok {
+		t.Fatalf("expected the shorthand to be replaced by its longhands")
+	}
+
+	expectDimention(t, props, "margin-top", 4)
+	expectDimention(t, props, "margin-right", 8)
+	expectDimention(t, props, "margin-bottom", 4)
+	expectDimention(t, props, "margin-left", 8)
+
+	expectDimention(t, props, "padding-top", 1)
+	expectDimention(t, props, "padding-right", 2)
+	expectDimention(t, props, "padding-bottom", 3)
+	expectDimention(t, props, "padding-left", 2)
+
+	if !props["padding-left"].Important {
+		t.Fatalf("expected longhands to keep !important")
+	}
+}
+
+func TestShorthand_BORDER(t *testing.T) {
+	props := parseDeclarationMap(t, "border: 1px solid red; border-left: dashed")
+
+	expectDimention(t, props, "border-top-width", 1)
+	expectKeyword(t, props, "border-bottom-style", "solid")
+	expectKeyword(t, props, "border-right-color", "red")
+
+	// omitted values are reset to their initial value
+	expectKeyword(t, props, "border-left-style", "dashed")
+	expectKeyword(t, props, "border-left-width", "medium")
+	expectKeyword(t, props, "border-left-color", "currentcolor")
+}
+
+func TestShorthand_FONT(t *testing.T) {
+	props := parseDeclarationMap(t, `font: italic bold 12px/1.5 "Open Sans", Ubuntu Mono, sans-serif`)
+
+	expectKeyword(t, props, "font-style", "italic")
+	expectKeyword(t, props, "font-weight", "bold")
+	expectKeyword(t, props, "font-variant", "normal")
+	expectDimention(t, props, "font-size", 12)
+	expectDimention(t, props, "line-height", 1.5)
+
+	family := props["font-family"]
+	list, ok := family.GetValue().(*plex_css.CssList)
+	if !ok || list.Separator != ',' || len(list.Values) != 3 {
+		t.Fatalf("expected a list of 3 font families, got %v", props["font-family"].Value)
+	}
+	if s, ok := list.Values[1].(*plex_css.CssString); !ok || s.Value != "Ubuntu Mono" {
+		t.Fatalf("expected identifiers to be joined into a family name, got %v", list.Values[1])
+	}
+}
+
+func TestShorthand_MISC(t *testing.T) {
+	props := parseDeclarationMap(t, "background: url(x.png) no-repeat #ffffff; list-style: none inside; flex: 2; grid-area: a / 2; text-decoration: underline overline red; inset: auto 0")
+
+	expectKeyword(t, props, "background-repeat", "no-repeat")
+	image := props["background-image"]
+	if url, ok := image.GetValue().(*plex_css.CssUrl); !ok || url.Url != "x.png" {
+		t.Fatalf("expected background-image to be an url, got %v", props["background-image"].Value)
+	}
+	color := props["background-color"]
+	if _, ok := color.GetValue().(*plex_css.CssColor); !ok {
+		t.Fatalf("expected background-color to be set, got %v", props["background-color"].Value)
+	}
+
+	expectKeyword(t, props, "list-style-position", "inside")
+	expectKeyword(t, props, "list-style-type", "none")
+	expectKeyword(t, props, "list-style-image", "none")
+
+	expectDimention(t, props, "flex-grow", 2)
+	expectDimention(t, props, "flex-shrink", 1)
+	expectDimention(t, props, "flex-basis", 0)
+
+	expectKeyword(t, props, "grid-row-start", "a")
+	expectDimention(t, props, "grid-column-start", 2)
+	expectKeyword(t, props, "grid-row-end", "a")
+	expectKeyword(t, props, "grid-column-end", "auto")
+
+	if len(props["text-decoration-line"].Value) != 2 {
+		t.Fatalf("expected two decoration lines, got %v", props["text-decoration-line"].Value)
+	}
+	expectKeyword(t, props, "text-decoration-color", "red")
+
+	expectKeyword(t, props, "top", "auto")
+	expectDimention(t, props, "left", 0)
+}
+
+func TestShorthand_INVALID(t *testing.T) {
+	props := parseDeclarationMap(t, "margin: 1px 2px 3px 4px 5px; border: 1px 2px; font: bold Ubuntu; flex: 1 2 3 4; padding: -1px; color: red")
+
+	for name := range props {
+		if name != "color" {
+			t.Fatalf("expected invalid shorthands to be dropped, found %s", name)
+		}
+	}
+}
+
+func TestShorthand_CSS_WIDE_KEYWORD(t *testing.T) {
+	props := parseDeclarationMap(t, "margin: inherit")
+
+	for _, side := range []string{"top", "right", "bottom", "left"} {
+		expectKeyword(t, props, "margin-"+side, "inherit")
+	}
+}
+
+func TestShorthand_CASE_INSENSITIVE(t *testing.T) {
+	props := parseDeclarationMap(t, "MARGIN: 0 AUTO; Border: 1px SOLID red")
+
+	expectDimention(t, props, "margin-top", 0)
+	expectKeyword(t, props, "margin-left", "auto")
+	expectKeyword(t, props, "border-top-style", "solid")
+}
+
+func TestValidation_INVALID(t *testing.T) {
+	parser := plex_css.CssParser{}
+	declarations, err := parser.ParseDeclarationsList("width: red; colr: blue; display: flex; font-weight: 1200; color: red")
+	if err != nil {
+		t.Fatalf("failed to parse declarations: %s", err)
+	}
+
+	if len(declarations) != 2 || declarations[0].Name != "display" || declarations[1].Name != "color" {
+		t.Fatalf("expected only the valid declarations to be kept, got %v", declarations)
+	}
+
+	expected := []string{"width", "colr", "font-weight"}
+	if len(parser.Diagnostics) != len(expected) {
+		t.Fatalf("expected %d diagnostics got %v", len(expected), parser.Diagnostics)
+	}
+	for i, diagnostic := range parser.Diagnostics {
+		if diagnostic.Property != expected[i] || diagnostic.Message == "" {
+			t.Fatalf("unexpected diagnostic %v", diagnostic)
+		}
+	}
+}
+
+func TestValidation_CASE_INSENSITIVE(t *testing.T) {
+	parser := plex_css.CssParser{}
+	declarations, err := parser.ParseDeclarationsList("COLOR: red; Margin-Top: 1px; display: BLOCK; float: LEFT; --Mixed-Case: 1")
+	if err != nil {
+		t.Fatalf("failed to parse declarations: %s", err)
+	}
+
+	if len(parser.Diagnostics) != 0 {
+		t.Fatalf("expected property names and keywords to match in any case, got %v", parser.Diagnostics)
+	}
+	names := []string{}
+	for _, declaration := range declarations {
+		names = append(names, declaration.Name)
+	}
+	expected := []string{"color", "margin-top", "display", "float", "--Mixed-Case"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("expected %q, got %q", expected, names)
+	}
+}
+
+func TestValidation_GRAMMAR(t *testing.T) {
+	valid := []string{
+		"font-family: \"Ubuntu Mono\", Ubuntu, sans-serif",
+		"content: \"a\" counter(item) open-quote",
+		"quotes: \"<\" \">\" \"[\" \"]\"",
+		"counter-reset: item 2 section",
+		"background-position: left 10px, 50% 50%",
+		"text-decoration-line: underline overline",
+		"width: calc(100% - 10px)",
+		"width: 10VMIN",
+		"margin: 1Q 2pc 3pt 4rlh",
+		"--custom: { anything }",
+	}
+	invalid := []string{
+		"font-family: Ubuntu,",
+		"quotes: \"<\"",
+		"counter-reset: 2",
+		"text-decoration-line: underline underline",
+		"width: -10px",
+		"display: block inline",
+		"width: 10foo",
+		"line-height: 2deg",
+	}
+
+	for _, source := range valid {
+		parser := plex_css.CssParser{}
+		parser.ParseDeclarationsList(source)
+		if len(parser.Diagnostics) != 0 {
+			t.Fatalf("expected '%s' to be valid, got %v", source, parser.Diagnostics)
+		}
+	}
+	for _, source := range invalid {
+		parser := plex_css.CssParser{}
+		parser.ParseDeclarationsList(source)
+		if len(parser.Diagnostics) != 1 {
+			t.Fatalf("expected '%s' to be invalid", source)
+		}
+	}
+}
+
+func TestValidation_STYLESHEET_DIAGNOSTICS(t *testing.T) {
+	parser := plex_css.CssParser{}
+	stylesheet, err := parser.ParseStylesheet("p { color: 12px; margin: 0 } div { widht: 10px }", plex_css.Origin_Author)
+	if err != nil {
+		t.Fatalf("failed to parse stylesheet: %s", err)
+	}
+
+	if len(stylesheet.Diagnostics) != 2 {
+		t.Fatalf("expected 2 diagnostics got %v", stylesheet.Diagnostics)
+	}
+	if len(stylesheet.Rules[0].Block) != 4 || len(stylesheet.Rules[1].Block) != 0 {
+		t.Fatalf("expected invalid declarations to be removed from the rules")
+	}
+}
+
+func TestCustomProperty_SUBSTITUTION(t *testing.T) {
+	parser := plex_css.CssParser{}
+	declarations, _ := parser.ParseDeclarationsList("--empty:; --size: 2px 3px; padding: var(--size); width: var(--size)")
+	if len(parser.Diagnostics) != 0 {
+		t.Fatalf("expected declarations with var() to be valid at parse time, got %v", parser.Diagnostics)
+	}
+
+	variables := map[string][]plex_css.Token{}
+	for _, dec := range declarations {
+		if plex_css.IsCustomProperty(dec.Name) {
+			variables[dec.Name] = dec.GetTokens()
+		}
+	}
+	lookup := func(name string) ([]plex_css.Token, bool) {
+		tokens, ok := variables[name]
+		return tokens, ok
+	}
+
+	if _, ok := variables["--empty"]; !ok {
+		t.Fatalf("expected an empty custom property to be kept")
+	}
+
+	props := plex_css.CssPropertyMap{}
+	for _, dec := range declarations {
+		if !dec.ContainsVar() {
+			continue
+		}
+		if resolved, err := plex_css.SubstituteVariables(dec, lookup); err == nil {
+			props[resolved.Name] = resolved
+		}
+	}
+
+	expectDimention(t, props, "padding-top", 2)
+	expectDimention(t, props, "padding-right", 3)
+	expectDimention(t, props, "padding-left", 3)
+	if _, ok := props["width"]; ok {
+		t.Fatalf("expected 'width: 2px 3px' to be invalid at computed-value time")
+	}
+}
+
+func TestParseRule(t *testing.T) {
+	parser := plex_css.CssParser{}
+	rule, err := parser.ParseRule(" p.note { color: red } ")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if text := rule.CssText(); text != "p.note { color: red; }" {
+		t.Fatalf("unexpected rule %q", text)
+	}
+
+	for _, text := range []string{"", "@media screen { }", "p { } div { }", "p"} {
+		if _, err := parser.ParseRule(text); err == nil {
+			t.Errorf("expected %q to be invalid", text)
+		}
+	}
+}
+
+func TestDeclarationBlock_PROPERTIES(t *testing.T) {
+	block, err := plex_css.SetProperty(nil, "Color", "red", "")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	block, err = plex_css.SetProperty(block, "margin", "1px 2px", "important")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if value := plex_css.GetPropertyValue(block, "margin"); value != "1px 2px" {
+		t.Fatalf("expected the margin shorthand to be '1px 2px', got %q", value)
+	}
+	if priority := plex_css.GetPropertyPriority(block, "margin"); priority != "important" {
+		t.Fatalf("expected margin to be important, got %q", priority)
+	}
+
+	// updating a property keeps its position
+	block, _ = plex_css.SetProperty(block, "color", "blue", "")
+	if text := plex_css.SerializeDeclarations(block); text != "color: blue; margin-top: 1px !important; margin-right: 2px !important; margin-bottom: 1px !important; margin-left: 2px !important;" {
+		t.Fatalf("unexpected declarations %q", text)
+	}
+
+	for _, value := range []string{"10", "red; width: 10px"} {
+		if _, err := plex_css.SetProperty(block, "color", value, ""); err == nil {
+			t.Errorf("expected 'color: %s' to be rejected", value)
+		}
+	}
+
+	block, value := plex_css.RemoveProperty(block, "margin")
+	if value != "1px 2px" || len(block) != 1 {
+		t.Fatalf("expected only color to be left, got %v %q", block, value)
+	}
+	if value := plex_css.GetPropertyValue(block, "margin-top"); value != "" {
+		t.Fatalf("expected margin-top to be removed, got %q", value)
+	}
+}
+
+func TestStylesheet_INSERT_DELETE_RULE(t *testing.T) {
+	parser := plex_css.CssParser{}
+	stylesheet, _ := parser.ParseStylesheet("p { color: red }", plex_css.Origin_Author)
+
+	if _, err := stylesheet.InsertRule("div { color: blue }", 0); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if _, err := stylesheet.InsertRule("span { }", 5); err == nil {
+		t.Fatalf("expected an out of range index to fail")
+	}
+	if err := stylesheet.Rules[1].SetSelectorText("p, .a"); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if text := stylesheet.CssText(); text != "div { color: blue; }\np, .a { color: red; }" {
+		t.Fatalf("unexpected stylesheet %q", text)
+	}
+
+	if err := stylesheet.DeleteRule(0); err != nil || len(stylesheet.Rules) != 1 {
+		t.Fatalf("expected the first rule to be deleted, %v", err)
+	}
+}
+
+func TestParseStylesheet_IMPORT(t *testing.T) {
+	parser := plex_css.CssParser{}
+	stylesheet, err := parser.ParseStylesheet(`
+		@charset "utf-8";
+		@import url("a.css") layer(theme.base) supports(display: flex) screen and (min-width: 400px);
+		@import 'b.css' layer;
+		@import url(c.css);
+		@import "d.css" supports((display: block) and (not (color: 10)));
+		p { color: red }
+		@import "e.css";
+	`, plex_css.Origin_Author)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if len(stylesheet.Imports) != 4 {
+		t.Fatalf("expected the import after the style rule to be dropped, got %d imports", len(stylesheet.Imports))
+	}
+
+	first := stylesheet.Imports[0]
+	if first.Href != "a.css" || !first.Layered || first.Layer != "theme.base" || first.Media.CssText() != "screen and (min-width: 400px)" {
+		t.Fatalf("unexpected import %v", first)
+	}
+	if !first.IsSupported() || !stylesheet.Imports[3].IsSupported() {
+		t.Fatalf("expected the supports() conditions to match")
+	}
+	if second := stylesheet.Imports[1]; second.Href != "b.css" || !second.Layered || second.Layer != "" {
+		t.Fatalf("expected an anonymous layer, got %v", second)
+	}
+	if third := stylesheet.Imports[2]; third.Href != "c.css" || third.Layered || len(third.Media.Queries) != 0 {
+		t.Fatalf("unexpected import %v", third)
+	}
+}
+
+func TestParseStylesheet_IMPORT_ROUND_TRIP(t *testing.T) {
+	source := `@import url("a.css") layer(theme.base) supports(display: flex) screen and (min-width: 400px);
+@import "d.css" supports((display:block) and (not (color: 10)));`
+
+	parser := plex_css.CssParser{}
+	stylesheet, err := parser.ParseStylesheet(source, plex_css.Origin_Author)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if text := stylesheet.CssText(); text != source {
+		t.Fatalf("expected %q, got %q", source, text)
+	}
+
+	reparsed, err := parser.ParseStylesheet(stylesheet.CssText(), plex_css.Origin_Author)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if len(reparsed.Imports) != 2 || reparsed.Imports[0].Layer != "theme.base" || !reparsed.Imports[0].IsSupported() || !reparsed.Imports[1].IsSupported() {
+		t.Fatalf("expected the serialized imports to parse back, got %v", reparsed.Imports)
+	}
+}
+
+func TestParseStylesheet_FONT_FACE(t *testing.T) {
+	parser := plex_css.CssParser{}
+	stylesheet, err := parser.ParseStylesheet(`
+		@font-face {
+			font-family: "Ubuntu";
+			src: local(Ubuntu Bold), url(fonts/ubuntu.woff2) format("woff2") tech(variations), url("Ubuntu-Bold.ttf") format(truetype), foo("bar");
+			font-weight: 700 600;
+			font-style: italic;
+			unicode-range: U+0000-00FF, u+4??, U+20AC;
+			font-display: swap;
+		}
+		@font-face { font-family: Open Sans; src: url(open.ttf); font-weight: heavy; unicode-range: U+110000 }
+		@font-face { src: url(missing-family.ttf) }
+		p { font-family: Ubuntu }
+	`, plex_css.Origin_Author)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if len(stylesheet.FontFaces) != 2 || len(stylesheet.AtRules) != 0 || len(stylesheet.Rules) != 1 {
+		t.Fatalf("expected 2 font faces, got %v", stylesheet.FontFaces)
+	}
+	if len(stylesheet.Diagnostics) != 1 {
+		t.Fatalf("expected the face without a family to be reported, got %v", stylesheet.Diagnostics)
+	}
+
+	face := stylesheet.FontFaces[0]
+	if face.Family != "Ubuntu" || face.Weight != [2]int{600, 700} || face.Style != "italic" || face.Display != "swap" {
+		t.Fatalf("unexpected descriptors %+v", face)
+	}
+	expected := []plex_css.FontFaceSource{
+		{Local: "Ubuntu Bold"},
+		{Url: "fonts/ubuntu.woff2", Format: "woff2", Tech: []string{"variations"}},
+		{Url: "Ubuntu-Bold.ttf", Format: "truetype"},
+	}
+	if !reflect.DeepEqual(face.Sources, expected) {
+		t.Fatalf("expected sources %v, got %v", expected, face.Sources)
+	}
+	if face.Sources[1].IsSupported() || !face.Sources[2].IsSupported() {
+		t.Fatalf("expected only truetype sources to be supported")
+	}
+
+	ranges := []plex_css.UnicodeRange{{Start: 0, End: 0xFF}, {Start: 0x400, End: 0x4FF}, {Start: 0x20AC, End: 0x20AC}}
+	if !reflect.DeepEqual(face.UnicodeRange, ranges) {
+		t.Fatalf("expected unicode ranges %v, got %v", ranges, face.UnicodeRange)
+	}
+	if !face.Covers('a') || !face.Covers('€') || !face.Covers('Ѐ') || face.Covers('あ') {
+		t.Fatalf("unexpected unicode range coverage")
+	}
+
+	// invalid descriptors keep their initial value
+	face = stylesheet.FontFaces[1]
+	if face.Family != "Open Sans" || face.Weight != [2]int{400, 400} || face.Style != "normal" || len(face.UnicodeRange) != 0 {
+		t.Fatalf("unexpected descriptors %+v", face)
+	}
+}
+
+func TestParseStylesheet_NESTING(t *testing.T) {
+	parser := plex_css.CssParser{}
+	stylesheet, err := parser.ParseStylesheet(`
+		.card, #main {
+			padding: 1px;
+			& > h2 { margin-top: 0 }
+			&.active { color: red }
+			p span { color: blue }
+			+ aside { color: green }
+			a:hover { color: red }
+			color: black;
+			@media (min-width: 500px) {
+				margin-top: 2px;
+				em { color: white }
+			}
+			colr: red;
+		}
+		& { color: red }
+		div { color: white }
+	`, plex_css.Origin_Author)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	expected := []string{
+		".card, #main { padding-top: 1px; padding-right: 1px; padding-bottom: 1px; padding-left: 1px; }",
+		":is(.card, #main) > h2 { margin-top: 0; }",
+		".active:is(.card, #main) { color: red; }",
+		":is(.card, #main) p span { color: blue; }",
+		":is(.card, #main) + aside { color: green; }",
+		":is(.card, #main) a:hover { color: red; }",
+		":is(.card, #main) { color: black; }",
+		"@media (min-width: 500px) { :is(.card, #main) { margin-top: 2px; } }",
+		"@media (min-width: 500px) { :is(.card, #main) em { color: white; } }",
+		"div { color: white; }",
+	}
+	if len(stylesheet.Rules) != len(expected) {
+		t.Fatalf("expected %d rules, got %q", len(expected), stylesheet.CssText())
+	}
+	for i, rule := range stylesheet.Rules {
+		if text := rule.CssText(); text != expected[i] {
+			t.Errorf("expected rule %d to be %q, got %q", i, expected[i], text)
+		}
+	}
+
+	// & counts like :is() with the most specific parent selector
+	specificities := map[int]plex_css.Specificity{
+		1: {A: 1, C: 1},
+		2: {A: 1, B: 1},
+		3: {A: 1, C: 2},
+		5: {A: 1, B: 1, C: 1},
+		6: {A: 1},
+	}
+	for i, expected := range specificities {
+		if specificity := stylesheet.Rules[i].Selector[0].GetSpecificity(); specificity != expected {
+			t.Errorf("expected rule %d to have specificity %v, got %v", i, expected, specificity)
+		}
+	}
+
+	single, err := parser.ParseStylesheet(`.a { .b & { color: red } & + & { color: blue } }`, plex_css.Origin_Author)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text := single.CssText(); text != ".a { }\n.b .a { color: red; }\n.a + .a { color: blue; }" {
+		t.Fatalf("unexpected nested rules %q", text)
+	}
+}
+
+func TestParseStylesheet_PSEUDO_CLASS(t *testing.T) {
+	parser := plex_css.CssParser{}
+	stylesheet, err := parser.ParseStylesheet(`
+		.card { &:hover { color: red } }
+		a:FOCUS-visible, #main:target { color: blue }
+		a:link { color: green }
+	`, plex_css.Origin_Author)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the state pseudo-classes are kept, other ones drop their rule
+	if text := stylesheet.CssText(); text != ".card { }\n.card:hover { color: red; }\na:focus-visible, #main:target { color: blue; }" {
+		t.Fatalf("unexpected rules %q", text)
+	}
+	if specificity := stylesheet.Rules[1].Selector[0].GetSpecificity(); specificity != (plex_css.Specificity{B: 2}) {
+		t.Fatalf("expected the pseudo-class to count like a class, got %v", specificity)
+	}
+	if len(stylesheet.Diagnostics) != 1 || stylesheet.Diagnostics[0].Message != "invalid selector: unsupported pseudo-class: link" {
+		t.Fatalf("expected the dropped rule to be reported, got %v", stylesheet.Diagnostics)
+	}
+}
+
+func TestParseStylesheet_LAYER(t *testing.T) {
+	parser := plex_css.CssParser{}
+	stylesheet, err := parser.ParseStylesheet(`
+		@layer reset, components;
+		@layer components {
+			.btn { color: red }
+			@layer base { .btn { color: blue } }
+		}
+		@layer { p { color: green } }
+		@layer components.base { a { color: red } }
+		@layer a b;
+		@media screen { @layer print { div { color: red } } }
+		h1 { color: white }
+	`, plex_css.Origin_Author)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if len(stylesheet.AtRules) != 0 || len(stylesheet.Diagnostics) != 1 {
+		t.Fatalf("expected the @layer rules to be consumed and the invalid one to be reported, got %v %v", stylesheet.AtRules, stylesheet.Diagnostics)
+	}
+
+	layers := []string{}
+	for _, rule := range stylesheet.Rules {
+		layers = append(layers, rule.Layer)
+	}
+	expected := []string{"components", "components.base", layers[2], "components.base", "print", ""}
+	if !strings.HasPrefix(layers[2], "\x00") || !reflect.DeepEqual(layers, expected) {
+		t.Fatalf("expected rule layers %q, got %q", expected, layers)
+	}
+	if !reflect.DeepEqual(stylesheet.Layers, []string{"reset", "components", "components.base", layers[2], "print"}) {
+		t.Fatalf("unexpected layer declarations %q", stylesheet.Layers)
+	}
+
+	expectedText := "@layer { p { color: green; } }"
+	if text := stylesheet.Rules[2].CssText(); text != expectedText {
+		t.Fatalf("expected %q, got %q", expectedText, text)
+	}
+	if text := stylesheet.CssText(); !strings.HasPrefix(text, "@layer reset, components, components.base, print;\n@layer components { .btn { color: red; } }") {
+		t.Fatalf("unexpected stylesheet text %q", text)
+	}
+}
+
+func TestParseStylesheet_LAYER_ORDER(t *testing.T) {
+	parser := plex_css.CssParser{}
+	stylesheet, err := parser.ParseStylesheet("@layer a, b; @layer b { p { color: red } } @layer a { p { color: blue } } @layer b.c, a;", plex_css.Origin_Author)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	expected := []string{"a", "b", "b.c"}
+	if !reflect.DeepEqual(stylesheet.Layers, expected) {
+		t.Fatalf("expected layers %q in the order they were first declared, got %q", expected, stylesheet.Layers)
+	}
+	expectedText := "@layer a, b, b.c;\n@layer b { p { color: red; } }\n@layer a { p { color: blue; } }"
+	if text := stylesheet.CssText(); text != expectedText {
+		t.Fatalf("expected %q, got %q", expectedText, text)
+	}
+}
+
+func TestParseStylesheet_RECOVERY(t *testing.T) {
+	parser := plex_css.CssParser{}
+	stylesheet, err := parser.ParseStylesheet(`
+		<!-- p { color:; width: 1px; margin 2px; height: 3px } -->
+		a { color: rgb(1, 2 } div { color: red
+	`, plex_css.Origin_Author)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if len(stylesheet.Rules) != 2 {
+		t.Fatalf("expected 2 rules, got %v", stylesheet.Rules)
+	}
+	names := []string{}
+	for _, declaration := range stylesheet.Rules[0].Block {
+		names = append(names, declaration.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"width", "height"}) {
+		t.Fatalf("expected the invalid declarations to be skipped, got %q", names)
+	}
+	if len(stylesheet.Diagnostics) == 0 {
+		t.Fatalf("expected the invalid declarations to be reported")
+	}
+
+	// the unclosed function swallows the rest of the stylesheet
+	expected := "a { }"
+	if text := stylesheet.Rules[1].CssText(); text != expected {
+		t.Fatalf("expected %q, got %q", expected, text)
+	}
+}
+
+func TestParseDeclarationsList_RECOVERY(t *testing.T) {
+	parser := plex_css.CssParser{}
+	declarations, err := parser.ParseDeclarationsList("color red; width: 1px; ] height: 3px; height: 2px !  important")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if len(declarations) != 2 || declarations[0].Name != "width" || declarations[1].Name != "height" || !declarations[1].Important {
+		t.Fatalf("expected width and an important height, got %v", declarations)
+	}
+}
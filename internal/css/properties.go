@@ -0,0 +1,247 @@
+package plex_css
+
+import (
+	"fmt"
+	"strings"
+)
+
+type PropertyDefinition struct {
+	Name      string
+	Inherited bool
+	// initial value written in css syntax
+	Initial string
+	// values accepted besides the CSS-wide keywords
+	Grammar Grammar
+}
+
+/*
+The properties supported by the engine. Declarations of any other property are dropped.
+
+Source: https://www.w3.org/TR/CSS2/propidx.html
+*/
+var PROPERTIES = map[string]PropertyDefinition{}
+
+// parsed initial values of PROPERTIES
+var initialValues = map[string][]CssValue{}
+
+func defineProperty(name string, inherited bool, initial string, grammar Grammar) {
+	PROPERTIES[name] = PropertyDefinition{
+		Name:      name,
+		Inherited: inherited,
+		Initial:   initial,
+		Grammar:   grammar,
+	}
+}
+
+func init() {
+	size := OneOf(Keyword("auto", "min-content", "max-content", "fit-content"), NON_NEGATIVE_LENGTH_PERCENTAGE)
+	maxSize := OneOf(Keyword("none", "min-content", "max-content", "fit-content"), NON_NEGATIVE_LENGTH_PERCENTAGE)
+	offset := OneOf(Keyword("auto"), LENGTH_PERCENTAGE)
+
+	// box model
+	defineProperty("display", false, "inline", Keyword(
+		"block", "inline", "inline-block", "list-item", "none", "contents", "flow-root", "run-in",
+		"flex", "inline-flex", "grid", "inline-grid", "table", "inline-table", "table-row-group",
+		"table-header-group", "table-footer-group", "table-row", "table-cell", "table-column-group",
+		"table-column", "table-caption",
+	))
+	defineProperty("position", false, "static", Keyword("static", "relative", "absolute", "fixed", "sticky"))
+	defineProperty("float", false, "none", Keyword("none", "left", "right", "inline-start", "inline-end"))
+	defineProperty("clear", false, "none", Keyword("none", "left", "right", "both", "inline-start", "inline-end"))
+	defineProperty("box-sizing", false, "content-box", Keyword("content-box", "border-box"))
+	defineProperty("width", false, "auto", size)
+	defineProperty("height", false, "auto", size)
+	defineProperty("min-width", false, "auto", size)
+	defineProperty("min-height", false, "auto", size)
+	defineProperty("max-width", false, "none", maxSize)
+	defineProperty("max-height", false, "none", maxSize)
+	defineProperty("aspect-ratio", false, "auto", AnyOf(Keyword("auto"), RATIO))
+	defineProperty("top", false, "auto", offset)
+	defineProperty("right", false, "auto", offset)
+	defineProperty("bottom", false, "auto", offset)
+	defineProperty("left", false, "auto", offset)
+	defineProperty("z-index", false, "auto", OneOf(Keyword("auto"), INTEGER))
+	defineProperty("overflow", false, "visible", Repeat(Keyword("visible", "hidden", "clip", "scroll", "auto"), 1, 2))
+	defineProperty("visibility", true, "visible", Keyword("visible", "hidden", "collapse"))
+	defineProperty("opacity", false, "1", OneOf(NUMBER, PERCENTAGE))
+
+	for _, side := range []string{"top", "right", "bottom", "left"} {
+		defineProperty("margin-"+side, false, "0", Type(isMarginValue))
+		defineProperty("padding-"+side, false, "0", Type(isPaddingValue))
+		defineProperty("border-"+side+"-width", false, "medium", Type(isLineWidth))
+		defineProperty("border-"+side+"-style", false, "none", Type(isLineStyle))
+		defineProperty("border-"+side+"-color", false, "currentcolor", COLOR)
+	}
+
+	defineProperty("outline-width", false, "medium", Type(isLineWidth))
+	defineProperty("outline-style", false, "none", OneOf(Keyword("auto"), Type(isLineStyle)))
+	defineProperty("outline-color", false, "currentcolor", OneOf(Keyword("invert"), COLOR))
+
+	// color and background
+	defineProperty("color", true, "black", COLOR)
+	defineProperty("background-color", false, "transparent", COLOR)
+	defineProperty("background-image", false, "none", CommaList(OneOf(Keyword("none"), IMAGE)))
+	defineProperty("background-repeat", false, "repeat", CommaList(OneOf(
+		Keyword("repeat-x", "repeat-y"),
+		Repeat(Keyword("repeat", "space", "round", "no-repeat"), 1, 2),
+	)))
+	defineProperty("background-position", false, "0% 0%", CommaList(Repeat(Type(isPositionValue), 1, 4)))
+	defineProperty("background-attachment", false, "scroll", CommaList(Keyword("scroll", "fixed", "local")))
+	defineProperty("background-size", false, "auto", CommaList(OneOf(
+		Keyword("cover", "contain"),
+		Repeat(Type(isBackgroundSize), 1, 2),
+	)))
+	defineProperty("background-origin", false, "padding-box", CommaList(Keyword("border-box", "padding-box", "content-box")))
+	defineProperty("background-clip", false, "border-box", CommaList(Keyword("border-box", "padding-box", "content-box", "text")))
+
+	// SVG painting
+	defineProperty("fill", true, "black", OneOf(Keyword("none"), COLOR))
+
+	// fonts, 'medium' resolves to 16px
+	defineProperty("font-family", true, "Ubuntu", CommaList(OneOf(STRING, Repeat(CUSTOM_IDENT, 1, -1))))
+	defineProperty("font-size", true, "16px", Type(isFontSize))
+	defineProperty("font-style", true, "normal", Keyword("normal", "italic", "oblique"))
+	defineProperty("font-weight", true, "normal", Type(isFontWeight))
+	defineProperty("font-variant", true, "normal", Keyword("normal", "none", "small-caps"))
+	defineProperty("font-stretch", true, "normal", OneOf(Keyword("normal"), Keyword(FONT_STRETCH_KEYWORDS...), PERCENTAGE))
+	defineProperty("line-height", true, "normal", Type(isLineHeight))
+
+	// text
+	defineProperty("text-align", true, "start", Keyword("start", "end", "left", "right", "center", "justify", "match-parent", "justify-all"))
+	defineProperty("text-indent", true, "0", Seq(LENGTH_PERCENTAGE, Optional(AnyOf(Keyword("hanging"), Keyword("each-line")))))
+	defineProperty("text-transform", true, "none", OneOf(
+		Keyword("none"),
+		AnyOf(Keyword("capitalize", "uppercase", "lowercase"), Keyword("full-width"), Keyword("full-size-kana")),
+	))
+	defineProperty("text-shadow", true, "none", OneOf(Keyword("none"), CommaList(AnyOf(COLOR, Repeat(LENGTH, 2, 3)))))
+	defineProperty("letter-spacing", true, "normal", OneOf(Keyword("normal"), LENGTH))
+	defineProperty("word-spacing", true, "normal", OneOf(Keyword("normal"), LENGTH_PERCENTAGE))
+	defineProperty("white-space", true, "normal", Keyword("normal", "pre", "nowrap", "pre-wrap", "pre-line", "break-spaces"))
+	defineProperty("vertical-align", false, "baseline", OneOf(
+		Keyword("baseline", "sub", "super", "text-top", "text-bottom", "middle", "top", "bottom"),
+		LENGTH_PERCENTAGE,
+	))
+	defineProperty("text-decoration-line", false, "none", OneOf(
+		Keyword("none"),
+		AnyOf(Keyword("underline"), Keyword("overline"), Keyword("line-through"), Keyword("blink")),
+	))
+	defineProperty("text-decoration-style", false, "solid", Keyword("solid", "double", "dotted", "dashed", "wavy"))
+	defineProperty("text-decoration-color", false, "currentcolor", COLOR)
+	defineProperty("text-decoration-thickness", false, "auto", OneOf(Keyword("auto", "from-font"), LENGTH_PERCENTAGE))
+	defineProperty("direction", true, "ltr", Keyword("ltr", "rtl"))
+
+	// lists and generated content
+	counters := OneOf(Keyword("none"), Repeat(Seq(CUSTOM_IDENT, Optional(INTEGER)), 1, -1))
+
+	defineProperty("list-style-type", true, "disc", Type(isListStyleType))
+	defineProperty("list-style-position", true, "outside", Keyword("inside", "outside"))
+	defineProperty("list-style-image", true, "none", OneOf(Keyword("none"), IMAGE))
+	defineProperty("content", false, "normal", OneOf(
+		Keyword("normal", "none"),
+		Seq(
+			Repeat(OneOf(
+				STRING,
+				IMAGE,
+				Type(func(v CssValue) bool { return isFunctionIn(v, "counter", "counters", "attr") }),
+				Keyword("open-quote", "close-quote", "no-open-quote", "no-close-quote"),
+			), 1, -1),
+			// alternative text
+			Optional(Seq(SLASH, Repeat(STRING, 1, -1))),
+		),
+	))
+	defineProperty("quotes", true, "auto", OneOf(Keyword("auto", "none"), Repeat(Seq(STRING, STRING), 1, -1)))
+	defineProperty("counter-reset", false, "none", counters)
+	defineProperty("counter-increment", false, "none", counters)
+
+	// flex and grid items
+	gridLine := Grammar(func(values []CssValue, pos int) []int {
+		ends := []int{}
+		for end := pos + 1; end <= len(values) && end <= pos+3; end++ {
+			if isGridLine(values[pos:end]) {
+				ends = append(ends, end)
+			}
+		}
+		return ends
+	})
+
+	defineProperty("flex-grow", false, "0", NON_NEGATIVE_NUMBER)
+	defineProperty("flex-shrink", false, "1", NON_NEGATIVE_NUMBER)
+	defineProperty("flex-basis", false, "auto", OneOf(Keyword("content"), size))
+	defineProperty("grid-row-start", false, "auto", gridLine)
+	defineProperty("grid-column-start", false, "auto", gridLine)
+	defineProperty("grid-row-end", false, "auto", gridLine)
+	defineProperty("grid-column-end", false, "auto", gridLine)
+
+	// fragmentation
+	breakBetween := Keyword("auto", "avoid", "always", "all", "avoid-page", "page", "left", "right", "recto", "verso", "avoid-column", "column", "avoid-region", "region")
+	defineProperty("break-before", false, "auto", breakBetween)
+	defineProperty("break-after", false, "auto", breakBetween)
+	defineProperty("break-inside", false, "auto", Keyword("auto", "avoid", "avoid-page", "avoid-column", "avoid-region"))
+
+	// containment
+	defineProperty("container-type", false, "normal", Keyword("normal", "size", "inline-size"))
+	defineProperty("container-name", false, "none", OneOf(Keyword("none"), Repeat(Type(isContainerName), 1, -1)))
+
+	defineProperty("cursor", true, "auto", Seq(
+		Repeat(Seq(IMAGE, Optional(Seq(NUMBER, NUMBER)), COMMA), 0, -1),
+		Keyword(
+			"auto", "default", "none", "context-menu", "help", "pointer", "progress", "wait", "cell",
+			"crosshair", "text", "vertical-text", "alias", "copy", "move", "no-drop", "not-allowed",
+			"grab", "grabbing", "e-resize", "n-resize", "ne-resize", "nw-resize", "s-resize", "se-resize",
+			"sw-resize", "w-resize", "ew-resize", "ns-resize", "nesw-resize", "nwse-resize", "col-resize",
+			"row-resize", "all-scroll", "zoom-in", "zoom-out",
+		),
+	))
+
+	parser := CssParser{}
+	for name, definition := range PROPERTIES {
+		declarations, err := parser.ParseDeclarationsList(name + ":" + definition.Initial)
+		if err != nil || len(declarations) != 1 {
+			panic(fmt.Sprintf("invalid initial value for property %s: %v", name, parser.Diagnostics))
+		}
+		initialValues[name] = declarations[0].Value
+	}
+}
+
+func GetPropertyDefinition(name string) (PropertyDefinition, bool) {
+	definition, ok := PROPERTIES[name]
+	return definition, ok
+}
+
+// Returns the initial value of a supported property or nil.
+func GetInitialValue(name string) []CssValue {
+	return initialValues[name]
+}
+
+/*
+CSS-wide keywords accepted by every property.
+
+Source: https://www.w3.org/TR/css-cascade-5/#defaulting-keywords
+*/
+const (
+	Keyword_Inherit     = "inherit"
+	Keyword_Initial     = "initial"
+	Keyword_Unset       = "unset"
+	Keyword_Revert      = "revert"
+	Keyword_RevertLayer = "revert-layer"
+)
+
+// Returns the CSS-wide keyword the declaration is set to, or "".
+func (d *Declaration) GetCssWideKeyword() string {
+	if len(d.Value) != 1 {
+		return ""
+	}
+
+	keyword, ok := d.Value[0].(*CssKeyword)
+	if !ok {
+		return ""
+	}
+
+	value := strings.ToLower(keyword.Value)
+	switch value {
+	case Keyword_Inherit, Keyword_Initial, Keyword_Unset, Keyword_Revert, Keyword_RevertLayer:
+		return value
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,71 @@
+package plex_css
+
+import "strings"
+
+/*
+Properties that apply to ::first-line.
+Source: https://www.w3.org/TR/css-pseudo-4/#first-line-styling
+*/
+var FIRST_LINE_PROPERTIES = []string{
+	"font", "color", "opacity", "background", "word-spacing", "letter-spacing",
+	"text-decoration", "text-transform", "text-shadow", "line-height", "vertical-align",
+}
+
+/*
+Properties that apply to ::first-letter.
+Source: https://www.w3.org/TR/css-pseudo-4/#first-letter-styling
+*/
+var FIRST_LETTER_PROPERTIES = []string{
+	"font", "color", "opacity", "background", "margin", "padding", "border", "float",
+	"word-spacing", "letter-spacing", "text-decoration", "text-transform", "text-shadow",
+	"line-height", "vertical-align", "box-shadow",
+}
+
+/*
+Properties that apply to ::marker.
+Source: https://www.w3.org/TR/css-lists-3/#marker-properties
+*/
+var MARKER_PROPERTIES = []string{
+	"font", "color", "white-space", "content", "direction", "unicode-bidi",
+	"text-combine-upright", "animation", "transition",
+}
+
+/*
+Properties that apply to highlight pseudo-elements such as ::selection.
+Source: https://www.w3.org/TR/css-pseudo-4/#highlight-styling
+*/
+var HIGHLIGHT_PROPERTIES = []string{
+	"color", "background-color", "text-decoration", "text-shadow",
+	"stroke-color", "fill-color", "stroke-width",
+}
+
+// Reports whether the property may be set on the given pseudo-element.
+// Matching is done on the property family so 'font' also allows 'font-size'.
+func IsPropertyAllowedOnPseudoElement(pseudo string, property string) bool {
+	// custom properties apply everywhere
+	if strings.HasPrefix(property, "--") {
+		return true
+	}
+
+	var allowed []string
+	switch pseudo {
+	case PseudoElement_FirstLine, PseudoElement_Placeholder:
+		allowed = FIRST_LINE_PROPERTIES
+	case PseudoElement_FirstLetter:
+		allowed = FIRST_LETTER_PROPERTIES
+	case PseudoElement_Marker:
+		allowed = MARKER_PROPERTIES
+	case PseudoElement_Selection:
+		allowed = HIGHLIGHT_PROPERTIES
+	default:
+		return true
+	}
+
+	for _, name := range allowed {
+		if property == name || strings.HasPrefix(property, name+"-") {
+			return true
+		}
+	}
+
+	return false
+}
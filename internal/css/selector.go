@@ -2,14 +2,24 @@ package plex_css
 
 import (
 	"fmt"
+	"slices"
+	"strings"
 
 	mapset "github.com/deckarep/golang-set/v2"
 	"github.com/moznion/go-optional"
 )
 
+/*
+Grammer:
+
+	<compound-selector> = [ <type-selector>? <subclass-selector>* [ <pseudo-element-selector> ]? ]!
+
+Parsing stops at the first whitespace or combinator token, ParseComplexSelector combines the compounds.
+*/
 func ParseSimpleSelector(tokens *[]Token) (Selector, error) {
 	selector := Selector{
-		Classes: mapset.NewSet[string](),
+		Classes:    mapset.NewSet[string](),
+		Attributes: map[string]SelectorAttribute{},
 	}
 
 	len := len(*tokens)
@@ -22,27 +32,202 @@ func ParseSimpleSelector(tokens *[]Token) (Selector, error) {
 		}
 		selector.Namespace = namespace
 		selector.TagName = tagname
-		return selector, nil
 	}
 
-	id, class, pesudoClass, attr, err := ParseSubclassSelector(tokens, &pos, len)
-	if err != nil {
-		return selector, err
+	for pos < len {
+		token := (*tokens)[pos]
+		if token.GetId() == Token_Whitespace || token.GetId() == Token_EOF {
+			return selector, nil
+		}
+
+		if isPseudoElementStart(tokens, pos, len) {
+			el, err := ParsePseudoElementSelector(tokens, &pos, len)
+			if err != nil {
+				return selector, err
+			}
+			selector.PseudoElements = append(selector.PseudoElements, el)
+			continue
+		}
+
+		// a pseudo-element must be the last simple selector in the compound
+		if selector.PseudoElements != nil {
+			return selector, fmt.Errorf("unexpected token after pseudo-element: %d", token.GetId())
+		}
+
+		start := pos
+		id, class, pesudoClass, attr, err := ParseSubclassSelector(tokens, &pos, len)
+		if err != nil {
+			return selector, err
+		}
+		if start == pos {
+			return selector, fmt.Errorf("unexpected token in selector: %d", token.GetId())
+		}
+
+		id.IfSome(func(v string) {
+			selector.Id = v
+		})
+		class.IfSome(func(v string) {
+			selector.Classes.Add(v)
+		})
+		pesudoClass.IfSome(func(v PesudoClass) {
+			selector.PseudoClasses = append(selector.PseudoClasses, v)
+		})
+		attr.IfSome(func(v SelectorAttribute) {
+			selector.Attributes[v.Value] = v
+		})
+	}
+
+	return selector, nil
+}
+
+/*
+Parses a comma separated list of complex selectors.
+If any selector in the list is invalid the whole list is invalid.
+
+Grammer:
+
+	<selector-list> = <complex-selector-list>
+*/
+func ParseSelectorList(tokens *[]Token) ([]Selector, error) {
+	return ParseNestedSelectorList(tokens, nil)
+}
+
+/*
+Parses the selectors of a rule nested in a style rule with the given selectors. Selectors
+without the nesting selector & are relative to the parent rule, "> p" is read as "& > p".
+A nil parent parses the selectors of a top level rule, where & is not allowed.
+
+Source: https://www.w3.org/TR/css-nesting-1/#syntax
+*/
+func ParseNestedSelectorList(tokens *[]Token, parent []Selector) ([]Selector, error) {
+	selectors := []Selector{}
+	part := []Token{}
+
+	flush := func() error {
+		part = trimWhitespace(part)
+		if len(part) == 0 {
+			return fmt.Errorf("empty selector")
+		}
+		if parent != nil && !slices.ContainsFunc(part, isNestingSelector) {
+			part = append([]Token{&RuneToken{Id: Token_Delim, Value: '&'}, &EmptyToken{Id: Token_Whitespace}}, part...)
+		}
+		selector, err := ParseComplexSelector(part, parent)
+		if err != nil {
+			return err
+		}
+		selectors = append(selectors, selector)
+		part = []Token{}
+		return nil
+	}
+
+	for _, token := range *tokens {
+		switch token.GetId() {
+		case Token_EOF:
+			continue
+		case Token_Comma:
+			if err := flush(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		part = append(part, token)
+	}
+
+	if err := flush(); err != nil {
+		return nil, err
+	}
+
+	return selectors, nil
+}
+
+func isNestingSelector(token Token) bool {
+	return isDelim(token, '&')
+}
+
+// The column combinator || is not supported, a single | belongs to a namespace prefix.
+func isCombinator(token Token) bool {
+	return isDelim(token, '>') || isDelim(token, '+') || isDelim(token, '~')
+}
+
+/*
+Parses compound selectors separated by combinators. The nesting selector & stands for the
+parent selectors.
+
+Grammer:
+
+	<complex-selector> = <compound-selector> [ <combinator>? <compound-selector> ]*
+*/
+func ParseComplexSelector(tokens []Token, parent []Selector) (Selector, error) {
+	var previous *Selector
+	combinator := Combinator_Descendant
+	pos := 0
+
+	for {
+		start := pos
+		for pos < len(tokens) && tokens[pos].GetId() != Token_Whitespace && !isCombinator(tokens[pos]) {
+			pos++
+		}
+		compound, err := parseCompoundSelector(tokens[start:pos], parent)
+		if err != nil {
+			return Selector{}, err
+		}
+		if previous != nil {
+			// pseudo-elements end the selector
+			if previous.GetPseudoElement() != "" {
+				return Selector{}, fmt.Errorf("unexpected selector after a pseudo-element")
+			}
+			compound.Previous = previous
+			compound.Combinator = combinator
+		}
+
+		for pos < len(tokens) && tokens[pos].GetId() == Token_Whitespace {
+			pos++
+		}
+		if pos >= len(tokens) {
+			return compound, nil
+		}
+
+		combinator = Combinator_Descendant
+		if isCombinator(tokens[pos]) {
+			combinator, err = ParseCombinator(&tokens, &pos, len(tokens))
+			if err != nil {
+				return Selector{}, err
+			}
+			for pos < len(tokens) && tokens[pos].GetId() == Token_Whitespace {
+				pos++
+			}
+			if pos >= len(tokens) {
+				return Selector{}, fmt.Errorf("expected a selector after the combinator")
+			}
+		}
+		previous = &compound
 	}
+}
 
-	id.IfSome(func(v string) {
-		selector.Id = v
-	})
-	class.IfSome(func(v string) {
-		selector.Classes.Add(v)
-	})
-	pesudoClass.IfSome(func(v PesudoClass) {
-		selector.PseudoClasses = append(selector.PseudoClasses, v)
-	})
-	attr.IfSome(func(v SelectorAttribute) {
-		selector.Attributes[v.Value] = v
-	})
+func parseCompoundSelector(tokens []Token, parent []Selector) (Selector, error) {
+	nesting := slices.ContainsFunc(tokens, isNestingSelector)
+	tokens = slices.DeleteFunc(slices.Clone(tokens), isNestingSelector)
 
+	if nesting && parent == nil {
+		return Selector{}, fmt.Errorf("the nesting selector '&' is only allowed in nested rules")
+	}
+	if !nesting && len(tokens) == 0 {
+		return Selector{}, fmt.Errorf("expected a compound selector")
+	}
+
+	selector := Selector{
+		Classes:    mapset.NewSet[string](),
+		Attributes: map[string]SelectorAttribute{},
+	}
+	if len(tokens) > 0 {
+		var err error
+		if selector, err = ParseSimpleSelector(&tokens); err != nil {
+			return Selector{}, err
+		}
+	}
+	if nesting {
+		selector.Nesting = parent
+	}
 	return selector, nil
 }
 
@@ -70,14 +255,14 @@ func ParseSubclassSelector(tokens *[]Token, pos *int, len int) (optional.Option[
 				return nil, nil, nil, nil, err
 			}
 			return nil, optional.Some(class), nil, nil, nil
-		case ':':
-			el, err := ParsePseudoClassSelector(tokens, pos, len)
-			if err != nil {
-				return nil, nil, nil, nil, err
-			}
-
-			return nil, nil, optional.Some(el), nil, nil
 		}
+	case Token_Colon:
+		el, err := ParsePseudoClassSelector(tokens, pos, len)
+		if err != nil {
+			return nil, nil, nil, nil, err
+		}
+
+		return nil, nil, optional.Some(el), nil, nil
 	case TSimpleBlack:
 		attr, err := ParseAttributeSelector(tokens, pos, len)
 		if err != nil {
@@ -89,12 +274,63 @@ func ParseSubclassSelector(tokens *[]Token, pos *int, len int) (optional.Option[
 	return nil, nil, nil, nil, nil
 }
 
+/*
+Grammer:
+
+	<pseudo-element-selector> = ':' <pseudo-class-selector> | <legacy-pseudo-element-selector>
+	<legacy-pseudo-element-selector> = ':' [before | after | first-line | first-letter]
+*/
 func ParsePseudoElementSelector(tokens *[]Token, pos *int, len int) (PesudoElement, error) {
-	return PesudoElement{}, fmt.Errorf("TODO: implement pesudo elements")
+	if !isPseudoElementStart(tokens, *pos, len) {
+		return PesudoElement{}, fmt.Errorf("was expecting a pseudo-element")
+	}
+
+	legacy := (*tokens)[(*pos)+1].GetId() != Token_Colon
+	if !legacy {
+		(*pos)++
+	}
+
+	if (*pos)+1 >= len {
+		return PesudoElement{}, fmt.Errorf("eof")
+	}
+
+	token := (*tokens)[(*pos)+1]
+	if token.GetId() != Token_Ident {
+		return PesudoElement{}, fmt.Errorf("was expecting a ident token but found: %d", token.GetId())
+	}
+
+	name := strings.ToLower(token.(*StringToken).Value)
+
+	if !IsSupportedPseudoElement(name) || (legacy && !isLegacyPseudoElement(name)) {
+		return PesudoElement{}, fmt.Errorf("unsupported pseudo-element: %s", name)
+	}
+
+	(*pos) += 2
+	return PesudoElement{Name: name}, nil
 }
 
+/*
+Grammer:
+
+	<pseudo-class-selector> = ':' <ident-token>
+*/
 func ParsePseudoClassSelector(tokens *[]Token, pos *int, len int) (PesudoClass, error) {
-	return PesudoClass{}, fmt.Errorf("TODO: implement pesudo classes")
+	if (*pos)+1 >= len {
+		return PesudoClass{}, fmt.Errorf("eof")
+	}
+
+	token := (*tokens)[(*pos)+1]
+	if token.GetId() != Token_Ident {
+		return PesudoClass{}, fmt.Errorf("was expecting a ident token but found: %d", token.GetId())
+	}
+
+	name := strings.ToLower(token.(*StringToken).Value)
+	if !IsSupportedPseudoClass(name) {
+		return PesudoClass{}, fmt.Errorf("unsupported pseudo-class: %s", name)
+	}
+
+	(*pos) += 2
+	return PesudoClass{Name: name}, nil
 }
 
 /*
@@ -112,7 +348,7 @@ Grammer:
 	<class-selector> = '.' <ident-token>
 */
 func ParseClassSelector(tokens *[]Token, pos *int, len int) (string, error) {
-	if (*pos)+1 > len {
+	if (*pos)+1 >= len {
 		return "", fmt.Errorf("eof")
 	}
 
@@ -174,13 +410,13 @@ func ParseCombinator(tokens *[]Token, pos *int, len int) (uint, error) {
 		switch v.Value {
 		case '>':
 			(*pos)++
-			return 0, nil
+			return Combinator_Child, nil
 		case '+':
 			(*pos)++
-			return 1, nil
+			return Combinator_NextSibling, nil
 		case '~':
 			(*pos)++
-			return 2, nil
+			return Combinator_SubsequentSibling, nil
 		case '|':
 			(*pos)++
 			if (*pos) > len {
@@ -188,7 +424,7 @@ func ParseCombinator(tokens *[]Token, pos *int, len int) (uint, error) {
 			}
 			if b, ok := (*tokens)[(*pos)].(*RuneToken); ok && b.Value == '|' {
 				(*pos)++
-				return 3, nil
+				return Combinator_Column, nil
 			}
 			return 0, fmt.Errorf("was expecting token '|' but got %d", (*tokens)[(*pos)].GetId())
 		}
@@ -344,3 +580,30 @@ func isWQStart(tokens *[]Token, pos *int, len int) bool {
 	}
 
 }
+
+func isPseudoElementStart(tokens *[]Token, pos int, len int) bool {
+	if pos+1 >= len || (*tokens)[pos].GetId() != Token_Colon {
+		return false
+	}
+
+	next := (*tokens)[pos+1]
+	if next.GetId() == Token_Colon {
+		return true
+	}
+
+	if v, ok := next.(*StringToken); ok && next.GetId() == Token_Ident {
+		return isLegacyPseudoElement(strings.ToLower(v.Value))
+	}
+
+	return false
+}
+
+// https://www.w3.org/TR/selectors-4/#pseudo-element-syntax
+func isLegacyPseudoElement(name string) bool {
+	switch name {
+	case "before", "after", "first-line", "first-letter":
+		return true
+	default:
+		return false
+	}
+}
@@ -359,3 +359,127 @@ func TestParseSimpleSelector_STAR(t *testing.T) {
 		t.Fatalf("Invalid selector")
 	}
 }
+
+// region-start pseudo-element
+
+func TestParseSimpleSelector_PSEUDO_ELEMENT(t *testing.T) {
+	tokenizer := plex_css.Tokenizer{}
+	tokens, err := tokenizer.Parse("q.quote::before")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	result, err := plex_css.ParseSimpleSelector(&tokens)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if result.TagName != "q" || !result.Classes.ContainsOne("quote") || result.GetPseudoElement() != "before" {
+		t.Fatalf("Invalid selector: %v", result)
+	}
+
+	spec := result.GetSpecificity()
+	if spec.A != 0 || spec.B != 1 || spec.C != 2 {
+		t.Fatalf("Invalid specificity: %v", spec)
+	}
+}
+
+func TestParseSimpleSelector_LEGACY_PSEUDO_ELEMENT(t *testing.T) {
+	tokenizer := plex_css.Tokenizer{}
+	tokens, err := tokenizer.Parse("label:after")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	result, err := plex_css.ParseSimpleSelector(&tokens)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if result.TagName != "label" || result.GetPseudoElement() != "after" {
+		t.Fatalf("Invalid selector: %v", result)
+	}
+}
+
+func TestParseSimpleSelector_INVALID_PSEUDO_ELEMENT(t *testing.T) {
+	tokenizer := plex_css.Tokenizer{}
+	tokens, err := tokenizer.Parse("p::before.late")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if _, err := plex_css.ParseSimpleSelector(&tokens); err == nil {
+		t.Fatalf("Expected a pseudo-element followed by a class to be invalid")
+	}
+}
+
+func TestParseSelectorList(t *testing.T) {
+	tokenizer := plex_css.Tokenizer{}
+	tokens, err := tokenizer.Parse("q::before, blockquote::before ")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	result, err := plex_css.ParseSelectorList(&tokens)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if len(result) != 2 || result[1].TagName != "blockquote" {
+		t.Fatalf("Invalid selector list: %v", result)
+	}
+}
+
+func TestParseSimpleSelector_TYPOGRAPHIC_PSEUDO_ELEMENTS(t *testing.T) {
+	for _, name := range []string{"first-line", "first-letter", "marker", "selection", "placeholder"} {
+		tokenizer := plex_css.Tokenizer{}
+		tokens, err := tokenizer.Parse("p::" + name)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+
+		result, err := plex_css.ParseSimpleSelector(&tokens)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+
+		if result.GetPseudoElement() != name {
+			t.Fatalf("Invalid pseudo-element: %s", result.GetPseudoElement())
+		}
+	}
+}
+
+func TestParseSimpleSelector_LEGACY_ONLY_FOR_CSS2_PSEUDO_ELEMENTS(t *testing.T) {
+	tokenizer := plex_css.Tokenizer{}
+	tokens, err := tokenizer.Parse("li:marker")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if _, err := plex_css.ParseSimpleSelector(&tokens); err == nil {
+		t.Fatalf("Expected single colon ::marker to be invalid")
+	}
+}
+
+func TestIsPropertyAllowedOnPseudoElement(t *testing.T) {
+	cases := []struct {
+		pseudo   string
+		property string
+		allowed  bool
+	}{
+		{plex_css.PseudoElement_FirstLine, "font-size", true},
+		{plex_css.PseudoElement_FirstLine, "margin-left", false},
+		{plex_css.PseudoElement_FirstLetter, "float", true},
+		{plex_css.PseudoElement_Marker, "background-color", false},
+		{plex_css.PseudoElement_Selection, "background-color", true},
+		{plex_css.PseudoElement_Selection, "font-size", false},
+		{plex_css.PseudoElement_Placeholder, "color", true},
+		{plex_css.PseudoElement_Before, "width", true},
+	}
+
+	for _, c := range cases {
+		if plex_css.IsPropertyAllowedOnPseudoElement(c.pseudo, c.property) != c.allowed {
+			t.Fatalf("%s on ::%s should be allowed=%v", c.property, c.pseudo, c.allowed)
+		}
+	}
+}
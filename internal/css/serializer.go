@@ -0,0 +1,629 @@
+package plex_css
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var CSS_UNIT_NAMES = map[CssUnit]string{
+	CssUnit_PRESENT: "%",
+	CssUnit_EM:      "em",
+	CssUnit_EX:      "ex",
+	CssUnit_CAP:     "cap",
+	CssUnit_CH:      "ch",
+	CssUnit_IC:      "ic",
+	CssUnit_REM:     "rem",
+	CssUnit_LH:      "lh",
+	CssUnit_RLH:     "rlh",
+	CssUnit_VW:      "vw",
+	CssUnit_VH:      "vh",
+	CssUnit_VI:      "vi",
+	CssUnit_VB:      "vb",
+	CssUnit_VMIN:    "vmin",
+	CssUnit_VMAX:    "vmax",
+	CssUnit_CQW:     "cqw",
+	CssUnit_CQH:     "cqh",
+	CssUnit_CQI:     "cqi",
+	CssUnit_CQB:     "cqb",
+	CssUnit_CQMIN:   "cqmin",
+	CssUnit_CQMAX:   "cqmax",
+	CssUnit_PX:      "px",
+	CssUnit_CM:      "cm",
+	CssUnit_MM:      "mm",
+	CssUnit_Q:       "q",
+	CssUnit_IN:      "in",
+	CssUnit_PT:      "pt",
+	CssUnit_PC:      "pc",
+	CssUnit_DEG:     "deg",
+	CssUnit_GRAD:    "grad",
+	CssUnit_RAD:     "rad",
+	CssUnit_TURN:    "turn",
+	CssUnit_S:       "s",
+	CssUnit_MS:      "ms",
+	CssUnit_HZ:      "hz",
+	CssUnit_KHZ:     "khz",
+	CssUnit_DPI:     "dpi",
+	CssUnit_DPCM:    "dpcm",
+	CssUnit_DPPX:    "dppx",
+	CssUnit_X:       "x",
+	CssUnit_FR:      "fr",
+}
+
+// Returns the CSS text of the stylesheet, one rule per line.
+// https://www.w3.org/TR/cssom-1/#serialize-a-css-rule
+func (s *Stylesheet) CssText() string {
+	rules := []string{}
+	for i := range s.Imports {
+		rules = append(rules, s.Imports[i].CssText())
+	}
+	for i := range s.FontFaces {
+		rules = append(rules, s.FontFaces[i].CssText())
+	}
+
+	// the layer order is kept with a statement naming every layer but the anonymous ones
+	layers := []string{}
+	for _, name := range s.Layers {
+		if !strings.Contains(name, anonymousLayerPrefix) {
+			layers = append(layers, serializeLayerName(name))
+		}
+	}
+	if len(layers) > 0 {
+		rules = append(rules, "@layer "+strings.Join(layers, ", ")+";")
+	}
+	for i := range s.Rules {
+		rules = append(rules, s.Rules[i].CssText())
+	}
+	for i := range s.Pages {
+		rules = append(rules, s.Pages[i].CssText())
+	}
+	for i := range s.AtRules {
+		rules = append(rules, s.AtRules[i].CssText())
+	}
+	return strings.Join(rules, "\n")
+}
+
+// div { color: red; margin-top: 0px; }
+func (r *Rule) CssText() string {
+	text := SerializeSelectorList(r.Selector) + " {"
+	for i := range r.Block {
+		text += " " + r.Block[i].CssText() + ";"
+	}
+	text += " }"
+	return r.wrapConditions(text)
+}
+
+// Wraps the text of a rule in the at-rules it is nested in.
+func (r *Rule) wrapConditions(text string) string {
+	if r.Layer != "" {
+		text = serializeLayerBlocks(r.Layer, text)
+	}
+	for i := len(r.Container) - 1; i >= 0; i-- {
+		text = "@container " + r.Container[i].CssText() + " { " + text + " }"
+	}
+	for i := len(r.Supports) - 1; i >= 0; i-- {
+		text = "@supports " + r.Supports[i].CssText() + " { " + text + " }"
+	}
+	for i := len(r.Media) - 1; i >= 0; i-- {
+		text = "@media " + r.Media[i].CssText() + " { " + text + " }"
+	}
+	return text
+}
+
+// Wraps the text in @layer blocks, an anonymous layer gets a block without a name.
+func serializeLayerBlocks(layer string, text string) string {
+	parts := strings.Split(layer, ".")
+	named := []string{}
+	for i := len(parts) - 1; i >= -1; i-- {
+		if i >= 0 && !strings.HasPrefix(parts[i], anonymousLayerPrefix) {
+			named = append([]string{serializeIdentifier(parts[i])}, named...)
+			continue
+		}
+		if len(named) > 0 {
+			text = "@layer " + strings.Join(named, ".") + " { " + text + " }"
+			named = []string{}
+		}
+		if i >= 0 {
+			text = "@layer { " + text + " }"
+		}
+	}
+	return text
+}
+
+func serializeLayerName(name string) string {
+	parts := strings.Split(name, ".")
+	for i := range parts {
+		parts[i] = serializeIdentifier(parts[i])
+	}
+	return strings.Join(parts, ".")
+}
+
+// @media screen { div { color: red } }
+func (r *AtRule) CssText() string {
+	text := "@" + serializeIdentifier(r.Name)
+	if len(r.Prelude) > 0 {
+		text += " " + serializeTokens(r.Prelude)
+	}
+	if r.Block.BlockType != Token_Clearly_Close {
+		return text + ";"
+	}
+
+	block := strings.TrimSpace(serializeTokens(r.Block.Tokens))
+	if block == "" {
+		return text + " { }"
+	}
+	return text + " { " + block + " }"
+}
+
+// color: red !important
+// https://www.w3.org/TR/cssom-1/#serialize-a-css-declaration
+func (d *Declaration) CssText() string {
+	text := d.Name + ": " + d.ValueText()
+	if d.Important {
+		text += " !important"
+	}
+	return text
+}
+
+/*
+Returns the CSS text of the declaration's value. Custom properties and values waiting for
+var() substitution keep the tokens they were written with.
+
+Source: https://www.w3.org/TR/cssom-1/#serialize-a-css-value
+*/
+func (d *Declaration) ValueText() string {
+	if d.tokens == nil {
+		values := []string{}
+		for _, value := range d.Value {
+			values = append(values, SerializeCssValue(value))
+		}
+		return strings.Join(values, " ")
+	}
+
+	if !IsCustomProperty(d.Name) && !d.ContainsVar() {
+		if values, ok := grammarValues(d.tokens); ok {
+			return serializeValueList(values)
+		}
+	}
+	return strings.TrimSpace(serializeTokens(d.tokens))
+}
+
+// Joins values by spaces, commas and slashes are kept as separators.
+func serializeValueList(values []CssValue) string {
+	text := ""
+	for i, value := range values {
+		if delim, ok := value.(*delimValue); ok && delim.Value == ',' {
+			text += ","
+			continue
+		}
+		if i > 0 {
+			text += " "
+		}
+		text += SerializeCssValue(value)
+	}
+	return text
+}
+
+// https://www.w3.org/TR/cssom-1/#serialize-a-css-component-value
+func SerializeCssValue(v CssValue) string {
+	switch value := v.(type) {
+	case *CssKeyword:
+		return serializeIdentifier(value.Value)
+	case *CssString:
+		return serializeString(value.Value)
+	case *CssDimention:
+		return serializeNumber(float64(value.Value)) + CSS_UNIT_NAMES[value.Unit]
+	case *CssColor:
+		return serializeColor(value)
+	case *CssExpression:
+		return serializeExpression(value)
+	case *CssFunction:
+		return serializeFunction(value)
+	case *CssUrl:
+		return "url(" + serializeString(value.Url) + ")"
+	case *CssList:
+		return serializeList(value)
+	case *CssRatio:
+		return serializeNumber(float64(value.Numerator)) + " / " + serializeNumber(float64(value.Denominator))
+	case *delimValue:
+		return string(value.Value)
+	}
+	return ""
+}
+
+/*
+Colors serialize as rgb(), or rgba() when they are not opaque. The alpha uses the fewest
+decimals that round trip to the same 8 bit value.
+
+Source: https://www.w3.org/TR/css-color-4/#serializing-sRGB-values
+*/
+func serializeColor(c *CssColor) string {
+	if c.A == 255 {
+		return fmt.Sprintf("rgb(%d, %d, %d)", c.R, c.G, c.B)
+	}
+
+	alpha := math.Round(float64(c.A)/255*100) / 100
+	if int(math.Round(alpha*255)) != c.A {
+		alpha = math.Round(float64(c.A)/255*1000) / 1000
+	}
+	return fmt.Sprintf("rgba(%d, %d, %d, %s)", c.R, c.G, c.B, serializeNumber(alpha))
+}
+
+// Commas are followed by a space, slashes are surrounded by spaces.
+func serializeList(l *CssList) string {
+	items := []string{}
+	for _, value := range l.Values {
+		items = append(items, SerializeCssValue(value))
+	}
+	switch l.Separator {
+	case ',':
+		return strings.Join(items, ", ")
+	case '/':
+		return strings.Join(items, " / ")
+	}
+	return strings.Join(items, " ")
+}
+
+func serializeFunction(f *CssFunction) string {
+	// math functions separate their arguments by commas
+	if slices.Contains(MATH_FUNCTIONS, f.Name) {
+		args := []string{}
+		for _, arg := range f.Args {
+			args = append(args, serializeCalcOperand(arg))
+		}
+		return f.Name + "(" + strings.Join(args, ", ") + ")"
+	}
+
+	if f.tokens != nil {
+		if values, ok := grammarValues(f.tokens); ok {
+			return serializeIdentifier(f.Name) + "(" + serializeValueList(values) + ")"
+		}
+		return serializeIdentifier(f.Name) + "(" + serializeTokens(f.tokens) + ")"
+	}
+
+	args := []string{}
+	for _, arg := range f.Args {
+		args = append(args, SerializeCssValue(arg))
+	}
+	return serializeIdentifier(f.Name) + "(" + strings.Join(args, ", ") + ")"
+}
+
+// https://www.w3.org/TR/css-values-4/#serialize-a-math-function
+func serializeExpression(e *CssExpression) string {
+	left := serializeCalcOperand(e.Left)
+	right := serializeCalcOperand(e.Right)
+
+	// operands binding less tightly than the operator are parenthesized, so is a right operand
+	// of the same precedence as '-' and '/' are not associative
+	if child, ok := e.Left.(*CssExpression); ok && calcPrecedence(child.Op) < calcPrecedence(e.Op) {
+		left = "(" + left + ")"
+	}
+	if child, ok := e.Right.(*CssExpression); ok {
+		if calcPrecedence(child.Op) < calcPrecedence(e.Op) || (calcPrecedence(child.Op) == calcPrecedence(e.Op) && (e.Op == '-' || e.Op == '/')) {
+			right = "(" + right + ")"
+		}
+	}
+
+	return left + " " + string(rune(e.Op)) + " " + right
+}
+
+func serializeCalcOperand(v CssValue) string {
+	if expression, ok := v.(*CssExpression); ok {
+		return serializeExpression(expression)
+	}
+	return SerializeCssValue(v)
+}
+
+func calcPrecedence(op uint8) int {
+	if op == '*' || op == '/' {
+		return 1
+	}
+	return 0
+}
+
+// Numbers use the shortest decimal form without an exponent.
+func serializeNumber(value float64) string {
+	if math.IsInf(value, 0) || math.IsNaN(value) {
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	}
+	return strconv.FormatFloat(float64(float32(value)), 'f', -1, 32)
+}
+
+// https://www.w3.org/TR/cssom-1/#serialize-an-identifier
+func serializeIdentifier(value string) string {
+	runes := []rune(value)
+	result := strings.Builder{}
+
+	for i, r := range runes {
+		switch {
+		case r == 0:
+			result.WriteRune('�')
+		case (r >= 0x1 && r <= 0x1F) || r == 0x7F,
+			i == 0 && r >= '0' && r <= '9',
+			i == 1 && r >= '0' && r <= '9' && runes[0] == '-':
+			result.WriteString(serializeCodePoint(r))
+		case i == 0 && r == '-' && len(runes) == 1:
+			result.WriteString("\\-")
+		case r >= 0x80 || r == '-' || r == '_' || (r >= '0' && r <= '9') || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z'):
+			result.WriteRune(r)
+		default:
+			result.WriteRune('\\')
+			result.WriteRune(r)
+		}
+	}
+
+	return result.String()
+}
+
+// https://www.w3.org/TR/cssom-1/#serialize-a-string
+func serializeString(value string) string {
+	result := strings.Builder{}
+	result.WriteRune('"')
+
+	for _, r := range value {
+		switch {
+		case r == 0:
+			result.WriteRune('�')
+		case (r >= 0x1 && r <= 0x1F) || r == 0x7F:
+			result.WriteString(serializeCodePoint(r))
+		case r == '"' || r == '\\':
+			result.WriteRune('\\')
+			result.WriteRune(r)
+		default:
+			result.WriteRune(r)
+		}
+	}
+
+	result.WriteRune('"')
+	return result.String()
+}
+
+func serializeCodePoint(r rune) string {
+	return fmt.Sprintf("\\%x ", r)
+}
+
+// https://www.w3.org/TR/selectors-4/#serializing-selectors
+func SerializeSelectorList(selectors []Selector) string {
+	texts := []string{}
+	for i := range selectors {
+		texts = append(texts, selectors[i].CssText())
+	}
+	return strings.Join(texts, ", ")
+}
+
+var COMBINATOR_TEXTS = map[uint]string{
+	Combinator_Child:             " > ",
+	Combinator_NextSibling:       " + ",
+	Combinator_SubsequentSibling: " ~ ",
+	Combinator_Column:            " || ",
+	Combinator_Descendant:        " ",
+}
+
+func (s *Selector) CssText() string {
+	prefix := ""
+	if s.Previous != nil {
+		prefix = s.Previous.CssText() + COMBINATOR_TEXTS[s.Combinator]
+	}
+	return prefix + s.compoundText()
+}
+
+// The nesting selector is written as :is() of the parent selectors, a lone & as the parent selector itself.
+func (s *Selector) compoundText() string {
+	text := ""
+
+	s.Namespace.IfSome(func(namespace string) {
+		switch namespace {
+		case "", "*":
+			text += namespace + "|"
+		default:
+			text += serializeIdentifier(namespace) + "|"
+		}
+	})
+
+	switch s.TagName {
+	case "":
+		if text != "" {
+			text += "*"
+		}
+	case "*":
+		text += "*"
+	default:
+		text += serializeIdentifier(s.TagName)
+	}
+
+	if s.Id != "" {
+		text += "#" + serializeIdentifier(s.Id)
+	}
+
+	// classes are a set, sorting keeps the text stable
+	if s.Classes != nil {
+		classes := s.Classes.ToSlice()
+		slices.Sort(classes)
+		for _, class := range classes {
+			text += "." + serializeIdentifier(class)
+		}
+	}
+
+	names := []string{}
+	for name := range s.Attributes {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		text += "[" + serializeIdentifier(name) + "]"
+	}
+
+	if len(s.Nesting) == 1 && text == "" && (s.Previous == nil || s.Nesting[0].Previous == nil) {
+		text = s.Nesting[0].CssText()
+	} else if len(s.Nesting) > 0 {
+		text += ":is(" + SerializeSelectorList(s.Nesting) + ")"
+	}
+
+	for _, pseudo := range s.PseudoClasses {
+		text += ":" + serializeIdentifier(pseudo.Name)
+	}
+
+	for _, pseudo := range s.PseudoElements {
+		text += "::" + serializeIdentifier(pseudo.Name)
+	}
+
+	if text == "" {
+		return "*"
+	}
+	return text
+}
+
+/*
+Writes the tokens back as CSS text. Tokens adjacent in the source are separated by an empty
+comment, which the tokenizer drops, only where their text would otherwise be read back as
+different tokens.
+
+Source: https://www.w3.org/TR/css-syntax-3/#serialization
+*/
+func serializeTokens(tokens []Token) string {
+	return joinTokens(tokens, "/**/")
+}
+
+func joinTokens(tokens []Token, separator string) string {
+	result := strings.Builder{}
+	for i, token := range tokens {
+		if i > 0 && needsSeparator(tokens[i-1], token) {
+			result.WriteString(separator)
+		}
+		result.WriteString(serializeToken(token))
+	}
+	return result.String()
+}
+
+// Reports whether the text of next would merge with the text of prev.
+func needsSeparator(prev Token, next Token) bool {
+	nextDelim := func(values ...rune) bool {
+		for _, value := range values {
+			if isDelim(next, value) {
+				return true
+			}
+		}
+		return false
+	}
+	nextIdentLike := false
+	switch next.GetId() {
+	case Token_Ident, TFunction, Token_Url, Token_Bad_Url:
+		nextIdentLike = true
+	}
+	// a sign can not continue a name or a number, but it can continue the exponent of a unit such as 'e'
+	nextNumeric, nextSign := false, byte(0)
+	if number, ok := next.(*NumberToken); ok {
+		nextNumeric = true
+		if text := serializeNumberToken(number); text[0] == '+' || text[0] == '-' {
+			nextSign = text[0]
+		}
+	}
+
+	switch prev.GetId() {
+	case Token_Ident:
+		return nextIdentLike || (nextNumeric && nextSign != '+') || nextDelim('-') || next.GetId() == Token_CDC || next.GetId() == Token_Pren_Open
+	case Token_At_Keyword, Token_Hash:
+		return nextIdentLike || (nextNumeric && nextSign != '+') || nextDelim('-') || next.GetId() == Token_CDC
+	case Token_Dimension:
+		return nextIdentLike || nextNumeric || nextDelim('-') || next.GetId() == Token_CDC
+	case Token_Number:
+		return nextIdentLike || (nextNumeric && nextSign == 0) || nextDelim('%', '-') || next.GetId() == Token_CDC
+	case Token_Delim:
+		switch {
+		case isDelim(prev, '#'), isDelim(prev, '-'):
+			return nextIdentLike || nextNumeric || nextDelim('-') || next.GetId() == Token_CDC
+		case isDelim(prev, '@'):
+			return nextIdentLike || nextDelim('-') || next.GetId() == Token_CDC
+		case isDelim(prev, '.'), isDelim(prev, '+'):
+			return nextNumeric
+		case isDelim(prev, '/'):
+			return nextDelim('*')
+		}
+	}
+	return false
+}
+
+// Numbers are written as they were in the source, so unicode ranges such as U+0-7F read back.
+func serializeNumberToken(t *NumberToken) string {
+	text := t.Repr
+	if text == "" {
+		text = serializeNumber(float64(t.Value))
+	}
+	switch t.Id {
+	case Token_Percentage:
+		return text + "%"
+	case Token_Dimension:
+		return text + serializeUnit(t.Unit)
+	}
+	return text
+}
+
+// A unit such as 'e3' is escaped so it is not read back as the exponent of the number.
+func serializeUnit(unit string) string {
+	runes := []rune(unit)
+	if len(runes) > 1 && (runes[0] == 'e' || runes[0] == 'E') {
+		exponent := runes[1:]
+		if exponent[0] == '+' || exponent[0] == '-' {
+			exponent = exponent[1:]
+		}
+		if len(exponent) > 0 && isDigit(exponent[0]) {
+			return serializeCodePoint(runes[0]) + serializeIdentifier(unit)[1:]
+		}
+	}
+	return serializeIdentifier(unit)
+}
+
+func serializeToken(token Token) string {
+	switch t := token.(type) {
+	case *StringToken:
+		switch t.Id {
+		case Token_Ident:
+			return serializeIdentifier(t.Value)
+		case Token_At_Keyword:
+			return "@" + serializeIdentifier(t.Value)
+		case Token_String:
+			return serializeString(t.Value)
+		case Token_Url:
+			return "url(" + serializeString(t.Value) + ")"
+		}
+	case *FlagedStringToken:
+		return "#" + serializeIdentifier(t.Value)
+	case *NumberToken:
+		return serializeNumberToken(t)
+	case *RuneToken:
+		return string(t.Value)
+	case *FunctionBlock:
+		if t.spaced == nil {
+			// built without its white space, the arguments were not adjacent in the source
+			return serializeIdentifier(t.Name) + "(" + joinTokens(t.Args, " ") + ")"
+		}
+		return serializeIdentifier(t.Name) + "(" + serializeTokens(t.spaced) + ")"
+	case *SimpleBlock:
+		open, end := "{", "}"
+		switch t.BlockType {
+		case Token_Square_Bracket_Close:
+			open, end = "[", "]"
+		case Token_Pren_Close:
+			open, end = "(", ")"
+		}
+		return open + serializeTokens(t.Tokens) + end
+	}
+
+	switch token.GetId() {
+	case Token_Whitespace:
+		return " "
+	case Token_Colon:
+		return ":"
+	case Token_Semicolon:
+		return ";"
+	case Token_Comma:
+		return ","
+	case Token_CDO:
+		return "<!--"
+	case Token_CDC:
+		return "-->"
+	}
+	return ""
+}
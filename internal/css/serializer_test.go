@@ -0,0 +1,206 @@
+package plex_css_test
+
+import (
+	"reflect"
+	"testing"
+	plex_css "visualsource/plex/internal/css"
+
+	mapset "github.com/deckarep/golang-set/v2"
+)
+
+func serializeDeclarations(t *testing.T, value string) []string {
+	parser := plex_css.CssParser{}
+	declarations, err := parser.ParseDeclarationsList(value)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	texts := []string{}
+	for i := range declarations {
+		texts = append(texts, declarations[i].CssText())
+	}
+	return texts
+}
+
+func TestSerialize_DECLARATIONS(t *testing.T) {
+	tests := map[string]string{
+		"color: red":                             "color: red",
+		"width: 10.50px !important":              "width: 10.5px !important",
+		"line-height: 150%":                      "line-height: 150%",
+		"color: #ff0000":                         "color: rgb(255, 0, 0)",
+		"color: #ff000080":                       "color: rgba(255, 0, 0, 0.5)",
+		"color: rgb(0 0 255 / 25%)":              "color: rgba(0, 0, 255, 0.25)",
+		"color: hsl(120, 100%, 50%)":             "color: rgb(0, 255, 0)",
+		"font-family: Arial,  'Times New Roman'": `font-family: Arial, "Times New Roman"`,
+		"width: calc(100% - 2 * 10px)":           "width: calc(100% - 2 * 10px)",
+		"width: calc(100% - (10px + 5%))":        "width: calc(100% - (10px + 5%))",
+		"width: min(10px, 5em)":                  "width: min(10px, 5em)",
+		"--gap:  1px  2px":                       "--gap: 1px 2px",
+		"margin-left: var(--gap, 3px)":           "margin-left: var(--gap, 3px)",
+		"background-image: url(\"a b.png\")":     `background-image: url("a b.png")`,
+		"aspect-ratio: 16/9":                     "aspect-ratio: 16 / 9",
+	}
+
+	for input, expected := range tests {
+		texts := serializeDeclarations(t, input)
+		if len(texts) != 1 {
+			t.Errorf("%q: expected one declaration, got %v", input, texts)
+			continue
+		}
+		if texts[0] != expected {
+			t.Errorf("%q: expected %q, got %q", input, expected, texts[0])
+		}
+	}
+}
+
+func TestSerialize_SHORTHAND(t *testing.T) {
+	texts := serializeDeclarations(t, "margin: 1px 2px")
+	expected := []string{"margin-top: 1px", "margin-right: 2px", "margin-bottom: 1px", "margin-left: 2px"}
+
+	if len(texts) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, texts)
+	}
+	for i := range expected {
+		if texts[i] != expected[i] {
+			t.Errorf("expected %q, got %q", expected[i], texts[i])
+		}
+	}
+}
+
+func TestSerialize_SELECTORS(t *testing.T) {
+	tests := map[string]string{
+		"div":              "div",
+		"*":                "*",
+		"div.b.a#main":     "div#main.a.b",
+		"svg|rect":         "svg|rect",
+		"*|p":              "*|p",
+		"p::first-line":    "p::first-line",
+		"h1,  h2 , .title": "h1, h2, .title",
+	}
+
+	for input, expected := range tests {
+		parser := plex_css.CssParser{}
+		stylesheet, err := parser.ParseStylesheet(input+" { color: red }", plex_css.Origin_Author)
+		if err != nil || len(stylesheet.Rules) != 1 {
+			t.Errorf("%q: failed to parse, %v", input, err)
+			continue
+		}
+
+		text := plex_css.SerializeSelectorList(stylesheet.Rules[0].Selector)
+		if text != expected {
+			t.Errorf("%q: expected %q, got %q", input, expected, text)
+		}
+	}
+}
+
+func TestSerialize_ESCAPING(t *testing.T) {
+	selector := plex_css.Selector{
+		TagName: "a b",
+		Id:      "-1x",
+		Classes: mapset.NewSet("10", "a.b"),
+	}
+
+	expected := `a\ b#-\31 x.\31 0.a\.b`
+	if text := selector.CssText(); text != expected {
+		t.Fatalf("expected %q, got %q", expected, text)
+	}
+
+	value := plex_css.SerializeCssValue(&plex_css.CssString{Value: "say \"hi\"\n"})
+	if value != `"say \"hi\"\a "` {
+		t.Fatalf("expected an escaped string, got %q", value)
+	}
+}
+
+func TestSerialize_STYLESHEET(t *testing.T) {
+	parser := plex_css.CssParser{}
+	stylesheet, err := parser.ParseStylesheet(`
+	body {
+		background-color: lightblue;
+	}
+
+	h1, p.note {
+		color: white;
+		text-align: center !important;
+	}
+
+	div { }
+	`, plex_css.Origin_Author)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	expected := "body { background-color: lightblue; }\n" +
+		"h1, p.note { color: white; text-align: center !important; }\n" +
+		"div { }"
+	if text := stylesheet.CssText(); text != expected {
+		t.Fatalf("expected\n%s\ngot\n%s", expected, text)
+	}
+}
+
+// Serialized text parses back into the same stylesheet.
+func TestSerialize_ROUND_TRIP(t *testing.T) {
+	tests := map[string]string{
+		"@supports selector(div.note#main) { p { color: red } }": "@supports selector(div.note#main) { p { color: red; } }",
+		"@supports selector(a > b:hover) { p { color: red } }":   "@supports selector(a > b:hover) { p { color: red; } }",
+		"p { --x: foo(a.b); --y: bar( 1px,-2px ) }":              "p { --x: foo(a.b); --y: bar( 1px,-2px ); }",
+		"p { --z: { a: b(c#d) } }":                               "p { --z: { a: b(c#d) }; }",
+	}
+
+	for input, expected := range tests {
+		parser := plex_css.CssParser{}
+		stylesheet, err := parser.ParseStylesheet(input, plex_css.Origin_Author)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		text := stylesheet.CssText()
+		if text != expected {
+			t.Errorf("%q: expected %q, got %q", input, expected, text)
+			continue
+		}
+
+		reparsed, err := parser.ParseStylesheet(text, plex_css.Origin_Author)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		if reparsed.CssText() != text {
+			t.Errorf("%q: expected the serialized text to parse back, got %q", text, reparsed.CssText())
+		}
+		if len(reparsed.Rules) != 1 || reparsed.Rules[0].IsSupported() != stylesheet.Rules[0].IsSupported() {
+			t.Errorf("%q: expected the reparsed rule to keep its @supports condition", text)
+		}
+	}
+
+	parser := plex_css.CssParser{}
+	stylesheet, _ := parser.ParseStylesheet("@supports selector(div.note#main) { p { color: red } }", plex_css.Origin_Author)
+	reparsed, _ := parser.ParseStylesheet(stylesheet.CssText(), plex_css.Origin_Author)
+	if !reparsed.Rules[0].IsSupported() {
+		t.Fatalf("expected the serialized selector() condition to still hold")
+	}
+}
+
+// At-rules are written back from their tokens, which keep the text of their numbers.
+func TestSerialize_AT_RULE_ROUND_TRIP(t *testing.T) {
+	source := `@font-face { font-family: "My Face"; src: url("a.ttf"); unicode-range: U+0-7F, u+4??, U+20AC }
+@unknown a/**/1 1/**/2 x(1/**/2 3) 1\65 3 +1.50 { b/**/-c: 1e3 }`
+
+	parser := plex_css.CssParser{}
+	stylesheet, err := parser.ParseStylesheet(source, plex_css.Origin_Author)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if text := stylesheet.CssText(); text != source {
+		t.Fatalf("expected %q, got %q", source, text)
+	}
+
+	reparsed, err := parser.ParseStylesheet(stylesheet.CssText(), plex_css.Origin_Author)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	ranges := []plex_css.UnicodeRange{{Start: 0, End: 0x7F}, {Start: 0x400, End: 0x4FF}, {Start: 0x20AC, End: 0x20AC}}
+	if len(reparsed.FontFaces) != 1 || !reflect.DeepEqual(reparsed.FontFaces[0].UnicodeRange, ranges) {
+		t.Fatalf("expected the unicode ranges %v to read back, got %v", ranges, reparsed.FontFaces)
+	}
+	if len(reparsed.AtRules) != 1 || reparsed.AtRules[0].CssText() != stylesheet.AtRules[0].CssText() {
+		t.Fatalf("expected the unknown at-rule to read back, got %v", reparsed.AtRules)
+	}
+}
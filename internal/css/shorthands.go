@@ -0,0 +1,725 @@
+package plex_css
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+A shorthand property and the longhands it sets.
+
+Source: https://www.w3.org/TR/css-cascade-5/#shorthand
+*/
+type ShorthandDefinition struct {
+	Name      string
+	Longhands []string
+	// returns one value per longhand, nil for longhands reset to their initial value
+	expand func(components []Token) ([][]CssValue, error)
+}
+
+var SHORTHANDS = map[string]ShorthandDefinition{}
+
+func defineShorthand(name string, longhands []string, expand func(components []Token) ([][]CssValue, error)) {
+	SHORTHANDS[name] = ShorthandDefinition{
+		Name:      name,
+		Longhands: longhands,
+		expand:    expand,
+	}
+}
+
+func GetShorthandDefinition(name string) (ShorthandDefinition, bool) {
+	definition, ok := SHORTHANDS[name]
+	return definition, ok
+}
+
+// Names the longhands of a box shorthand in top, right, bottom, left order.
+func sideLonghands(prefix string, suffix string) []string {
+	longhands := []string{}
+	for _, side := range []string{"top", "right", "bottom", "left"} {
+		longhands = append(longhands, prefix+side+suffix)
+	}
+	return longhands
+}
+
+func init() {
+	defineShorthand("margin", sideLonghands("margin-", ""), expandBox(isMarginValue))
+	defineShorthand("padding", sideLonghands("padding-", ""), expandBox(isPaddingValue))
+	defineShorthand("inset", []string{"top", "right", "bottom", "left"}, expandBox(isMarginValue))
+	defineShorthand("border-width", sideLonghands("border-", "-width"), expandBox(isLineWidth))
+	defineShorthand("border-style", sideLonghands("border-", "-style"), expandBox(isLineStyle))
+	defineShorthand("border-color", sideLonghands("border-", "-color"), expandBox(isColorValue))
+
+	border := []string{}
+	for _, side := range []string{"top", "right", "bottom", "left"} {
+		longhands := []string{"border-" + side + "-width", "border-" + side + "-style", "border-" + side + "-color"}
+		defineShorthand("border-"+side, longhands, expandBorder(1))
+		border = append(border, longhands...)
+	}
+	defineShorthand("border", border, expandBorder(4))
+	defineShorthand("outline", []string{"outline-width", "outline-style", "outline-color"}, expandOutline)
+
+	defineShorthand("background", []string{
+		"background-color",
+		"background-image",
+		"background-repeat",
+		"background-attachment",
+		"background-position",
+		"background-size",
+		"background-origin",
+		"background-clip",
+	}, expandBackground)
+
+	defineShorthand("font", []string{
+		"font-style",
+		"font-variant",
+		"font-weight",
+		"font-stretch",
+		"font-size",
+		"line-height",
+		"font-family",
+	}, expandFont)
+
+	defineShorthand("list-style", []string{"list-style-position", "list-style-image", "list-style-type"}, expandListStyle)
+	defineShorthand("text-decoration", []string{
+		"text-decoration-line",
+		"text-decoration-style",
+		"text-decoration-color",
+		"text-decoration-thickness",
+	}, expandTextDecoration)
+	defineShorthand("flex", []string{"flex-grow", "flex-shrink", "flex-basis"}, expandFlex)
+	defineShorthand("grid-area", []string{"grid-row-start", "grid-column-start", "grid-row-end", "grid-column-end"}, expandGridArea)
+	defineShorthand("container", []string{"container-name", "container-type"}, expandContainer)
+}
+
+/*
+Replaces a shorthand declaration by its longhands. Omitted longhands are reset to their
+initial value and CSS-wide keywords apply to every longhand.
+*/
+func expandShorthand(dec Declaration, tokens []Token) ([]Declaration, error) {
+	definition, ok := SHORTHANDS[strings.ToLower(dec.Name)]
+	if !ok {
+		return []Declaration{dec}, nil
+	}
+
+	values := make([][]CssValue, len(definition.Longhands))
+	if dec.ContainsVar() {
+		return pendingSubstitution(dec, definition), nil
+	}
+	if dec.GetCssWideKeyword() != "" {
+		for i := range values {
+			values[i] = dec.Value
+		}
+	} else {
+		expanded, err := definition.expand(components(tokens))
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for shorthand '%s': %s", dec.Name, err)
+		}
+		values = expanded
+	}
+
+	longhands := []Declaration{}
+	for i, name := range definition.Longhands {
+		value := values[i]
+		if value == nil {
+			value = GetInitialValue(name)
+		}
+		longhands = append(longhands, Declaration{Name: name, Value: value, Important: dec.Important})
+	}
+
+	return longhands, nil
+}
+
+// The component values of a declaration without white space.
+func components(tokens []Token) []Token {
+	result := []Token{}
+	for _, token := range tokens {
+		if token.GetId() != Token_Whitespace {
+			result = append(result, token)
+		}
+	}
+	return result
+}
+
+func componentValue(token Token) CssValue {
+	pos := 0
+	tokens := []Token{token}
+	return parseValue(&tokens, &pos)
+}
+
+func isDelim(token Token, value rune) bool {
+	return isRune(value, &token)
+}
+
+/*
+Follows the box rules: one value sets all four sides, two set top/bottom and right/left,
+three set top, right/left and bottom, four set top, right, bottom and left.
+
+Source: https://www.w3.org/TR/css-box-4/#margin-shorthand
+*/
+func expandBox(match func(CssValue) bool) func(components []Token) ([][]CssValue, error) {
+	return func(components []Token) ([][]CssValue, error) {
+		if len(components) < 1 || len(components) > 4 {
+			return nil, fmt.Errorf("expected 1 to 4 values but got %d", len(components))
+		}
+
+		values := []CssValue{}
+		for _, component := range components {
+			value := componentValue(component)
+			if value == nil || !match(value) {
+				return nil, fmt.Errorf("unexpected value")
+			}
+			values = append(values, value)
+		}
+
+		top, right, bottom, left := values[0], values[0], values[0], values[0]
+		switch len(values) {
+		case 2:
+			right, left = values[1], values[1]
+		case 3:
+			right, bottom, left = values[1], values[2], values[1]
+		case 4:
+			right, bottom, left = values[1], values[2], values[3]
+		}
+
+		return [][]CssValue{{top}, {right}, {bottom}, {left}}, nil
+	}
+}
+
+/*
+Assigns components given in any order to the first slot accepting them. Each slot takes at
+most one component.
+
+Source: https://www.w3.org/TR/css-values-4/#comb-any
+*/
+func matchAnyOrder(components []Token, slots ...func(CssValue) bool) ([]CssValue, error) {
+	values := make([]CssValue, len(slots))
+
+	for _, component := range components {
+		value := componentValue(component)
+		if value == nil {
+			return nil, fmt.Errorf("unexpected value")
+		}
+
+		matched := false
+		for i, slot := range slots {
+			if values[i] == nil && slot(value) {
+				values[i] = value
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return nil, fmt.Errorf("unexpected value")
+		}
+	}
+
+	return values, nil
+}
+
+func toValues(values []CssValue) [][]CssValue {
+	result := make([][]CssValue, len(values))
+	for i, value := range values {
+		if value != nil {
+			result[i] = []CssValue{value}
+		}
+	}
+	return result
+}
+
+// https://www.w3.org/TR/css-backgrounds-3/#propdef-border
+func expandBorder(sides int) func(components []Token) ([][]CssValue, error) {
+	return func(components []Token) ([][]CssValue, error) {
+		values, err := matchAnyOrder(components, isLineWidth, isLineStyle, isColorValue)
+		if err != nil {
+			return nil, err
+		}
+
+		result := [][]CssValue{}
+		for i := 0; i < sides; i++ {
+			result = append(result, toValues(values)...)
+		}
+		return result, nil
+	}
+}
+
+// https://www.w3.org/TR/css-ui-4/#outline
+func expandOutline(components []Token) ([][]CssValue, error) {
+	values, err := matchAnyOrder(components, isLineWidth, func(v CssValue) bool {
+		return isLineStyle(v) || IsCssKeyword(v, "auto")
+	}, isColorValue)
+	if err != nil {
+		return nil, err
+	}
+	return toValues(values), nil
+}
+
+/*
+Expands a single background layer.
+
+Source: https://www.w3.org/TR/css-backgrounds-3/#background
+*/
+func expandBackground(components []Token) ([][]CssValue, error) {
+	var color, image, attachment CssValue
+	var repeat, position, size, boxes []CssValue
+
+	i := 0
+	for i < len(components) {
+		if components[i].GetId() == Token_Comma {
+			return nil, fmt.Errorf("multiple background layers are not supported")
+		}
+
+		value := componentValue(components[i])
+		switch {
+		case value == nil:
+			return nil, fmt.Errorf("unexpected value")
+		case color == nil && isColorValue(value):
+			color = value
+			i++
+		case image == nil && isImageValue(value):
+			image = value
+			i++
+		case attachment == nil && isKeywordIn(value, "scroll", "fixed", "local"):
+			attachment = value
+			i++
+		case repeat == nil && isKeywordIn(value, "repeat-x", "repeat-y"):
+			repeat = []CssValue{value}
+			i++
+		case repeat == nil && isKeywordIn(value, "repeat", "space", "round", "no-repeat"):
+			repeat, i = consumeWhile(components, i, 2, func(v CssValue) bool {
+				return isKeywordIn(v, "repeat", "space", "round", "no-repeat")
+			})
+		case position == nil && isPositionValue(value):
+			position, i = consumeWhile(components, i, 4, isPositionValue)
+
+			// <bg-position> [ / <bg-size> ]?
+			if i < len(components) && isDelim(components[i], '/') {
+				size, i = consumeWhile(components, i+1, 2, isBackgroundSize)
+				if size == nil {
+					return nil, fmt.Errorf("expected a background size after '/'")
+				}
+			}
+		case len(boxes) < 2 && isKeywordIn(value, "border-box", "padding-box", "content-box"):
+			boxes = append(boxes, value)
+			i++
+		default:
+			return nil, fmt.Errorf("unexpected value")
+		}
+	}
+
+	values := toValues([]CssValue{color, image, nil, attachment})
+	values[2] = repeat
+	values = append(values, position, size)
+
+	// a single box sets both the origin and the clip
+	switch len(boxes) {
+	case 0:
+		values = append(values, nil, nil)
+	case 1:
+		values = append(values, boxes, boxes)
+	default:
+		values = append(values, boxes[:1], boxes[1:])
+	}
+
+	return values, nil
+}
+
+// Consumes up to max consecutive components accepted by match.
+func consumeWhile(components []Token, i int, max int, match func(CssValue) bool) ([]CssValue, int) {
+	var values []CssValue
+	for i < len(components) && len(values) < max {
+		value := componentValue(components[i])
+		if value == nil || !match(value) {
+			break
+		}
+		values = append(values, value)
+		i++
+	}
+	return values, i
+}
+
+/*
+Expands [ <font-style> || <font-variant-css2> || <font-weight> || <font-stretch-css3> ]?
+<font-size> [ / <line-height> ]? <font-family>.
+
+Source: https://www.w3.org/TR/css-fonts-4/#font-prop
+*/
+func expandFont(components []Token) ([][]CssValue, error) {
+	if len(components) == 1 {
+		if value := componentValue(components[0]); isKeywordIn(value, "caption", "icon", "menu", "message-box", "small-caption", "status-bar") {
+			return nil, fmt.Errorf("system fonts are not supported")
+		}
+	}
+
+	var style, variant, weight, stretch CssValue
+	normals := 0
+
+	i := 0
+prefix:
+	for ; i < len(components); i++ {
+		value := componentValue(components[i])
+		switch {
+		case value == nil:
+			return nil, fmt.Errorf("unexpected value")
+		case IsCssKeyword(value, "normal"):
+			normals++
+		case style == nil && isKeywordIn(value, "italic", "oblique"):
+			style = value
+		case variant == nil && IsCssKeyword(value, "small-caps"):
+			variant = value
+		case weight == nil && isFontWeight(value):
+			weight = value
+		case stretch == nil && isKeywordIn(value, FONT_STRETCH_KEYWORDS...):
+			stretch = value
+		default:
+			break prefix
+		}
+	}
+
+	set := normals
+	for _, value := range []CssValue{style, variant, weight, stretch} {
+		if value != nil {
+			set++
+		}
+	}
+	if set > 4 {
+		return nil, fmt.Errorf("too many font values")
+	}
+
+	if i >= len(components) || !isFontSize(componentValue(components[i])) {
+		return nil, fmt.Errorf("expected a font size")
+	}
+	size := componentValue(components[i])
+	i++
+
+	var lineHeight CssValue
+	if i < len(components) && isDelim(components[i], '/') {
+		if i+1 >= len(components) || !isLineHeight(componentValue(components[i+1])) {
+			return nil, fmt.Errorf("expected a line height after '/'")
+		}
+		lineHeight = componentValue(components[i+1])
+		i += 2
+	}
+
+	families, err := parseFontFamilies(components[i:])
+	if err != nil {
+		return nil, err
+	}
+
+	// the families are a comma separated list, like a 'font-family' declaration
+	if len(families) > 1 {
+		families = []CssValue{&CssList{Separator: ',', Values: families}}
+	}
+
+	values := toValues([]CssValue{style, variant, weight, stretch, size, lineHeight})
+	return append(values, families), nil
+}
+
+/*
+Parses a comma separated list of family names. Names made of several identifiers are
+joined with a single space.
+
+Source: https://www.w3.org/TR/css-fonts-4/#family-name-syntax
+*/
+func parseFontFamilies(components []Token) ([]CssValue, error) {
+	families := []CssValue{}
+	words := []string{}
+
+	flush := func() error {
+		if len(words) == 0 {
+			return fmt.Errorf("expected a font family")
+		}
+		if len(words) == 1 {
+			families = append(families, &CssKeyword{Value: words[0]})
+		} else {
+			families = append(families, &CssString{Value: strings.Join(words, " ")})
+		}
+		words = []string{}
+		return nil
+	}
+
+	for i := 0; i < len(components); i++ {
+		switch token := components[i].(type) {
+		case *StringToken:
+			switch {
+			case token.Id == Token_Ident:
+				words = append(words, token.Value)
+			case token.Id == Token_String && len(words) == 0:
+				families = append(families, &CssString{Value: token.Value})
+				if i+1 < len(components) && components[i+1].GetId() != Token_Comma {
+					return nil, fmt.Errorf("expected ',' after a quoted font family")
+				}
+			default:
+				return nil, fmt.Errorf("unexpected value in font family")
+			}
+		case *EmptyToken:
+			if token.Id != Token_Comma {
+				return nil, fmt.Errorf("unexpected value in font family")
+			}
+			if len(words) > 0 {
+				if err := flush(); err != nil {
+					return nil, err
+				}
+			} else if len(families) == 0 || i+1 >= len(components) {
+				return nil, fmt.Errorf("expected a font family")
+			}
+		default:
+			return nil, fmt.Errorf("unexpected value in font family")
+		}
+	}
+
+	if len(words) > 0 || len(families) == 0 {
+		if err := flush(); err != nil {
+			return nil, err
+		}
+	}
+
+	return families, nil
+}
+
+/*
+Expands <'list-style-position'> || <'list-style-image'> || <'list-style-type'>. A 'none'
+sets whichever of the image and the type is not otherwise given.
+
+Source: https://www.w3.org/TR/css-lists-3/#list-style-property
+*/
+func expandListStyle(components []Token) ([][]CssValue, error) {
+	var position, image, listType CssValue
+	nones := []CssValue{}
+
+	for _, component := range components {
+		value := componentValue(component)
+		switch {
+		case value == nil:
+			return nil, fmt.Errorf("unexpected value")
+		case IsCssKeyword(value, "none"):
+			nones = append(nones, value)
+		case position == nil && isKeywordIn(value, "inside", "outside"):
+			position = value
+		case image == nil && isImageValue(value):
+			image = value
+		case listType == nil && isListStyleType(value):
+			listType = value
+		default:
+			return nil, fmt.Errorf("unexpected value")
+		}
+	}
+
+	for _, none := range nones {
+		switch {
+		case listType == nil && (image != nil || len(nones) == 1):
+			listType = none
+			if image == nil {
+				image = none
+			}
+		case image == nil:
+			image = none
+		default:
+			return nil, fmt.Errorf("too many 'none' values")
+		}
+	}
+
+	return toValues([]CssValue{position, image, listType}), nil
+}
+
+// https://www.w3.org/TR/css-text-decor-4/#text-decoration-property
+func expandTextDecoration(components []Token) ([][]CssValue, error) {
+	var lines []CssValue
+	var style, color, thickness CssValue
+
+	for _, component := range components {
+		value := componentValue(component)
+		switch {
+		case value == nil:
+			return nil, fmt.Errorf("unexpected value")
+		case IsCssKeyword(value, "none") && lines == nil:
+			lines = []CssValue{value}
+		case isKeywordIn(value, "underline", "overline", "line-through", "blink") && !containsKeyword(lines, "none") && !containsKeyword(lines, value.(*CssKeyword).Value):
+			lines = append(lines, value)
+		case style == nil && isKeywordIn(value, "solid", "double", "dotted", "dashed", "wavy"):
+			style = value
+		case color == nil && isColorValue(value):
+			color = value
+		case thickness == nil && (isKeywordIn(value, "auto", "from-font") || isLengthPercentage(value)):
+			thickness = value
+		default:
+			return nil, fmt.Errorf("unexpected value")
+		}
+	}
+
+	values := toValues([]CssValue{nil, style, color, thickness})
+	values[0] = lines
+	return values, nil
+}
+
+/*
+Expands none | [ <'flex-grow'> <'flex-shrink'>? || <'flex-basis'> ]. Omitted flex factors
+become 1 and an omitted basis becomes 0.
+
+Source: https://www.w3.org/TR/css-flexbox-1/#flex-property
+*/
+func expandFlex(components []Token) ([][]CssValue, error) {
+	if len(components) == 1 {
+		switch value := componentValue(components[0]); {
+		case IsCssKeyword(value, "none"):
+			return [][]CssValue{{number(0)}, {number(0)}, {&CssKeyword{Value: "auto"}}}, nil
+		case IsCssKeyword(value, "auto"):
+			return [][]CssValue{{number(1)}, {number(1)}, {&CssKeyword{Value: "auto"}}}, nil
+		}
+	}
+
+	var grow, shrink, basis CssValue
+	previousWasGrow := false
+
+	for _, component := range components {
+		value := componentValue(component)
+		isFactor := isNumberValue(value) && value.(*CssDimention).Value >= 0
+
+		switch {
+		case value == nil:
+			return nil, fmt.Errorf("unexpected value")
+		case isFactor && grow == nil:
+			grow = value
+			previousWasGrow = true
+			continue
+		case isFactor && shrink == nil && previousWasGrow:
+			shrink = value
+		case basis == nil && (isKeywordIn(value, "auto", "content") || isLengthPercentage(value)):
+			// a unitless zero that does not follow the flex factors is a basis
+			basis = value
+		default:
+			return nil, fmt.Errorf("unexpected value")
+		}
+		previousWasGrow = false
+	}
+
+	if grow == nil {
+		grow = number(1)
+	}
+	if shrink == nil {
+		shrink = number(1)
+	}
+	if basis == nil {
+		basis = &CssDimention{Value: 0, Unit: CssUnit_PX}
+	}
+
+	return [][]CssValue{{grow}, {shrink}, {basis}}, nil
+}
+
+/*
+Expands <grid-line> [ / <grid-line> ]{0,3}. Omitted lines copy the opposite line when it is
+a custom identifier and are 'auto' otherwise.
+
+Source: https://www.w3.org/TR/css-grid-2/#propdef-grid-area
+*/
+func expandGridArea(components []Token) ([][]CssValue, error) {
+	lines := [][]CssValue{{}}
+	for _, component := range components {
+		if isDelim(component, '/') {
+			lines = append(lines, []CssValue{})
+			continue
+		}
+
+		value := componentValue(component)
+		if value == nil {
+			return nil, fmt.Errorf("unexpected value")
+		}
+		lines[len(lines)-1] = append(lines[len(lines)-1], value)
+	}
+
+	if len(lines) > 4 {
+		return nil, fmt.Errorf("expected at most 4 grid lines")
+	}
+
+	for _, line := range lines {
+		if !isGridLine(line) {
+			return nil, fmt.Errorf("invalid grid line")
+		}
+	}
+
+	auto := []CssValue{&CssKeyword{Value: "auto"}}
+	copyOrAuto := func(line []CssValue) []CssValue {
+		if len(line) == 1 && IsCssValue(line[0], TCssValue_KEYWORD) && !IsCssKeyword(line[0], "auto") {
+			return line
+		}
+		return auto
+	}
+
+	if len(lines) < 2 {
+		lines = append(lines, copyOrAuto(lines[0]))
+	}
+	if len(lines) < 3 {
+		lines = append(lines, copyOrAuto(lines[0]))
+	}
+	if len(lines) < 4 {
+		lines = append(lines, copyOrAuto(lines[1]))
+	}
+
+	return lines, nil
+}
+
+/*
+Expands <'container-name'> [ / <'container-type'> ]?, an omitted type is 'normal'.
+
+Source: https://www.w3.org/TR/css-contain-3/#container-shorthand
+*/
+func expandContainer(components []Token) ([][]CssValue, error) {
+	parts := [][]CssValue{{}}
+	for _, component := range components {
+		if isDelim(component, '/') {
+			parts = append(parts, []CssValue{})
+			continue
+		}
+
+		value := componentValue(component)
+		if value == nil {
+			return nil, fmt.Errorf("unexpected value")
+		}
+		parts[len(parts)-1] = append(parts[len(parts)-1], value)
+	}
+
+	if len(parts) > 2 {
+		return nil, fmt.Errorf("expected at most one '/'")
+	}
+	if !PROPERTIES["container-name"].Grammar.Matches(parts[0]) {
+		return nil, fmt.Errorf("invalid container name")
+	}
+	if len(parts) == 1 {
+		return [][]CssValue{parts[0], nil}, nil
+	}
+	if !PROPERTIES["container-type"].Grammar.Matches(parts[1]) {
+		return nil, fmt.Errorf("invalid container type")
+	}
+	return parts, nil
+}
+
+// https://www.w3.org/TR/css-grid-2/#typedef-grid-row-start-grid-line
+func isGridLine(line []CssValue) bool {
+	if len(line) == 0 || len(line) > 3 {
+		return false
+	}
+	if len(line) == 1 && IsCssKeyword(line[0], "auto") {
+		return true
+	}
+
+	idents, integers, spans := 0, 0, 0
+	for _, value := range line {
+		switch {
+		case IsCssKeyword(value, "span"):
+			spans++
+		case IsCssKeyword(value, "auto"):
+			return false
+		case IsCssValue(value, TCssValue_KEYWORD):
+			idents++
+		case isNumberValue(value) && value.(*CssDimention).Value != 0:
+			integers++
+		default:
+			return false
+		}
+	}
+
+	return idents <= 1 && integers <= 1 && spans <= 1 && idents+integers > 0
+}
+
+func number(value float32) CssValue {
+	return &CssDimention{Value: value, Unit: CssUnit_NO_UNIT}
+}
@@ -0,0 +1,230 @@
+package plex_css
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+/*
+A feature query of @supports or of the supports() of an @import. The engine supports a
+declaration when it knows the property and the property's grammar accepts the value, and a
+selector when the selector parser accepts it.
+
+Source: https://www.w3.org/TR/css-conditional-3/#at-supports
+*/
+type SupportsCondition interface {
+	Matches() bool
+}
+
+// The prelude of an @supports rule.
+type SupportsQuery struct {
+	Condition SupportsCondition
+	prelude   []Token
+}
+
+func (q *SupportsQuery) CssText() string {
+	return serializeTokens(q.prelude)
+}
+
+// Reports whether the feature query of every enclosing @supports rule holds.
+func (r *Rule) IsSupported() bool {
+	for i := range r.Supports {
+		if !r.Supports[i].Condition.Matches() {
+			return false
+		}
+	}
+	return true
+}
+
+type supportsNot struct {
+	condition SupportsCondition
+}
+
+func (c supportsNot) Matches() bool {
+	return !c.condition.Matches()
+}
+
+type supportsAnd []SupportsCondition
+
+func (c supportsAnd) Matches() bool {
+	for _, condition := range c {
+		if !condition.Matches() {
+			return false
+		}
+	}
+	return true
+}
+
+type supportsOr []SupportsCondition
+
+func (c supportsOr) Matches() bool {
+	return slices.ContainsFunc(c, SupportsCondition.Matches)
+}
+
+// General enclosed syntax the engine does not know, it is never supported.
+type supportsUnknown struct{}
+
+func (c supportsUnknown) Matches() bool {
+	return false
+}
+
+// https://www.w3.org/TR/css-conditional-3/#typedef-supports-decl
+type supportsDeclaration struct {
+	tokens []Token
+}
+
+func (c supportsDeclaration) Matches() bool {
+	if slices.ContainsFunc(c.tokens, func(token Token) bool { return token.GetId() == Token_Semicolon }) {
+		return false
+	}
+
+	parser := CssParser{}
+	parser.input = c.tokens
+	parser.len = len(c.tokens)
+	declarations, _ := parser.ConsumeDeclarationsList()
+	return len(declarations) > 0 && len(parser.Diagnostics) == 0
+}
+
+// https://www.w3.org/TR/css-conditional-4/#typedef-supports-selector-fn
+type supportsSelector struct {
+	tokens []Token
+}
+
+func (c supportsSelector) Matches() bool {
+	return isSelectorSupported(c.tokens)
+}
+
+// Reports whether the tokens are a single complex selector the engine can match.
+func isSelectorSupported(tokens []Token) bool {
+	tokens = trimWhitespace(tokens)
+	if len(tokens) == 0 || slices.ContainsFunc(tokens, func(token Token) bool { return token.GetId() == Token_Comma }) {
+		return false
+	}
+	_, err := ParseComplexSelector(tokens, nil)
+	return err == nil
+}
+
+// https://www.w3.org/TR/css-conditional-5/#typedef-supports-font-tech-fn
+type supportsFontTech string
+
+func (c supportsFontTech) Matches() bool {
+	return isStringIn(string(c), FONT_TECHNOLOGIES)
+}
+
+// https://www.w3.org/TR/css-conditional-5/#typedef-supports-font-format-fn
+type supportsFontFormat string
+
+func (c supportsFontFormat) Matches() bool {
+	return isStringIn(string(c), FONT_FORMATS)
+}
+
+// https://www.w3.org/TR/css-conditional-3/#typedef-supports-condition
+func ParseSupportsCondition(tokens []Token) (SupportsCondition, error) {
+	parser := supportsParser{tokens: components(tokens)}
+	condition, err := parser.condition()
+	if err != nil {
+		return nil, err
+	}
+	if parser.pos < len(parser.tokens) {
+		return nil, fmt.Errorf("unexpected content after the supports condition")
+	}
+	return condition, nil
+}
+
+// Parses feature queries from component values without white space.
+type supportsParser struct {
+	tokens []Token
+	pos    int
+}
+
+func (p *supportsParser) keyword() string {
+	if p.pos >= len(p.tokens) || p.tokens[p.pos].GetId() != Token_Ident {
+		return ""
+	}
+	return strings.ToLower(p.tokens[p.pos].(*StringToken).Value)
+}
+
+func (p *supportsParser) condition() (SupportsCondition, error) {
+	if p.keyword() == "not" {
+		p.pos++
+		condition, err := p.inParens()
+		return supportsNot{condition}, err
+	}
+
+	first, err := p.inParens()
+	if err != nil {
+		return nil, err
+	}
+
+	conditions := []SupportsCondition{first}
+	operator := ""
+	for keyword := p.keyword(); keyword == "and" || keyword == "or"; keyword = p.keyword() {
+		// and and or can not be mixed without parentheses
+		if operator != "" && operator != keyword {
+			return nil, fmt.Errorf("'and' and 'or' can not be mixed")
+		}
+		operator = keyword
+		p.pos++
+
+		condition, err := p.inParens()
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, condition)
+	}
+
+	switch operator {
+	case "and":
+		return supportsAnd(conditions), nil
+	case "or":
+		return supportsOr(conditions), nil
+	}
+	return first, nil
+}
+
+// https://www.w3.org/TR/css-conditional-3/#typedef-supports-in-parens
+func (p *supportsParser) inParens() (SupportsCondition, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("expected a supports condition")
+	}
+
+	token := p.tokens[p.pos]
+	p.pos++
+
+	switch t := token.(type) {
+	case *FunctionBlock:
+		return supportsFunction(t), nil
+	case *SimpleBlock:
+		if t.BlockType != Token_Pren_Close {
+			break
+		}
+		if condition, err := ParseSupportsCondition(t.Tokens); err == nil {
+			return condition, nil
+		}
+		contents := components(t.Tokens)
+		if len(contents) > 1 && contents[0].GetId() == Token_Ident && contents[1].GetId() == Token_Colon {
+			return supportsDeclaration{tokens: t.Tokens}, nil
+		}
+		return supportsUnknown{}, nil
+	}
+	return nil, fmt.Errorf("expected '(' in the supports condition")
+}
+
+// Functions other than selector(), font-tech() and font-format() are general enclosed syntax.
+func supportsFunction(function *FunctionBlock) SupportsCondition {
+	switch strings.ToLower(function.Name) {
+	case "selector":
+		return supportsSelector{tokens: function.spacedArgs()}
+	case "font-tech", "font-format":
+		if len(function.Args) != 1 || function.Args[0].GetId() != Token_Ident {
+			break
+		}
+		keyword := strings.ToLower(function.Args[0].(*StringToken).Value)
+		if strings.EqualFold(function.Name, "font-tech") {
+			return supportsFontTech(keyword)
+		}
+		return supportsFontFormat(keyword)
+	}
+	return supportsUnknown{}
+}
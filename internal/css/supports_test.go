@@ -0,0 +1,97 @@
+package plex_css_test
+
+import (
+	"testing"
+	plex_css "visualsource/plex/internal/css"
+)
+
+func TestSupports_MATCHES(t *testing.T) {
+	tests := map[string]bool{
+		"(display: block)":                              true,
+		"(display: flex)":                               true,
+		"(display: red)":                                false,
+		"(display: FLEX)":                               true,
+		"(DISPLAY: Block)":                              true,
+		"(not-a-property: 1px)":                         false,
+		"(color: red !important)":                       true,
+		"not (display: red)":                            true,
+		"(display: block) and (color: 10)":              false,
+		"(display: block) or (color: 10)":               true,
+		"((display: block) and (color: red)) or (a: b)": true,
+		"(--custom: anything)":                          true,
+		"selector(p)":                                   true,
+		"selector(div.note#main)":                       true,
+		"selector(p::before)":                           true,
+		"selector(:has(a))":                             false,
+		"selector(a:hover)":                             true,
+		"selector(a:link)":                              false,
+		"selector(div p)":                               true,
+		"selector(.a .b)":                               true,
+		"selector(div > p + a ~ b)":                     true,
+		"selector(div >)":                               false,
+		"selector(&)":                                   false,
+		"selector(p::before span)":                      false,
+		"selector(p, div)":                              false,
+		"not selector(:has(a))":                         true,
+		"font-format(truetype)":                         true,
+		"font-format(woff2)":                            false,
+		"font-format(\"truetype\")":                     false,
+		"font-tech(color-colrv1)":                       false,
+		"not font-tech(variations)":                     true,
+		"unknown(display: block)":                       false,
+		"(unknown syntax here)":                         false,
+	}
+
+	for condition, expected := range tests {
+		parser := plex_css.CssParser{}
+		stylesheet, err := parser.ParseStylesheet("@supports "+condition+" { p { color: red } }", plex_css.Origin_Author)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		if len(stylesheet.Rules) != 1 {
+			t.Fatalf("%q: expected the @supports rule to be flattened into one rule, got %v", condition, stylesheet)
+		}
+		if stylesheet.Rules[0].IsSupported() != expected {
+			t.Errorf("expected %q to hold: %v", condition, expected)
+		}
+	}
+}
+
+func TestSupports_NESTED(t *testing.T) {
+	parser := plex_css.CssParser{}
+	stylesheet, err := parser.ParseStylesheet(`
+		p { color: blue }
+		@supports (display: block) {
+			@media screen {
+				div { color: red }
+			}
+			@supports not (display: block) {
+				span { color: green }
+			}
+		}
+		@supports display: block { a { color: red } }
+		h1 { color: white }
+	`, plex_css.Origin_Author)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if len(stylesheet.Rules) != 4 || len(stylesheet.AtRules) != 0 || len(stylesheet.Diagnostics) != 1 {
+		t.Fatalf("expected 4 rules and the invalid @supports to be reported, got %v", stylesheet)
+	}
+
+	supported := []string{}
+	for _, rule := range stylesheet.Rules {
+		if rule.IsSupported() {
+			supported = append(supported, plex_css.SerializeSelectorList(rule.Selector))
+		}
+	}
+	if len(supported) != 3 || supported[0] != "p" || supported[1] != "div" || supported[2] != "h1" {
+		t.Fatalf("unexpected supported rules %v", supported)
+	}
+
+	expected := "@media screen { @supports (display: block) { div { color: red; } } }"
+	if text := stylesheet.Rules[1].CssText(); text != expected {
+		t.Fatalf("expected %q, got %q", expected, text)
+	}
+}
@@ -1,7 +1,7 @@
 package plex_css
 
 import (
-	"fmt"
+	"errors"
 	"strconv"
 	"strings"
 	"unicode"
@@ -24,17 +24,21 @@ func CreateTestTokenizer(value string) Tokenizer {
 	}
 }
 
+/*
+Splits the input into tokens ending with an EOF token. Tokenizing never fails: every parse
+error is recovered from as the specification says, such as a string closed at the end of the
+input or a newline in a string giving a <bad-string-token>.
+
+Source: https://www.w3.org/TR/css-syntax-3/#tokenization
+*/
 func (t *Tokenizer) Parse(value string) ([]Token, error) {
 	t.pos = 0
-	t.data = []rune(value)
+	t.data = preprocess(value)
 	t.len = len(t.data)
 	t.Tokens = []Token{}
 
 	for !t.eof() {
-		err := t.ConsumeToken()
-		if err != nil {
-			return nil, err
-		}
+		t.ConsumeToken()
 	}
 
 	t.Tokens = append(t.Tokens, &EmptyToken{Id: Token_EOF})
@@ -42,17 +46,15 @@ func (t *Tokenizer) Parse(value string) ([]Token, error) {
 	return t.Tokens, nil
 }
 
-func (t *Tokenizer) ConsumeToken() error {
+// https://www.w3.org/TR/css-syntax-3/#consume-token
+func (t *Tokenizer) ConsumeToken() {
 	char := t.data[t.pos]
 	switch {
 	case t.CheckNextTwo('/', '*'):
 		t.ConsumeComment()
 	case char == '"' || char == '\'':
-		err := t.ConsumeString()
-		if err != nil {
-			return err
-		}
-	case unicode.IsSpace(char):
+		t.ConsumeString()
+	case isWhitespace(char):
 		t.ConsumeWhilespace()
 		t.Tokens = append(t.Tokens, &EmptyToken{Id: Token_Whitespace})
 	case char == '#':
@@ -72,7 +74,7 @@ func (t *Tokenizer) ConsumeToken() error {
 				Flag:  flagType,
 			})
 
-			return nil
+			return
 		}
 
 		t.Tokens = append(t.Tokens, &RuneToken{Id: Token_Delim, Value: char})
@@ -85,7 +87,7 @@ func (t *Tokenizer) ConsumeToken() error {
 	case char == '+':
 		if t.DoNextStartNumber() {
 			t.ConsumeNumeric()
-			return nil
+			return
 		}
 		t.Tokens = append(t.Tokens, &RuneToken{Id: Token_Delim, Value: char})
 		t.pos++
@@ -95,7 +97,7 @@ func (t *Tokenizer) ConsumeToken() error {
 	case char == '-':
 		if t.DoNextStartNumber() {
 			t.ConsumeNumeric()
-			return nil
+			return
 		}
 
 		if t.pos+2 < t.len && t.data[t.pos+1] == '-' && t.data[t.pos+2] == '>' {
@@ -103,12 +105,12 @@ func (t *Tokenizer) ConsumeToken() error {
 
 			t.Tokens = append(t.Tokens, &EmptyToken{Id: Token_CDC})
 
-			return nil
+			return
 		}
 
 		if t.DoNextStartIdentSequence() {
 			t.ConsumeIdentLike()
-			return nil
+			return
 		}
 
 		t.Tokens = append(t.Tokens, &RuneToken{Id: Token_Delim, Value: char})
@@ -121,7 +123,7 @@ func (t *Tokenizer) ConsumeToken() error {
 		*/
 		if t.DoNextStartNumber() {
 			t.ConsumeNumeric()
-			return nil
+			return
 		}
 
 		t.Tokens = append(t.Tokens, &RuneToken{Id: Token_Delim, Value: char})
@@ -136,7 +138,7 @@ func (t *Tokenizer) ConsumeToken() error {
 		if t.IsNextRune('!', 1) && t.IsNextRune('-', 2) && t.IsNextRune('-', 3) {
 			t.pos += 4
 			t.Tokens = append(t.Tokens, &EmptyToken{Id: Token_CDO})
-			return nil
+			return
 		}
 
 		t.Tokens = append(t.Tokens, &RuneToken{Id: Token_Delim, Value: char})
@@ -156,7 +158,7 @@ func (t *Tokenizer) ConsumeToken() error {
 				Value: string(value),
 			})
 
-			return nil
+			return
 		}
 
 		t.Tokens = append(t.Tokens, &RuneToken{Id: Token_Delim, Value: '@'})
@@ -170,7 +172,7 @@ func (t *Tokenizer) ConsumeToken() error {
 
 		if t.AreNextValidEscape(0) {
 			t.ConsumeIdentLike()
-			return nil
+			return
 		}
 
 		/*
@@ -182,14 +184,13 @@ func (t *Tokenizer) ConsumeToken() error {
 		// Return a <delim-token> with its value set to the current input code point.
 		t.Tokens = append(t.Tokens, &RuneToken{Id: Token_Delim, Value: char})
 		t.pos++
-		return fmt.Errorf("invalid escape")
 	case char == '{':
 		t.Tokens = append(t.Tokens, &EmptyToken{Id: Token_Clearly_Open})
 		t.pos++
 	case char == '}':
 		t.Tokens = append(t.Tokens, &EmptyToken{Id: Token_Clearly_Close})
 		t.pos++
-	case unicode.IsDigit(char):
+	case isDigit(char):
 		t.ConsumeNumeric()
 	case isIdentStartCodePoint(char):
 		t.ConsumeIdentLike()
@@ -197,12 +198,10 @@ func (t *Tokenizer) ConsumeToken() error {
 		t.Tokens = append(t.Tokens, &RuneToken{Id: Token_Delim, Value: char})
 		t.pos++
 	}
-
-	return nil
 }
 
 func (t *Tokenizer) ConsumeWhilespace() {
-	for !t.eof() && unicode.IsSpace(t.data[t.pos]) {
+	for !t.eof() && isWhitespace(t.data[t.pos]) {
 		t.pos++
 	}
 }
@@ -218,7 +217,9 @@ func (t *Tokenizer) ConsumeComment() {
 	}
 }
 func (t *Tokenizer) ConsumeNumeric() {
+	start := t.pos
 	value, dataType := t.ConsumeNumber()
+	repr := string(t.data[start:t.pos])
 
 	if t.DoNextStartIdentSequence() {
 		ident := t.ConsumeIdent()
@@ -228,6 +229,7 @@ func (t *Tokenizer) ConsumeNumeric() {
 			Value:    value,
 			DataType: dataType,
 			Unit:     string(ident),
+			Repr:     repr,
 		})
 		return
 	}
@@ -239,6 +241,7 @@ func (t *Tokenizer) ConsumeNumeric() {
 			Id:       Token_Percentage,
 			DataType: dataType,
 			Value:    value,
+			Repr:     repr,
 		})
 
 		return
@@ -248,6 +251,7 @@ func (t *Tokenizer) ConsumeNumeric() {
 		Id:       Token_Number,
 		Value:    value,
 		DataType: dataType,
+		Repr:     repr,
 	})
 }
 
@@ -260,21 +264,18 @@ func (t *Tokenizer) ConsumeIdentLike() {
 		t.pos++
 
 		// See https://github.com/w3c/csswg-drafts/issues/5416 for clearity on https://www.w3.org/TR/css-syntax-3/#consume-an-ident-like-token url function parse
-		for {
-			if unicode.IsSpace(t.data[t.pos]) && unicode.IsSpace(t.data[t.pos+1]) {
-				t.pos++
-				continue
-			}
-
-			if (t.data[t.pos] == '"' || t.data[t.pos] == '\'') || (t.data[t.pos+1] == '"' || t.data[t.pos+1] == '\'') {
-				t.Tokens = append(t.Tokens, &StringToken{
-					Id:    Token_Function,
-					Value: string(value),
-				})
-				return
-			}
+		for t.pos+1 < t.len && isWhitespace(t.data[t.pos]) && isWhitespace(t.data[t.pos+1]) {
+			t.pos++
+		}
 
-			break
+		// a quoted url is a function taking a string
+		isQuote := func(offset int) bool { return t.IsNextRune('"', offset) || t.IsNextRune('\'', offset) }
+		if isQuote(0) || (!t.eof() && isWhitespace(t.data[t.pos]) && isQuote(1)) {
+			t.Tokens = append(t.Tokens, &StringToken{
+				Id:    Token_Function,
+				Value: string(value),
+			})
+			return
 		}
 
 		t.ConsumeUrl()
@@ -295,7 +296,9 @@ func (t *Tokenizer) ConsumeIdentLike() {
 		Value: string(value),
 	})
 }
-func (t *Tokenizer) ConsumeString() error {
+
+// https://www.w3.org/TR/css-syntax-3/#consume-string-token
+func (t *Tokenizer) ConsumeString() {
 	delim := t.data[t.pos]
 	t.pos++
 
@@ -306,147 +309,189 @@ func (t *Tokenizer) ConsumeString() error {
 		case t.eof():
 			// This is a parse error. Return the <string-token>.
 			t.Tokens = append(t.Tokens, &StringToken{Id: Token_String, Value: string(content)})
-			return nil
+			return
 		case t.data[t.pos] == delim:
 			t.pos++
 			// finish
 			t.Tokens = append(t.Tokens, &StringToken{Id: Token_String, Value: string(content)})
-			return nil
+			return
 		case t.data[t.pos] == '\n':
 			// This is a parse error. Reconsume the current input code point, create a <bad-string-token>, and return it.
-			return fmt.Errorf("TODO implement newline in string. see: https://www.w3.org/TR/css-syntax-3/#consume-string-token")
+			t.Tokens = append(t.Tokens, &StringToken{Id: Token_Bad_String, Value: string(content)})
+			return
 		case t.data[t.pos] == '\\':
-			return fmt.Errorf("TODO implement escape parseing. see: https://www.w3.org/TR/css-syntax-3/#consume-string-token")
+			t.pos++
+			switch {
+			case t.eof():
+				// an escape at the end of the input is dropped
+			case t.data[t.pos] == '\n':
+				// an escaped newline continues the string on the next line
+				t.pos++
+			default:
+				content = append(content, t.ConsumeEscaped())
+			}
 		default:
 			content = append(content, t.data[t.pos])
 			t.pos++
 		}
 	}
 }
-func (t *Tokenizer) ConsumeUrl() error {
+
+// https://www.w3.org/TR/css-syntax-3/#consume-url-token
+func (t *Tokenizer) ConsumeUrl() {
 	t.ConsumeWhilespace()
 
 	reper := []rune{}
 
-L:
 	for {
 		switch {
+		case t.eof():
+			// This is a parse error. Return the <url-token>.
+			t.Tokens = append(t.Tokens, &StringToken{Id: Token_Url, Value: string(reper)})
+			return
 		case t.IsCurrent(')'):
 			t.pos++
-			break L
-		case unicode.IsSpace(t.data[t.pos]):
+			t.Tokens = append(t.Tokens, &StringToken{Id: Token_Url, Value: string(reper)})
+			return
+		case isWhitespace(t.data[t.pos]):
+			// white space is only allowed before the closing parenthesis
 			t.ConsumeWhilespace()
-		case t.IsCurrent('"') || t.IsCurrent('\'') || t.IsCurrent('(') || !unicode.IsPrint(t.data[t.pos]):
-			for !t.eof() && !t.IsCurrent('}') {
-				if t.AreNextValidEscape(0) {
-					t.ConsumeEscaped()
-				} else {
-					t.pos++
-				}
-			}
-
-			if t.IsCurrent('}') {
-				t.pos++
+			if t.eof() || t.IsCurrent(')') {
+				continue
 			}
-
-			t.Tokens = append(t.Tokens, &EmptyToken{Id: Token_Bad_Url})
-			return nil
+			t.consumeBadUrl()
+			return
+		case t.IsCurrent('"') || t.IsCurrent('\'') || t.IsCurrent('(') || isNonPrintable(t.data[t.pos]):
+			t.consumeBadUrl()
+			return
 		case t.IsCurrent('\\'):
-			t.pos++
-			data, err := t.ConsumeEscaped()
-			if err != nil {
-				return err
+			if !t.AreNextValidEscape(0) {
+				t.consumeBadUrl()
+				return
 			}
-			reper = append(reper, data...)
+			t.pos++
+			reper = append(reper, t.ConsumeEscaped())
 		default:
 			reper = append(reper, t.data[t.pos])
 			t.pos++
 		}
 	}
-
-	t.Tokens = append(t.Tokens, &StringToken{
-		Id:    Token_Url,
-		Value: string(reper),
-	})
-
-	return nil
 }
 
-func (t *Tokenizer) ConsumeEscaped() ([]rune, error) {
+// Consumes what is left of a bad url up to its closing parenthesis, escaped ones included.
+// https://www.w3.org/TR/css-syntax-3/#consume-remnants-of-bad-url
+func (t *Tokenizer) consumeBadUrl() {
+	for !t.eof() && !t.IsCurrent(')') {
+		if t.AreNextValidEscape(0) {
+			t.pos++
+			t.ConsumeEscaped()
+			continue
+		}
+		t.pos++
+	}
+	if t.IsCurrent(')') {
+		t.pos++
+	}
+	t.Tokens = append(t.Tokens, &EmptyToken{Id: Token_Bad_Url})
+}
 
-	target := []rune{}
+/*
+Consumes the code point after a '\': up to 6 hex digits and a single white space after them,
+or any other code point as itself. Escapes of 0, surrogates, code points past the maximum and
+the end of the input give U+FFFD.
 
+Source: https://www.w3.org/TR/css-syntax-3/#consume-escaped-code-point
+*/
+func (t *Tokenizer) ConsumeEscaped() rune {
 	if t.eof() {
-		target = append(target, '�')
-	} else if unicode.IsDigit(t.data[t.pos]) || unicode.IsLetter(t.data[t.pos]) {
-		// prase hex digit
-		return target, fmt.Errorf("TODO: handle hex escape code")
-	} else {
-		target = append(target, t.data[t.pos])
+		return unicode.ReplacementChar
+	}
+
+	if !isHexDigit(t.data[t.pos]) {
+		char := t.data[t.pos]
+		t.pos++
+		return char
+	}
+
+	value := 0
+	for i := 0; i < 6 && !t.eof() && isHexDigit(t.data[t.pos]); i++ {
+		digit, _ := strconv.ParseInt(string(t.data[t.pos]), 16, 32)
+		value = value*16 + int(digit)
+		t.pos++
+	}
+	if !t.eof() && isWhitespace(t.data[t.pos]) {
+		t.pos++
 	}
 
-	return target, nil
+	if value == 0 || (value >= 0xD800 && value <= 0xDFFF) || value > unicode.MaxRune {
+		return unicode.ReplacementChar
+	}
+	return rune(value)
 }
+
+// https://www.w3.org/TR/css-syntax-3/#consume-name
 func (t *Tokenizer) ConsumeIdent() []rune {
 
 	result := []rune{}
-	// TODO: add escape parsing. See https://www.w3.org/TR/css-syntax-3/#consume-an-ident-sequence
-	for !t.eof() && isIdentCodePoint(t.data[t.pos]) {
-		result = append(result, t.data[t.pos])
-		t.pos++
+	for !t.eof() {
+		switch {
+		case isIdentCodePoint(t.data[t.pos]):
+			result = append(result, t.data[t.pos])
+			t.pos++
+		case t.AreNextValidEscape(0):
+			t.pos++
+			result = append(result, t.ConsumeEscaped())
+		default:
+			return result
+		}
 	}
 
 	return result
 }
+
+// https://www.w3.org/TR/css-syntax-3/#consume-number
 func (t *Tokenizer) ConsumeNumber() (float32, NumberType) {
 	var valueType NumberType = NumberType_Integer
 
 	reper := []rune{}
-
-	if t.data[t.pos] == '+' || t.data[t.pos] == '-' {
-		reper = append(reper, t.data[t.pos])
-		t.pos++
+	consumeDigits := func() {
+		for !t.eof() && isDigit(t.data[t.pos]) {
+			reper = append(reper, t.data[t.pos])
+			t.pos++
+		}
 	}
 
-	for !t.eof() && unicode.IsDigit(t.data[t.pos]) {
+	if t.IsCurrent('+') || t.IsCurrent('-') {
 		reper = append(reper, t.data[t.pos])
 		t.pos++
 	}
+	consumeDigits()
 
-	if !t.eof() && t.data[t.pos] == '.' && unicode.IsDigit(t.data[t.pos+1]) {
-		reper = append(reper, t.data[t.pos])
-		t.pos++
+	if t.IsCurrent('.') && t.isDigitAt(1) {
 		reper = append(reper, t.data[t.pos])
 		t.pos++
 		valueType = NumberType_Number
-		for !t.eof() && unicode.IsDigit(t.data[t.pos]) {
-			reper = append(reper, t.data[t.pos])
-			t.pos++
-		}
+		consumeDigits()
 	}
 
-	if !t.eof() && (t.data[t.pos] == 'e' || t.data[t.pos] == 'E') && (unicode.IsDigit(t.data[t.pos+1]) ||
-		(t.data[t.pos+1] == '+' || t.data[t.pos+1] == '-' && unicode.IsDigit(t.data[t.pos+2]))) {
-
-		reper = append(reper, t.data[t.pos])
-		t.pos++
-
-		if t.data[t.pos] == '+' || t.data[t.pos] == '-' {
-			reper = append(reper, t.data[t.pos])
-			t.pos++
+	// an exponent needs a digit after the optional sign
+	if t.IsCurrent('e') || t.IsCurrent('E') {
+		sign := 0
+		if t.IsNextRune('+', 1) || t.IsNextRune('-', 1) {
+			sign = 1
 		}
-
-		valueType = NumberType_Number
-
-		for !t.eof() && unicode.IsDigit(t.data[t.pos]) {
-			reper = append(reper, t.data[t.pos])
-			t.pos++
+		if t.isDigitAt(1 + sign) {
+			reper = append(reper, t.data[t.pos:t.pos+1+sign]...)
+			t.pos += 1 + sign
+			valueType = NumberType_Number
+			consumeDigits()
 		}
 	}
 
 	value, err := strconv.ParseFloat(string(reper), 32)
 
-	if err != nil {
+	// numbers out of the float32 range are clamped to it
+	if err != nil && !errors.Is(err, strconv.ErrRange) {
 		return 0, valueType
 	}
 
@@ -495,29 +540,25 @@ func (t *Tokenizer) eof() bool {
 
 // https://www.w3.org/TR/css-syntax-3/#starts-with-a-valid-escape
 func (t *Tokenizer) AreNextValidEscape(offset int) bool {
-	if (offset+t.pos)+1 >= t.len-1 {
+	if !t.IsNextRune('\\', offset) {
 		return false
 	}
 
-	if t.data[(offset+t.pos)] != '\\' {
-		return false
-	}
-
-	if t.data[(offset+t.pos)+1] == '\n' {
-		return false
-	}
-
-	return true
+	// a '\' at the end of the input escapes to U+FFFD
+	return !t.IsNextRune('\n', offset+1)
 }
 
 // https://www.w3.org/TR/css-syntax-3/#would-start-an-identifier
 func (t *Tokenizer) DoNextStartIdentSequence() bool {
-	if t.pos+2 >= t.len {
+	if t.eof() {
 		return false
 	}
 	char := t.data[t.pos]
 	switch {
 	case char == '-':
+		if t.pos+1 >= t.len {
+			return false
+		}
 		if isIdentStartCodePoint(t.data[t.pos+1]) || t.data[t.pos+1] == '-' {
 			return true
 		}
@@ -537,16 +578,16 @@ func (t *Tokenizer) DoNextStartIdentSequence() bool {
 }
 
 func (t *Tokenizer) DoNextStartNumber() bool {
-	if t.pos+3 > t.len {
+	if t.eof() {
 		return false
 	}
 
 	if t.data[t.pos] == '+' || t.data[t.pos] == '-' {
-		if unicode.IsDigit(t.data[t.pos+1]) {
+		if t.isDigitAt(1) {
 			return true
 		}
 
-		if t.data[t.pos+1] == '.' && unicode.IsDigit(t.data[t.pos+2]) {
+		if t.IsNextRune('.', 1) && t.isDigitAt(2) {
 			return true
 		}
 
@@ -554,20 +595,65 @@ func (t *Tokenizer) DoNextStartNumber() bool {
 	}
 
 	if t.data[t.pos] == '.' {
-		return unicode.IsDigit(t.data[t.pos+1])
+		return t.isDigitAt(1)
 	}
 
-	if unicode.IsDigit(t.data[t.pos]) {
-		return true
-	}
+	return isDigit(t.data[t.pos])
+}
 
-	return false
+func (t *Tokenizer) isDigitAt(offset int) bool {
+	return t.pos+offset < t.len && isDigit(t.data[t.pos+offset])
 }
 
+// https://www.w3.org/TR/css-syntax-3/#ident-start-code-point
 func isIdentStartCodePoint(value rune) bool {
-	return unicode.IsLetter(value) || value == '_'
+	return (value >= 'a' && value <= 'z') || (value >= 'A' && value <= 'Z') || value == '_' || value >= 0x80
 }
 
 func isIdentCodePoint(value rune) bool {
-	return isIdentStartCodePoint(value) || unicode.IsDigit(value) || value == '-'
+	return isIdentStartCodePoint(value) || isDigit(value) || value == '-'
+}
+
+func isDigit(value rune) bool {
+	return value >= '0' && value <= '9'
+}
+
+func isHexDigit(value rune) bool {
+	return isDigit(value) || (value >= 'a' && value <= 'f') || (value >= 'A' && value <= 'F')
+}
+
+// Newlines are normalized to '\n' before tokenizing.
+func isWhitespace(value rune) bool {
+	return value == '\n' || value == '\t' || value == ' '
+}
+
+// https://www.w3.org/TR/css-syntax-3/#non-printable-code-point
+func isNonPrintable(value rune) bool {
+	return value <= 0x08 || value == 0x0B || (value >= 0x0E && value <= 0x1F) || value == 0x7F
+}
+
+/*
+Replaces CR LF, CR and FF by LF, and NULL and surrogates by U+FFFD.
+
+Source: https://www.w3.org/TR/css-syntax-3/#input-preprocessing
+*/
+func preprocess(value string) []rune {
+	input := []rune(value)
+	data := make([]rune, 0, len(input))
+	for i := 0; i < len(input); i++ {
+		char := input[i]
+		switch {
+		case char == '\r':
+			if i+1 < len(input) && input[i+1] == '\n' {
+				i++
+			}
+			char = '\n'
+		case char == '\f':
+			char = '\n'
+		case char == 0 || (char >= 0xD800 && char <= 0xDFFF):
+			char = unicode.ReplacementChar
+		}
+		data = append(data, char)
+	}
+	return data
 }
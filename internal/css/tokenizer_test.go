@@ -208,8 +208,8 @@ func TestConsumeToken_HashShort(t *testing.T) {
 		if e.Value != "ID" {
 			t.Fatalf("Value does not match input")
 		}
-		if e.Flag != "unrestricted" {
-			t.Fatalf("Expected flag to be of 'unrestricted' not %s", e.Flag)
+		if e.Flag != "id" {
+			t.Fatalf("Expected flag to be of 'id' not %s", e.Flag)
 		}
 	} else {
 		t.Fatalf("Token is not a Rune token got: %v", result[0])
@@ -818,3 +818,45 @@ func TestParse(t *testing.T) {
 		t.Fatalf("Missing token expected 127 tokens got: %d", len(result))
 	}
 }
+
+func TestConsumeToken_Escape(t *testing.T) {
+	parser := plex_css.Tokenizer{}
+
+	result, err := parser.Parse(`\66 oo \0 \D800 "a\"b\
+c"`)
+	if err != nil {
+		t.Fatalf("There was an error: %s", err)
+	}
+
+	values := []string{}
+	for _, token := range result {
+		if e, ok := token.(*plex_css.StringToken); ok {
+			values = append(values, e.Value)
+		}
+	}
+	expected := []string{"foo", "��", "a\"bc"}
+	if len(values) != len(expected) {
+		t.Fatalf("Expected %q got %q", expected, values)
+	}
+	for i := range expected {
+		if values[i] != expected[i] {
+			t.Fatalf("Expected %q got %q", expected, values)
+		}
+	}
+}
+
+func TestConsumeString_Newline(t *testing.T) {
+	parser := plex_css.Tokenizer{}
+
+	result, err := parser.Parse("\"abc\ndef")
+	if err != nil {
+		t.Fatalf("There was an error: %s", err)
+	}
+
+	if result[0].GetId() != plex_css.Token_Bad_String {
+		t.Fatalf("Did not find Bad String Token Found %d", result[0].GetId())
+	}
+	if result[len(result)-1].GetId() != plex_css.Token_EOF {
+		t.Fatalf("Did not find EOF Token Found %d", result[len(result)-1].GetId())
+	}
+}
@@ -45,13 +45,25 @@ type Token interface {
 
 type FunctionBlock struct {
 	Name string
+	// the arguments without white space
 	Args []Token
+	// the arguments with their white space, to write them back as they were written and for
+	// grammars where it is significant such as selectors
+	spaced []Token
 }
 
 func (f *FunctionBlock) GetId() TokenType {
 	return TFunction
 }
 
+// The arguments as written, functions built without their white space have only Args.
+func (f *FunctionBlock) spacedArgs() []Token {
+	if f.spaced != nil {
+		return f.spaced
+	}
+	return f.Args
+}
+
 type SimpleBlock struct {
 	Tokens    []Token
 	BlockType TokenType
@@ -66,6 +78,8 @@ type NumberToken struct {
 	Value    float32
 	DataType NumberType
 	Unit     string
+	// the number as written, unicode ranges are read from it
+	Repr string
 }
 
 func (t *NumberToken) GetId() TokenType {
@@ -5,17 +5,48 @@ import (
 	"github.com/moznion/go-optional"
 )
 
+// Cascade origins in ascending order of precedence for normal declarations.
+// https://www.w3.org/TR/css-cascade-5/#cascading-origins
+const (
+	Origin_UserAgent uint = iota
+	Origin_User
+	Origin_Author
+)
+
 type Stylesheet struct {
-	Rules    []Rule
-	AtRules  []AtRule
+	Rules   []Rule
+	AtRules []AtRule
+	// the @import rules, in order; loading a stylesheet puts the imported rules in Rules
+	Imports []ImportRule
+	// the valid @font-face rules, imported ones included
+	FontFaces []FontFace
+	// the valid @page rules, imported ones included
+	Pages    []PageRule
 	TopLevel bool
 	Origin   uint
+	// cascade layer names in the order they were first declared
+	Layers []string
+	// parts of the stylesheet that were dropped while parsing
+	Diagnostics []Diagnostic
+	// set on an imported stylesheet, the number of layers declared before its @import
+	layersBefore int
+}
+
+// Explains why the parser dropped part of its input.
+type Diagnostic struct {
+	// the property of a dropped declaration
+	Property string
+	Message  string
 }
 
 type Declaration struct {
 	Name      string
 	Value     []CssValue
 	Important bool
+	// component values the value was parsed from
+	tokens []Token
+	// the shorthand a longhand is expanded from once its variables are substituted
+	shorthand string
 }
 
 func (d *Declaration) GetValue() CssValue {
@@ -37,6 +68,14 @@ type AtRule struct {
 type Rule struct {
 	Selector []Selector
 	Block    []Declaration
+	// name of the cascade layer the rule belongs to, empty when unlayered
+	Layer string
+	// media query lists of the enclosing @media rules, the rule applies when all of them match
+	Media []MediaQueryList
+	// feature queries of the enclosing @supports rules, the rule applies when all of them hold
+	Supports []SupportsQuery
+	// queries of the enclosing @container rules, the rule applies when all of them match
+	Container []ContainerQuery
 }
 
 type SelectorAttribute struct {
@@ -45,9 +84,50 @@ type SelectorAttribute struct {
 	Modifier  rune
 }
 
-type PesudoClass struct{}
-type PesudoElement struct{}
+type PesudoClass struct {
+	Name string
+}
+
+// Reports whether the engine parses the given pseudo-class. They all depend on user interaction
+// or on the location of the document, which the engine does not track, so they never match.
+func IsSupportedPseudoClass(name string) bool {
+	switch name {
+	case "hover", "active", "focus", "focus-visible", "focus-within", "visited", "target", "target-within":
+		return true
+	default:
+		return false
+	}
+}
+
+const (
+	PseudoElement_Before      = "before"
+	PseudoElement_After       = "after"
+	PseudoElement_FirstLine   = "first-line"
+	PseudoElement_FirstLetter = "first-letter"
+	PseudoElement_Marker      = "marker"
+	PseudoElement_Selection   = "selection"
+	PseudoElement_Placeholder = "placeholder"
+)
+
+type PesudoElement struct {
+	Name string
+}
+
+// Reports whether the engine knows how to style the given pseudo-element.
+func IsSupportedPseudoElement(name string) bool {
+	switch name {
+	case PseudoElement_Before, PseudoElement_After, PseudoElement_FirstLine, PseudoElement_FirstLetter,
+		PseudoElement_Marker, PseudoElement_Selection, PseudoElement_Placeholder:
+		return true
+	default:
+		return false
+	}
+}
 
+/*
+A compound selector. Complex selectors chain compounds through Previous, the rightmost
+compound is the subject of the selector.
+*/
 type Selector struct {
 	TagName        string
 	Id             string
@@ -56,6 +136,28 @@ type Selector struct {
 	PseudoClasses  []PesudoClass
 	PseudoElements []PesudoElement
 	Classes        mapset.Set[string]
+	// the selectors of the parent rule the nesting selector & stands for, one of them has to match
+	Nesting []Selector
+	// the compound before the combinator, nil for the leftmost compound
+	Previous   *Selector
+	Combinator uint
+}
+
+// https://www.w3.org/TR/selectors-4/#combinators
+const (
+	Combinator_Child uint = iota
+	Combinator_NextSibling
+	Combinator_SubsequentSibling
+	Combinator_Column
+	Combinator_Descendant
+)
+
+// Returns the name of the pseudo-element this selector targets or "" when it targets the element itself.
+func (s *Selector) GetPseudoElement() string {
+	if len(s.PseudoElements) == 0 {
+		return ""
+	}
+	return s.PseudoElements[len(s.PseudoElements)-1].Name
 }
 
 func (s *Selector) GetSpecificity() Specificity {
@@ -75,7 +177,7 @@ func (s *Selector) GetSpecificity() Specificity {
 	}
 
 	spec.B += uint(len(s.Attributes))
-	// TODO: count pseudo-classes
+	spec.B += uint(len(s.PseudoClasses))
 
 	// count the number of type selectors and pseudo-elements in the selector (= C)
 
@@ -83,21 +185,52 @@ func (s *Selector) GetSpecificity() Specificity {
 		spec.C++
 	}
 
-	// TODO: count pseudo-elemets
+	spec.C += uint(len(s.PseudoElements))
+
+	// the nesting selector counts like :is(), with its most specific selector
+	nesting := Specificity{}
+	for i := range s.Nesting {
+		if specificity := s.Nesting[i].GetSpecificity(); nesting.Less(&specificity) {
+			nesting = specificity
+		}
+	}
+	spec = spec.add(nesting)
+
+	if s.Previous != nil {
+		spec = spec.add(s.Previous.GetSpecificity())
+	}
 
 	return spec
 }
 
+func (s Specificity) add(p Specificity) Specificity {
+	return Specificity{A: s.A + p.A, B: s.B + p.B, C: s.C + p.C}
+}
+
 type Specificity struct {
 	A uint
 	B uint
 	C uint
 }
 
+// Compares specificities component by component.
+// Returns -1 when s is less specific than p, 1 when more specific and 0 when equal.
+func (s *Specificity) Compare(p *Specificity) int {
+	for _, pair := range [3][2]uint{{s.A, p.A}, {s.B, p.B}, {s.C, p.C}} {
+		if pair[0] < pair[1] {
+			return -1
+		}
+		if pair[0] > pair[1] {
+			return 1
+		}
+	}
+	return 0
+}
+
 func (s *Specificity) Greater(p *Specificity) bool {
-	return s.A > p.A || s.B > p.B || s.C > p.C
+	return s.Compare(p) > 0
 }
 
 func (s *Specificity) Less(p *Specificity) bool {
-	return s.A < p.A || s.B < p.B || s.C < p.C
+	return s.Compare(p) < 0
 }
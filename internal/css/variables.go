@@ -0,0 +1,163 @@
+package plex_css
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Reports whether the property is a custom property, a name starting with two dashes.
+// https://www.w3.org/TR/css-variables-1/#defining-variables
+func IsCustomProperty(name string) bool {
+	return strings.HasPrefix(name, "--")
+}
+
+func normalizePropertyName(name string) string {
+	if IsCustomProperty(name) {
+		return name
+	}
+	return strings.ToLower(name)
+}
+
+// The component values the declaration was parsed from. Custom properties keep their
+// value as tokens until they are substituted.
+func (d *Declaration) GetTokens() []Token {
+	return d.tokens
+}
+
+// Reports whether the declaration's value references a custom property with var().
+func (d *Declaration) ContainsVar() bool {
+	return containsVar(d.tokens)
+}
+
+func containsVar(tokens []Token) bool {
+	for _, token := range tokens {
+		switch t := token.(type) {
+		case *FunctionBlock:
+			if strings.EqualFold(t.Name, "var") || containsVar(t.Args) {
+				return true
+			}
+		case *SimpleBlock:
+			if containsVar(t.Tokens) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+/*
+Creates the declarations of a shorthand whose value contains var(). Every longhand holds the
+shorthand's tokens and is expanded again once the variables are substituted.
+
+Source: https://www.w3.org/TR/css-variables-1/#variables-in-shorthands
+*/
+func pendingSubstitution(dec Declaration, definition ShorthandDefinition) []Declaration {
+	longhands := []Declaration{}
+	for _, name := range definition.Longhands {
+		longhands = append(longhands, Declaration{
+			Name:      name,
+			Value:     dec.Value,
+			Important: dec.Important,
+			tokens:    dec.tokens,
+			shorthand: dec.Name,
+		})
+	}
+	return longhands
+}
+
+/*
+Replaces every var() in the declaration by the value lookup returns for the custom property,
+or by the fallback when it has none. The substituted declaration must match the grammar of its
+property, otherwise it is invalid at computed-value time.
+
+Source: https://www.w3.org/TR/css-variables-1/#substitute-a-var
+*/
+func SubstituteVariables(dec Declaration, lookup func(name string) ([]Token, bool)) (Declaration, error) {
+	tokens, err := substituteVar(dec.tokens, lookup)
+	if err != nil {
+		return Declaration{}, err
+	}
+
+	if dec.shorthand != "" {
+		shorthand := Declaration{Name: dec.shorthand, Value: ParseCssValue(tokens), Important: dec.Important, tokens: tokens}
+		if err := ValidateDeclaration(&shorthand); err != nil {
+			return Declaration{}, err
+		}
+
+		longhands, err := expandShorthand(shorthand, tokens)
+		if err != nil {
+			return Declaration{}, err
+		}
+		for _, longhand := range longhands {
+			if longhand.Name == dec.Name {
+				return longhand, nil
+			}
+		}
+		return Declaration{}, fmt.Errorf("'%s' does not set '%s'", dec.shorthand, dec.Name)
+	}
+
+	result := Declaration{Name: dec.Name, Value: ParseCssValue(tokens), Important: dec.Important, tokens: tokens}
+	if err := ValidateDeclaration(&result); err != nil {
+		return Declaration{}, err
+	}
+	return result, nil
+}
+
+func substituteVar(tokens []Token, lookup func(name string) ([]Token, bool)) ([]Token, error) {
+	result := []Token{}
+	for _, token := range tokens {
+		switch t := token.(type) {
+		case *FunctionBlock:
+			if strings.EqualFold(t.Name, "var") {
+				value, err := resolveVar(t, lookup)
+				if err != nil {
+					return nil, err
+				}
+				result = append(result, value...)
+				continue
+			}
+
+			args, err := substituteVar(t.spacedArgs(), lookup)
+			if err != nil {
+				return nil, err
+			}
+			// function arguments are stored without white space
+			result = append(result, &FunctionBlock{Name: t.Name, Args: components(args), spaced: args})
+		case *SimpleBlock:
+			inner, err := substituteVar(t.Tokens, lookup)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, &SimpleBlock{Tokens: inner, BlockType: t.BlockType})
+		default:
+			result = append(result, token)
+		}
+	}
+	return result, nil
+}
+
+// var( <custom-property-name> , <declaration-value>? )
+func resolveVar(function *FunctionBlock, lookup func(name string) ([]Token, bool)) ([]Token, error) {
+	args := components(function.Args)
+	if len(args) == 0 {
+		return nil, fmt.Errorf("var() expects a custom property name")
+	}
+
+	ident, ok := args[0].(*StringToken)
+	if !ok || ident.Id != Token_Ident || !IsCustomProperty(ident.Value) {
+		return nil, fmt.Errorf("var() expects a custom property name")
+	}
+
+	if value, ok := lookup(ident.Value); ok {
+		return value, nil
+	}
+
+	if len(args) > 1 {
+		if args[1].GetId() != Token_Comma {
+			return nil, fmt.Errorf("expected ',' after '%s' in var()", ident.Value)
+		}
+		return substituteVar(args[2:], lookup)
+	}
+
+	return nil, fmt.Errorf("custom property '%s' is not defined", ident.Value)
+}
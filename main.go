@@ -43,12 +43,13 @@ func parseArgs() string {
 func run() int {
 	htmlFile := parseArgs()
 
-	//var fontCache = plex.FontCache{}
+	var fontCache = plex.CreateFontCache()
+	var document *plex.Document
 	var window *sdl.Window
 	var renderer *sdl.Renderer
 	var err error
 
-	stylesheet, err := plex.LoadLocalStylesheet("./resources/useragent.css")
+	stylesheet, err := plex.LoadLocalStylesheet("./resources/useragent.css", plex_css.Origin_UserAgent)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to load stylesheet %s\n", err)
 		return 1
@@ -63,6 +64,13 @@ func run() int {
 	}
 	defer ttf.Quit()
 
+	defer func() {
+		sdl.Do(fontCache.Close)
+	}()
+
+	// font-relative units are measured with the faces the loaded documents declare
+	plex.DefaultFontMetrics = fontCache
+
 	/*sdl.Do(func() {
 		err = sdl.Init(sdl.INIT_EVERYTHING)
 	})
@@ -79,7 +87,7 @@ func run() int {
 			sdl.WINDOWPOS_UNDEFINED,
 			WindowWidth,
 			WindowHeight,
-			sdl.WINDOW_SHOWN,
+			sdl.WINDOW_SHOWN|sdl.WINDOW_RESIZABLE,
 		)
 	})
 
@@ -134,7 +142,7 @@ func run() int {
 			} else {
 				fmt.Fprintf(os.Stderr, "Failed OpenFont %s\n", err)
 			}*/
-			err = plex.LoadLocalHtmlDocument(htmlFile, renderer, []plex_css.Stylesheet{stylesheet})
+			document, err = plex.LoadLocalHtmlDocument(htmlFile, renderer, []plex_css.Stylesheet{stylesheet}, fontCache)
 		})
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to font %s\n", err)
@@ -151,10 +159,15 @@ func run() int {
 					runningMutex.Lock()
 					running = false
 					runningMutex.Unlock()
+				case *sdl.WindowEvent:
+					// media queries and viewport units follow the window size
+					if t.Event == sdl.WINDOWEVENT_SIZE_CHANGED && document != nil {
+						document.SetViewport(plex.GetViewport(window))
+					}
 				case *sdl.KeyboardEvent:
 					if t.Keysym.Sym == sdl.K_F5 && t.State == sdl.RELEASED {
 						fmt.Println("Reloading html document")
-						err = plex.LoadLocalHtmlDocument("./test.html", renderer, []plex_css.Stylesheet{stylesheet})
+						document, err = plex.LoadLocalHtmlDocument("./test.html", renderer, []plex_css.Stylesheet{stylesheet}, fontCache)
 						if err != nil {
 							fmt.Printf("Render Error: %s", err)
 						}